@@ -22,6 +22,7 @@ import (
 
 var (
 	onModuleHashMistmatchFlag = "on-module-hash-mistmatch"
+	primaryKeySeparatorFlag   = "primary-key-separator"
 )
 
 var supportedOutputTypes = "sf.substreams.sink.database.v1.DatabaseChanges,sf.substreams.database.v1.DatabaseChanges"
@@ -79,6 +80,8 @@ func newDBLoader(
 		return nil, fmt.Errorf("load psql table: %w", err)
 	}
 
+	dbLoader.SetPrimaryKeySeparator(sflags.MustGetString(cmd, primaryKeySeparatorFlag))
+
 	return dbLoader, nil
 }
 
@@ -93,6 +96,11 @@ func AddCommonSinkerFlags(flags *pflag.FlagSet) {
 		- If 'ignore' is set, we pick the cursor at the highest block number and use it as the starting point. Subsequent
 		updates to the cursor will overwrite the module hash in the database.
 	`))
+	flags.String(primaryKeySeparatorFlag, "", cli.FlagDescription(`
+		Separator splitting the single primary key string ('Pk') of a table change into the columns of a table with a composite primary key,
+		for example '/' for keys like 'a/b' or ':' for keys like 'a:b'. The parts are assigned to the primary key columns in the order they are
+		declared. If empty (default), single primary keys are only accepted for tables with a single primary key column.
+	`))
 }
 
 func readBlockRangeArgument(in string) (blockRange *bstream.Range, err error) {
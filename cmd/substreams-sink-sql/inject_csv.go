@@ -3,16 +3,15 @@ package main
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
 	"regexp"
 	"strconv"
-	"strings"
 	"time"
 
-	"github.com/jackc/pgx/v4/pgxpool"
 	_ "github.com/lib/pq"
 	"github.com/spf13/cobra"
 	. "github.com/streamingfast/cli"
@@ -55,10 +54,11 @@ func injectCSVE(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("unable to create input store: %w", err)
 	}
 
-	pool, err := pgxpool.Connect(ctx, sqlDSN.ConnString())
+	pool, err := db.OpenPgxDB(sqlDSN)
 	if err != nil {
-		return fmt.Errorf("connecting to database: %w", err)
+		return err
 	}
+	defer pool.Close()
 
 	t0 := time.Now()
 
@@ -80,10 +80,10 @@ type TableFiller struct {
 	in            dstore.Store
 	startBlockNum uint64
 	stopBlockNum  uint64
-	pool          *pgxpool.Pool
+	pool          *sql.DB
 }
 
-func NewTableFiller(pool *pgxpool.Pool, pqSchema, tblName string, startBlockNum, stopBlockNum uint64, inStore dstore.Store) *TableFiller {
+func NewTableFiller(pool *sql.DB, pqSchema, tblName string, startBlockNum, stopBlockNum uint64, inStore dstore.Store) *TableFiller {
 	return &TableFiller{
 		tblName:       tblName,
 		pqSchema:      pqSchema,
@@ -196,25 +196,14 @@ func (t *TableFiller) injectCSVFromFile(ctx context.Context, filename string, db
 }
 
 func (t *TableFiller) injectCSVFromReader(ctx context.Context, fl io.Reader, source string, dbColumns []string) error {
-	query := fmt.Sprintf(`COPY %s.%s ("%s") FROM STDIN WITH (FORMAT CSV, HEADER)`,
-		db.EscapeIdentifier(t.pqSchema),
-		db.EscapeIdentifier(t.tblName),
-		strings.Join(dbColumns, `","`))
 	zlog.Info("loading file into sql from reader", zap.String("source", source), zap.String("table_name", t.tblName), zap.Strings("db_columns", dbColumns))
 
 	t0 := time.Now()
 
-	conn, err := t.pool.Acquire(ctx)
+	count, err := db.CopyFromCSV(ctx, t.pool, t.pqSchema, t.tblName, dbColumns, fl)
 	if err != nil {
-		return fmt.Errorf("pool acquire: %w", err)
+		return err
 	}
-	defer conn.Release()
-
-	tag, err := conn.Conn().PgConn().CopyFrom(ctx, fl, query)
-	if err != nil {
-		return fmt.Errorf("failed COPY FROM for %q: %w", t.tblName, err)
-	}
-	count := tag.RowsAffected()
 	elapsed := time.Since(t0)
 	zlog.Info("loaded file into sql",
 		zap.String("source", source),
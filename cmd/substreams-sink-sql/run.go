@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -9,6 +10,7 @@ import (
 	. "github.com/streamingfast/cli"
 	"github.com/streamingfast/cli/sflags"
 	sink "github.com/streamingfast/substreams-sink"
+	"github.com/streamingfast/substreams-sink-sql/db"
 	"github.com/streamingfast/substreams-sink-sql/sinker"
 	"github.com/streamingfast/substreams/manifest"
 )
@@ -30,6 +32,61 @@ var sinkRunCmd = Command(sinkRunE,
 		flags.Int("undo-buffer-size", 0, "If non-zero, handling of reorgs in the database is disabled. Instead, a buffer is introduced to only process a blocks once it has been confirmed by that many blocks, introducing a latency but slightly reducing the load on the database when close to head.")
 		flags.Int("flush-interval", 1000, "When in catch up mode, flush every N blocks")
 		flags.StringP("endpoint", "e", "", "Specify the substreams endpoint, ex: `mainnet.eth.streamingfast.io:443`")
+		flags.StringSlice("field-update-op", nil, FlagDescription(`
+			How updates and upserts apply the value received for a column to its current value, of the form '<table>.<column>=<op>', can be repeated.
+			The operation is one of 'set' (default, overwrite the value), 'add', 'max', 'min', 'set_if_null' or 'append', ex: 'accounts.balance=add'.
+		`))
+		flags.StringSlice("bytes-encoding", nil, FlagDescription(`
+			Encoding of the values received for binary columns, decoded before being written, one of 'raw' (default), 'hex', '0xhex' or 'base64', can be repeated.
+			A bare '<encoding>' applies to all 'bytea', 'blob', '(var)binary' and ClickHouse 'FixedString' columns, '<table>.<column>=<encoding>' to a
+			specific column, ex: 'hex' or 'transfers.tx_hash=0xhex'. Primary key columns are always written as is.
+		`))
+		flags.String("timestamp-unit", "auto", FlagDescription(`
+			Unit of the unix timestamps received for timestamp and date columns, one of 's', 'ms', 'us' or 'ns'. When 'auto' (default),
+			the unit is detected from the magnitude of each value. RFC 3339 timestamps and 'YYYY-MM-DD' dates are accepted regardless.
+		`))
+		flags.String("null-sentinel", "", FlagDescription(`
+			Field value standing for SQL NULL, a field of a table change having exactly that value sets its column to NULL, ex: '\N'.
+			When empty (default), values are always written as is.
+		`))
+		flags.Bool("block-metadata-columns", false, FlagDescription(`
+			Fill the block metadata columns of the tables having them, from the block of the changes, on every insert, update and upsert.
+			The columns are '_block_num', '_block_timestamp' and '_block_id' unless renamed with --block-metadata-column-name.
+		`))
+		flags.StringSlice("block-metadata-column-name", nil, FlagDescription(`
+			Name of a block metadata column, of the form '<field>=<column>' where '<field>' is one of 'block_num', 'block_timestamp' or
+			'block_id', can be repeated. An empty '<column>' disables the field, ex: 'block_num=block_number'.
+		`))
+		flags.StringSlice("soft-delete", nil, FlagDescription(`
+			Tables whose deletes are soft deletes, setting the '_deleted_at' and '_deleted_block' columns of the row instead of removing it,
+			can be repeated. A later insert of the same primary key revives the row. Use '*' for all the tables having one of those columns.
+		`))
+		flags.StringSlice("versioned-table", nil, FlagDescription(`
+			Tables keeping a row per version of each entity, can be repeated. Changes close the current version of the row by setting its
+			'valid_to_block' column and insert a new one starting at 'valid_from_block', deletes only close the current version.
+		`))
+		flags.Bool("changes-log", false, FlagDescription(`
+			Record every operation flushed in the append-only 'substreams_changes' table, along with its block, within the flush transaction.
+			Reorgs are recorded as compensating entries. The table is created by 'setup --changes-log'.
+		`))
+		flags.Bool("blocks-table", false, FlagDescription(`
+			Record each block applied in the 'substreams_blocks' table, with its id, timestamp, flush time and the amount of rows changed
+			in each table, within the flush transaction. Reverted blocks are removed. The table is created by 'setup --blocks-table'.
+		`))
+		flags.StringSlice("retention", nil, FlagDescription(`
+			Retention window of a table, of the form '<table>.<column>=<window>', can be repeated. The window is either a block count, the column
+			holding the block number of the rows, or a duration like '12h' or '30d', the column holding their timestamp. Expired rows are
+			deleted after flushes in batches of bounded size, ex: 'trades.block_num=100000' or 'events.timestamp=7d'.
+		`))
+		flags.Duration("retention-interval", time.Minute, "Minimum time between two deletions of the expired rows of the tables having a retention window")
+		flags.String("rollups", "", FlagDescription(`
+			Path of a YAML file declaring rollup tables, each aggregating a source table per time bucket and group by columns with sum, count,
+			min and max measures. Rollup rows are updated in the flush transaction and on reorgs, see the README for the file format.
+		`))
+		flags.Bool("check-old-values", false, FlagDescription(`
+			Before each flush, check that the rows updated or deleted hold the old values of the changes sent by Substreams, the sink
+			stops with a divergence error naming the table, primary key and block otherwise. Requires one query per row updated or deleted.
+		`))
 	}),
 	OnCommandErrorLogAndExit(zlog),
 )
@@ -63,6 +120,29 @@ func sinkRunE(cmd *cobra.Command, args []string) error {
 
 	handleReorgs := sflags.MustGetInt(cmd, "undo-buffer-size") == 0
 
+	fieldUpdateOps, err := db.ParseFieldUpdateOps(sflags.MustGetStringSlice(cmd, "field-update-op"))
+	if err != nil {
+		return fmt.Errorf("invalid field update operations: %w", err)
+	}
+
+	timestampUnit, err := db.ParseTimestampUnit(sflags.MustGetString(cmd, "timestamp-unit"))
+	if err != nil {
+		return fmt.Errorf("invalid timestamp unit: %w", err)
+	}
+
+	var blockMetadataColumns *db.BlockMetadataColumns
+	if sflags.MustGetBool(cmd, "block-metadata-columns") {
+		blockMetadataColumns, err = db.ParseBlockMetadataColumns(sflags.MustGetStringSlice(cmd, "block-metadata-column-name"))
+		if err != nil {
+			return fmt.Errorf("invalid block metadata columns: %w", err)
+		}
+	}
+
+	bytesEncodings, err := db.ParseBytesEncodings(sflags.MustGetStringSlice(cmd, "bytes-encoding"))
+	if err != nil {
+		return fmt.Errorf("invalid bytes encodings: %w", err)
+	}
+
 	sink, err := sink.NewFromViper(
 		cmd,
 		supportedOutputTypes,
@@ -82,10 +162,67 @@ func sinkRunE(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("new db loader: %w", err)
 	}
 
+	if err := dbLoader.SetFieldUpdateOps(fieldUpdateOps); err != nil {
+		return fmt.Errorf("set field update operations: %w", err)
+	}
+
+	if err := dbLoader.SetBytesEncodings(bytesEncodings); err != nil {
+		return fmt.Errorf("set bytes encodings: %w", err)
+	}
+
+	dbLoader.SetTimestampUnit(timestampUnit)
+	dbLoader.SetBlockMetadataColumns(blockMetadataColumns)
+
+	if err := dbLoader.SetSoftDeleteTables(sflags.MustGetStringSlice(cmd, "soft-delete")); err != nil {
+		return fmt.Errorf("set soft delete tables: %w", err)
+	}
+
+	if err := dbLoader.SetVersionedTables(sflags.MustGetStringSlice(cmd, "versioned-table")); err != nil {
+		return fmt.Errorf("set versioned tables: %w", err)
+	}
+
+	if err := dbLoader.SetChangesLog(sflags.MustGetBool(cmd, "changes-log")); err != nil {
+		return fmt.Errorf("set changes log: %w", err)
+	}
+
+	if err := dbLoader.SetBlocksTable(sflags.MustGetBool(cmd, "blocks-table")); err != nil {
+		return fmt.Errorf("set blocks table: %w", err)
+	}
+
+	retentionWindows, err := db.ParseRetentionWindows(sflags.MustGetStringSlice(cmd, "retention"))
+	if err != nil {
+		return fmt.Errorf("invalid retention windows: %w", err)
+	}
+
+	if err := dbLoader.SetRetentionWindows(retentionWindows, sflags.MustGetDuration(cmd, "retention-interval")); err != nil {
+		return fmt.Errorf("set retention windows: %w", err)
+	}
+
+	if rollupsPath := sflags.MustGetString(cmd, "rollups"); rollupsPath != "" {
+		content, err := os.ReadFile(rollupsPath)
+		if err != nil {
+			return fmt.Errorf("read rollups file: %w", err)
+		}
+
+		rollups, err := db.ParseRollups(content)
+		if err != nil {
+			return fmt.Errorf("invalid rollups file %q: %w", rollupsPath, err)
+		}
+
+		if err := dbLoader.SetRollups(rollups); err != nil {
+			return fmt.Errorf("set rollups: %w", err)
+		}
+	}
+
+	if err := dbLoader.SetCheckOldValues(sflags.MustGetBool(cmd, "check-old-values")); err != nil {
+		return fmt.Errorf("set check old values: %w", err)
+	}
+
 	postgresSinker, err := sinker.New(sink, dbLoader, zlog, tracer)
 	if err != nil {
 		return fmt.Errorf("unable to setup postgres sinker: %w", err)
 	}
+	postgresSinker.SetNullSentinel(sflags.MustGetString(cmd, "null-sentinel"))
 
 	app.SuperviseAndStart(postgresSinker)
 
@@ -20,6 +20,10 @@ var sinkSetupCmd = Command(sinkSetupE,
 	Flags(func(flags *pflag.FlagSet) {
 		flags.Bool("postgraphile", false, "Will append the necessary 'comments' on cursors table to fully support postgraphile")
 		flags.Bool("system-tables-only", false, "will only create/update the systems tables (cursors, substreams_history) and ignore the schema from the manifest")
+		flags.Bool("changes-log", false, "Will create the 'substreams_changes' table recording every operation flushed, see 'run --changes-log'")
+		flags.Bool("blocks-table", false, "Will create the 'substreams_blocks' table recording each block applied, see 'run --blocks-table'")
+		flags.StringSlice("retention", nil, "Retention windows of the form '<table>.<column>=<duration>' to define as the TTL of the tables on ClickHouse, see 'run --retention'")
+		flags.StringSlice("versioned-table", nil, "Versioned tables to create the indexes of, the unique index of the current version of each row and the index of the block range of the versions, can be repeated")
 		flags.Bool("ignore-duplicate-table-errors", false, "[Dev] Use this if you want to ignore duplicate table errors, take caution that this means the 'schemal.sql' file will not have run fully!")
 	}),
 )
@@ -32,6 +36,11 @@ func sinkSetupE(cmd *cobra.Command, args []string) error {
 	ignoreDuplicateTableErrors := sflags.MustGetBool(cmd, "ignore-duplicate-table-errors")
 	systemTableOnly := sflags.MustGetBool(cmd, "system-tables-only")
 
+	retentionWindows, err := db.ParseRetentionWindows(sflags.MustGetStringSlice(cmd, "retention"))
+	if err != nil {
+		return fmt.Errorf("invalid retention windows: %w", err)
+	}
+
 	reader, err := manifest.NewReader(manifestPath)
 	if err != nil {
 		return fmt.Errorf("setup manifest reader: %w", err)
@@ -64,6 +73,34 @@ func sinkSetupE(cmd *cobra.Command, args []string) error {
 			return fmt.Errorf("setup: %w", err)
 		}
 	}
+
+	if sflags.MustGetBool(cmd, "changes-log") {
+		if err := dbLoader.SetupChangesLog(ctx, sflags.MustGetBool(cmd, "postgraphile")); err != nil {
+			return fmt.Errorf("setup changes log: %w", err)
+		}
+	}
+
+	if sflags.MustGetBool(cmd, "blocks-table") {
+		if err := dbLoader.SetupBlocksTable(ctx, sflags.MustGetBool(cmd, "postgraphile")); err != nil {
+			return fmt.Errorf("setup blocks table: %w", err)
+		}
+	}
+
+	versionedTables := sflags.MustGetStringSlice(cmd, "versioned-table")
+	if len(versionedTables) > 0 || len(retentionWindows) > 0 {
+		if err := dbLoader.LoadTables(); err != nil {
+			return fmt.Errorf("load tables: %w", err)
+		}
+
+		if err := dbLoader.SetupVersionedTables(ctx, versionedTables); err != nil {
+			return fmt.Errorf("setup versioned tables: %w", err)
+		}
+
+		if err := dbLoader.SetupRetention(ctx, retentionWindows); err != nil {
+			return fmt.Errorf("setup retention: %w", err)
+		}
+	}
+
 	zlog.Info("setup completed successfully")
 	return nil
 }
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"sort"
+	"strings"
+)
+
+// operationBatch groups together operations of the same type, on the same table and
+// touching the same set of columns so that a dialect can flush them using a single
+// statement instead of one statement per row.
+type operationBatch struct {
+	opType     OperationType
+	table      *TableInfo
+	columns    []string
+	operations []*Operation
+
+	// fieldOps are the field update operations shared by the batch's operations, keyed by
+	// escaped column name like columns.
+	fieldOps map[string]FieldUpdateOp
+}
+
+// batchOperations splits the operations of a single table into batches of at most
+// `maxRows` operations whose bind parameters count stays below `maxParams`. Operations
+// are grouped by type and column set, batches are returned in the order their first
+// operation was seen.
+//
+// Within a flush, there is at most one operation per primary key, so re-ordering
+// operations of different rows of the same table does not change the end result.
+func batchOperations(entries *OrderedMap[string, *Operation], maxRows int, maxParams int) (out []*operationBatch) {
+	openBatches := map[string]*operationBatch{}
+	for entryPair := entries.Oldest(); entryPair != nil; entryPair = entryPair.Next() {
+		op := entryPair.Value
+
+		columns := op.columnNames()
+		key := op.shapeKey(columns)
+
+		// Upper bound of the parameters bound for a row, its values, its primary key and its history record
+		batchMaxRows := maxParams / (len(columns) + len(op.primaryKey) + 4)
+		if batchMaxRows > maxRows {
+			batchMaxRows = maxRows
+		}
+
+		batch, found := openBatches[key]
+		if !found || len(batch.operations) >= batchMaxRows {
+			batch = &operationBatch{
+				opType:   op.opType,
+				table:    op.table,
+				columns:  columns,
+				fieldOps: op.escapedFieldOps(),
+			}
+
+			openBatches[key] = batch
+			out = append(out, batch)
+		}
+
+		batch.operations = append(batch.operations, op)
+	}
+
+	return out
+}
+
+// shapeKey identifies the statements writing the operation, operations of the same table sharing
+// a key are written by the same query.
+func (o *Operation) shapeKey(columns []string) string {
+	return o.table.identifier + "/" + string(o.opType) + "/" + strings.Join(columns, ",") + "/" + o.fieldOpsKey()
+}
+
+// columnNames returns the escaped, sorted, column names of the operation's data
+func (o *Operation) columnNames() []string {
+	if len(o.data) == 0 {
+		return nil
+	}
+
+	columns := make([]string, 0, len(o.data))
+	for column := range o.data {
+		columns = append(columns, EscapeIdentifier(column))
+	}
+	sort.Strings(columns)
+
+	return columns
+}
+
+func (b *operationBatch) primaryKeys() []map[string]string {
+	primaryKeys := make([]map[string]string, len(b.operations))
+	for i, op := range b.operations {
+		primaryKeys[i] = op.primaryKey
+	}
+
+	return primaryKeys
+}
+
+// upsertsOnConflict returns whether the rows of the batch already existing are updated, which
+// is the case of upserts and of the inserts of soft deleted tables reviving a soft deleted row.
+func (b *operationBatch) upsertsOnConflict() bool {
+	return b.opType == OperationTypeUpsert || (b.opType == OperationTypeInsert && b.table.softDelete)
+}
+
+// keyStatements keys the statements of a batch of a single row by the shape of its operation so
+// that they are prepared, the query of a larger batch depending on its row count.
+func (b *operationBatch) keyStatements(statements []*statement) []*statement {
+	if len(b.operations) != 1 {
+		return statements
+	}
+
+	op := b.operations[0]
+	return keyStatements(op.shapeKey(b.columns), statements)
+}
+
+func (b *operationBatch) reversibleOperations() (out []*Operation) {
+	for _, op := range b.operations {
+		if op.reversibleBlockNum != nil {
+			out = append(out, op)
+		}
+	}
+
+	return out
+}
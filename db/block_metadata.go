@@ -0,0 +1,138 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BlockMetadata is the metadata of the block of the changes, written to the block metadata columns
+type BlockMetadata struct {
+	Number    uint64
+	ID        string
+	Timestamp time.Time
+}
+
+// BlockMetadataColumns are the names of the reserved columns filled from the block of the changes
+// by inserts, updates and upserts, an empty name disabling the column.
+type BlockMetadataColumns struct {
+	BlockNum       string
+	BlockTimestamp string
+	BlockID        string
+}
+
+// DefaultBlockMetadataColumns returns the default names of the block metadata columns
+func DefaultBlockMetadataColumns() *BlockMetadataColumns {
+	return &BlockMetadataColumns{
+		BlockNum:       "_block_num",
+		BlockTimestamp: "_block_timestamp",
+		BlockID:        "_block_id",
+	}
+}
+
+// ParseBlockMetadataColumns parses the names of the block metadata columns overriding the default
+// ones, of the form `<field>=<column>` where `<field>` is one of `block_num`, `block_timestamp` or
+// `block_id`, an empty `<column>` disabling the field.
+func ParseBlockMetadataColumns(in []string) (*BlockMetadataColumns, error) {
+	out := DefaultBlockMetadataColumns()
+	for _, element := range in {
+		field, column, found := strings.Cut(element, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid block metadata column %q, expected <field>=<column>", element)
+		}
+
+		column = strings.TrimSpace(column)
+		switch strings.ToLower(strings.TrimSpace(field)) {
+		case "block_num":
+			out.BlockNum = column
+		case "block_timestamp":
+			out.BlockTimestamp = column
+		case "block_id":
+			out.BlockID = column
+		default:
+			return nil, fmt.Errorf("unknown block metadata field %q, valid fields are block_num, block_timestamp and block_id", field)
+		}
+	}
+
+	return out, nil
+}
+
+// SetBlockMetadataColumns enables the filling of the block metadata columns, each table having
+// some of them gets them filled by its inserts, updates and upserts. Nil disables the feature.
+func (l *Loader) SetBlockMetadataColumns(columns *BlockMetadataColumns) {
+	l.blockMetadataColumns = columns
+	for _, table := range l.tables {
+		l.resolveBlockMetadataColumns(table)
+	}
+}
+
+// resolveBlockMetadataColumns sets the block metadata columns the table has, system tables never
+// have any.
+func (l *Loader) resolveBlockMetadataColumns(table *TableInfo) {
+	table.blockMetadataColumns = nil
+	if l.blockMetadataColumns == nil || table.name == CURSORS_TABLE || table.name == HISTORY_TABLE {
+		return
+	}
+
+	out := &BlockMetadataColumns{}
+	if _, found := table.columnsByName[l.blockMetadataColumns.BlockNum]; found {
+		out.BlockNum = l.blockMetadataColumns.BlockNum
+	}
+	if _, found := table.columnsByName[l.blockMetadataColumns.BlockTimestamp]; found {
+		out.BlockTimestamp = l.blockMetadataColumns.BlockTimestamp
+	}
+	if _, found := table.columnsByName[l.blockMetadataColumns.BlockID]; found {
+		out.BlockID = l.blockMetadataColumns.BlockID
+	}
+
+	if *out != (BlockMetadataColumns{}) {
+		table.blockMetadataColumns = out
+	}
+}
+
+// AddBlockMetadata sets the values of the table's block metadata columns in the data of a change,
+// overwriting the ones sent by Substreams. It does nothing if the table has none.
+func (l *Loader) AddBlockMetadata(tableName string, data map[string]string, block *BlockMetadata) {
+	table, found := l.tables[tableName]
+	if !found || table.blockMetadataColumns == nil {
+		return
+	}
+
+	columns := table.blockMetadataColumns
+	if columns.BlockNum != "" {
+		data[columns.BlockNum] = strconv.FormatUint(block.Number, 10)
+	}
+	if columns.BlockTimestamp != "" {
+		data[columns.BlockTimestamp] = blockTimestampValue(table.columnsByName[columns.BlockTimestamp], block.Timestamp)
+	}
+	if columns.BlockID != "" {
+		data[columns.BlockID] = block.ID
+	}
+}
+
+// blockTimestampValue returns the block timestamp in seconds for integer columns and as a RFC 3339
+// timestamp, keeping its full precision, otherwise.
+func blockTimestampValue(column *ColumnInfo, timestamp time.Time) string {
+	if isIntegerColumn(column) {
+		return strconv.FormatInt(timestamp.Unix(), 10)
+	}
+
+	return timestamp.UTC().Format(time.RFC3339Nano)
+}
+
+// isIntegerColumn returns true if the values of the column are integers
+func isIntegerColumn(column *ColumnInfo) bool {
+	if column.scanType == nil {
+		return false
+	}
+
+	switch baseScanType(column.scanType).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBlockMetadataColumns(t *testing.T) {
+	columns, err := ParseBlockMetadataColumns([]string{"block_num=block_number", "BLOCK_ID="})
+	require.NoError(t, err)
+	assert.Equal(t, &BlockMetadataColumns{BlockNum: "block_number", BlockTimestamp: "_block_timestamp"}, columns)
+
+	_, err = ParseBlockMetadataColumns([]string{"block_hash=hash"})
+	assert.ErrorContains(t, err, `unknown block metadata field "block_hash"`)
+
+	_, err = ParseBlockMetadataColumns([]string{"block_num"})
+	assert.ErrorContains(t, err, `invalid block metadata column "block_num"`)
+}
+
+func TestAddBlockMetadata(t *testing.T) {
+	tables := TestTables("testschema")
+	tables["blocks"] = mustNewTableInfo("testschema", "blocks", []string{"id"}, map[string]*ColumnInfo{
+		"id":               NewColumnInfo("id", "text", ""),
+		"_block_num":       NewColumnInfo("_block_num", "int8", int64(0)),
+		"_block_timestamp": NewColumnInfo("_block_timestamp", "TIMESTAMPTZ", time.Time{}),
+	})
+	tables["seconds"] = mustNewTableInfo("testschema", "seconds", []string{"id"}, map[string]*ColumnInfo{
+		"id":               NewColumnInfo("id", "text", ""),
+		"_block_timestamp": NewColumnInfo("_block_timestamp", "int8", int64(0)),
+	})
+
+	l, _ := NewTestLoader(zlog, tracer, "testschema", tables)
+	block := &BlockMetadata{Number: 10, ID: "abc", Timestamp: time.Unix(1700000000, 500000000)}
+
+	data := map[string]string{"_block_num": "1"}
+	l.AddBlockMetadata("blocks", data, block)
+	assert.Equal(t, map[string]string{"_block_num": "1"}, data, "block metadata columns are opt-in")
+
+	l.SetBlockMetadataColumns(DefaultBlockMetadataColumns())
+	assert.Nil(t, l.tables["xfer"].blockMetadataColumns)
+	assert.Nil(t, l.tables[CURSORS_TABLE].blockMetadataColumns)
+
+	l.AddBlockMetadata("blocks", data, block)
+	assert.Equal(t, map[string]string{"_block_num": "10", "_block_timestamp": "2023-11-14T22:13:20.5Z"}, data)
+
+	data = map[string]string{}
+	l.AddBlockMetadata("seconds", data, block)
+	assert.Equal(t, map[string]string{"_block_timestamp": "1700000000"}, data)
+
+	data = map[string]string{"from": "a"}
+	l.AddBlockMetadata("xfer", data, block)
+	assert.Equal(t, map[string]string{"from": "a"}, data)
+}
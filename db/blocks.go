@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+const BLOCKS_TABLE = "substreams_blocks"
+
+// blocksTableDialect is implemented by the dialects supporting the blocks table
+type blocksTableDialect interface {
+	GetCreateBlocksQuery(schema string, withPostgraphile bool) string
+
+	// blocksStatements returns the statements inserting the blocks in the blocks table
+	blocksStatements(schema string, blocks []*blockEntry) ([]*statement, error)
+
+	// revertBlocksStatement returns the statement deleting the blocks after the last valid block
+	revertBlocksStatement(schema string, lastValidBlock uint64) *statement
+}
+
+// blockEntry is a row of the blocks table
+type blockEntry struct {
+	block *BlockMetadata
+
+	// rowCounts are the amount of operations received for each table in the block
+	rowCounts map[string]int
+}
+
+// SetBlocksTable enables the blocks table, recording each block applied along with its timestamp,
+// the time it was flushed at and the amount of rows changed in each table. It is written in the
+// same transaction as the data and the cursor, the blocks reverted being removed from it. The
+// tables must have been loaded first, the blocks table must exist, see SetupBlocksTable.
+func (l *Loader) SetBlocksTable(enabled bool) error {
+	if !enabled {
+		l.blocksTable = false
+		return nil
+	}
+
+	if _, ok := l.getDialect().(blocksTableDialect); !ok {
+		return fmt.Errorf("the blocks table is not supported by the current database")
+	}
+
+	if _, found := l.tables[BLOCKS_TABLE]; !found {
+		return &SystemTableError{fmt.Errorf("%s.%s table is not found and the blocks table is enabled", EscapeIdentifier(l.schema), BLOCKS_TABLE)}
+	}
+
+	l.blocksTable = true
+	return nil
+}
+
+// SetupBlocksTable creates the blocks table
+func (l *Loader) SetupBlocksTable(ctx context.Context, withPostgraphile bool) error {
+	blocksTable, ok := l.getDialect().(blocksTableDialect)
+	if !ok {
+		return fmt.Errorf("the blocks table is not supported by the current database")
+	}
+
+	_, err := l.ExecContext(ctx, blocksTable.GetCreateBlocksQuery(l.schema, withPostgraphile))
+	return err
+}
+
+// countBlockRow counts an operation of the table in the current block, if the blocks table is enabled
+func (l *Loader) countBlockRow(tableName string) {
+	if !l.blocksTable || len(l.blocks) == 0 {
+		return
+	}
+
+	l.blocks[len(l.blocks)-1].rowCounts[tableName]++
+}
+
+// flushBlocks writes the blocks applied since the last flush to the blocks table
+func (l *Loader) flushBlocks(ctx context.Context, tx Tx) error {
+	if len(l.blocks) == 0 {
+		return nil
+	}
+
+	blocksTable, ok := l.getDialect().(blocksTableDialect)
+	if !ok {
+		return fmt.Errorf("the blocks table is not supported by the current database")
+	}
+
+	statements, err := blocksTable.blocksStatements(l.schema, l.blocks)
+	if err != nil {
+		return fmt.Errorf("preparing statements: %w", err)
+	}
+
+	for _, stmt := range statements {
+		if l.tracer.Enabled() {
+			l.logger.Debug("adding query from blocks table to transaction", zap.String("query", stmt.query))
+		}
+
+		if _, err := l.execPrepared(ctx, tx, stmt); err != nil {
+			return fmt.Errorf("executing query %q: %w", stmt.query, err)
+		}
+	}
+
+	return nil
+}
+
+// revertBlocks removes the blocks after the last valid block from the blocks table, if enabled
+func (l *Loader) revertBlocks(ctx context.Context, tx Tx, lastValidBlock uint64) error {
+	if !l.blocksTable {
+		return nil
+	}
+
+	stmt := l.getDialect().(blocksTableDialect).revertBlocksStatement(l.schema, lastValidBlock)
+	if _, err := tx.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+		return fmt.Errorf("executing query %q: %w", stmt.query, err)
+	}
+
+	return nil
+}
+
+// rowCountsJSON returns the row counts of the block as a JSON object keyed by table name
+func (b *blockEntry) rowCountsJSON() (string, error) {
+	out, err := json.Marshal(b.rowCounts)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
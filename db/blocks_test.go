@@ -0,0 +1,36 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	sink "github.com/streamingfast/substreams-sink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlushAndRevertBlocks(t *testing.T) {
+	ctx := context.Background()
+	l, tx := NewTestLoader(zlog, tracer, "testschema", featureTestTables("testschema"))
+	require.NoError(t, l.SetBlocksTable(true))
+
+	l.SetCurrentBlock(&BlockMetadata{Number: 10, ID: "a", Timestamp: time.Unix(1700000000, 0).UTC()})
+	require.NoError(t, l.Insert("xfer", map[string]string{"id": "1"}, map[string]string{"from": "x"}, nil))
+	require.NoError(t, l.Insert("xfer", map[string]string{"id": "2"}, map[string]string{"from": "y"}, nil))
+	l.SetCurrentBlock(&BlockMetadata{Number: 11, ID: "b"})
+
+	_, err := l.Flush(ctx, "abc", sink.NewBlankCursor(), 11)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`INSERT INTO "testschema"."substreams_blocks" (block_num,block_id,block_timestamp,flushed_at,row_counts) VALUES ($1,$2,$3,now(),$4),($5,$6,$7,now(),$8); ` +
+			`[10 a 2023-11-14 22:13:20 +0000 UTC {"xfer":2} 11 b <nil> {}]`,
+	}, tx.ResultsContaining(`"testschema"."substreams_blocks"`))
+	assert.Empty(t, l.blocks)
+
+	tx.queries = nil
+	require.NoError(t, l.Revert(ctx, "abc", sink.NewBlankCursor(), 10))
+	assert.Equal(t, []string{
+		`DELETE FROM "testschema"."substreams_blocks" WHERE block_num > $1; [10]`,
+	}, tx.ResultsContaining(`"testschema"."substreams_blocks"`))
+}
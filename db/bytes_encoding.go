@@ -0,0 +1,212 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// BytesEncoding is the encoding of the values received for a binary column, they are decoded
+// before being written so that the column holds the actual bytes.
+type BytesEncoding string
+
+const (
+	// BytesEncodingRaw writes the bytes of the value as is, the default
+	BytesEncodingRaw BytesEncoding = "raw"
+
+	// BytesEncodingHex decodes hexadecimal values, like `abcd`
+	BytesEncodingHex BytesEncoding = "hex"
+
+	// BytesEncoding0xHex decodes hexadecimal values prefixed with `0x`, like `0xabcd`
+	BytesEncoding0xHex BytesEncoding = "0xhex"
+
+	// BytesEncodingBase64 decodes standard base64 values, like `q80=`
+	BytesEncodingBase64 BytesEncoding = "base64"
+)
+
+var bytesEncodings = map[string]BytesEncoding{
+	"raw":    BytesEncodingRaw,
+	"hex":    BytesEncodingHex,
+	"0xhex":  BytesEncoding0xHex,
+	"base64": BytesEncodingBase64,
+}
+
+// BytesEncodings configures the encoding of the values of binary columns
+type BytesEncodings struct {
+	// Default is the encoding of the binary columns without an encoding of their own, the binary
+	// columns being the ones of type `bytea`, `blob`, `(var)binary` and ClickHouse `FixedString`.
+	Default BytesEncoding
+
+	// Columns are the encodings of specific columns, keyed by table then column name, any column
+	// can be decoded this way, like a ClickHouse `String` column.
+	Columns map[string]map[string]BytesEncoding
+}
+
+// binaryColumnTypes are the database types whose columns use the default bytes encoding
+var binaryColumnTypes = map[string]bool{
+	"bytea":       true,
+	"blob":        true,
+	"tinyblob":    true,
+	"mediumblob":  true,
+	"longblob":    true,
+	"binary":      true,
+	"varbinary":   true,
+	"fixedstring": true,
+}
+
+// ParseBytesEncodings parses bytes encodings of the form `<encoding>`, setting the default
+// encoding, or `<table>.<column>=<encoding>` where `<encoding>` is one of `raw`, `hex`, `0xhex`
+// or `base64`.
+func ParseBytesEncodings(in []string) (*BytesEncodings, error) {
+	out := &BytesEncodings{Default: BytesEncodingRaw, Columns: map[string]map[string]BytesEncoding{}}
+	for _, element := range in {
+		column, encodingName, found := strings.Cut(element, "=")
+		if !found {
+			encoding, err := parseBytesEncoding(element)
+			if err != nil {
+				return nil, err
+			}
+
+			out.Default = encoding
+			continue
+		}
+
+		table, column, found := strings.Cut(strings.TrimSpace(column), ".")
+		if !found || table == "" || column == "" {
+			return nil, fmt.Errorf("invalid bytes encoding %q, expected <encoding> or <table>.<column>=<encoding>", element)
+		}
+
+		encoding, err := parseBytesEncoding(encodingName)
+		if err != nil {
+			return nil, err
+		}
+
+		if out.Columns[table] == nil {
+			out.Columns[table] = map[string]BytesEncoding{}
+		}
+		out.Columns[table][column] = encoding
+	}
+
+	return out, nil
+}
+
+func parseBytesEncoding(in string) (BytesEncoding, error) {
+	encoding, found := bytesEncodings[strings.ToLower(strings.TrimSpace(in))]
+	if !found {
+		return "", fmt.Errorf("unknown bytes encoding %q, valid encodings are raw, hex, 0xhex and base64", in)
+	}
+
+	return encoding, nil
+}
+
+// SetBytesEncodings configures the encoding of the values of binary columns, the tables must
+// have been loaded first.
+func (l *Loader) SetBytesEncodings(encodings *BytesEncodings) error {
+	for tableName, columns := range encodings.Columns {
+		table, found := l.tables[tableName]
+		if !found {
+			return fmt.Errorf("unknown table %q", tableName)
+		}
+
+		for column, encoding := range columns {
+			if _, found := table.columnsByName[column]; !found {
+				return fmt.Errorf("unknown column %q in table %q", column, tableName)
+			}
+
+			if table.isPrimaryColumn(column) && encoding != BytesEncodingRaw {
+				return fmt.Errorf("column %q of table %q is part of the primary key, bytes encodings are not supported on primary key columns", column, tableName)
+			}
+		}
+	}
+
+	l.bytesEncodings = encodings
+	for _, table := range l.tables {
+		l.resolveValueConversions(table)
+	}
+
+	return nil
+}
+
+// resolveValueConversions sets the bytes encoding and the value converter of the table's columns,
+// the bytes encoding taking precedence over the converter of the column's type. Enum columns
+// without a converter for their type are validated against their labels.
+func (l *Loader) resolveValueConversions(table *TableInfo) {
+	for _, column := range table.columnsByName {
+		column.bytesEncoding = l.columnBytesEncoding(table, column)
+		if column.bytesEncoding != BytesEncodingRaw {
+			column.converter = column.bytesEncoding.convert
+			continue
+		}
+
+		column.converter = l.valueConverter(column.databaseTypeName)
+		if column.converter == nil && column.enumLabels != nil {
+			column.converter = enumValueConverter(table, column)
+		}
+	}
+}
+
+// columnBytesEncoding returns the bytes encoding of the column. Primary key columns are always
+// written as is, their values are bound without being converted in the statements matching rows.
+func (l *Loader) columnBytesEncoding(table *TableInfo, column *ColumnInfo) BytesEncoding {
+	if l.bytesEncodings == nil || table.isPrimaryColumn(column.name) {
+		return BytesEncodingRaw
+	}
+
+	if encoding, found := l.bytesEncodings.Columns[table.name][column.name]; found {
+		return encoding
+	}
+
+	if l.bytesEncodings.Default != "" && binaryColumnTypes[valueConverterKeys(column.databaseTypeName)[0]] {
+		return l.bytesEncodings.Default
+	}
+
+	return BytesEncodingRaw
+}
+
+// convert is the value converter of the columns using the encoding, the decoded bytes are bound as
+// a byte slice except for the ClickHouse string types whose driver expects a Go string.
+func (e BytesEncoding) convert(typeName string, value string) (any, error) {
+	decoded, err := e.decode(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %w", e, value, err)
+	}
+
+	switch valueConverterKeys(typeName)[0] {
+	case "string", "fixedstring":
+		return string(decoded), nil
+	}
+
+	return decoded, nil
+}
+
+func (e BytesEncoding) decode(value string) ([]byte, error) {
+	switch e {
+	case BytesEncodingHex:
+		return hex.DecodeString(value)
+	case BytesEncoding0xHex:
+		if !strings.HasPrefix(value, "0x") && !strings.HasPrefix(value, "0X") {
+			return nil, fmt.Errorf("missing 0x prefix")
+		}
+		return hex.DecodeString(value[2:])
+	case BytesEncodingBase64:
+		return base64.StdEncoding.DecodeString(value)
+	default:
+		return []byte(value), nil
+	}
+}
+
+// encode returns the value of the column encoded back, for values read from the database which
+// are written again.
+func (e BytesEncoding) encode(value string) string {
+	switch e {
+	case BytesEncodingHex:
+		return hex.EncodeToString([]byte(value))
+	case BytesEncoding0xHex:
+		return "0x" + hex.EncodeToString([]byte(value))
+	case BytesEncodingBase64:
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	default:
+		return value
+	}
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBytesEncodings(t *testing.T) {
+	encodings, err := ParseBytesEncodings([]string{"hex", "xfer.from=0xhex", " xfer.to = BASE64"})
+	require.NoError(t, err)
+	assert.Equal(t, &BytesEncodings{
+		Default: BytesEncodingHex,
+		Columns: map[string]map[string]BytesEncoding{
+			"xfer": {"from": BytesEncoding0xHex, "to": BytesEncodingBase64},
+		},
+	}, encodings)
+
+	encodings, err = ParseBytesEncodings(nil)
+	require.NoError(t, err)
+	assert.Equal(t, BytesEncodingRaw, encodings.Default)
+
+	_, err = ParseBytesEncodings([]string{"base58"})
+	assert.ErrorContains(t, err, `unknown bytes encoding "base58"`)
+
+	_, err = ParseBytesEncodings([]string{"from=hex"})
+	assert.ErrorContains(t, err, `invalid bytes encoding "from=hex"`)
+}
+
+func TestBytesEncodingConvert(t *testing.T) {
+	tests := []struct {
+		encoding  BytesEncoding
+		typeName  string
+		value     string
+		expect    any
+		expectErr bool
+	}{
+		{encoding: BytesEncodingHex, typeName: "BYTEA", value: "abcd", expect: []byte{0xab, 0xcd}},
+		{encoding: BytesEncodingHex, typeName: "BYTEA", value: "0xabcd", expectErr: true},
+		{encoding: BytesEncoding0xHex, typeName: "BYTEA", value: "0xabcd", expect: []byte{0xab, 0xcd}},
+		{encoding: BytesEncoding0xHex, typeName: "BYTEA", value: "abcd", expectErr: true},
+		{encoding: BytesEncodingBase64, typeName: "BLOB", value: "q80=", expect: []byte{0xab, 0xcd}},
+		{encoding: BytesEncodingBase64, typeName: "BLOB", value: "q80", expectErr: true},
+		{encoding: BytesEncoding0xHex, typeName: "FixedString(2)", value: "0xabcd", expect: "\xab\xcd"},
+		{encoding: BytesEncodingHex, typeName: "String", value: "abcd", expect: "\xab\xcd"},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s/%s/%s", test.encoding, test.typeName, test.value), func(t *testing.T) {
+			out, err := test.encoding.convert(test.typeName, test.value)
+			if test.expectErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expect, out)
+			assert.Equal(t, test.value, test.encoding.encode("\xab\xcd"))
+		})
+	}
+}
+
+func TestSetBytesEncodings(t *testing.T) {
+	tables := map[string]*TableInfo{
+		"blocks": mustNewTableInfo("testschema", "blocks", []string{"hash"}, map[string]*ColumnInfo{
+			"hash":   NewColumnInfo("hash", "BYTEA", []byte{}),
+			"parent": NewColumnInfo("parent", "BYTEA", []byte{}),
+			"miner":  NewColumnInfo("miner", "TEXT", ""),
+		}),
+	}
+
+	l, _ := NewTestLoader(zlog, tracer, "testschema", tables)
+	require.NoError(t, l.SetBytesEncodings(&BytesEncodings{Default: BytesEncoding0xHex}))
+
+	table := l.tables["blocks"]
+	assert.Equal(t, BytesEncodingRaw, table.columnsByName["hash"].bytesEncoding)
+	assert.Equal(t, BytesEncoding0xHex, table.columnsByName["parent"].bytesEncoding)
+	assert.Equal(t, BytesEncodingRaw, table.columnsByName["miner"].bytesEncoding)
+
+	_, values, err := (&postgresDialect{}).prepareColValues(table, map[string]string{"parent": "0xabcd", "miner": "0xabcd"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []any{[]byte{0xab, 0xcd}, "0xabcd"}, values)
+
+	row, err := (&postgresDialect{}).prepareRowValues(table, map[string]string{"hash": "h1"}, map[string]string{"parent": "0xabcd"})
+	require.NoError(t, err)
+	assert.Equal(t, `\xabcd`, row["parent"])
+
+	_, _, err = (&postgresDialect{}).prepareColValues(table, map[string]string{"parent": "abcd"})
+	assert.ErrorContains(t, err, "missing 0x prefix")
+
+	assert.ErrorContains(t, l.SetBytesEncodings(&BytesEncodings{Columns: map[string]map[string]BytesEncoding{"blocks": {"hash": BytesEncodingHex}}}), "part of the primary key")
+	assert.ErrorContains(t, l.SetBytesEncodings(&BytesEncodings{Columns: map[string]map[string]BytesEncoding{"blocks": {"unknown": BytesEncodingHex}}}), `unknown column "unknown"`)
+}
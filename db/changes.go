@@ -0,0 +1,187 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+const CHANGES_TABLE = "substreams_changes"
+
+// ChangeOpRevert is the operation of the changes log entries recording the revert of a versioned
+// table, its versions opened after the block of the entry being removed and the ones closed after
+// it being opened again.
+const ChangeOpRevert = "REVERT"
+
+// changesLogDialect is implemented by the dialects supporting the changes log
+type changesLogDialect interface {
+	GetCreateChangesQuery(schema string, withPostgraphile bool) string
+
+	// changesStatements returns the statements appending the changes to the changes log
+	changesStatements(schema string, changes []*change) []*statement
+}
+
+// change is an entry of the changes log
+type change struct {
+	op        string
+	tableName string
+	pk        any // JSON of the row's primary key, nil for the revert of a versioned table
+	newValue  any // JSON of the row's new values, nil for deletes
+	block     *BlockMetadata
+	revert    bool
+}
+
+// SetChangesLog enables the changes log, an append-only table recording every operation flushed
+// along with its block, written in the same transaction as the data and the cursor. Reverts are
+// recorded as compensating entries. The tables must have been loaded first, the changes table
+// must exist, see SetupChangesLog.
+func (l *Loader) SetChangesLog(enabled bool) error {
+	if !enabled {
+		l.changesLog = false
+		return nil
+	}
+
+	if _, ok := l.getDialect().(changesLogDialect); !ok {
+		return fmt.Errorf("the changes log is not supported by the current database")
+	}
+
+	if _, found := l.tables[CHANGES_TABLE]; !found {
+		return &SystemTableError{fmt.Errorf("%s.%s table is not found and the changes log is enabled", EscapeIdentifier(l.schema), CHANGES_TABLE)}
+	}
+
+	l.changesLog = true
+	return nil
+}
+
+// SetupChangesLog creates the changes table
+func (l *Loader) SetupChangesLog(ctx context.Context, withPostgraphile bool) error {
+	changesLog, ok := l.getDialect().(changesLogDialect)
+	if !ok {
+		return fmt.Errorf("the changes log is not supported by the current database")
+	}
+
+	_, err := l.ExecContext(ctx, changesLog.GetCreateChangesQuery(l.schema, withPostgraphile))
+	return err
+}
+
+// SetCurrentBlock sets the block of the changes received next, recorded in the changes log and
+// in the blocks table.
+func (l *Loader) SetCurrentBlock(block *BlockMetadata) {
+	l.currentBlock = block
+	if l.blocksTable {
+		l.blocks = append(l.blocks, &blockEntry{block: block, rowCounts: map[string]int{}})
+	}
+}
+
+// logChange records the operation in the changes log, if enabled. Operations are recorded as
+// received, before being folded with the other operations of their row.
+func (l *Loader) logChange(op *Operation) error {
+	if !l.changesLog {
+		return nil
+	}
+
+	pk, err := json.Marshal(op.primaryKey)
+	if err != nil {
+		return fmt.Errorf("marshalling primary key: %w", err)
+	}
+
+	var newValue any
+	if op.opType != OperationTypeDelete {
+		row := make(map[string]any, len(op.data))
+		for column, value := range op.data {
+			if value == NullValue {
+				row[column] = nil
+				continue
+			}
+			row[column] = value
+		}
+
+		newValueJSON, err := json.Marshal(row)
+		if err != nil {
+			return fmt.Errorf("marshalling new value: %w", err)
+		}
+		newValue = string(newValueJSON)
+	}
+
+	l.changes = append(l.changes, &change{
+		op:        string(op.opType),
+		tableName: op.table.name,
+		pk:        string(pk),
+		newValue:  newValue,
+		block:     l.currentBlock,
+	})
+
+	return nil
+}
+
+// logRevertedHistory records in the changes log, if enabled, the compensating entries of the
+// history rows being reverted: a delete for an insert and the restored row for an update or a
+// delete.
+func (l *Loader) logRevertedHistory(history []*historyRow) {
+	if !l.changesLog {
+		return
+	}
+
+	for _, row := range history {
+		entry := &change{tableName: l.historyTableName(row.tableName), pk: row.pk, block: l.currentBlock, revert: true}
+		switch row.op {
+		case "I":
+			entry.op = string(OperationTypeDelete)
+		case "D":
+			entry.op = string(OperationTypeInsert)
+			entry.newValue = row.prevValue
+		default:
+			entry.op = string(OperationTypeUpdate)
+			entry.newValue = row.prevValue
+		}
+
+		l.changes = append(l.changes, entry)
+	}
+}
+
+// logRevertedVersions records in the changes log, if enabled, the revert of a versioned table
+func (l *Loader) logRevertedVersions(table *TableInfo) {
+	if !l.changesLog {
+		return
+	}
+
+	l.changes = append(l.changes, &change{op: ChangeOpRevert, tableName: table.name, block: l.currentBlock, revert: true})
+}
+
+// historyTableName returns the name of the table of a history row, which records it escaped and
+// possibly qualified by its schema.
+func (l *Loader) historyTableName(escaped string) string {
+	for _, table := range l.tables {
+		if table.identifier == escaped || table.nameEscaped == escaped {
+			return table.name
+		}
+	}
+
+	return escaped
+}
+
+// flushChanges writes the recorded changes to the changes log
+func (l *Loader) flushChanges(ctx context.Context, tx Tx) error {
+	if len(l.changes) == 0 {
+		return nil
+	}
+
+	changesLog, ok := l.getDialect().(changesLogDialect)
+	if !ok {
+		return fmt.Errorf("the changes log is not supported by the current database")
+	}
+
+	for _, stmt := range changesLog.changesStatements(l.schema, l.changes) {
+		if l.tracer.Enabled() {
+			l.logger.Debug("adding query from changes log to transaction", zap.String("query", stmt.query))
+		}
+
+		if _, err := l.execPrepared(ctx, tx, stmt); err != nil {
+			return fmt.Errorf("executing query %q: %w", stmt.query, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	sink "github.com/streamingfast/substreams-sink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlushChanges(t *testing.T) {
+	l, tx := NewTestLoader(zlog, tracer, "testschema", featureTestTables("testschema"))
+	require.NoError(t, l.SetChangesLog(true))
+
+	block10, block11 := uint64(10), uint64(11)
+	l.SetCurrentBlock(&BlockMetadata{Number: 10, ID: "a"})
+	require.NoError(t, l.Insert("xfer", map[string]string{"id": "1"}, map[string]string{"from": "x"}, &block10))
+	l.SetCurrentBlock(&BlockMetadata{Number: 11, ID: "b"})
+	require.NoError(t, l.Update("xfer", map[string]string{"id": "1"}, map[string]string{"to": NullValue}, &block11))
+	require.NoError(t, l.Delete("xfer", map[string]string{"id": "2"}, &block11))
+
+	_, err := l.Flush(context.Background(), "abc", sink.NewBlankCursor(), 9)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		`INSERT INTO "testschema"."substreams_changes" (op,table_name,pk,new_value,block_num,block_id,revert) VALUES ($1,$2,$3,$4,$5,$6,$7),($8,$9,$10,$11,$12,$13,$14),($15,$16,$17,$18,$19,$20,$21); ` +
+			`[INSERT xfer {"id":"1"} {"from":"x","id":"1"} 10 a false UPDATE xfer {"id":"1"} {"to":null} 11 b false DELETE xfer {"id":"2"} <nil> 11 b false]`,
+	}, tx.ResultsContaining(`"testschema"."substreams_changes"`))
+	assert.Empty(t, l.changes)
+}
+
+func TestRevertChanges(t *testing.T) {
+	l, tx := NewTestLoader(zlog, tracer, "testschema", featureTestTables("testschema"))
+	require.NoError(t, l.SetChangesLog(true))
+	require.NoError(t, l.SetVersionedTables([]string{"owners"}))
+
+	l.logRevertedHistory([]*historyRow{
+		{op: "U", tableName: `"xfer"`, pk: `{"id":"1"}`, prevValue: `{"id":"1","from":"x"}`, blockNum: 12},
+		{op: "I", tableName: `"testschema"."xfer"`, pk: `{"id":"2"}`, blockNum: 11},
+	})
+	assert.Equal(t, []*change{
+		{op: "UPDATE", tableName: "xfer", pk: `{"id":"1"}`, newValue: `{"id":"1","from":"x"}`, revert: true},
+		{op: "DELETE", tableName: "xfer", pk: `{"id":"2"}`, revert: true},
+	}, l.changes)
+	l.changes = nil
+
+	require.NoError(t, l.Revert(context.Background(), "abc", sink.NewBlankCursor(), 10))
+	assert.Equal(t, []string{
+		`INSERT INTO "testschema"."substreams_changes" (op,table_name,pk,new_value,block_num,block_id,revert) VALUES ($1,$2,$3,$4,$5,$6,$7); [REVERT owners <nil> <nil> 10  true]`,
+	}, tx.ResultsContaining(`"testschema"."substreams_changes"`))
+}
@@ -0,0 +1,482 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bobg/go-generics/v2/slices"
+	"github.com/shopspring/decimal"
+	"golang.org/x/exp/maps"
+)
+
+// clickhouseEngine is the engine of a ClickHouse table as reported by `system.tables`, the
+// engine defines which columns are managed by the sink to revert or replace rows.
+type clickhouseEngine struct {
+	// name is the engine name without its `Replicated` prefix, like `CollapsingMergeTree`
+	name string
+
+	// params are the column parameters of the engine, the replication path and replica name
+	// of replicated engines are excluded.
+	params []string
+}
+
+// parseClickhouseEngine parses the engine of a table out of the `engine` and `engine_full`
+// columns of `system.tables`, for example `CollapsingMergeTree` and
+// `CollapsingMergeTree(sign) ORDER BY id SETTINGS index_granularity = 8192`.
+func parseClickhouseEngine(engine, engineFull string) *clickhouseEngine {
+	out := &clickhouseEngine{name: strings.TrimPrefix(engine, "Replicated")}
+
+	start := strings.Index(engineFull, engine+"(")
+	if start == -1 {
+		return out
+	}
+
+	depth := 0
+	var param strings.Builder
+	addParam := func() {
+		value := strings.TrimSpace(param.String())
+		param.Reset()
+
+		// Quoted parameters are the replication path and replica name of replicated engines
+		if value == "" || strings.HasPrefix(value, "'") {
+			return
+		}
+		out.params = append(out.params, strings.Trim(value, "`\""))
+	}
+
+	for _, c := range engineFull[start+len(engine)+1:] {
+		switch {
+		case c == '(':
+			depth++
+		case c == ')' && depth == 0:
+			addParam()
+			return out
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			addParam()
+			continue
+		}
+		param.WriteRune(c)
+	}
+
+	return out
+}
+
+// isCollapsing returns true for the engines able to cancel a row by inserting it again with a
+// negative sign, the way rows of reverted blocks are removed.
+func (e *clickhouseEngine) isCollapsing() bool {
+	return e.name == "CollapsingMergeTree" || e.name == "VersionedCollapsingMergeTree"
+}
+
+// isReplacing returns true for `ReplacingMergeTree`, rows are updated by inserting them again
+// with a higher version, and deleted by inserting them with their deleted column set.
+func (e *clickhouseEngine) isReplacing() bool {
+	return e.name == "ReplacingMergeTree"
+}
+
+// reversible returns true if the rows of reverted blocks can be undone on the engine, replacing
+// engines need a deleted column to undo inserts.
+func (e *clickhouseEngine) reversible() bool {
+	return e.isCollapsing() || e.isDeletedColumn() != ""
+}
+
+// signColumn returns the sign column of collapsing engines, empty for others
+func (e *clickhouseEngine) signColumn() string {
+	if e.isCollapsing() && len(e.params) > 0 {
+		return e.params[0]
+	}
+	return ""
+}
+
+// versionColumn returns the version column of `VersionedCollapsingMergeTree` and
+// `ReplacingMergeTree`, empty for others
+func (e *clickhouseEngine) versionColumn() string {
+	switch {
+	case e.name == "VersionedCollapsingMergeTree" && len(e.params) > 1:
+		return e.params[1]
+	case e.isReplacing() && len(e.params) > 0:
+		return e.params[0]
+	}
+	return ""
+}
+
+// isDeletedColumn returns the deleted column of `ReplacingMergeTree`, empty for others
+func (e *clickhouseEngine) isDeletedColumn() string {
+	if e.isReplacing() && len(e.params) > 1 {
+		return e.params[1]
+	}
+	return ""
+}
+
+// clickhouseTableEngines returns the engine of each table of the loader's schema keyed by table name
+func clickhouseTableEngines(ctx context.Context, l *Loader) (map[string]*clickhouseEngine, error) {
+	rows, err := l.DB.QueryContext(ctx, `SELECT name, engine, engine_full FROM system.tables WHERE database = ?`, l.schema)
+	if err != nil {
+		return nil, fmt.Errorf("querying table engines: %w", err)
+	}
+	defer rows.Close()
+
+	out := map[string]*clickhouseEngine{}
+	for rows.Next() {
+		var name, engine, engineFull string
+		if err := rows.Scan(&name, &engine, &engineFull); err != nil {
+			return nil, fmt.Errorf("scanning table engine: %w", err)
+		}
+
+		out[name] = parseClickhouseEngine(engine, engineFull)
+	}
+
+	return out, rows.Err()
+}
+
+// clickhouseColumnType returns the type of the column, empty if the table has no such column
+func clickhouseColumnType(ctx context.Context, l *Loader, table, column string) (string, error) {
+	rows, err := l.DB.QueryContext(ctx, `SELECT type FROM system.columns WHERE database = ? AND table = ? AND name = ?`, l.schema, table, column)
+	if err != nil {
+		return "", fmt.Errorf("querying column type: %w", err)
+	}
+	defer rows.Close()
+
+	var columnType string
+	if rows.Next() {
+		if err := rows.Scan(&columnType); err != nil {
+			return "", fmt.Errorf("scanning column type: %w", err)
+		}
+	}
+
+	return columnType, rows.Err()
+}
+
+// clickhouseMaxSelectKeys bounds the amount of primary keys looked up by a single query
+const clickhouseMaxSelectKeys = 1000
+
+// clickhouseCurrentRows returns the current value of the rows having one of the primary keys, keyed
+// by their unique id. Deleted rows of `ReplacingMergeTree` tables are excluded.
+func clickhouseCurrentRows(ctx context.Context, l *Loader, info *TableInfo, engine *clickhouseEngine, primaryKeys []map[string]string) (map[string]map[string]string, error) {
+	columns := maps.Keys(info.columnsByName)
+	sort.Strings(columns)
+
+	out := make(map[string]map[string]string, len(primaryKeys))
+	for start := 0; start < len(primaryKeys); start += clickhouseMaxSelectKeys {
+		end := start + clickhouseMaxSelectKeys
+		if end > len(primaryKeys) {
+			end = len(primaryKeys)
+		}
+
+		args := newPositionalArgs()
+		where := getPrimaryKeysInClause(primaryKeys[start:end], args)
+		if deletedColumn := engine.isDeletedColumn(); deletedColumn != "" {
+			where += " AND " + EscapeIdentifier(deletedColumn) + " = 0"
+		}
+
+		query := fmt.Sprintf("SELECT %s FROM %s FINAL WHERE %s",
+			strings.Join(slices.Map(columns, EscapeIdentifier), ","),
+			info.identifier,
+			where,
+		)
+
+		if err := readClickhouseRows(ctx, l, query, args.args, columns, func(row map[string]string) {
+			for column, value := range row {
+				// Decoded binary values are encoded back, the row being written again as received
+				if value != NullValue {
+					row[column] = info.columnsByName[column].bytesEncoding.encode(value)
+				}
+			}
+
+			primaryKey := make(map[string]string, len(info.primaryColumns))
+			for _, column := range info.primaryColumns {
+				primaryKey[column.name] = row[column.name]
+			}
+			out[createRowUniqueID(primaryKey)] = row
+		}); err != nil {
+			return nil, fmt.Errorf("reading current rows of %q: %w", info.name, err)
+		}
+	}
+
+	return out, nil
+}
+
+func readClickhouseRows(ctx context.Context, l *Loader, query string, args []any, columns []string, onRow func(row map[string]string)) error {
+	rows, err := l.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("executing query %q: %w", query, err)
+	}
+	defer rows.Close()
+
+	values := make([]any, len(columns))
+	pointers := make([]any, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return fmt.Errorf("scanning row: %w", err)
+		}
+
+		row := make(map[string]string, len(columns))
+		for i, column := range columns {
+			row[column] = clickhouseValueToString(values[i])
+		}
+		onRow(row)
+	}
+
+	return rows.Err()
+}
+
+// clickhouseValueToString returns the value read from ClickHouse in the textual form of the
+// values received from Substreams, which `convertToType` converts back.
+func clickhouseValueToString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return NullValue
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// nextRowVersion returns the version of the next row written to a `ReplacingMergeTree` table. It
+// is based on the current time, to keep increasing across restarts, and always greater than the
+// previous one.
+func (l *Loader) nextRowVersion() uint64 {
+	version := uint64(time.Now().UnixNano())
+	if version <= l.rowVersion {
+		version = l.rowVersion + 1
+	}
+
+	l.rowVersion = version
+	return version
+}
+
+// clickhouseValueConverters returns the built-in value converters of ClickHouse for the types
+// which the driver expects as a specific Go type, the timestamp elements of arrays and maps being
+// converted by `convertTimestamp`.
+func clickhouseValueConverters(convertTimestamp ValueConverter) ValueConverters {
+	c := &clickhouseComposites{convertTimestamp: convertTimestamp}
+	c.converters = ValueConverters{
+		"decimal":    convertClickhouseDecimal,
+		"decimal32":  convertClickhouseDecimal,
+		"decimal64":  convertClickhouseDecimal,
+		"decimal128": convertClickhouseDecimal,
+		"decimal256": convertClickhouseDecimal,
+		"int128":     convertClickhouseBigInt,
+		"int256":     convertClickhouseBigInt,
+		"uint128":    convertClickhouseBigInt,
+		"uint256":    convertClickhouseBigInt,
+		"array":      c.convertArray,
+		"map":        c.convertMap,
+	}
+
+	return c.converters
+}
+
+func (d clickhouseDialect) builtinValueConverters(convertTimestamp ValueConverter) ValueConverters {
+	return clickhouseValueConverters(convertTimestamp)
+}
+
+// clickhouseComposites converts the values of arrays and maps, their elements being converted by
+// the built-in converters of their type.
+type clickhouseComposites struct {
+	converters       ValueConverters
+	convertTimestamp ValueConverter
+}
+
+// clickhouseScalarTypes are the Go types of the ClickHouse types converted by `convertToType`,
+// used for the elements of arrays and maps.
+var clickhouseScalarTypes = map[string]reflect.Type{
+	"string":      reflect.TypeOf(""),
+	"fixedstring": reflect.TypeOf(""),
+	"uuid":        reflect.TypeOf(""),
+	"enum8":       reflect.TypeOf(""),
+	"enum16":      reflect.TypeOf(""),
+	"bool":        reflect.TypeOf(false),
+	"int8":        reflect.TypeOf(int8(0)),
+	"int16":       reflect.TypeOf(int16(0)),
+	"int32":       reflect.TypeOf(int32(0)),
+	"int64":       reflect.TypeOf(int64(0)),
+	"uint8":       reflect.TypeOf(uint8(0)),
+	"uint16":      reflect.TypeOf(uint16(0)),
+	"uint32":      reflect.TypeOf(uint32(0)),
+	"uint64":      reflect.TypeOf(uint64(0)),
+	"float32":     reflect.TypeOf(float32(0)),
+	"float64":     reflect.TypeOf(float64(0)),
+}
+
+func convertClickhouseDecimal(typeName string, value string) (any, error) {
+	out, err := decimal.NewFromString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q, expected a decimal number", typeName, value)
+	}
+
+	return out, nil
+}
+
+func convertClickhouseBigInt(typeName string, value string) (any, error) {
+	out, ok := new(big.Int).SetString(strings.TrimSpace(value), 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s value %q, expected an integer", typeName, value)
+	}
+
+	if out.Sign() < 0 && strings.HasPrefix(strings.ToLower(typeName), "u") {
+		return nil, fmt.Errorf("invalid %s value %q, expected a positive integer", typeName, value)
+	}
+
+	return out, nil
+}
+
+// convertArray converts a JSON array into a slice of the array's element type
+func (c *clickhouseComposites) convertArray(typeName string, value string) (any, error) {
+	params := clickhouseTypeParams(typeName)
+	if len(params) != 1 {
+		return nil, fmt.Errorf("invalid array type %q", typeName)
+	}
+
+	var elements []any
+	if err := decodeJSON(value, &elements); err != nil {
+		return nil, fmt.Errorf("invalid %s value %q, expected a JSON array: %w", typeName, value, err)
+	}
+
+	if len(elements) == 0 {
+		return []any{}, nil
+	}
+
+	var out reflect.Value
+	for i, element := range elements {
+		converted, err := c.convertElement(params[0], element)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q, element %d: %w", typeName, value, i, err)
+		}
+
+		if i == 0 {
+			out = reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(converted)), 0, len(elements))
+		}
+		out = reflect.Append(out, reflect.ValueOf(converted))
+	}
+
+	return out.Interface(), nil
+}
+
+// convertMap converts a JSON object into a map of the map's key and value types
+func (c *clickhouseComposites) convertMap(typeName string, value string) (any, error) {
+	params := clickhouseTypeParams(typeName)
+	if len(params) != 2 {
+		return nil, fmt.Errorf("invalid map type %q", typeName)
+	}
+
+	var entries map[string]any
+	if err := decodeJSON(value, &entries); err != nil {
+		return nil, fmt.Errorf("invalid %s value %q, expected a JSON object: %w", typeName, value, err)
+	}
+
+	if len(entries) == 0 {
+		return map[string]any{}, nil
+	}
+
+	keys := maps.Keys(entries)
+	sort.Strings(keys)
+
+	var out reflect.Value
+	for i, key := range keys {
+		convertedKey, err := c.convertElement(params[0], key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q, key %q: %w", typeName, value, key, err)
+		}
+
+		convertedValue, err := c.convertElement(params[1], entries[key])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q, value of key %q: %w", typeName, value, key, err)
+		}
+
+		if i == 0 {
+			out = reflect.MakeMapWithSize(reflect.MapOf(reflect.TypeOf(convertedKey), reflect.TypeOf(convertedValue)), len(keys))
+		}
+		out.SetMapIndex(reflect.ValueOf(convertedKey), reflect.ValueOf(convertedValue))
+	}
+
+	return out.Interface(), nil
+}
+
+// convertElement converts an element of an array or a map decoded from JSON into the element type,
+// using the built-in converter of the type, the timestamp one or `convertToType` for scalar types.
+func (c *clickhouseComposites) convertElement(typeName string, element any) (any, error) {
+	var value string
+	switch v := element.(type) {
+	case nil:
+		return nil, fmt.Errorf("null elements are not supported")
+	case string:
+		value = v
+	case json.Number:
+		value = v.String()
+	case bool:
+		value = strconv.FormatBool(v)
+	default:
+		document, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		value = string(document)
+	}
+
+	typeName = unwrapClickhouseType(typeName)
+	key := valueConverterKeys(typeName)[0]
+	if converter, found := c.converters[key]; found {
+		return converter(typeName, value)
+	}
+
+	if timestampColumnTypes[key] {
+		return c.convertTimestamp(typeName, value)
+	}
+
+	if scalarType, found := clickhouseScalarTypes[key]; found {
+		converted, err := convertToType(value, scalarType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %w", typeName, value, err)
+		}
+		return converted, nil
+	}
+
+	return nil, fmt.Errorf("unsupported element type %q", typeName)
+}
+
+// clickhouseTypeParams returns the parameters of a type, like `String` and `Array(UInt64)` for
+// `Map(String, Array(UInt64))`.
+func clickhouseTypeParams(typeName string) (out []string) {
+	open := strings.IndexByte(typeName, '(')
+	if open == -1 || !strings.HasSuffix(typeName, ")") {
+		return nil
+	}
+
+	depth := 0
+	var param strings.Builder
+	for _, c := range typeName[open+1 : len(typeName)-1] {
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			out = append(out, strings.TrimSpace(param.String()))
+			param.Reset()
+			continue
+		}
+		param.WriteRune(c)
+	}
+
+	return append(out, strings.TrimSpace(param.String()))
+}
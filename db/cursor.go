@@ -24,7 +24,7 @@ type cursorRow struct {
 // GetAllCursors returns an unordered map given for each module's hash recorded
 // the active cursor for it.
 func (l *Loader) GetAllCursors(ctx context.Context) (out map[string]*sink.Cursor, err error) {
-	rows, err := l.DB.QueryContext(ctx, fmt.Sprintf("SELECT id, cursor, block_num, block_id from %s", l.cursorTable.identifier))
+	rows, err := l.DB.QueryContext(ctx, l.getDialect().GetAllCursorsQuery(l.cursorTable.identifier))
 	if err != nil {
 		return nil, fmt.Errorf("query all cursors: %w", err)
 	}
@@ -101,14 +101,8 @@ func cursorAtHighestBlock(in map[string]*sink.Cursor) (hash string, highest *sin
 }
 
 func (l *Loader) InsertCursor(ctx context.Context, moduleHash string, c *sink.Cursor) error {
-	query := fmt.Sprintf("INSERT INTO %s (id, cursor, block_num, block_id) values ('%s', '%s', %d, '%s')",
-		l.cursorTable.identifier,
-		moduleHash,
-		c,
-		c.Block().Num(),
-		c.Block().ID(),
-	)
-	if _, err := l.DB.ExecContext(ctx, query); err != nil {
+	query, args := l.getDialect().GetInsertCursorQuery(l.cursorTable.identifier, moduleHash, c, c.Block().Num(), c.Block().ID())
+	if _, err := l.DB.ExecContext(ctx, query, args...); err != nil {
 		return fmt.Errorf("insert cursor: %w", err)
 	}
 
@@ -120,16 +114,16 @@ func (l *Loader) InsertCursor(ctx context.Context, moduleHash string, c *sink.Cu
 // You can use tx=nil to run the query outside of a transaction.
 func (l *Loader) UpdateCursor(ctx context.Context, tx Tx, moduleHash string, c *sink.Cursor) error {
 	l.logger.Debug("updating cursor", zap.String("module_hash", moduleHash), zap.Stringer("cursor", c))
-	_, err := l.runModifiyQuery(ctx, tx, "update", l.getDialect().GetUpdateCursorQuery(
-		l.cursorTable.identifier, moduleHash, c, c.Block().Num(), c.Block().ID(),
-	))
+	query, args := l.getDialect().GetUpdateCursorQuery(l.cursorTable.identifier, moduleHash, c, c.Block().Num(), c.Block().ID())
+	_, err := l.runModifiyQuery(ctx, tx, "update", query, args...)
 	return err
 }
 
 // DeleteCursor deletes the active cursor for the given 'moduleHash'. If no cursor is active and
 // no delete occurrred, returns ErrCursorNotFound. If the delete was not successful on the database, returns an error.
 func (l *Loader) DeleteCursor(ctx context.Context, moduleHash string) error {
-	_, err := l.runModifiyQuery(ctx, nil, "delete", fmt.Sprintf("DELETE FROM %s WHERE id = '%s'", l.cursorTable.identifier, moduleHash))
+	query, args := l.getDialect().GetDeleteCursorQuery(l.cursorTable.identifier, moduleHash)
+	_, err := l.runModifiyQuery(ctx, nil, "delete", query, args...)
 	return err
 }
 
@@ -153,13 +147,13 @@ type sqlExecutor interface {
 //
 // If `tx` is nil, we use `l.DB` as the execution context, so an operations happening outside
 // a transaction. Otherwise, tx is the execution context.
-func (l *Loader) runModifiyQuery(ctx context.Context, tx Tx, action string, query string) (rowsAffected int64, err error) {
+func (l *Loader) runModifiyQuery(ctx context.Context, tx Tx, action string, query string, args ...any) (rowsAffected int64, err error) {
 	var executor sqlExecutor = l.DB
 	if tx != nil {
 		executor = tx
 	}
 
-	result, err := executor.ExecContext(ctx, query)
+	result, err := executor.ExecContext(ctx, query, args...)
 	if err != nil {
 		return 0, fmt.Errorf("%s cursor: %w", action, err)
 	}
@@ -38,6 +38,74 @@ type Loader struct {
 	entriesCount uint64
 	tables       map[string]*TableInfo
 	cursorTable  *TableInfo
+	statements   *preparedStatements
+
+	// dialect is set when the DSN scheme selects its own dialect, otherwise it's resolved from the driver
+	dialect dialect
+
+	// rowVersion is the last version given to a row of a ClickHouse `ReplacingMergeTree` table
+	rowVersion uint64
+
+	// fieldUpdateOps are the update operations of the columns which are not overwritten by updates
+	fieldUpdateOps FieldUpdateOps
+
+	// primaryKeySeparator splits single primary key strings of tables with a composite primary key
+	primaryKeySeparator string
+
+	// valueConverters are the value converters of the database types, see RegisterValueConverter
+	valueConverters ValueConverters
+
+	// timestampUnit is the unit of the unix timestamps of timestamp columns, detected when empty
+	timestampUnit TimestampUnit
+
+	// blockMetadataColumns are the names of the columns filled from the block of the changes, nil if disabled
+	blockMetadataColumns *BlockMetadataColumns
+
+	// softDeleteTables are the tables whose deletes are soft deletes, see SetSoftDeleteTables
+	softDeleteTables map[string]bool
+
+	// versionedTables are the versioned tables, see SetVersionedTables
+	versionedTables map[string]bool
+
+	// versions are the operations of the versioned tables, scheduled apart since they are not
+	// folded across blocks, see ScheduleVersion
+	versions *OrderedMap[string, *OrderedMap[string, *Operation]]
+
+	// bytesEncodings are the encodings of the binary columns, nil if they are all written as is
+	bytesEncodings *BytesEncodings
+
+	// changesLog is set when the operations are recorded in the changes table, see SetChangesLog
+	changesLog bool
+
+	// changes are the entries of the changes log not yet flushed
+	changes []*change
+
+	// blocksTable is set when the blocks applied are recorded in the blocks table, see SetBlocksTable
+	blocksTable bool
+
+	// blocks are the blocks applied since the last flush, recorded in the blocks table
+	blocks []*blockEntry
+
+	// currentBlock is the block of the changes being received, see SetCurrentBlock
+	currentBlock *BlockMetadata
+
+	// retentionWindows are the retention windows of the tables, keyed by table name, see SetRetentionWindows
+	retentionWindows map[string]*RetentionWindow
+
+	// retentionInterval is the minimum time between two prunings of the expired rows
+	retentionInterval time.Duration
+
+	// lastRetentionAt is the time the expired rows were last pruned at
+	lastRetentionAt time.Time
+
+	// retentionFailures are the consecutive failed prunings of the tables, keyed by table name
+	retentionFailures map[string]int
+
+	// rollups are the rollup tables maintained from their source table, see SetRollups
+	rollups []*rollup
+
+	// checkOldValues is set when the rows updated or deleted are checked to hold the old values of the changes
+	checkOldValues bool
 
 	handleReorgs       bool
 	flushInterval      time.Duration
@@ -72,12 +140,16 @@ func NewLoader(
 		database:           dsn.database,
 		schema:             dsn.schema,
 		entries:            NewOrderedMap[string, *OrderedMap[string, *Operation]](),
+		versions:           NewOrderedMap[string, *OrderedMap[string, *Operation]](),
 		tables:             map[string]*TableInfo{},
+		statements:         newPreparedStatements(db),
+		dialect:            schemeDialect[dsn.scheme],
 		flushInterval:      flushInterval,
 		moduleMismatchMode: moduleMismatchMode,
 		logger:             logger,
 		tracer:             tracer,
 	}
+
 	_, err = l.tryDialect()
 	if err != nil {
 		return nil, fmt.Errorf("dialect not found: %s", err)
@@ -113,7 +185,30 @@ type Tx interface {
 	Rollback() error
 	Commit() error
 	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
-	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryContext(ctx context.Context, query string, args ...any) (Rows, error)
+}
+
+// Rows are the rows returned by a query of a transaction, implemented by `*sql.Rows` and by the
+// rows of the native pgx API.
+type Rows interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+	Close() error
+}
+
+// sqlTx adapts a `database/sql` transaction to the `Tx` interface
+type sqlTx struct {
+	*sql.Tx
+}
+
+func (t *sqlTx) QueryContext(ctx context.Context, query string, args ...any) (Rows, error) {
+	rows, err := t.Tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
 }
 
 func (l *Loader) Begin() (Tx, error) {
@@ -124,7 +219,15 @@ func (l *Loader) BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
 	if l.testTx != nil {
 		return l.testTx, nil
 	}
-	return l.DB.BeginTx(ctx, opts)
+	if beginner, ok := l.getDialect().(txBeginner); ok {
+		return beginner.BeginTx(ctx, l.DB, opts)
+	}
+
+	tx, err := l.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{Tx: tx}, nil
 }
 
 func (l *Loader) FlushInterval() time.Duration {
@@ -132,16 +235,34 @@ func (l *Loader) FlushInterval() time.Duration {
 }
 
 func (l *Loader) LoadTables() error {
-	schemaTables, err := schema.Tables(l.DB)
+	dialect := l.getDialect()
+
+	tables, primaryKey := schema.Tables, schema.PrimaryKey
+	if introspector, ok := dialect.(schemaIntrospector); ok {
+		tables, primaryKey = introspector.Tables, introspector.PrimaryKey
+	}
+
+	schemaTables, err := tables(l.DB)
 	if err != nil {
 		return fmt.Errorf("retrieving table and schema: %w", err)
 	}
 
+	var enumLabels map[string]map[string][]string
+	if introspector, ok := dialect.(enumIntrospector); ok {
+		if enumLabels, err = introspector.EnumLabels(l.DB, l.schema); err != nil {
+			return fmt.Errorf("retrieving enum labels: %w", err)
+		}
+	}
+
 	seenCursorTable := false
 	seenHistoryTable := false
 	for schemaTableName, columns := range schemaTables {
 		schemaName := schemaTableName[0]
 		tableName := schemaTableName[1]
+		if schemaName == "" {
+			// SQLite has no schema, its tables are all part of the one we are connected to
+			schemaName = l.schema
+		}
 		l.logger.Debug("processing schema's table",
 			zap.String("schema_name", schemaName),
 			zap.String("table_name", tableName),
@@ -166,28 +287,33 @@ func (l *Loader) LoadTables() error {
 		for _, f := range columns {
 			columnByName[f.Name()] = &ColumnInfo{
 				name:             f.Name(),
-				escapedName:      EscapeIdentifier(f.Name()),
+				escapedName:      dialect.EscapeIdentifier(f.Name()),
 				databaseTypeName: f.DatabaseTypeName(),
 				scanType:         f.ScanType(),
+				enumLabels:       enumLabels[tableName][f.Name()],
 			}
 		}
 
-		key, err := schema.PrimaryKey(l.DB, schemaName, tableName)
+		key, err := primaryKey(l.DB, schemaName, tableName)
 		if err != nil {
 			return fmt.Errorf("get primary key: %w", err)
 		}
 
-		l.tables[tableName], err = NewTableInfo(schemaName, tableName, key, columnByName)
+		l.tables[tableName], err = newTableInfo(dialect.EscapeIdentifier, schemaName, tableName, key, columnByName)
 		if err != nil {
 			return fmt.Errorf("invalid table: %w", err)
 		}
+		l.resolveValueConversions(l.tables[tableName])
+		l.resolveBlockMetadataColumns(l.tables[tableName])
+		l.resolveSoftDelete(l.tables[tableName])
+		l.resolveVersioned(l.tables[tableName])
 	}
 
 	if !seenCursorTable {
-		return &SystemTableError{fmt.Errorf(`%s.%s table is not found`, EscapeIdentifier(l.schema), CURSORS_TABLE)}
+		return &SystemTableError{fmt.Errorf(`%s.%s table is not found`, dialect.EscapeIdentifier(l.schema), CURSORS_TABLE)}
 	}
 	if l.handleReorgs && !seenHistoryTable {
-		return &SystemTableError{fmt.Errorf("%s.%s table is not found and reorgs handling is enabled.", EscapeIdentifier(l.schema), HISTORY_TABLE)}
+		return &SystemTableError{fmt.Errorf("%s.%s table is not found and reorgs handling is enabled.", dialect.EscapeIdentifier(l.schema), HISTORY_TABLE)}
 	}
 
 	l.cursorTable = l.tables[CURSORS_TABLE]
@@ -211,7 +337,7 @@ func (l *Loader) validateCursorTables(columns []*sql.ColumnType) (err error) {
 			return &SystemTableError{fmt.Errorf("unexpected column %q in cursors table", columnName)}
 		}
 		expectedType := columnsCheck[columnName]
-		actualType := f.ScanType().Kind().String()
+		actualType := baseScanType(f.ScanType()).Kind().String()
 		if expectedType != actualType {
 			return &SystemTableError{fmt.Errorf("column %q has invalid type, expected %q has %q", columnName, expectedType, actualType)}
 		}
@@ -298,6 +424,12 @@ func (l *Loader) Setup(ctx context.Context, schemaSql string, withPostgraphile b
 		return fmt.Errorf("setup history table: %w", err)
 	}
 
+	if validator, ok := l.getDialect().(setupValidator); ok && l.handleReorgs {
+		if err := validator.ValidateSetup(ctx, l); err != nil {
+			return fmt.Errorf("validate setup: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -322,6 +454,10 @@ func (l *Loader) getDialect() dialect {
 }
 
 func (l *Loader) tryDialect() (dialect, error) {
+	if l.dialect != nil {
+		return l.dialect, nil
+	}
+
 	dt := fmt.Sprintf("%T", l.DB.Driver())
 	d, ok := driverDialect[dt]
 	if !ok {
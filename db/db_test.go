@@ -0,0 +1,160 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoaderSetters(t *testing.T) {
+	tests := []struct {
+		name      string
+		tables    map[string]*TableInfo
+		set       func(l *Loader) error
+		expectErr string
+	}{
+		{
+			name:      "soft delete unknown table",
+			set:       func(l *Loader) error { return l.SetSoftDeleteTables([]string{"unknown"}) },
+			expectErr: `unknown table "unknown"`,
+		},
+		{
+			name:      "soft delete table without soft delete columns",
+			set:       func(l *Loader) error { return l.SetSoftDeleteTables([]string{"xfer"}) },
+			expectErr: `table "xfer" has neither a "_deleted_at" nor a "_deleted_block" column`,
+		},
+		{
+			name: "soft delete table",
+			set:  func(l *Loader) error { return l.SetSoftDeleteTables([]string{"tokens"}) },
+		},
+		{
+			name:      "versioned unknown table",
+			set:       func(l *Loader) error { return l.SetVersionedTables([]string{"unknown"}) },
+			expectErr: `unknown table "unknown"`,
+		},
+		{
+			name:      "versioned table without validity columns",
+			set:       func(l *Loader) error { return l.SetVersionedTables([]string{"xfer"}) },
+			expectErr: `versioned table "xfer" has no "valid_from_block" column`,
+		},
+		{
+			name:      "versioned table without validity in its primary key",
+			set:       func(l *Loader) error { return l.SetVersionedTables([]string{"unkeyed"}) },
+			expectErr: `the primary key of versioned table "unkeyed" must be made of the row's primary key and of the "valid_from_block" column`,
+		},
+		{
+			name: "versioned table",
+			set:  func(l *Loader) error { return l.SetVersionedTables([]string{"owners"}) },
+		},
+		{
+			name:      "changes log without table",
+			tables:    TestTables("testschema"),
+			set:       func(l *Loader) error { return l.SetChangesLog(true) },
+			expectErr: `"testschema"."substreams_changes" table is not found and the changes log is enabled`,
+		},
+		{
+			name:   "changes log disabled without table",
+			tables: TestTables("testschema"),
+			set:    func(l *Loader) error { return l.SetChangesLog(false) },
+		},
+		{
+			name: "changes log",
+			set:  func(l *Loader) error { return l.SetChangesLog(true) },
+		},
+		{
+			name:      "blocks table missing",
+			tables:    TestTables("testschema"),
+			set:       func(l *Loader) error { return l.SetBlocksTable(true) },
+			expectErr: `"testschema"."substreams_blocks" table is not found and the blocks table is enabled`,
+		},
+		{
+			name:   "blocks table disabled without table",
+			tables: TestTables("testschema"),
+			set:    func(l *Loader) error { return l.SetBlocksTable(false) },
+		},
+		{
+			name: "blocks table",
+			set:  func(l *Loader) error { return l.SetBlocksTable(true) },
+		},
+		{
+			name: "retention window unknown table",
+			set: func(l *Loader) error {
+				return l.SetRetentionWindows([]*RetentionWindow{{Table: "unknown", Column: "id", Blocks: 10}}, 0)
+			},
+			expectErr: `unknown table "unknown"`,
+		},
+		{
+			name: "retention window unknown column",
+			set: func(l *Loader) error {
+				return l.SetRetentionWindows([]*RetentionWindow{{Table: "trades", Column: "unknown", Blocks: 10}}, 0)
+			},
+			expectErr: `unknown column "unknown" in table "trades"`,
+		},
+		{
+			name: "retention window system table",
+			set: func(l *Loader) error {
+				return l.SetRetentionWindows([]*RetentionWindow{{Table: CURSORS_TABLE, Column: "block_num", Blocks: 10}}, 0)
+			},
+			expectErr: `system table "cursors" cannot have a retention window`,
+		},
+		{
+			name: "rollup unknown source table",
+			set: func(l *Loader) error {
+				rollup := tradesHourlyRollup()
+				rollup.Source = "unknown"
+				return l.SetRollups([]*Rollup{rollup})
+			},
+			expectErr: `rollup "trades_hourly": unknown source table "unknown"`,
+		},
+		{
+			name: "rollup text bucket column",
+			set: func(l *Loader) error {
+				rollup := tradesHourlyRollup()
+				rollup.Bucket.Column = "pair"
+				return l.SetRollups([]*Rollup{rollup})
+			},
+			expectErr: `rollup "trades_hourly": bucket column "pair" of table "trades" is not a timestamp`,
+		},
+		{
+			name: "rollup unknown measure column",
+			set: func(l *Loader) error {
+				rollup := tradesHourlyRollup()
+				rollup.Measures[0].Source = "price"
+				return l.SetRollups([]*Rollup{rollup})
+			},
+			expectErr: `rollup "trades_hourly": unknown column "price" in table "trades"`,
+		},
+		{
+			name: "rollup primary key without group by columns",
+			set: func(l *Loader) error {
+				rollup := tradesHourlyRollup()
+				rollup.GroupBy = nil
+				return l.SetRollups([]*Rollup{rollup})
+			},
+			expectErr: `rollup "trades_hourly": the primary key of table "trades_hourly" must be made of the bucket column and of the group by columns`,
+		},
+		{
+			name: "rollup",
+			set:  func(l *Loader) error { return l.SetRollups([]*Rollup{tradesHourlyRollup()}) },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tables := test.tables
+			if tables == nil {
+				tables = featureTestTables("testschema")
+			}
+
+			l, _ := NewTestLoader(zlog, tracer, "testschema", tables)
+			err := test.set(l)
+			if test.expectErr != "" {
+				assert.ErrorContains(t, err, test.expectErr)
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 
 	sink "github.com/streamingfast/substreams-sink"
@@ -17,18 +18,61 @@ func (e UnknownDriverError) Error() string {
 }
 
 type dialect interface {
+	EscapeIdentifier(valueToEscape string) string
 	GetCreateCursorQuery(schema string, withPostgraphile bool) string
 	GetCreateHistoryQuery(schema string, withPostgraphile bool) string
 	ExecuteSetupScript(ctx context.Context, l *Loader, schemaSql string) error
 	DriverSupportRowsAffected() bool
-	GetUpdateCursorQuery(table, moduleHash string, cursor *sink.Cursor, block_num uint64, block_id string) string
-	ParseDatetimeNormalization(value string) string
+	GetAllCursorsQuery(table string) string
+	GetInsertCursorQuery(table, moduleHash string, cursor *sink.Cursor, block_num uint64, block_id string) (string, []any)
+	GetUpdateCursorQuery(table, moduleHash string, cursor *sink.Cursor, block_num uint64, block_id string) (string, []any)
+	GetDeleteCursorQuery(table, moduleHash string) (string, []any)
 	Flush(tx Tx, ctx context.Context, l *Loader, outputModuleHash string, lastFinalBlock uint64) (int, error)
 	Revert(tx Tx, ctx context.Context, l *Loader, lastValidFinalBlock uint64) error
 	OnlyInserts() bool
 }
 
+// schemaIntrospector is implemented by the dialects whose database is not supported by
+// `github.com/jimsmart/schema`, `LoadTables` then uses it to list the tables and their primary key.
+type schemaIntrospector interface {
+	Tables(db *sql.DB) (map[[2]string][]*sql.ColumnType, error)
+	PrimaryKey(db *sql.DB, schema, table string) ([]string, error)
+}
+
+// enumIntrospector is implemented by the dialects supporting enum types, `LoadTables` then uses it
+// to retrieve the labels of the enum columns of the schema, keyed by table and column name.
+type enumIntrospector interface {
+	EnumLabels(db *sql.DB, schema string) (map[string]map[string][]string, error)
+}
+
+// txBeginner is implemented by the dialects requiring their own kind of transaction
+type txBeginner interface {
+	BeginTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions) (Tx, error)
+}
+
+// txRetrier is implemented by the dialects whose transactions can fail because of contention,
+// a transaction failing with an error for which it returns true is attempted again from the start.
+type txRetrier interface {
+	RetryableTxError(err error) bool
+}
+
+// setupValidator is implemented by the dialects which can only handle reorgs for tables defined
+// in a certain way, `Setup` then uses it to validate the tables once created.
+type setupValidator interface {
+	ValidateSetup(ctx context.Context, l *Loader) error
+}
+
+// schemeDialect maps the DSN schemes which select a dialect of their own, the database being
+// reached through the driver of another one, `driverDialect` is used for the other schemes.
+var schemeDialect = map[string]dialect{
+	"cockroach": cockroachDialect{}, // github.com/lib/pq
+}
+
 var driverDialect = map[string]dialect{
 	"*pq.Driver":            postgresDialect{},   // github.com/lib/pq
+	"*stdlib.Driver":        pgxDialect{},        // github.com/jackc/pgx/v4/stdlib
+	"*mysql.MySQLDriver":    mysqlDialect{},      // github.com/go-sql-driver/mysql
+	"*sqlite3.SQLiteDriver": sqliteDialect{},     // github.com/mattn/go-sqlite3
+	"duckdb.Driver":         duckdbDialect{},     // github.com/marcboeker/go-duckdb
 	"*clickhouse.stdDriver": clickhouseDialect{}, // github.com/clickhouse-go/v2
 }
@@ -8,13 +8,13 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"time"
 
 	_ "github.com/ClickHouse/clickhouse-go/v2"
 
 	"github.com/streamingfast/cli"
 	sink "github.com/streamingfast/substreams-sink"
 	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
 )
 
 type clickhouseDialect struct{}
@@ -22,66 +22,488 @@ type clickhouseDialect struct{}
 // Clickhouse should be used to insert a lot of data in batches. The current official clickhouse
 // driver doesn't support Transactions for multiple tables. The only way to add in batches is
 // creating a transaction for a table, adding all rows and commiting it.
+//
+// Updates, upserts and deletes are supported for tables using the
+// `ReplacingMergeTree(version, is_deleted)` engine, they are turned into inserts of the full row with a higher version, the deleted column
+// being set for deletes. The current value of the rows is read first so that updates setting only
+// some columns keep the others.
+//
+// Reorgs are handled for tables using the `CollapsingMergeTree` or `VersionedCollapsingMergeTree`
+// engine, the sink sets the sign column of the rows it inserts and records the rows of reversible
+// blocks in the history table. Reverting a block inserts back its rows with a negative sign which
+// cancels them out. On `ReplacingMergeTree` tables, the previous value of the rows is inserted
+// back with a higher version instead, inserted rows being deleted. The history of the flushed
+// operations is written before their rows, each table's rows then being inserted in their own
+// batch.
 func (d clickhouseDialect) Flush(tx Tx, ctx context.Context, l *Loader, outputModuleHash string, lastFinalBlock uint64) (int, error) {
+	engines, err := clickhouseTableEngines(ctx, l)
+	if err != nil {
+		return 0, err
+	}
+
 	var entryCount int
+	var history []*historyRow
+	tableRows := NewOrderedMap[string, []map[string]string]()
 	for entriesPair := l.entries.Oldest(); entriesPair != nil; entriesPair = entriesPair.Next() {
 		tableName := entriesPair.Key
 		entries := entriesPair.Value
-		tx, err := l.DB.BeginTx(ctx, nil)
-		if err != nil {
-			return entryCount, fmt.Errorf("failed to begin db transaction")
-		}
 
 		if l.tracer.Enabled() {
 			l.logger.Debug("flushing table entries", zap.String("table_name", tableName), zap.Int("entry_count", entries.Len()))
 		}
-		info := l.tables[tableName]
-		columns := make([]string, 0, len(info.columnsByName))
-		for column := range info.columnsByName {
-			columns = append(columns, column)
-		}
-		sort.Strings(columns)
-		query := fmt.Sprintf(
-			"INSERT INTO %s.%s (%s)",
-			EscapeIdentifier(l.schema),
-			EscapeIdentifier(tableName),
-			strings.Join(columns, ","))
-		batch, err := tx.Prepare(query)
+
+		engine, found := engines[tableName]
+		if !found {
+			return entryCount, fmt.Errorf("table %q not found in %q", tableName, l.schema)
+		}
+
+		currentRows, err := d.currentRows(ctx, l, engine, entries)
 		if err != nil {
-			return entryCount, fmt.Errorf("failed to prepare insert into %q: %w", tableName, err)
+			return entryCount, err
 		}
+
+		rows := make([]map[string]string, 0, entries.Len())
 		for entryPair := entries.Oldest(); entryPair != nil; entryPair = entryPair.Next() {
 			entry := entryPair.Value
 
-			if err != nil {
-				return entryCount, fmt.Errorf("failed to get query: %w", err)
-			}
+			var row, prevRow map[string]string
+			historyOp := "I"
+			switch entry.opType {
+			case OperationTypeInsert:
+				row = d.insertRow(l, entry, engine)
+				prevRow = row
 
-			if l.tracer.Enabled() {
-				l.logger.Debug("adding query from operation to transaction", zap.Stringer("op", entry), zap.String("query", query))
-			}
+			case OperationTypeUpsert:
+				current, found := currentRows[entryPair.Key]
+				data, err := foldFieldUpdates(current, entry.data, entry.fieldOps)
+				if err != nil {
+					return entryCount, fmt.Errorf("applying field update operations of %s: %w", entry, err)
+				}
 
-			values, err := convertOpToClickhouseValues(entry)
-			if err != nil {
-				return entryCount, fmt.Errorf("failed to get values: %w", err)
+				row = d.replacingRow(l, current, data, engine.versionColumn(), engine.isDeletedColumn(), false)
+				prevRow = row
+				if found {
+					prevRow, historyOp = current, "U"
+				}
+
+			case OperationTypeReplace:
+				// The row is written again as a whole, columns not set by the data get their default value
+				current, found := currentRows[entryPair.Key]
+				row = d.replacingRow(l, nil, entry.data, engine.versionColumn(), engine.isDeletedColumn(), false)
+				prevRow = row
+				if found {
+					prevRow, historyOp = current, "U"
+				}
+
+			case OperationTypeUpdate, OperationTypeDelete:
+				current, found := currentRows[entryPair.Key]
+				if !found {
+					// Like in SQL, updating or deleting a row which does not exist does nothing
+					if l.tracer.Enabled() {
+						l.logger.Debug("skipping operation on row not found", zap.Stringer("op", entry))
+					}
+					continue
+				}
+
+				data, err := foldFieldUpdates(current, entry.data, entry.fieldOps)
+				if err != nil {
+					return entryCount, fmt.Errorf("applying field update operations of %s: %w", entry, err)
+				}
+
+				deleted := entry.opType == OperationTypeDelete
+				row = d.replacingRow(l, current, data, engine.versionColumn(), engine.isDeletedColumn(), deleted)
+				prevRow = current
+
+				historyOp = "U"
+				if deleted {
+					historyOp = "D"
+				}
+
+			default:
+				panic(fmt.Errorf("unknown operation type %q", entry.opType))
 			}
 
-			if _, err := batch.ExecContext(ctx, values...); err != nil {
-				return entryCount, fmt.Errorf("executing for entry %q: %w", values, err)
+			if entry.reversibleBlockNum != nil && l.handleReorgs {
+				if !engine.reversible() {
+					return entryCount, fmt.Errorf("table %q uses the %s engine which cannot revert rows, handling reorgs requires a CollapsingMergeTree, VersionedCollapsingMergeTree or ReplacingMergeTree(version, is_deleted) engine", tableName, engine.name)
+				}
+
+				history = append(history, &historyRow{
+					op:        historyOp,
+					tableName: tableName,
+					pk:        primaryKeyToJSON(entry.primaryKey),
+					prevValue: rowToJSON(prevRow),
+					blockNum:  *entry.reversibleBlockNum,
+				})
 			}
+
+			rows = append(rows, row)
+		}
+
+		tableRows.Set(tableName, rows)
+	}
+
+	if l.handleReorgs {
+		// The history is written first, rows committed without it could not be reverted
+		if err := d.saveHistory(ctx, l, history); err != nil {
+			return entryCount, err
 		}
+	}
+
+	for pair := tableRows.Oldest(); pair != nil; pair = pair.Next() {
+		if err := d.insertRows(ctx, l, l.tables[pair.Key], pair.Value); err != nil {
+			return entryCount, err
+		}
+		entryCount += len(pair.Value)
+	}
 
-		if err := tx.Commit(); err != nil {
-			return entryCount, fmt.Errorf("failed to commit db transaction: %w", err)
+	if l.handleReorgs {
+		if err := d.pruneHistory(ctx, l, lastFinalBlock); err != nil {
+			return entryCount, err
 		}
-		entryCount += entries.Len()
 	}
 
 	return entryCount, nil
 }
 
+// currentRows returns the current value of the rows updated, upserted, replaced or deleted by the operations
+// keyed by their unique id, the table must use the `ReplacingMergeTree` engine if there is any.
+func (d clickhouseDialect) currentRows(ctx context.Context, l *Loader, engine *clickhouseEngine, entries *OrderedMap[string, *Operation]) (map[string]map[string]string, error) {
+	var table *TableInfo
+	var primaryKeys []map[string]string
+	for entryPair := entries.Oldest(); entryPair != nil; entryPair = entryPair.Next() {
+		entry := entryPair.Value
+		if entry.opType == OperationTypeInsert {
+			continue
+		}
+
+		if !engine.isReplacing() {
+			return nil, fmt.Errorf("%s operation on table %q requires the ReplacingMergeTree engine, got %s", strings.ToLower(string(entry.opType)), entry.table.name, engine.name)
+		}
+		if entry.opType == OperationTypeDelete && engine.isDeletedColumn() == "" {
+			return nil, fmt.Errorf("delete operation on table %q requires the ReplacingMergeTree engine to define its is_deleted column", entry.table.name)
+		}
+
+		table = entry.table
+		primaryKeys = append(primaryKeys, entry.primaryKey)
+	}
+
+	if len(primaryKeys) == 0 {
+		return nil, nil
+	}
+
+	return clickhouseCurrentRows(ctx, l, table, engine, primaryKeys)
+}
+
+// insertRow returns the row inserted for the operation, its data with the sign and version
+// columns of collapsing engines set if the data does not set them already. On `ReplacingMergeTree`
+// tables, the version is always set and the row marked as not deleted.
+func (d clickhouseDialect) insertRow(l *Loader, o *Operation, engine *clickhouseEngine) map[string]string {
+	if engine.isReplacing() {
+		return d.replacingRow(l, nil, o.data, engine.versionColumn(), engine.isDeletedColumn(), false)
+	}
+
+	signColumn, versionColumn := engine.signColumn(), engine.versionColumn()
+	if signColumn == "" {
+		return o.data
+	}
+
+	row := make(map[string]string, len(o.data)+2)
+	for column, value := range o.data {
+		row[column] = value
+	}
+
+	row[signColumn] = "1"
+	if _, found := row[versionColumn]; versionColumn != "" && !found {
+		// The version only needs to be the same for a row and its cancelling row
+		row[versionColumn] = "0"
+		if o.reversibleBlockNum != nil {
+			row[versionColumn] = strconv.FormatUint(*o.reversibleBlockNum, 10)
+		}
+	}
+
+	return row
+}
+
+// replacingRow returns the row to insert in a `ReplacingMergeTree` table, the current row with the
+// data merged in and the next version, the deleted column is set if defined.
+func (d clickhouseDialect) replacingRow(l *Loader, current, data map[string]string, versionColumn, deletedColumn string, deleted bool) map[string]string {
+	row := make(map[string]string, len(current)+len(data)+2)
+	for column, value := range current {
+		row[column] = value
+	}
+	for column, value := range data {
+		row[column] = value
+	}
+
+	if versionColumn != "" {
+		row[versionColumn] = strconv.FormatUint(l.nextRowVersion(), 10)
+	}
+	if deletedColumn != "" {
+		row[deletedColumn] = "0"
+		if deleted {
+			row[deletedColumn] = "1"
+		}
+	}
+
+	return row
+}
+
+// insertRows inserts the rows in the table using a single batch, every column of the table must be set
+func (d clickhouseDialect) insertRows(ctx context.Context, l *Loader, info *TableInfo, rows []map[string]string) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	tx, err := l.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin db transaction")
+	}
+	defer tx.Rollback()
+
+	columns := make([]string, 0, len(info.columnsByName))
+	for column := range info.columnsByName {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	query := fmt.Sprintf(
+		"INSERT INTO %s (%s)",
+		info.identifier,
+		strings.Join(columns, ","))
+	batch, err := tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert into %q: %w", info.name, err)
+	}
+
+	for _, row := range rows {
+		if l.tracer.Enabled() {
+			l.logger.Debug("adding row to transaction", zap.String("table_name", info.name), zap.String("query", query))
+		}
+
+		values, err := convertRowToClickhouseValues(info, columns, row)
+		if err != nil {
+			return fmt.Errorf("failed to get values: %w", err)
+		}
+
+		if _, err := batch.ExecContext(ctx, values...); err != nil {
+			return fmt.Errorf("executing for entry %q: %w", values, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit db transaction: %w", err)
+	}
+	return nil
+}
+
+// saveHistory records the rows of reversible blocks in the history table
+func (d clickhouseDialect) saveHistory(ctx context.Context, l *Loader, history []*historyRow) error {
+	if len(history) == 0 {
+		return nil
+	}
+
+	tx, err := l.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin db transaction")
+	}
+	defer tx.Rollback()
+
+	batch, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (op, table_name, pk, prev_value, block_num)", d.historyTable(l.schema)))
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert into history: %w", err)
+	}
+
+	for _, row := range history {
+		if _, err := batch.ExecContext(ctx, row.op, row.tableName, row.pk, row.prevValue, row.blockNum); err != nil {
+			return fmt.Errorf("executing history insert: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit history: %w", err)
+	}
+	return nil
+}
+
+// pruneHistory deletes the history of the blocks that are now final, deletes being expensive
+// on ClickHouse, they are only issued when there is something to delete.
+func (d clickhouseDialect) pruneHistory(ctx context.Context, l *Loader, lastFinalBlock uint64) error {
+	var count uint64
+	countQuery := fmt.Sprintf("SELECT count() FROM %s WHERE block_num <= ?", d.historyTable(l.schema))
+	if err := l.DB.QueryRowContext(ctx, countQuery, lastFinalBlock).Scan(&count); err != nil {
+		return fmt.Errorf("counting final history rows: %w", err)
+	}
+
+	if count == 0 {
+		return nil
+	}
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE block_num <= ?", d.historyTable(l.schema))
+	if _, err := l.DB.ExecContext(ctx, query, lastFinalBlock); err != nil {
+		return fmt.Errorf("executing prune query %q: %w", query, err)
+	}
+	return nil
+}
+
+// Revert inserts the rows cancelling the ones of the reverted blocks then deletes their history.
+// The ClickHouse driver has no transaction spanning several statements, `tx` is not used and the
+// steps are not atomic: if the history deletion fails after the cancelling rows were inserted,
+// reverting again inserts them a second time.
 func (d clickhouseDialect) Revert(tx Tx, ctx context.Context, l *Loader, lastValidFinalBlock uint64) error {
-	return fmt.Errorf("clickhouse driver does not support reorg management.")
+	query := fmt.Sprintf(`SELECT op,table_name,pk,prev_value,block_num FROM %s WHERE block_num > ? ORDER BY block_num DESC`,
+		d.historyTable(l.schema),
+	)
+
+	rows, err := l.DB.QueryContext(ctx, query, lastValidFinalBlock)
+	if err != nil {
+		return err
+	}
+
+	history, err := readHistoryRows(rows)
+	rows.Close()
+	if err != nil {
+		return fmt.Errorf("iterating on rows from query %q: %w", query, err)
+	}
+
+	engines, err := clickhouseTableEngines(ctx, l)
+	if err != nil {
+		return err
+	}
+
+	l.logger.Info("reverting forked block block(s)", zap.Uint64("last_valid_final_block", lastValidFinalBlock))
+	cancellingRows := NewOrderedMap[string, []map[string]string]()
+	for _, row := range history {
+		l.logger.Debug("reverting", zap.String("operation", row.op), zap.String("table_name", row.tableName), zap.String("pk", row.pk), zap.Uint64("block_num", row.blockNum))
+
+		engine, found := engines[row.tableName]
+		if !found || !engine.reversible() {
+			return fmt.Errorf("table %q does not use a reversible engine anymore, cannot revert its rows", row.tableName)
+		}
+
+		prevRow, err := jsonToRow(row.prevValue)
+		if err != nil {
+			return fmt.Errorf("revert row of %q: %w", row.tableName, err)
+		}
+
+		var cancellingRow map[string]string
+		switch {
+		case row.op == "I" && engine.isCollapsing():
+			prevRow[engine.signColumn()] = "-1"
+			cancellingRow = prevRow
+		case row.op == "I":
+			cancellingRow = d.replacingRow(l, prevRow, nil, engine.versionColumn(), engine.isDeletedColumn(), true)
+		case (row.op == "U" || row.op == "D") && engine.isReplacing():
+			cancellingRow = d.replacingRow(l, prevRow, nil, engine.versionColumn(), engine.isDeletedColumn(), false)
+		default:
+			return fmt.Errorf("invalid op %q in revert command for table %q using the %s engine", row.op, row.tableName, engine.name)
+		}
+
+		tableRows, _ := cancellingRows.Get(row.tableName)
+		cancellingRows.Set(row.tableName, append(tableRows, cancellingRow))
+	}
+
+	for pair := cancellingRows.Oldest(); pair != nil; pair = pair.Next() {
+		info, found := l.tables[pair.Key]
+		if !found {
+			return fmt.Errorf("unknown table %q", pair.Key)
+		}
+
+		if err := d.insertRows(ctx, l, info, pair.Value); err != nil {
+			return fmt.Errorf("cancelling rows of %q: %w", pair.Key, err)
+		}
+	}
+
+	pruneHistory := fmt.Sprintf(`DELETE FROM %s WHERE block_num > ?`, d.historyTable(l.schema))
+	if _, err := l.DB.ExecContext(ctx, pruneHistory, lastValidFinalBlock); err != nil {
+		return fmt.Errorf("executing pruneHistory: %w", err)
+	}
+	return nil
+}
+
+// ValidateSetup checks the engine of the schema's tables. Tables on a collapsing engine must have
+// an `Int8` sign column, and a version column for `VersionedCollapsingMergeTree`. Tables on the
+// `ReplacingMergeTree` engine must have a `UInt64` version column and an `UInt8` deleted column.
+// Tables on other engines cannot have their rows reverted, a warning is emitted as those can only
+// be used with reorgs handling disabled.
+func (d clickhouseDialect) ValidateSetup(ctx context.Context, l *Loader) error {
+	engines, err := clickhouseTableEngines(ctx, l)
+	if err != nil {
+		return err
+	}
+
+	tables := maps.Keys(engines)
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		if table == CURSORS_TABLE || table == HISTORY_TABLE {
+			continue
+		}
+
+		engine := engines[table]
+		if !engine.reversible() {
+			l.logger.Warn("table engine cannot revert rows, reorgs must be avoided using an undo buffer (flag '--undo-buffer-size') or the table must use the CollapsingMergeTree, VersionedCollapsingMergeTree or ReplacingMergeTree(version, is_deleted) engine",
+				zap.String("table_name", table),
+				zap.String("engine", engine.name),
+			)
+		}
+
+		if signColumn := engine.signColumn(); signColumn != "" {
+			if err := d.validateColumnType(ctx, l, table, "sign", signColumn, "Int8"); err != nil {
+				return err
+			}
+		}
+
+		if versionColumn := engine.versionColumn(); versionColumn != "" {
+			expectedType := ""
+			if engine.isReplacing() {
+				expectedType = "UInt64"
+			}
+
+			if err := d.validateColumnType(ctx, l, table, "version", versionColumn, expectedType); err != nil {
+				return err
+			}
+		}
+
+		if deletedColumn := engine.isDeletedColumn(); deletedColumn != "" {
+			if err := d.validateColumnType(ctx, l, table, "is_deleted", deletedColumn, "UInt8"); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateColumnType checks the column exists and, if expectedType is not empty, that it has this type
+func (d clickhouseDialect) validateColumnType(ctx context.Context, l *Loader, table, role, column, expectedType string) error {
+	columnType, err := clickhouseColumnType(ctx, l, table, column)
+	if err != nil {
+		return err
+	}
+
+	if columnType == "" {
+		return fmt.Errorf("table %q %s column %q not found", table, role, column)
+	}
+	if expectedType != "" && columnType != expectedType {
+		return fmt.Errorf("table %q %s column %q must be of type %s, got %q", table, role, column, expectedType, columnType)
+	}
+	return nil
+}
+
+// retentionTTLQuery returns the query setting the TTL of the table, expiring its rows once the
+// window elapsed since the timestamp of their column. A TTL cannot be expressed in blocks.
+func (d clickhouseDialect) retentionTTLQuery(table *TableInfo, column *ColumnInfo, window *RetentionWindow) (string, error) {
+	if window.Duration == 0 {
+		return "", fmt.Errorf("retention windows in blocks are not supported by ClickHouse, use a duration against a timestamp column")
+	}
+
+	expiry := column.escapedName
+	if isIntegerColumn(column) {
+		// Timestamps stored as integers are in seconds
+		expiry = "toDateTime(" + expiry + ")"
+	}
+
+	return fmt.Sprintf("ALTER TABLE %s MODIFY TTL %s + INTERVAL %d SECOND", table.identifier, expiry, int64(window.Duration.Seconds())), nil
 }
 
 func (d clickhouseDialect) GetCreateCursorQuery(schema string, withPostgraphile bool) string {
@@ -98,7 +520,21 @@ func (d clickhouseDialect) GetCreateCursorQuery(schema string, withPostgraphile
 }
 
 func (d clickhouseDialect) GetCreateHistoryQuery(schema string, withPostgraphile bool) string {
-	panic("clickhouse does not support reorg management")
+	_ = withPostgraphile // TODO: see if this can work
+	return fmt.Sprintf(cli.Dedent(`
+	CREATE TABLE IF NOT EXISTS %s
+	(
+		op          String,
+		table_name  String,
+		pk          String,
+		prev_value  String,
+		block_num   UInt64
+	) Engine = MergeTree() ORDER BY block_num;
+	`), d.historyTable(schema))
+}
+
+func (d clickhouseDialect) historyTable(schema string) string {
+	return fmt.Sprintf("%s.%s", EscapeIdentifier(schema), EscapeIdentifier(HISTORY_TABLE))
 }
 
 func (d clickhouseDialect) ExecuteSetupScript(ctx context.Context, l *Loader, schemaSql string) error {
@@ -113,14 +549,30 @@ func (d clickhouseDialect) ExecuteSetupScript(ctx context.Context, l *Loader, sc
 	return nil
 }
 
-func (d clickhouseDialect) GetUpdateCursorQuery(table, moduleHash string, cursor *sink.Cursor, block_num uint64, block_id string) string {
+func (d clickhouseDialect) EscapeIdentifier(valueToEscape string) string {
+	return EscapeIdentifier(valueToEscape)
+}
+
+func (d clickhouseDialect) GetAllCursorsQuery(table string) string {
+	return fmt.Sprintf("SELECT id, cursor, block_num, block_id FROM %s", table)
+}
+
+func (d clickhouseDialect) GetInsertCursorQuery(table, moduleHash string, cursor *sink.Cursor, block_num uint64, block_id string) (string, []any) {
 	return query(`
-			INSERT INTO %s (id, cursor, block_num, block_id) values ('%s', '%s', %d, '%s')
-	`, table, moduleHash, cursor, block_num, block_id)
+			INSERT INTO %s (id, cursor, block_num, block_id) values (?, ?, ?, ?)
+	`, table), []any{moduleHash, cursor.String(), block_num, block_id}
+}
+
+// GetUpdateCursorQuery inserts a new cursor row, the cursors table uses the `ReplacingMergeTree`
+// engine so the latest row for a given id replaces the previous one(s).
+func (d clickhouseDialect) GetUpdateCursorQuery(table, moduleHash string, cursor *sink.Cursor, block_num uint64, block_id string) (string, []any) {
+	return d.GetInsertCursorQuery(table, moduleHash, cursor, block_num, block_id)
 }
 
-func (d clickhouseDialect) ParseDatetimeNormalization(value string) string {
-	return fmt.Sprintf("parseDateTimeBestEffort(%s)", escapeStringValue(value))
+func (d clickhouseDialect) GetDeleteCursorQuery(table, moduleHash string) (string, []any) {
+	return query(`
+			DELETE FROM %s WHERE id = ?
+	`, table), []any{moduleHash}
 }
 
 func (d clickhouseDialect) DriverSupportRowsAffected() bool {
@@ -128,22 +580,21 @@ func (d clickhouseDialect) DriverSupportRowsAffected() bool {
 }
 
 func (d clickhouseDialect) OnlyInserts() bool {
-	return true
+	return false
 }
 
-func convertOpToClickhouseValues(o *Operation) ([]any, error) {
-	columns := make([]string, len(o.data))
-	i := 0
-	for column := range o.data {
-		columns[i] = column
-		i++
-	}
-	sort.Strings(columns)
-	values := make([]any, len(o.data))
-	for i, v := range columns {
-		convertedType, err := convertToType(o.data[v], o.table.columnsByName[v].scanType)
+// convertRowToClickhouseValues returns the values of the row's columns, in order, typed for the table
+func convertRowToClickhouseValues(info *TableInfo, columns []string, row map[string]string) ([]any, error) {
+	values := make([]any, len(columns))
+	for i, column := range columns {
+		columnInfo, found := info.columnsByName[column]
+		if !found {
+			return nil, fmt.Errorf("cannot find column %q for table %q", column, info.identifier)
+		}
+
+		convertedType, err := columnInfo.convertValue(row[column], convertToType)
 		if err != nil {
-			return nil, fmt.Errorf("converting value %q to type %q in column %q: %w", o.data[v], o.table.columnsByName[v].scanType, v, err)
+			return nil, fmt.Errorf("converting value %q of column %q in table %s to %s: %w", row[column], column, info.identifier, columnInfo.databaseTypeName, err)
 		}
 		values[i] = convertedType
 	}
@@ -151,6 +602,10 @@ func convertOpToClickhouseValues(o *Operation) ([]any, error) {
 }
 
 func convertToType(value string, valueType reflect.Type) (any, error) {
+	if value == NullValue {
+		return nil, nil
+	}
+
 	switch valueType.Kind() {
 	case reflect.String:
 		return value, nil
@@ -190,20 +645,8 @@ func convertToType(value string, valueType reflect.Type) (any, error) {
 		return strconv.ParseFloat(value, 10)
 	case reflect.Struct:
 		if valueType == reflectTypeTime {
-			if integerRegex.MatchString(value) {
-				i, err := strconv.Atoi(value)
-				if err != nil {
-					return "", fmt.Errorf("could not convert %s to int: %w", value, err)
-				}
-
-				return int64(i), nil
-			}
-
-			v, err := time.Parse("2006-01-02T15:04:05Z", value)
-			if err != nil {
-				return "", fmt.Errorf("could not convert %s to time: %w", value, err)
-			}
-			return v.Unix(), nil
+			// Timestamp columns are converted by `Loader.convertTimestamp`
+			return value, nil
 		}
 		return "", fmt.Errorf("unsupported struct type %s", valueType)
 
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseClickhouseEngine(t *testing.T) {
+	tests := []struct {
+		name          string
+		engine        string
+		engineFull    string
+		expect        *clickhouseEngine
+		collapsing    bool
+		reversible    bool
+		signColumn    string
+		versionColumn string
+		deletedColumn string
+	}{
+		{
+			name:       "collapsing",
+			engine:     "CollapsingMergeTree",
+			engineFull: "CollapsingMergeTree(sign) ORDER BY id SETTINGS index_granularity = 8192",
+			expect:     &clickhouseEngine{name: "CollapsingMergeTree", params: []string{"sign"}},
+			collapsing: true,
+			reversible: true,
+			signColumn: "sign",
+		},
+		{
+			name:          "versioned collapsing",
+			engine:        "VersionedCollapsingMergeTree",
+			engineFull:    "VersionedCollapsingMergeTree(sign, `_block_num`) ORDER BY id",
+			expect:        &clickhouseEngine{name: "VersionedCollapsingMergeTree", params: []string{"sign", "_block_num"}},
+			collapsing:    true,
+			reversible:    true,
+			signColumn:    "sign",
+			versionColumn: "_block_num",
+		},
+		{
+			name:       "replicated collapsing",
+			engine:     "ReplicatedCollapsingMergeTree",
+			engineFull: "ReplicatedCollapsingMergeTree('/clickhouse/tables/{shard}/xfer', '{replica}', sign) ORDER BY id",
+			expect:     &clickhouseEngine{name: "CollapsingMergeTree", params: []string{"sign"}},
+			collapsing: true,
+			reversible: true,
+			signColumn: "sign",
+		},
+		{
+			name:          "replacing",
+			engine:        "ReplacingMergeTree",
+			engineFull:    "ReplacingMergeTree(version, is_deleted) ORDER BY id",
+			expect:        &clickhouseEngine{name: "ReplacingMergeTree", params: []string{"version", "is_deleted"}},
+			reversible:    true,
+			versionColumn: "version",
+			deletedColumn: "is_deleted",
+		},
+		{
+			name:          "replacing without deleted column",
+			engine:        "ReplacingMergeTree",
+			engineFull:    "ReplacingMergeTree(version) ORDER BY id",
+			expect:        &clickhouseEngine{name: "ReplacingMergeTree", params: []string{"version"}},
+			versionColumn: "version",
+		},
+		{
+			name:       "merge tree",
+			engine:     "MergeTree",
+			engineFull: "MergeTree ORDER BY id SETTINGS index_granularity = 8192",
+			expect:     &clickhouseEngine{name: "MergeTree"},
+		},
+		{
+			name:       "merge tree with partition expression",
+			engine:     "MergeTree",
+			engineFull: "MergeTree() PARTITION BY toYYYYMM(timestamp) ORDER BY id",
+			expect:     &clickhouseEngine{name: "MergeTree"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			engine := parseClickhouseEngine(test.engine, test.engineFull)
+
+			assert.Equal(t, test.expect, engine)
+			assert.Equal(t, test.collapsing, engine.isCollapsing())
+			assert.Equal(t, test.reversible, engine.reversible())
+			assert.Equal(t, test.signColumn, engine.signColumn())
+			assert.Equal(t, test.versionColumn, engine.versionColumn())
+			assert.Equal(t, test.deletedColumn, engine.isDeletedColumn())
+		})
+	}
+}
+
+func TestClickhouseInsertRow(t *testing.T) {
+	l := &Loader{}
+	blockNum := uint64(10)
+	op := &Operation{
+		opType:             OperationTypeInsert,
+		data:               map[string]string{"id": "1", "value": "a"},
+		reversibleBlockNum: &blockNum,
+	}
+
+	assert.Equal(t,
+		map[string]string{"id": "1", "value": "a"},
+		clickhouseDialect{}.insertRow(l, op, &clickhouseEngine{name: "MergeTree"}),
+	)
+	assert.Equal(t,
+		map[string]string{"id": "1", "value": "a", "sign": "1"},
+		clickhouseDialect{}.insertRow(l, op, &clickhouseEngine{name: "CollapsingMergeTree", params: []string{"sign"}}),
+	)
+	assert.Equal(t,
+		map[string]string{"id": "1", "value": "a", "sign": "1", "version": "10"},
+		clickhouseDialect{}.insertRow(l, op, &clickhouseEngine{name: "VersionedCollapsingMergeTree", params: []string{"sign", "version"}}),
+	)
+
+	row := clickhouseDialect{}.insertRow(l, op, &clickhouseEngine{name: "ReplacingMergeTree", params: []string{"version", "is_deleted"}})
+	assert.Equal(t, strconv.FormatUint(l.rowVersion, 10), row["version"])
+	assert.Equal(t, "0", row["is_deleted"])
+
+	assert.Equal(t, map[string]string{"id": "1", "value": "a"}, op.data, "operation data must be left untouched")
+}
+
+func TestClickhouseReplacingRow(t *testing.T) {
+	l := &Loader{rowVersion: math.MaxUint64 - 10}
+	current := map[string]string{"id": "1", "value": "a", "count": "3", "version": "5", "is_deleted": "0"}
+
+	updated := clickhouseDialect{}.replacingRow(l, current, map[string]string{"count": "4"}, "version", "is_deleted", false)
+	assert.Equal(t, map[string]string{"id": "1", "value": "a", "count": "4", "version": strconv.FormatUint(math.MaxUint64-9, 10), "is_deleted": "0"}, updated)
+
+	deleted := clickhouseDialect{}.replacingRow(l, current, nil, "version", "is_deleted", true)
+	assert.Equal(t, map[string]string{"id": "1", "value": "a", "count": "3", "version": strconv.FormatUint(math.MaxUint64-8, 10), "is_deleted": "1"}, deleted)
+
+	assert.Equal(t, "5", current["version"], "current row must be left untouched")
+}
@@ -0,0 +1,453 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bobg/go-generics/v2/slices"
+	"github.com/lib/pq"
+	"github.com/streamingfast/cli"
+	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
+)
+
+// cockroachMaxBatchRows is lower than the Postgres one, CockroachDB favors statements and
+// transactions of moderate size which are less likely to conflict with concurrent ones.
+const cockroachMaxBatchRows = 250
+
+// cockroachRetryableCode is the SQLSTATE of serialization failures, the transaction must be retried
+const cockroachRetryableCode = "40001"
+
+// cockroachDialect is the Postgres dialect variant compatible with CockroachDB, selected with
+// the `cockroach` DSN scheme. CockroachDB speaks the Postgres wire protocol but supports neither
+// `json_populate_record(set)` nor serial ids the way Postgres does, so history rows and updates
+// are written without them:
+//   - the history table ids are generated with `unique_rowid()`;
+//   - previous values are saved as a JSON object of the columns text representation built with
+//     `json_build_object`, reverts bind those texts which CockroachDB parses to the column's type;
+//   - updates are applied one row at a time;
+//   - new versions of versioned tables are written from the bound values of the operation.
+//
+// Transactions failing with a serialization error (SQLSTATE 40001) are retried by the loader.
+type cockroachDialect struct {
+	postgresDialect
+}
+
+func (d cockroachDialect) RetryableTxError(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == cockroachRetryableCode
+}
+
+func (d cockroachDialect) GetCreateHistoryQuery(schema string, withPostgraphile bool) string {
+	_ = withPostgraphile // Postgraphile is not supported on CockroachDB
+	return fmt.Sprintf(cli.Dedent(`
+		create table if not exists %s
+		(
+			id           int8 not null primary key default unique_rowid(),
+			op           char,
+			table_name   text,
+			pk           text,
+			prev_value   text,
+			block_num    bigint
+		);
+		`),
+		d.historyTable(schema),
+	)
+}
+
+func (d cockroachDialect) GetCreateChangesQuery(schema string, withPostgraphile bool) string {
+	_ = withPostgraphile // Postgraphile is not supported on CockroachDB
+	return fmt.Sprintf(cli.Dedent(`
+		create table if not exists %s
+		(
+			id           int8 not null primary key default unique_rowid(),
+			op           text not null,
+			table_name   text not null,
+			pk           text,
+			new_value    text,
+			block_num    bigint,
+			block_id     text,
+			revert       boolean not null default false
+		);
+		`),
+		d.changesTable(schema),
+	)
+}
+
+func (d cockroachDialect) GetCreateBlocksQuery(schema string, withPostgraphile bool) string {
+	_ = withPostgraphile // Postgraphile is not supported on CockroachDB
+	return fmt.Sprintf(cli.Dedent(`
+		create table if not exists %s
+		(
+			block_num        bigint not null primary key,
+			block_id         text not null,
+			block_timestamp  timestamptz,
+			flushed_at       timestamptz not null,
+			row_counts       jsonb not null
+		);
+		`),
+		d.blocksTable(schema),
+	)
+}
+
+// pruneStatement deletes at most limit expired rows, CockroachDB having no `ctid` but supporting
+// `DELETE ... LIMIT`.
+func (d cockroachDialect) pruneStatement(table *TableInfo, column *ColumnInfo, cutoff any, limit int) *statement {
+	return &statement{
+		query: fmt.Sprintf("DELETE FROM %s WHERE %s < $1 LIMIT %d;", table.identifier, column.escapedName, limit),
+		args:  []any{cutoff},
+	}
+}
+
+func (d cockroachDialect) Revert(tx Tx, ctx context.Context, l *Loader, lastValidFinalBlock uint64) error {
+	query := fmt.Sprintf(`SELECT op,table_name,pk,prev_value,block_num FROM %s WHERE "block_num" > $1 ORDER BY "block_num" DESC, "id" DESC`,
+		d.historyTable(l.schema),
+	)
+
+	rows, err := tx.QueryContext(ctx, query, lastValidFinalBlock)
+	if err != nil {
+		return err
+	}
+
+	var history []*historyRow
+	if rows != nil { // rows will be nil with no error only in testing scenarios
+		history, err = readHistoryRows(rows)
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("iterating on rows from query %q: %w", query, err)
+		}
+	}
+
+	l.logger.Info("reverting forked block block(s)", zap.Uint64("last_valid_final_block", lastValidFinalBlock))
+	l.logRevertedHistory(history)
+
+	rollupRows, err := l.revertedRollupRows(history)
+	if err != nil {
+		return fmt.Errorf("reverted rollup rows: %w", err)
+	}
+
+	detached, err := l.detachRollupGroups(ctx, tx, rollupRows)
+	if err != nil {
+		return fmt.Errorf("detach rollup groups: %w", err)
+	}
+
+	for _, row := range history {
+		l.logger.Debug("reverting", zap.String("operation", row.op), zap.String("table_name", row.tableName), zap.String("pk", row.pk), zap.Uint64("block_num", row.blockNum))
+
+		if err := d.revertOp(tx, ctx, row.op, row.tableName, row.pk, row.prevValue); err != nil {
+			return fmt.Errorf("revertOp: %w", err)
+		}
+	}
+
+	if err := l.attachRollupGroups(ctx, tx, rollupRows, detached); err != nil {
+		return fmt.Errorf("attach rollup groups: %w", err)
+	}
+
+	pruneHistory := fmt.Sprintf(`DELETE FROM %s WHERE "block_num" > $1;`, d.historyTable(l.schema))
+	if _, err := tx.ExecContext(ctx, pruneHistory, lastValidFinalBlock); err != nil {
+		return fmt.Errorf("executing pruneHistory: %w", err)
+	}
+	return nil
+}
+
+func (d cockroachDialect) Flush(tx Tx, ctx context.Context, l *Loader, outputModuleHash string, lastFinalBlock uint64) (int, error) {
+	var rowCount int
+	for entriesPair := l.entries.Oldest(); entriesPair != nil; entriesPair = entriesPair.Next() {
+		tableName := entriesPair.Key
+		entries := entriesPair.Value
+
+		if l.tracer.Enabled() {
+			l.logger.Debug("flushing table rows", zap.String("table_name", tableName), zap.Int("row_count", entries.Len()))
+		}
+		for _, batch := range batchOperations(entries, cockroachMaxBatchRows, postgresMaxParams) {
+			statements, err := d.prepareBatchStatements(l.schema, batch)
+			if err != nil {
+				return 0, fmt.Errorf("failed to prepare statement: %w", err)
+			}
+
+			for _, stmt := range batch.keyStatements(statements) {
+				if l.tracer.Enabled() {
+					l.logger.Debug("adding query from batched operations to transaction", zap.String("table_name", tableName), zap.String("op_type", string(batch.opType)), zap.Int("row_count", len(batch.operations)), zap.String("query", stmt.query))
+				}
+
+				if _, err := l.execPrepared(ctx, tx, stmt); err != nil {
+					return 0, fmt.Errorf("executing query %q: %w", stmt.query, err)
+				}
+			}
+		}
+		rowCount += entries.Len()
+	}
+
+	if err := d.pruneReversibleSegment(tx, ctx, l.schema, lastFinalBlock); err != nil {
+		return 0, err
+	}
+
+	return rowCount, nil
+}
+
+func (d cockroachDialect) revertOp(tx Tx, ctx context.Context, op, escapedTableName, pk, prevValue string) error {
+	pkmap := make(map[string]string)
+	if err := json.Unmarshal([]byte(pk), &pkmap); err != nil {
+		return fmt.Errorf("revertOp: unmarshalling %q: %w", pk, err)
+	}
+
+	args := newPostgresArgs()
+	var query string
+	switch op {
+	case "I":
+		query = fmt.Sprintf(`DELETE FROM %s WHERE %s;`,
+			escapedTableName,
+			getPrimaryKeyWhereClause(pkmap, args),
+		)
+
+	case "D":
+		columns, values, err := jsonToTextRow(prevValue)
+		if err != nil {
+			return err
+		}
+
+		query = fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s);`,
+			escapedTableName,
+			strings.Join(slices.Map(columns, EscapeIdentifier), ","),
+			strings.Join(slices.Map(values, args.add), ","),
+		)
+
+	case "U":
+		columns, values, err := jsonToTextRow(prevValue)
+		if err != nil {
+			return err
+		}
+
+		updates := make([]string, len(columns))
+		for i, column := range columns {
+			updates[i] = EscapeIdentifier(column) + "=" + args.add(values[i])
+		}
+
+		query = fmt.Sprintf(`UPDATE %s SET %s WHERE %s;`,
+			escapedTableName,
+			strings.Join(updates, ", "),
+			getPrimaryKeyWhereClause(pkmap, args),
+		)
+
+	default:
+		panic("invalid op in revert command")
+	}
+
+	if _, err := tx.ExecContext(ctx, query, args.args...); err != nil {
+		return fmt.Errorf("executing revert query %q: %w", query, err)
+	}
+	return nil
+}
+
+// prepareBatchStatements returns the statements applying the operations of the batch preceded by
+// the statement saving the history of its reversible operations, if any.
+//
+// Inserts, upserts and replaces are the same as for Postgres, deletes are turned into
+// `DELETE ... WHERE <pk> IN (...)` and updates are applied one row at a time.
+func (d cockroachDialect) prepareBatchStatements(schema string, b *operationBatch) ([]*statement, error) {
+	if b.opType == OperationTypeInsert && !b.upsertsOnConflict() {
+		return d.postgresDialect.prepareBatchStatements(schema, b)
+	}
+
+	if b.upsertsOnConflict() {
+		if _, found := b.operations[0].primaryKey[""]; found {
+			return nil, fmt.Errorf("trying to perform %s operation but table %q don't have a primary key set, this is not accepted", b.opType, b.table.name)
+		}
+
+		upsert, err := d.insertStatement(b, true)
+		if err != nil {
+			return nil, err
+		}
+
+		return withHistory(d.saveUpserts(schema, b.table, b.reversibleOperations(), d.rowJSON(b.table)), upsert), nil
+	}
+
+	if b.opType == OperationTypeReplace {
+		if _, found := b.operations[0].primaryKey[""]; found {
+			return nil, fmt.Errorf("trying to perform %s operation but table %q don't have a primary key set, this is not accepted", b.opType, b.table.name)
+		}
+
+		insert, err := d.insertStatement(b, false)
+		if err != nil {
+			return nil, err
+		}
+
+		history := d.saveUpserts(schema, b.table, b.reversibleOperations(), d.rowJSON(b.table))
+		return append(withHistory(history, d.deleteStatement(b)), insert), nil
+	}
+
+	for _, o := range b.operations {
+		// A table without a primary key set yield a `primaryKey` map with a single entry where the key is an empty string
+		if _, found := o.primaryKey[""]; found {
+			return nil, fmt.Errorf("trying to perform %s operation but table %q don't have a primary key set, this is not accepted", o.opType, o.table.name)
+		}
+	}
+
+	switch b.opType {
+	case OperationTypeUpdate:
+		if len(b.columns) == 0 {
+			// Nothing to update, the row stays untouched
+			return nil, nil
+		}
+
+		var statements []*statement
+		if history := d.saveRows("U", schema, b.table, b.reversibleOperations()); history != nil {
+			statements = append(statements, history)
+		}
+
+		for _, o := range b.operations {
+			columns, values, err := d.prepareColValues(o.table, o.data)
+			if err != nil {
+				return nil, fmt.Errorf("preparing column & values: %w", err)
+			}
+
+			args := newPostgresArgs()
+			updates := make([]string, len(columns))
+			for i, column := range columns {
+				updates[i] = column + "=" + standardFieldUpdateSQL.expression(b.fieldOps[column], column, func() string { return args.add(values[i]) })
+			}
+
+			update := args.statement(fmt.Sprintf("UPDATE %s SET %s WHERE %s;",
+				o.table.identifier,
+				strings.Join(updates, ", "),
+				getPrimaryKeyWhereClause(o.primaryKey, args),
+			))
+			// The rows of the batch are all updated by the same query, prepared once
+			update.key = o.shapeKey(b.columns)
+			statements = append(statements, update)
+		}
+
+		return statements, nil
+
+	case OperationTypeDelete:
+		return withHistory(d.saveRows("D", schema, b.table, b.reversibleOperations()), d.deleteStatement(b)), nil
+
+	default:
+		panic(fmt.Errorf("unknown operation type %q", b.opType))
+	}
+}
+
+// rowJSON returns the expression building the JSON object of the text representation of each
+// column of the table's row, the way rows are saved in the history table.
+func (d cockroachDialect) rowJSON(table *TableInfo) string {
+	columns := maps.Keys(table.columnsByName)
+	sort.Strings(columns)
+
+	jsonFields := make([]string, len(columns))
+	for i, column := range columns {
+		jsonFields[i] = "'" + strings.ReplaceAll(column, "'", "''") + "'," + table.columnsByName[column].escapedName + "::text"
+	}
+	return "json_build_object(" + strings.Join(jsonFields, ",") + ")::text"
+}
+
+// saveRows returns a single statement recording in the history table the current value of each
+// row touched by the reversible operations, nil is returned if there is none. The row is saved as
+// a JSON object of the text representation of each column.
+func (d cockroachDialect) saveRows(op, schema string, table *TableInfo, ops []*Operation) *statement {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	rowJSON := d.rowJSON(table)
+
+	args := newPostgresArgs()
+	selects := make([]string, len(ops))
+	for i, o := range ops {
+		// Parameters of the selected values are cast explicitly, their type cannot be inferred through the `UNION ALL`
+		selects[i] = fmt.Sprintf(`SELECT %s::text,%s::text,%s::text,%s::text,%s::bigint FROM %s WHERE %s`,
+			args.add(op), args.add(table.identifier), args.add(primaryKeyToJSON(o.primaryKey)), rowJSON, args.add(*o.reversibleBlockNum),
+			table.identifier,
+			getPrimaryKeyWhereClause(o.primaryKey, args),
+		)
+	}
+
+	return args.statement(fmt.Sprintf(`INSERT INTO %s (op,table_name,pk,prev_value,block_num) %s;`,
+		d.historyTable(schema),
+		strings.Join(selects, " UNION ALL "),
+	))
+}
+
+// versionStatements returns the statements writing the operation on a versioned table.
+//
+// Inserts, replaces and deletes are the same as for Postgres, updates and upserts bind the values
+// of the operation directly instead of reading them from `json_populate_record`.
+func (d cockroachDialect) versionStatements(op *Operation) ([]*statement, error) {
+	if op.opType != OperationTypeUpdate && op.opType != OperationTypeUpsert {
+		return d.postgresDialect.versionStatements(op)
+	}
+
+	if op.opType == OperationTypeUpdate && len(op.data) == 0 {
+		// Nothing to update, the row keeps its current version
+		return nil, nil
+	}
+
+	dataColumns, dataValues, err := d.prepareColValues(op.table, op.data)
+	if err != nil {
+		return nil, fmt.Errorf("preparing column & values: %w", err)
+	}
+
+	values := make(map[string]any, len(dataColumns))
+	for i, column := range dataColumns {
+		values[column] = dataValues[i]
+	}
+
+	columnNames := maps.Keys(op.table.columnsByName)
+	sort.Strings(columnNames) // sorted for determinism in tests
+
+	validFrom := EscapeIdentifier(VersionValidFromColumn)
+	validTo := EscapeIdentifier(VersionValidToColumn)
+
+	args := newPostgresArgs()
+	var columns, selects []string
+	for _, columnName := range columnNames {
+		if columnName == VersionValidFromColumn || columnName == VersionValidToColumn {
+			continue
+		}
+
+		column := EscapeIdentifier(columnName)
+		if value, found := values[column]; found {
+			selects = append(selects, standardFieldUpdateSQL.expression(op.fieldOps[columnName], "c."+column, func() string { return args.add(value) }))
+		} else {
+			selects = append(selects, "c."+column)
+		}
+		columns = append(columns, column)
+	}
+
+	blockNum := args.add(op.versionBlockNum)
+	newVersion := args.statement(fmt.Sprintf("INSERT INTO %s (%s,%s) SELECT %s,%s FROM %s AS c WHERE %s AND c.%s = %s;",
+		op.table.identifier,
+		strings.Join(columns, ","),
+		validFrom,
+		strings.Join(selects, ","),
+		blockNum,
+		op.table.identifier,
+		getPrimaryKeyWhereClause(op.primaryKey, args),
+		validTo,
+		blockNum,
+	))
+
+	statements := []*statement{d.closeVersionStatement(op), newVersion}
+	if op.opType == OperationTypeUpsert {
+		insertArgs := newPostgresArgs()
+		insertValues := slices.Map(dataValues, insertArgs.add)
+		insertBlockNum := insertArgs.add(op.versionBlockNum)
+
+		statements = append(statements, insertArgs.statement(fmt.Sprintf("INSERT INTO %s (%s,%s) SELECT %s,%s WHERE NOT EXISTS (SELECT 1 FROM %s WHERE %s AND %s = %s);",
+			op.table.identifier,
+			strings.Join(dataColumns, ","),
+			validFrom,
+			strings.Join(insertValues, ","),
+			insertBlockNum,
+			op.table.identifier,
+			getPrimaryKeyWhereClause(op.primaryKey, insertArgs),
+			validFrom,
+			insertBlockNum,
+		)))
+	}
+
+	return statements, nil
+}
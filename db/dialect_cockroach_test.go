@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCockroachRevertOp(t *testing.T) {
+	tests := []struct {
+		name      string
+		op        string
+		pk        string
+		prevValue string
+		expect    string
+	}{
+		{
+			name:   "rollback insert row",
+			op:     "I",
+			pk:     `{"id":"2345"}`,
+			expect: `DELETE FROM "testschema"."xfer" WHERE "id" = $1; [2345]`,
+		},
+		{
+			name:      "rollback delete row",
+			op:        "D",
+			pk:        `{"id":"2345"}`,
+			prevValue: `{"id":"2345","sender":"0xdead","receiver":null}`,
+			expect:    `INSERT INTO "testschema"."xfer" ("id","receiver","sender") VALUES ($1,$2,$3); [2345 <nil> 0xdead]`,
+		},
+		{
+			name:      "rollback update row",
+			op:        "U",
+			pk:        `{"id":"2345"}`,
+			prevValue: `{"id":"2345","sender":"0xdead","receiver":"0xbeef"}`,
+			expect:    `UPDATE "testschema"."xfer" SET "id"=$1, "receiver"=$2, "sender"=$3 WHERE "id" = $4; [2345 0xbeef 0xdead 2345]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := &TestTx{}
+
+			err := cockroachDialect{}.revertOp(tx, context.Background(), test.op, `"testschema"."xfer"`, test.pk, test.prevValue)
+			require.NoError(t, err)
+			assert.Equal(t, []string{test.expect}, tx.Results())
+		})
+	}
+}
+
+func TestCockroachRetryableTxError(t *testing.T) {
+	d := cockroachDialect{}
+
+	assert.True(t, d.RetryableTxError(fmt.Errorf("dialect flush: %w", &pq.Error{Code: "40001"})))
+	assert.False(t, d.RetryableTxError(fmt.Errorf("dialect flush: %w", &pq.Error{Code: "23505"})))
+	assert.False(t, d.RetryableTxError(fmt.Errorf("connection refused")))
+}
+
+func TestCockroachVersionStatements(t *testing.T) {
+	l, _ := NewTestLoader(zlog, tracer, "testschema", featureTestTables("testschema"))
+	require.NoError(t, l.SetVersionedTables([]string{"owners"}))
+	require.NoError(t, l.ScheduleVersion("owners", OperationTypeUpdate, map[string]string{"id": "1"}, map[string]string{"owner": "b"}, 11))
+	require.NoError(t, l.ScheduleVersion("owners", OperationTypeUpsert, map[string]string{"id": "2"}, map[string]string{"owner": "a"}, 11))
+
+	versions, _ := l.versions.Get("owners")
+
+	statements, err := cockroachDialect{}.versionStatements(versions.Oldest().Value)
+	require.NoError(t, err)
+	require.Len(t, statements, 2)
+	assert.Equal(t, `INSERT INTO "testschema"."owners" ("id","owner","valid_from_block") SELECT c."id",$1,$2 FROM "testschema"."owners" AS c WHERE "id" = $3 AND c."valid_to_block" = $2;`, statements[1].query)
+	assert.Equal(t, []any{"b", uint64(11), "1"}, statements[1].args)
+
+	statements, err = cockroachDialect{}.versionStatements(versions.Newest().Value)
+	require.NoError(t, err)
+	require.Len(t, statements, 3)
+	assert.Equal(t, `INSERT INTO "testschema"."owners" ("id","owner","valid_from_block") SELECT $1,$2,$3 WHERE NOT EXISTS (SELECT 1 FROM "testschema"."owners" WHERE "id" = $4 AND "valid_from_block" = $3);`, statements[2].query)
+	assert.Equal(t, []any{"2", "a", uint64(11), "2"}, statements[2].args)
+
+	for _, stmt := range statements {
+		assert.NotContains(t, stmt.query, "json_populate_record")
+	}
+}
@@ -0,0 +1,813 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bobg/go-generics/v2/slices"
+	"github.com/marcboeker/go-duckdb"
+	"github.com/streamingfast/cli"
+	sink "github.com/streamingfast/substreams-sink"
+	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
+)
+
+// duckdbMaxBatchRows caps the amount of rows a single batched statement holds
+const duckdbMaxBatchRows = 1000
+
+// duckdbMaxParams caps the amount of bind parameters of a single statement, DuckDB has no
+// hard limit but very large statements are slower to bind than a few smaller ones.
+const duckdbMaxParams = 65535
+
+// duckdbDialect targets a single DuckDB database file, making the sink output directly queryable
+// by analysts. Inserted rows are bulk loaded using DuckDB's Appender API, reorgs are handled
+// through the history table.
+//
+// The previous values saved in the history table are read back and encoded to JSON by the dialect
+// itself, as the JSON extension of DuckDB is not always available.
+type duckdbDialect struct{}
+
+func (d duckdbDialect) EscapeIdentifier(valueToEscape string) string {
+	return EscapeIdentifier(valueToEscape)
+}
+
+// BeginTx opens the transaction on a dedicated connection, the Appender used to bulk load rows
+// must be created from the same connection for its rows to be part of the transaction.
+func (d duckdbDialect) BeginTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions) (Tx, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+
+	if _, err := conn.ExecContext(ctx, "BEGIN TRANSACTION"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+
+	return &duckdbTx{conn: conn}, nil
+}
+
+// Tables lists the tables of the database, `github.com/jimsmart/schema` does not support DuckDB
+func (d duckdbDialect) Tables(db *sql.DB) (map[[2]string][]*sql.ColumnType, error) {
+	rows, err := db.Query(`SELECT table_schema, table_name FROM information_schema.tables WHERE table_type = 'BASE TABLE'`)
+	if err != nil {
+		return nil, fmt.Errorf("listing tables: %w", err)
+	}
+
+	var names [][2]string
+	for rows.Next() {
+		var name [2]string
+		if err := rows.Scan(&name[0], &name[1]); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning table name: %w", err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating tables: %w", err)
+	}
+
+	out := make(map[[2]string][]*sql.ColumnType, len(names))
+	for _, name := range names {
+		columns, err := d.columnTypes(db, name[0], name[1])
+		if err != nil {
+			return nil, fmt.Errorf("table %s.%s: %w", name[0], name[1], err)
+		}
+
+		out[name] = columns
+	}
+
+	return out, nil
+}
+
+func (d duckdbDialect) columnTypes(db *sql.DB, schema, table string) ([]*sql.ColumnType, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s.%s LIMIT 0", EscapeIdentifier(schema), EscapeIdentifier(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return rows.ColumnTypes()
+}
+
+// PrimaryKey returns the primary key column(s) of the table in the order they are declared
+func (d duckdbDialect) PrimaryKey(db *sql.DB, schema, table string) (out []string, err error) {
+	rows, err := db.Query(`SELECT unnest(constraint_column_names) FROM duckdb_constraints() WHERE schema_name = ? AND table_name = ? AND constraint_type = 'PRIMARY KEY'`, schema, table)
+	if err != nil {
+		return nil, fmt.Errorf("querying primary key: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, fmt.Errorf("scanning primary key column: %w", err)
+		}
+		out = append(out, column)
+	}
+
+	return out, rows.Err()
+}
+
+func (d duckdbDialect) Revert(tx Tx, ctx context.Context, l *Loader, lastValidFinalBlock uint64) error {
+	query := fmt.Sprintf(`SELECT op,table_name,pk,prev_value,block_num FROM %s WHERE "block_num" > ? ORDER BY "block_num" DESC, "id" DESC`,
+		d.historyTable(l.schema),
+	)
+
+	rows, err := tx.QueryContext(ctx, query, lastValidFinalBlock)
+	if err != nil {
+		return err
+	}
+
+	var history []*historyRow
+	if rows != nil { // rows will be nil with no error only in testing scenarios
+		history, err = readHistoryRows(rows)
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("iterating on rows from query %q: %w", query, err)
+		}
+	}
+
+	l.logger.Info("reverting forked block block(s)", zap.Uint64("last_valid_final_block", lastValidFinalBlock))
+	for _, row := range history {
+		l.logger.Debug("reverting", zap.String("operation", row.op), zap.String("table_name", row.tableName), zap.String("pk", row.pk), zap.Uint64("block_num", row.blockNum))
+
+		if err := d.revertOp(tx, ctx, row.op, row.tableName, row.pk, row.prevValue); err != nil {
+			return fmt.Errorf("revertOp: %w", err)
+		}
+	}
+
+	pruneHistory := fmt.Sprintf(`DELETE FROM %s WHERE "block_num" > ?;`, d.historyTable(l.schema))
+	if _, err := tx.ExecContext(ctx, pruneHistory, lastValidFinalBlock); err != nil {
+		return fmt.Errorf("executing pruneHistory: %w", err)
+	}
+	return nil
+}
+
+func (d duckdbDialect) Flush(tx Tx, ctx context.Context, l *Loader, outputModuleHash string, lastFinalBlock uint64) (int, error) {
+	var rowCount int
+	for entriesPair := l.entries.Oldest(); entriesPair != nil; entriesPair = entriesPair.Next() {
+		tableName := entriesPair.Key
+		entries := entriesPair.Value
+
+		if l.tracer.Enabled() {
+			l.logger.Debug("flushing table rows", zap.String("table_name", tableName), zap.Int("row_count", entries.Len()))
+		}
+		for _, batch := range batchOperations(entries, duckdbMaxBatchRows, duckdbMaxParams) {
+			if batch.opType == OperationTypeInsert && len(batch.operations[0].data) == len(batch.table.columnsByName) {
+				if err := d.appendInserts(ctx, tx, l.schema, batch); err != nil {
+					return 0, fmt.Errorf("appending rows into %q: %w", tableName, err)
+				}
+				continue
+			}
+
+			statements, err := d.prepareBatchStatements(ctx, tx, l.schema, batch)
+			if err != nil {
+				return 0, fmt.Errorf("failed to prepare statement: %w", err)
+			}
+
+			for _, stmt := range batch.keyStatements(statements) {
+				if l.tracer.Enabled() {
+					l.logger.Debug("adding query from batched operations to transaction", zap.String("table_name", tableName), zap.String("op_type", string(batch.opType)), zap.Int("row_count", len(batch.operations)), zap.String("query", stmt.query))
+				}
+
+				if _, err := l.execPrepared(ctx, tx, stmt); err != nil {
+					return 0, fmt.Errorf("executing query %q: %w", stmt.query, err)
+				}
+			}
+		}
+		rowCount += entries.Len()
+	}
+
+	pruneHistory := fmt.Sprintf(`DELETE FROM %s WHERE block_num <= ?;`, d.historyTable(l.schema))
+	if _, err := tx.ExecContext(ctx, pruneHistory, lastFinalBlock); err != nil {
+		return 0, fmt.Errorf("executing prune query %q: %w", pruneHistory, err)
+	}
+
+	return rowCount, nil
+}
+
+// appendInserts writes the rows of the insert batch through an Appender. The Appender requires a
+// value for every column of the table in their declaration order, only batches setting all the
+// columns are appended, the others are inserted with a regular `INSERT` to keep column defaults.
+func (d duckdbDialect) appendInserts(ctx context.Context, tx Tx, schema string, b *operationBatch) error {
+	dtx, ok := tx.(*duckdbTx)
+	if !ok {
+		return fmt.Errorf("duckdb dialect requires a duckdb transaction, got %T", tx)
+	}
+
+	if history := d.saveInserts(schema, b.table.identifier, b.reversibleOperations()); history != nil {
+		if _, err := tx.ExecContext(ctx, history.query, history.args...); err != nil {
+			return fmt.Errorf("executing query %q: %w", history.query, err)
+		}
+	}
+
+	columns, err := d.orderedColumns(ctx, tx, b.table)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]driver.Value, len(b.operations))
+	for i, o := range b.operations {
+		row := make([]driver.Value, len(columns))
+		for j, columnName := range columns {
+			value, found := o.data[columnName]
+			if !found {
+				return fmt.Errorf("missing value for column %q of table %s", columnName, o.table.identifier)
+			}
+
+			if row[j], err = o.table.columnsByName[columnName].convertValue(value, d.normalizeValueType); err != nil {
+				return fmt.Errorf("getting sql value from table %s for column %q raw value %q: %w", o.table.identifier, columnName, value, err)
+			}
+		}
+		rows[i] = row
+	}
+
+	return dtx.conn.Raw(func(driverConn any) error {
+		conn, ok := driverConn.(driver.Conn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+
+		appender, err := duckdb.NewAppenderFromConn(conn, b.table.schema, b.table.name)
+		if err != nil {
+			return fmt.Errorf("creating appender: %w", err)
+		}
+
+		for _, row := range rows {
+			if err := appender.AppendRow(row...); err != nil {
+				appender.Close()
+				return fmt.Errorf("appending row: %w", err)
+			}
+		}
+
+		// Closing the appender flushes the rows it buffered
+		return appender.Close()
+	})
+}
+
+// orderedColumns returns the table's column names in their declaration order
+func (d duckdbDialect) orderedColumns(ctx context.Context, tx Tx, table *TableInfo) (out []string, err error) {
+	rows, err := tx.QueryContext(ctx, `SELECT column_name FROM information_schema.columns WHERE table_schema = ? AND table_name = ? ORDER BY ordinal_position`, table.schema, table.name)
+	if err != nil {
+		return nil, fmt.Errorf("querying columns of %s: %w", table.identifier, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, fmt.Errorf("scanning column name: %w", err)
+		}
+		out = append(out, column)
+	}
+
+	return out, rows.Err()
+}
+
+func (d duckdbDialect) revertOp(tx Tx, ctx context.Context, op, escapedTableName, pk, prevValue string) error {
+	pkmap := make(map[string]string)
+	if err := json.Unmarshal([]byte(pk), &pkmap); err != nil {
+		return fmt.Errorf("revertOp: unmarshalling %q: %w", pk, err)
+	}
+
+	args := newPositionalArgs()
+	var query string
+	switch op {
+	case "I":
+		query = fmt.Sprintf(`DELETE FROM %s WHERE %s;`,
+			escapedTableName,
+			getPrimaryKeyWhereClause(pkmap, args),
+		)
+
+	case "D":
+		columns, values, err := jsonToTextRow(prevValue)
+		if err != nil {
+			return err
+		}
+
+		query = fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s);`,
+			escapedTableName,
+			strings.Join(slices.Map(columns, EscapeIdentifier), ","),
+			strings.Join(slices.Map(values, args.add), ","),
+		)
+
+	case "U":
+		columns, values, err := jsonToTextRow(prevValue)
+		if err != nil {
+			return err
+		}
+
+		// Primary key columns are left out, DuckDB turns updates of indexed columns into a delete
+		// followed by an insert which fails the unique constraint within the same transaction
+		var updates []string
+		for i, column := range columns {
+			if _, found := pkmap[column]; !found {
+				updates = append(updates, EscapeIdentifier(column)+"="+args.add(values[i]))
+			}
+		}
+
+		if len(updates) == 0 {
+			// Only primary key columns, the row is unchanged
+			return nil
+		}
+
+		query = fmt.Sprintf(`UPDATE %s SET %s WHERE %s;`,
+			escapedTableName,
+			strings.Join(updates, ", "),
+			getPrimaryKeyWhereClause(pkmap, args),
+		)
+
+	default:
+		panic("invalid op in revert command")
+	}
+
+	if _, err := tx.ExecContext(ctx, query, args.args...); err != nil {
+		return fmt.Errorf("executing revert query %q: %w", query, err)
+	}
+	return nil
+}
+
+func (d duckdbDialect) GetCreateCursorQuery(schema string, withPostgraphile bool) string {
+	_ = withPostgraphile // Postgraphile only exists for Postgres
+	return fmt.Sprintf(cli.Dedent(`
+		create table if not exists %s.%s
+		(
+			id         text not null primary key,
+			cursor     text,
+			block_num  bigint,
+			block_id   text
+		);
+		`),
+		EscapeIdentifier(schema), EscapeIdentifier(CURSORS_TABLE),
+	)
+}
+
+func (d duckdbDialect) GetCreateHistoryQuery(schema string, withPostgraphile bool) string {
+	_ = withPostgraphile // Postgraphile only exists for Postgres
+	sequence := fmt.Sprintf("%s.%s", EscapeIdentifier(schema), EscapeIdentifier(HISTORY_TABLE+"_id_seq"))
+
+	return fmt.Sprintf(cli.Dedent(`
+		create sequence if not exists %s;
+		create table if not exists %s
+		(
+			id           bigint primary key default nextval('%s'),
+			op           text,
+			table_name   text,
+			pk           text,
+			prev_value   text,
+			block_num    bigint
+		);
+		`),
+		sequence,
+		d.historyTable(schema),
+		strings.ReplaceAll(sequence, "'", "''"),
+	)
+}
+
+func (d duckdbDialect) ExecuteSetupScript(ctx context.Context, l *Loader, schemaSql string) error {
+	if _, err := l.ExecContext(ctx, schemaSql); err != nil {
+		return fmt.Errorf("exec schema: %w", err)
+	}
+	return nil
+}
+
+func (d duckdbDialect) GetAllCursorsQuery(table string) string {
+	return fmt.Sprintf("SELECT id, cursor, block_num, block_id FROM %s", table)
+}
+
+func (d duckdbDialect) GetInsertCursorQuery(table, moduleHash string, cursor *sink.Cursor, block_num uint64, block_id string) (string, []any) {
+	return query(`
+		INSERT INTO %s (id, cursor, block_num, block_id) values (?, ?, ?, ?);
+	`, table), []any{moduleHash, cursor.String(), block_num, block_id}
+}
+
+func (d duckdbDialect) GetUpdateCursorQuery(table, moduleHash string, cursor *sink.Cursor, block_num uint64, block_id string) (string, []any) {
+	return query(`
+		UPDATE %s set cursor = ?, block_num = ?, block_id = ? WHERE id = ?;
+	`, table), []any{cursor.String(), block_num, block_id, moduleHash}
+}
+
+func (d duckdbDialect) GetDeleteCursorQuery(table, moduleHash string) (string, []any) {
+	return query(`
+		DELETE FROM %s WHERE id = ?;
+	`, table), []any{moduleHash}
+}
+
+func (d duckdbDialect) DriverSupportRowsAffected() bool {
+	return true
+}
+
+func (d duckdbDialect) OnlyInserts() bool {
+	return false
+}
+
+func (d duckdbDialect) historyTable(schema string) string {
+	return fmt.Sprintf("%s.%s", EscapeIdentifier(schema), EscapeIdentifier(HISTORY_TABLE))
+}
+
+// prepareBatchStatements returns the statements applying the operations of the batch preceded by
+// the statement saving the history of its reversible operations, if any.
+//
+// Inserts are turned into a multi-row `INSERT ... VALUES (...),(...)`, upserts and the inserts of
+// soft deleted tables into the same with an `ON CONFLICT (<pk>) DO UPDATE` clause, deletes into
+// `DELETE ... WHERE <pk> IN (...)`, replaces into a delete followed by an insert and updates are
+// applied one row at a time.
+func (d duckdbDialect) prepareBatchStatements(ctx context.Context, tx Tx, schema string, b *operationBatch) ([]*statement, error) {
+	if b.opType != OperationTypeInsert {
+		for _, o := range b.operations {
+			// A table without a primary key set yield a `primaryKey` map with a single entry where the key is an empty string
+			if _, found := o.primaryKey[""]; found {
+				return nil, fmt.Errorf("trying to perform %s operation but table %q don't have a primary key set, this is not accepted", o.opType, o.table.name)
+			}
+		}
+	}
+
+	switch b.opType {
+	case OperationTypeInsert, OperationTypeUpsert, OperationTypeReplace:
+		args := newPositionalArgs()
+
+		var columns []string
+		rows := make([]string, len(b.operations))
+		for i, o := range b.operations {
+			var values []any
+			var err error
+			columns, values, err = d.prepareColValues(o.table, o.data)
+			if err != nil {
+				return nil, fmt.Errorf("preparing column & values: %w", err)
+			}
+
+			rows[i] = "(" + strings.Join(slices.Map(values, args.add), ",") + ")"
+		}
+
+		if b.upsertsOnConflict() {
+			history, err := d.saveUpserts(ctx, tx, schema, b.table, b.reversibleOperations())
+			if err != nil {
+				return nil, err
+			}
+
+			upsertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s %s;",
+				b.table.identifier,
+				strings.Join(columns, ","),
+				strings.Join(rows, ","),
+				onConflictUpdateClause(b.table, columns, b.fieldOps, standardFieldUpdateSQL),
+			)
+
+			return withHistory(history, args.statement(upsertQuery)), nil
+		}
+
+		insertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s;",
+			b.table.identifier,
+			strings.Join(columns, ","),
+			strings.Join(rows, ","),
+		)
+
+		if b.opType == OperationTypeReplace {
+			// The history records the row as it was before being deleted, like for an upsert
+			history, err := d.saveUpserts(ctx, tx, schema, b.table, b.reversibleOperations())
+			if err != nil {
+				return nil, err
+			}
+
+			return append(withHistory(history, d.deleteStatement(b)), args.statement(insertQuery)), nil
+		}
+
+		return withHistory(d.saveInserts(schema, b.table.identifier, b.reversibleOperations()), args.statement(insertQuery)), nil
+
+	case OperationTypeUpdate:
+		if len(b.columns) == 0 {
+			// Nothing to update, the row stays untouched
+			return nil, nil
+		}
+
+		history, err := d.saveRows(ctx, tx, "U", schema, b.table, b.reversibleOperations())
+		if err != nil {
+			return nil, err
+		}
+
+		var statements []*statement
+		if history != nil {
+			statements = append(statements, history)
+		}
+
+		for _, o := range b.operations {
+			columns, values, err := d.prepareColValues(o.table, o.data)
+			if err != nil {
+				return nil, fmt.Errorf("preparing column & values: %w", err)
+			}
+
+			args := newPositionalArgs()
+			updates := make([]string, len(columns))
+			for i, column := range columns {
+				updates[i] = column + "=" + standardFieldUpdateSQL.expression(b.fieldOps[column], column, func() string { return args.add(values[i]) })
+			}
+
+			update := args.statement(fmt.Sprintf("UPDATE %s SET %s WHERE %s;",
+				o.table.identifier,
+				strings.Join(updates, ", "),
+				getPrimaryKeyWhereClause(o.primaryKey, args),
+			))
+			// The rows of the batch are all updated by the same query, prepared once
+			update.key = o.shapeKey(b.columns)
+			statements = append(statements, update)
+		}
+
+		return statements, nil
+
+	case OperationTypeDelete:
+		history, err := d.saveRows(ctx, tx, "D", schema, b.table, b.reversibleOperations())
+		if err != nil {
+			return nil, err
+		}
+
+		return withHistory(history, d.deleteStatement(b)), nil
+
+	default:
+		panic(fmt.Errorf("unknown operation type %q", b.opType))
+	}
+}
+
+// deleteStatement returns the statement deleting all the rows of the batch at once
+func (d duckdbDialect) deleteStatement(b *operationBatch) *statement {
+	args := newPositionalArgs()
+	return args.statement(fmt.Sprintf("DELETE FROM %s WHERE %s;",
+		b.table.identifier,
+		d.primaryKeysInClause(b.primaryKeys(), args),
+	))
+}
+
+// oldValuesStatement returns the statement checking that the operation's row holds its old values
+func (d duckdbDialect) oldValuesStatement(op *Operation) (*statement, error) {
+	columns, values, err := d.prepareColValues(op.table, op.oldValues.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	args := newPositionalArgs()
+	return args.statement(oldValuesQuery(op.table, columns, values, args, func() string {
+		return getPrimaryKeyWhereClause(op.primaryKey, args)
+	})), nil
+}
+
+// saveInserts returns the statement recording in the history table the reversible inserts
+// of the batch, nil is returned if none of the operations is reversible.
+func (d duckdbDialect) saveInserts(schema string, table string, ops []*Operation) *statement {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	args := newPositionalArgs()
+	rows := make([]string, len(ops))
+	for i, op := range ops {
+		rows[i] = fmt.Sprintf("(%s,%s,%s,%s)",
+			args.add("I"),
+			args.add(table),
+			args.add(primaryKeyToJSON(op.primaryKey)),
+			args.add(*op.reversibleBlockNum),
+		)
+	}
+
+	return args.statement(fmt.Sprintf(`INSERT INTO %s (op,table_name,pk,block_num) values %s;`,
+		d.historyTable(schema),
+		strings.Join(rows, ","),
+	))
+}
+
+// saveRows returns the statement recording in the history table the current value of each row
+// touched by the reversible operations, nil is returned if there is none. The rows are read within
+// the transaction with every column cast to text and saved as a JSON object of those texts.
+func (d duckdbDialect) saveRows(ctx context.Context, tx Tx, op, schema string, table *TableInfo, ops []*Operation) (*statement, error) {
+	if len(ops) == 0 {
+		return nil, nil
+	}
+
+	columns := maps.Keys(table.columnsByName)
+	sort.Strings(columns)
+
+	selectColumns := slices.Map(columns, func(column string) string {
+		return "CAST(" + table.columnsByName[column].escapedName + " AS VARCHAR)"
+	})
+
+	args := newPositionalArgs()
+	var rows []string
+	for _, o := range ops {
+		prevValue, found, err := d.readRow(ctx, tx, table, columns, selectColumns, o.primaryKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if !found {
+			// Nothing to save, the row does not exist so the operation won't change anything
+			continue
+		}
+
+		rows = append(rows, fmt.Sprintf("(%s,%s,%s,%s,%s)",
+			args.add(op),
+			args.add(table.identifier),
+			args.add(primaryKeyToJSON(o.primaryKey)),
+			args.add(prevValue),
+			args.add(*o.reversibleBlockNum),
+		))
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	return args.statement(fmt.Sprintf(`INSERT INTO %s (op,table_name,pk,prev_value,block_num) values %s;`,
+		d.historyTable(schema),
+		strings.Join(rows, ","),
+	)), nil
+}
+
+// saveUpserts returns the statement recording in the history table whether each row touched by
+// the reversible upserts existed, nil is returned if there is none. An existing row is recorded as
+// updated along with its current value, read like saveRows does, a missing one as inserted.
+func (d duckdbDialect) saveUpserts(ctx context.Context, tx Tx, schema string, table *TableInfo, ops []*Operation) (*statement, error) {
+	if len(ops) == 0 {
+		return nil, nil
+	}
+
+	columns := maps.Keys(table.columnsByName)
+	sort.Strings(columns)
+
+	selectColumns := slices.Map(columns, func(column string) string {
+		return "CAST(" + table.columnsByName[column].escapedName + " AS VARCHAR)"
+	})
+
+	args := newPositionalArgs()
+	rows := make([]string, len(ops))
+	for i, o := range ops {
+		prevValue, found, err := d.readRow(ctx, tx, table, columns, selectColumns, o.primaryKey)
+		if err != nil {
+			return nil, err
+		}
+
+		op, prev := "U", any(prevValue)
+		if !found {
+			op, prev = "I", nil
+		}
+
+		rows[i] = fmt.Sprintf("(%s,%s,%s,%s,%s)",
+			args.add(op),
+			args.add(table.identifier),
+			args.add(primaryKeyToJSON(o.primaryKey)),
+			args.add(prev),
+			args.add(*o.reversibleBlockNum),
+		)
+	}
+
+	return args.statement(fmt.Sprintf(`INSERT INTO %s (op,table_name,pk,prev_value,block_num) values %s;`,
+		d.historyTable(schema),
+		strings.Join(rows, ","),
+	)), nil
+}
+
+// readRow returns the JSON object of the row with the given primary key, `found` is false if there is no such row
+func (d duckdbDialect) readRow(ctx context.Context, tx Tx, table *TableInfo, columns []string, selectColumns []string, primaryKey map[string]string) (prevValue string, found bool, err error) {
+	args := newPositionalArgs()
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s",
+		strings.Join(selectColumns, ","),
+		table.identifier,
+		getPrimaryKeyWhereClause(primaryKey, args),
+	)
+
+	rows, err := tx.QueryContext(ctx, query, args.args...)
+	if err != nil {
+		return "", false, fmt.Errorf("reading previous value of %s: %w", table.identifier, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return "", false, rows.Err()
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	if err := rows.Scan(dest...); err != nil {
+		return "", false, fmt.Errorf("scanning previous value of %s: %w", table.identifier, err)
+	}
+
+	row := make(map[string]*string, len(columns))
+	for i, column := range columns {
+		if values[i].Valid {
+			row[column] = &values[i].String
+		} else {
+			row[column] = nil
+		}
+	}
+
+	out, err := json.Marshal(row)
+	if err != nil {
+		return "", false, fmt.Errorf("marshalling previous value of %s: %w", table.identifier, err)
+	}
+
+	return string(out), true, nil
+}
+
+func (d duckdbDialect) prepareColValues(table *TableInfo, colValues map[string]string) (columns []string, values []any, err error) {
+	if len(colValues) == 0 {
+		return
+	}
+
+	columns = maps.Keys(colValues)
+	sort.Strings(columns) // sorted for determinism in tests
+
+	values = make([]any, len(columns))
+	for i, columnName := range columns {
+		value := colValues[columnName]
+		columnInfo, found := table.columnsByName[columnName]
+		if !found {
+			return nil, nil, fmt.Errorf("cannot find column %q for table %q (valid columns are %q)", columnName, table.identifier, strings.Join(maps.Keys(table.columnsByName), ", "))
+		}
+
+		normalizedValue, err := columnInfo.convertValue(value, d.normalizeValueType)
+		if err != nil {
+			return nil, nil, fmt.Errorf("getting sql value from table %s for column %q raw value %q: %w", table.identifier, columnName, value, err)
+		}
+
+		values[i] = normalizedValue
+		columns[i] = columnInfo.escapedName
+	}
+	return
+}
+
+// normalizeValueType converts the value received from Substreams into the value bound for the
+// column. DuckDB casts text values to the column's type by itself, only booleans are converted here.
+func (d duckdbDialect) normalizeValueType(value string, valueType reflect.Type) (any, error) {
+	if value == NullValue {
+		return nil, nil
+	}
+
+	valueType = baseScanType(valueType)
+	switch {
+	case valueType == reflectTypeTime:
+		// Timestamp columns are converted by `Loader.convertTimestamp`, other time types are parsed by the database
+		return value, nil
+
+	case valueType.Kind() == reflect.Bool:
+		return strconv.ParseBool(value)
+
+	default:
+		return value, nil
+	}
+}
+
+// primaryKeysInClause returns the clause matching any of the received primary keys, a single column
+// key gives `"id" IN (?,?)` while a composite one gives `("id" = ? AND "idx" = ?) OR (...)`.
+func (d duckdbDialect) primaryKeysInClause(primaryKeys []map[string]string, args *queryArgs) string {
+	if len(primaryKeys[0]) == 1 {
+		return getPrimaryKeysInClause(primaryKeys, args)
+	}
+
+	clauses := make([]string, len(primaryKeys))
+	for i, primaryKey := range primaryKeys {
+		clauses[i] = "(" + getPrimaryKeyWhereClause(primaryKey, args) + ")"
+	}
+
+	return strings.Join(clauses, " OR ")
+}
+
+// duckdbTx is a transaction managed with explicit statements on a dedicated connection
+type duckdbTx struct {
+	conn *sql.Conn
+}
+
+func (t *duckdbTx) Rollback() error {
+	defer t.conn.Close()
+
+	_, err := t.conn.ExecContext(context.Background(), "ROLLBACK")
+	return err
+}
+
+func (t *duckdbTx) Commit() error {
+	defer t.conn.Close()
+
+	_, err := t.conn.ExecContext(context.Background(), "COMMIT")
+	return err
+}
+
+func (t *duckdbTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return t.conn.ExecContext(ctx, query, args...)
+}
+
+func (t *duckdbTx) QueryContext(ctx context.Context, query string, args ...any) (Rows, error) {
+	rows, err := t.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,619 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bobg/go-generics/v2/slices"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/streamingfast/cli"
+	sink "github.com/streamingfast/substreams-sink"
+	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
+)
+
+// mysqlMaxBatchRows caps the amount of rows a single batched statement holds
+const mysqlMaxBatchRows = 1000
+
+// mysqlMaxParams is the maximum amount of placeholders MySQL accepts in a single prepared statement
+const mysqlMaxParams = 65535
+
+// mysqlDialect supports MySQL and MariaDB. Identifiers are quoted using backticks, the schema
+// is the database, reorgs are handled through the history table whose previous values are
+// JSON documents produced and read back with MySQL's JSON functions.
+type mysqlDialect struct{}
+
+func (d mysqlDialect) EscapeIdentifier(valueToEscape string) string {
+	if strings.Contains(valueToEscape, "`") {
+		valueToEscape = strings.ReplaceAll(valueToEscape, "`", "``")
+	}
+
+	return "`" + valueToEscape + "`"
+}
+
+func (d mysqlDialect) Revert(tx Tx, ctx context.Context, l *Loader, lastValidFinalBlock uint64) error {
+	query := fmt.Sprintf(`SELECT op,table_name,pk,prev_value,block_num FROM %s WHERE block_num > ? ORDER BY block_num DESC`,
+		d.historyTable(l.schema),
+	)
+
+	rows, err := tx.QueryContext(ctx, query, lastValidFinalBlock)
+	if err != nil {
+		return err
+	}
+
+	var history []*historyRow
+	if rows != nil { // rows will be nil with no error only in testing scenarios
+		history, err = readHistoryRows(rows)
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("iterating on rows from query %q: %w", query, err)
+		}
+	}
+
+	l.logger.Info("reverting forked block block(s)", zap.Uint64("last_valid_final_block", lastValidFinalBlock))
+	for _, row := range history {
+		l.logger.Debug("reverting", zap.String("operation", row.op), zap.String("table_name", row.tableName), zap.String("pk", row.pk), zap.Uint64("block_num", row.blockNum))
+
+		if err := d.revertOp(tx, ctx, row.op, row.tableName, row.pk, row.prevValue, d.binaryColumns(l.tables[l.historyTableName(row.tableName)])); err != nil {
+			return fmt.Errorf("revertOp: %w", err)
+		}
+	}
+
+	pruneHistory := fmt.Sprintf(`DELETE FROM %s WHERE block_num > ?;`, d.historyTable(l.schema))
+	if _, err := tx.ExecContext(ctx, pruneHistory, lastValidFinalBlock); err != nil {
+		return fmt.Errorf("executing pruneHistory: %w", err)
+	}
+	return nil
+}
+
+func (d mysqlDialect) Flush(tx Tx, ctx context.Context, l *Loader, outputModuleHash string, lastFinalBlock uint64) (int, error) {
+	var rowCount int
+	for entriesPair := l.entries.Oldest(); entriesPair != nil; entriesPair = entriesPair.Next() {
+		tableName := entriesPair.Key
+		entries := entriesPair.Value
+
+		if l.tracer.Enabled() {
+			l.logger.Debug("flushing table rows", zap.String("table_name", tableName), zap.Int("row_count", entries.Len()))
+		}
+		for _, batch := range batchOperations(entries, mysqlMaxBatchRows, mysqlMaxParams) {
+			statements, err := d.prepareBatchStatements(l.schema, batch)
+			if err != nil {
+				return 0, fmt.Errorf("failed to prepare statement: %w", err)
+			}
+
+			for _, stmt := range batch.keyStatements(statements) {
+				if l.tracer.Enabled() {
+					l.logger.Debug("adding query from batched operations to transaction", zap.String("table_name", tableName), zap.String("op_type", string(batch.opType)), zap.Int("row_count", len(batch.operations)), zap.String("query", stmt.query))
+				}
+
+				if _, err := l.execPrepared(ctx, tx, stmt); err != nil {
+					return 0, fmt.Errorf("executing query %q: %w", stmt.query, err)
+				}
+			}
+		}
+		rowCount += entries.Len()
+	}
+
+	pruneHistory := fmt.Sprintf(`DELETE FROM %s WHERE block_num <= ?;`, d.historyTable(l.schema))
+	if _, err := tx.ExecContext(ctx, pruneHistory, lastFinalBlock); err != nil {
+		return 0, fmt.Errorf("executing prune query %q: %w", pruneHistory, err)
+	}
+
+	return rowCount, nil
+}
+
+// revertOp reverts the operation recorded in a history row, the values of `binaryColumns` are
+// hex encoded in the saved row and are decoded back.
+func (d mysqlDialect) revertOp(tx Tx, ctx context.Context, op, escapedTableName, pk, prevValue string, binaryColumns map[string]bool) error {
+	pkmap := make(map[string]string)
+	if err := json.Unmarshal([]byte(pk), &pkmap); err != nil {
+		return fmt.Errorf("revertOp: unmarshalling %q: %w", pk, err)
+	}
+
+	args := newPositionalArgs()
+	var query string
+	switch op {
+	case "I":
+		query = fmt.Sprintf(`DELETE FROM %s WHERE %s;`,
+			escapedTableName,
+			d.primaryKeyWhereClause("", pkmap, args),
+		)
+
+	case "D":
+		columns, err := d.columnNamesFromJSON(prevValue)
+		if err != nil {
+			return err
+		}
+
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			values[i] = d.jsonColumnValue("v.prev_value", column, binaryColumns[column])
+		}
+
+		query = fmt.Sprintf(`INSERT INTO %s (%s) SELECT %s FROM (SELECT %s AS prev_value) AS v;`,
+			escapedTableName,
+			strings.Join(slices.Map(columns, d.EscapeIdentifier), ","),
+			strings.Join(values, ","),
+			args.add(prevValue),
+		)
+
+	case "U":
+		columns, err := d.columnNamesFromJSON(prevValue)
+		if err != nil {
+			return err
+		}
+
+		updates := make([]string, len(columns))
+		for i, column := range columns {
+			updates[i] = fmt.Sprintf("r.%s=%s", d.EscapeIdentifier(column), d.jsonColumnValue("v.prev_value", column, binaryColumns[column]))
+		}
+
+		query = fmt.Sprintf(`UPDATE %s AS r, (SELECT %s AS prev_value) AS v SET %s WHERE %s;`,
+			escapedTableName,
+			args.add(prevValue),
+			strings.Join(updates, ", "),
+			d.primaryKeyWhereClause("r.", pkmap, args),
+		)
+
+	default:
+		panic("invalid op in revert command")
+	}
+
+	if _, err := tx.ExecContext(ctx, query, args.args...); err != nil {
+		return fmt.Errorf("executing revert query %q: %w", query, err)
+	}
+	return nil
+}
+
+// jsonColumnValue returns the expression extracting the value of `column` out of the JSON
+// `document`, JSON nulls are turned into SQL NULL instead of the 'null' string. The value of a
+// binary column is decoded from its hex representation.
+func (d mysqlDialect) jsonColumnValue(document string, column string, binary bool) string {
+	path := mysqlStringLiteral(`$."` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(column) + `"`)
+
+	value := fmt.Sprintf("JSON_UNQUOTE(JSON_EXTRACT(%s,%s))", document, path)
+	if binary {
+		value = "UNHEX(" + value + ")"
+	}
+
+	return fmt.Sprintf("CASE JSON_TYPE(JSON_EXTRACT(%s,%s)) WHEN 'NULL' THEN NULL ELSE %s END", document, path, value)
+}
+
+// binaryColumns returns the names of the table's columns holding raw bytes, nil for an unknown table
+func (d mysqlDialect) binaryColumns(table *TableInfo) map[string]bool {
+	if table == nil {
+		return nil
+	}
+
+	out := map[string]bool{}
+	for name, column := range table.columnsByName {
+		if isMySQLBinaryColumn(column) {
+			out[name] = true
+		}
+	}
+
+	return out
+}
+
+// isMySQLBinaryColumn returns whether the column holds raw bytes, `JSON_OBJECT` encodes those with
+// MySQL's own base64 format so they are saved hex encoded in the history rows instead.
+func isMySQLBinaryColumn(column *ColumnInfo) bool {
+	return binaryColumnTypes[valueConverterKeys(column.databaseTypeName)[0]]
+}
+
+func (d mysqlDialect) columnNamesFromJSON(in string) ([]string, error) {
+	valueMap := make(map[string]any)
+	if err := json.Unmarshal([]byte(in), &valueMap); err != nil {
+		return nil, fmt.Errorf("unmarshalling %q into valueMap: %w", in, err)
+	}
+
+	columns := maps.Keys(valueMap)
+	sort.Strings(columns)
+
+	return columns, nil
+}
+
+func (d mysqlDialect) GetCreateCursorQuery(schema string, withPostgraphile bool) string {
+	_ = withPostgraphile // Postgraphile only exists for Postgres
+	return fmt.Sprintf(cli.Dedent(`
+		CREATE TABLE IF NOT EXISTS %s.%s
+		(
+			id         VARCHAR(255) NOT NULL PRIMARY KEY,
+			%s   TEXT NOT NULL,
+			block_num  BIGINT NOT NULL,
+			block_id   VARCHAR(255) NOT NULL
+		);
+		`),
+		d.EscapeIdentifier(schema), d.EscapeIdentifier(CURSORS_TABLE), d.EscapeIdentifier("cursor"),
+	)
+}
+
+func (d mysqlDialect) GetCreateHistoryQuery(schema string, withPostgraphile bool) string {
+	_ = withPostgraphile // Postgraphile only exists for Postgres
+	return fmt.Sprintf(cli.Dedent(`
+		CREATE TABLE IF NOT EXISTS %s
+		(
+			id           BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+			op           CHAR(1),
+			table_name   TEXT,
+			pk           TEXT,
+			prev_value   JSON,
+			block_num    BIGINT
+		);
+		`),
+		d.historyTable(schema),
+	)
+}
+
+func (d mysqlDialect) ExecuteSetupScript(ctx context.Context, l *Loader, schemaSql string) error {
+	// The connection is opened with `multiStatements` so the script can be sent as a whole
+	if _, err := l.ExecContext(ctx, schemaSql); err != nil {
+		return fmt.Errorf("exec schema: %w", err)
+	}
+	return nil
+}
+
+func (d mysqlDialect) GetAllCursorsQuery(table string) string {
+	return fmt.Sprintf("SELECT id, %s, block_num, block_id FROM %s", d.EscapeIdentifier("cursor"), table)
+}
+
+func (d mysqlDialect) GetInsertCursorQuery(table, moduleHash string, cursor *sink.Cursor, block_num uint64, block_id string) (string, []any) {
+	return query(`
+		INSERT INTO %s (id, %s, block_num, block_id) VALUES (?, ?, ?, ?);
+	`, table, d.EscapeIdentifier("cursor")), []any{moduleHash, cursor.String(), block_num, block_id}
+}
+
+func (d mysqlDialect) GetUpdateCursorQuery(table, moduleHash string, cursor *sink.Cursor, block_num uint64, block_id string) (string, []any) {
+	return query(`
+		UPDATE %s SET %s = ?, block_num = ?, block_id = ? WHERE id = ?;
+	`, table, d.EscapeIdentifier("cursor")), []any{cursor.String(), block_num, block_id, moduleHash}
+}
+
+func (d mysqlDialect) GetDeleteCursorQuery(table, moduleHash string) (string, []any) {
+	return query(`
+		DELETE FROM %s WHERE id = ?;
+	`, table), []any{moduleHash}
+}
+
+func (d mysqlDialect) DriverSupportRowsAffected() bool {
+	return true
+}
+
+func (d mysqlDialect) OnlyInserts() bool {
+	return false
+}
+
+func (d mysqlDialect) historyTable(schema string) string {
+	return fmt.Sprintf("%s.%s", d.EscapeIdentifier(schema), d.EscapeIdentifier(HISTORY_TABLE))
+}
+
+// prepareBatchStatements returns the statements applying the operations of the batch preceded by
+// the statement saving the history of its reversible operations, if any.
+//
+// Inserts are turned into a multi-row `INSERT`, upserts and the inserts of soft deleted tables
+// into a multi-row `INSERT ... ON DUPLICATE KEY UPDATE`, replaces into a delete followed by the
+// same upsert and deletes into `DELETE ... WHERE <pk> IN (...)`. MySQL has no set based update
+// from a list of values, each update is its own statement.
+func (d mysqlDialect) prepareBatchStatements(schema string, b *operationBatch) ([]*statement, error) {
+	if b.opType != OperationTypeInsert {
+		for _, o := range b.operations {
+			// A table without a primary key set yield a `primaryKey` map with a single entry where the key is an empty string
+			if _, found := o.primaryKey[""]; found {
+				return nil, fmt.Errorf("trying to perform %s operation but table %q don't have a primary key set, this is not accepted", o.opType, o.table.name)
+			}
+		}
+	}
+
+	switch b.opType {
+	case OperationTypeInsert, OperationTypeUpsert, OperationTypeReplace:
+		args := newPositionalArgs()
+
+		var columns []string
+		rows := make([]string, len(b.operations))
+		for i, o := range b.operations {
+			var values []any
+			var err error
+			columns, values, err = d.prepareColValues(o.table, o.data)
+			if err != nil {
+				return nil, fmt.Errorf("preparing column & values: %w", err)
+			}
+
+			rows[i] = "(" + strings.Join(slices.Map(values, args.add), ",") + ")"
+		}
+
+		insertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s",
+			b.table.identifier,
+			strings.Join(columns, ","),
+			strings.Join(rows, ","),
+		)
+
+		if b.opType == OperationTypeInsert && !b.upsertsOnConflict() {
+			return withHistory(d.saveInserts(schema, b.table.identifier, b.reversibleOperations()), args.statement(insertQuery+";")), nil
+		}
+
+		updates := make([]string, len(columns))
+		for i, column := range columns {
+			value := "VALUES(" + column + ")"
+			updates[i] = column + "=" + mysqlFieldUpdateSQL.expression(b.fieldOps[column], column, func() string { return value })
+		}
+		upsert := args.statement(insertQuery + " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ") + ";")
+
+		// The history records the row as it was before being written, a replace deleting it first
+		history := d.saveUpserts(schema, b.table, b.reversibleOperations())
+		if b.opType == OperationTypeReplace {
+			return append(withHistory(history, d.deleteStatement(b)), upsert), nil
+		}
+
+		return withHistory(history, upsert), nil
+
+	case OperationTypeUpdate:
+		if len(b.columns) == 0 {
+			// Nothing to update, the row stays untouched
+			return nil, nil
+		}
+
+		var statements []*statement
+		if history := d.saveRows("U", schema, b.table, b.reversibleOperations()); history != nil {
+			statements = append(statements, history)
+		}
+
+		for _, o := range b.operations {
+			columns, values, err := d.prepareColValues(o.table, o.data)
+			if err != nil {
+				return nil, fmt.Errorf("preparing column & values: %w", err)
+			}
+
+			args := newPositionalArgs()
+			updates := make([]string, len(columns))
+			for i, column := range columns {
+				updates[i] = column + "=" + mysqlFieldUpdateSQL.expression(b.fieldOps[column], column, func() string { return args.add(values[i]) })
+			}
+
+			update := args.statement(fmt.Sprintf("UPDATE %s SET %s WHERE %s;",
+				o.table.identifier,
+				strings.Join(updates, ", "),
+				d.primaryKeyWhereClause("", o.primaryKey, args),
+			))
+			// The rows of the batch are all updated by the same query, prepared once
+			update.key = o.shapeKey(b.columns)
+			statements = append(statements, update)
+		}
+
+		return statements, nil
+
+	case OperationTypeDelete:
+		return withHistory(d.saveRows("D", schema, b.table, b.reversibleOperations()), d.deleteStatement(b)), nil
+
+	default:
+		panic(fmt.Errorf("unknown operation type %q", b.opType))
+	}
+}
+
+// deleteStatement returns the statement deleting all the rows of the batch at once
+func (d mysqlDialect) deleteStatement(b *operationBatch) *statement {
+	args := newPositionalArgs()
+	return args.statement(fmt.Sprintf("DELETE FROM %s WHERE %s;",
+		b.table.identifier,
+		d.primaryKeysInClause(b.primaryKeys(), args),
+	))
+}
+
+// oldValuesStatement returns the statement checking that the operation's row holds its old values
+func (d mysqlDialect) oldValuesStatement(op *Operation) (*statement, error) {
+	columns, values, err := d.prepareColValues(op.table, op.oldValues.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	args := newPositionalArgs()
+	return args.statement(oldValuesQuery(op.table, columns, values, args, func() string {
+		return d.primaryKeyWhereClause("", op.primaryKey, args)
+	})), nil
+}
+
+// saveInserts returns the statement recording in the history table the reversible inserts
+// of the batch, nil is returned if none of the operations is reversible.
+func (d mysqlDialect) saveInserts(schema string, table string, ops []*Operation) *statement {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	args := newPositionalArgs()
+	rows := make([]string, len(ops))
+	for i, op := range ops {
+		rows[i] = fmt.Sprintf("(%s,%s,%s,%s)",
+			args.add("I"),
+			args.add(table),
+			args.add(primaryKeyToJSON(op.primaryKey)),
+			args.add(*op.reversibleBlockNum),
+		)
+	}
+
+	return args.statement(fmt.Sprintf(`INSERT INTO %s (op,table_name,pk,block_num) VALUES %s;`,
+		d.historyTable(schema),
+		strings.Join(rows, ","),
+	))
+}
+
+// saveRows returns a single statement recording in the history table the current value of each
+// row touched by the reversible operations, nil is returned if there is none. The row is saved as
+// a JSON document built with `JSON_OBJECT` out of all the table's columns.
+func (d mysqlDialect) saveRows(op, schema string, table *TableInfo, ops []*Operation) *statement {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	rowJSON := d.rowJSON(table)
+
+	args := newPositionalArgs()
+	selects := make([]string, len(ops))
+	for i, o := range ops {
+		selects[i] = fmt.Sprintf(`SELECT %s,%s,%s,%s,%s FROM %s WHERE %s`,
+			args.add(op), args.add(table.identifier), args.add(primaryKeyToJSON(o.primaryKey)), rowJSON, args.add(*o.reversibleBlockNum),
+			table.identifier,
+			d.primaryKeyWhereClause("", o.primaryKey, args),
+		)
+	}
+
+	return args.statement(fmt.Sprintf(`INSERT INTO %s (op,table_name,pk,prev_value,block_num) %s;`,
+		d.historyTable(schema),
+		strings.Join(selects, " UNION ALL "),
+	))
+}
+
+// saveUpserts returns a single statement recording in the history table whether each row touched
+// by the reversible upserts existed, nil is returned if there is none. An existing row is recorded
+// as updated along with its current value, a missing one as inserted.
+func (d mysqlDialect) saveUpserts(schema string, table *TableInfo, ops []*Operation) *statement {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	rowJSON := d.rowJSON(table)
+
+	args := newPositionalArgs()
+	selects := make([]string, 2*len(ops))
+	for i, o := range ops {
+		selects[2*i] = fmt.Sprintf(`SELECT %s,%s,%s,%s,%s FROM %s WHERE %s`,
+			args.add("U"), args.add(table.identifier), args.add(primaryKeyToJSON(o.primaryKey)), rowJSON, args.add(*o.reversibleBlockNum),
+			table.identifier,
+			d.primaryKeyWhereClause("", o.primaryKey, args),
+		)
+		selects[2*i+1] = fmt.Sprintf(`SELECT %s,%s,%s,NULL,%s FROM DUAL WHERE NOT EXISTS (SELECT 1 FROM %s WHERE %s)`,
+			args.add("I"), args.add(table.identifier), args.add(primaryKeyToJSON(o.primaryKey)), args.add(*o.reversibleBlockNum),
+			table.identifier,
+			d.primaryKeyWhereClause("", o.primaryKey, args),
+		)
+	}
+
+	return args.statement(fmt.Sprintf(`INSERT INTO %s (op,table_name,pk,prev_value,block_num) %s;`,
+		d.historyTable(schema),
+		strings.Join(selects, " UNION ALL "),
+	))
+}
+
+// rowJSON returns the expression building the JSON document of the table's row out of all its
+// columns, binary columns being hex encoded.
+func (d mysqlDialect) rowJSON(table *TableInfo) string {
+	columns := maps.Keys(table.columnsByName)
+	sort.Strings(columns)
+
+	jsonFields := make([]string, len(columns))
+	for i, column := range columns {
+		columnInfo := table.columnsByName[column]
+
+		value := columnInfo.escapedName
+		if isMySQLBinaryColumn(columnInfo) {
+			value = "HEX(" + value + ")"
+		}
+		jsonFields[i] = mysqlStringLiteral(column) + "," + value
+	}
+	return "JSON_OBJECT(" + strings.Join(jsonFields, ",") + ")"
+}
+
+func (d mysqlDialect) prepareColValues(table *TableInfo, colValues map[string]string) (columns []string, values []any, err error) {
+	if len(colValues) == 0 {
+		return
+	}
+
+	columns = maps.Keys(colValues)
+	sort.Strings(columns) // sorted for determinism in tests
+
+	values = make([]any, len(columns))
+	for i, columnName := range columns {
+		value := colValues[columnName]
+		columnInfo, found := table.columnsByName[columnName]
+		if !found {
+			return nil, nil, fmt.Errorf("cannot find column %q for table %q (valid columns are %q)", columnName, table.identifier, strings.Join(maps.Keys(table.columnsByName), ", "))
+		}
+
+		normalizedValue, err := columnInfo.convertValue(value, d.normalizeValueType)
+		if err != nil {
+			return nil, nil, fmt.Errorf("getting sql value from table %s for column %q raw value %q: %w", table.identifier, columnName, value, err)
+		}
+
+		values[i] = normalizedValue
+		columns[i] = columnInfo.escapedName
+	}
+	return
+}
+
+// normalizeValueType converts the value received from Substreams into the Go type bound as a
+// parameter for the column's type. The MySQL driver reports nullable columns using the `sql.Null*`
+// types and text or decimal columns as raw bytes, those are handled like their non-null counterpart.
+func (d mysqlDialect) normalizeValueType(value string, valueType reflect.Type) (any, error) {
+	if value == NullValue {
+		return nil, nil
+	}
+
+	valueType = baseScanType(valueType)
+	if valueType == reflectTypeTime {
+		// Timestamp columns are converted by `Loader.convertTimestamp`, other time types are parsed by the database
+		return value, nil
+	}
+
+	switch valueType.Kind() {
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(value, 10, 64)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(value, 10, 64)
+
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(value, 64)
+
+	default:
+		return value, nil
+	}
+}
+
+func (d mysqlDialect) primaryKeyWhereClause(prefix string, primaryKey map[string]string, args *queryArgs) string {
+	keys := maps.Keys(primaryKey)
+	sort.Strings(keys)
+
+	reg := make([]string, len(keys))
+	for i, key := range keys {
+		reg[i] = prefix + d.EscapeIdentifier(key) + " = " + args.add(primaryKey[key])
+	}
+
+	return strings.Join(reg, " AND ")
+}
+
+// primaryKeysInClause returns the clause matching any of the received primary keys, a single column
+// key gives "`id` IN (?,?)" while a composite one gives "(`id`,`idx`) IN ((?,?),(?,?))".
+func (d mysqlDialect) primaryKeysInClause(primaryKeys []map[string]string, args *queryArgs) string {
+	keys := maps.Keys(primaryKeys[0])
+	sort.Strings(keys)
+
+	tuples := make([]string, len(primaryKeys))
+	for i, primaryKey := range primaryKeys {
+		values := make([]string, len(keys))
+		for j, key := range keys {
+			values[j] = args.add(primaryKey[key])
+		}
+
+		tuples[i] = strings.Join(values, ",")
+		if len(keys) > 1 {
+			tuples[i] = "(" + tuples[i] + ")"
+		}
+	}
+
+	columns := strings.Join(slices.Map(keys, d.EscapeIdentifier), ",")
+	if len(keys) > 1 {
+		columns = "(" + columns + ")"
+	}
+
+	return columns + " IN (" + strings.Join(tuples, ",") + ")"
+}
+
+func mysqlStringLiteral(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `''`).Replace(value) + "'"
+}
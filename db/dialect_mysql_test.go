@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMySQLRevertOp(t *testing.T) {
+	type row struct {
+		op         string
+		table_name string
+		pk         string
+		prev_value string
+	}
+
+	tests := []struct {
+		name          string
+		row           row
+		binaryColumns map[string]bool
+		expect        string
+	}{
+		{
+			name: "rollback insert row",
+			row: row{
+				op:         "I",
+				table_name: "`testschema`.`xfer`",
+				pk:         `{"id":"2345"}`,
+			},
+			expect: "DELETE FROM `testschema`.`xfer` WHERE `id` = ?; [2345]",
+		},
+		{
+			name: "rollback delete row",
+			row: row{
+				op:         "D",
+				table_name: "`testschema`.`xfer`",
+				pk:         `{"id":"2345"}`,
+				prev_value: `{"id":"2345","sender":null}`,
+			},
+			expect: "INSERT INTO `testschema`.`xfer` (`id`,`sender`) SELECT " +
+				`CASE JSON_TYPE(JSON_EXTRACT(v.prev_value,'$."id"')) WHEN 'NULL' THEN NULL ELSE JSON_UNQUOTE(JSON_EXTRACT(v.prev_value,'$."id"')) END,` +
+				`CASE JSON_TYPE(JSON_EXTRACT(v.prev_value,'$."sender"')) WHEN 'NULL' THEN NULL ELSE JSON_UNQUOTE(JSON_EXTRACT(v.prev_value,'$."sender"')) END` +
+				` FROM (SELECT ? AS prev_value) AS v; [{"id":"2345","sender":null}]`,
+		},
+		{
+			name: "rollback update row",
+			row: row{
+				op:         "U",
+				table_name: "`testschema`.`xfer`",
+				pk:         `{"id":"2345"}`,
+				prev_value: `{"sender":"0xdead"}`,
+			},
+			expect: "UPDATE `testschema`.`xfer` AS r, (SELECT ? AS prev_value) AS v SET r.`sender`=" +
+				`CASE JSON_TYPE(JSON_EXTRACT(v.prev_value,'$."sender"')) WHEN 'NULL' THEN NULL ELSE JSON_UNQUOTE(JSON_EXTRACT(v.prev_value,'$."sender"')) END` +
+				" WHERE r.`id` = ?; [{\"sender\":\"0xdead\"} 2345]",
+		},
+		{
+			name: "rollback update row with binary column",
+			row: row{
+				op:         "U",
+				table_name: "`testschema`.`xfer`",
+				pk:         `{"id":"2345"}`,
+				prev_value: `{"hash":"DEAD"}`,
+			},
+			binaryColumns: map[string]bool{"hash": true},
+			expect: "UPDATE `testschema`.`xfer` AS r, (SELECT ? AS prev_value) AS v SET r.`hash`=" +
+				`CASE JSON_TYPE(JSON_EXTRACT(v.prev_value,'$."hash"')) WHEN 'NULL' THEN NULL ELSE UNHEX(JSON_UNQUOTE(JSON_EXTRACT(v.prev_value,'$."hash"'))) END` +
+				" WHERE r.`id` = ?; [{\"hash\":\"DEAD\"} 2345]",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := &TestTx{}
+			row := test.row
+
+			err := mysqlDialect{}.revertOp(tx, context.Background(), row.op, row.table_name, row.pk, row.prev_value, test.binaryColumns)
+			require.NoError(t, err)
+			assert.Equal(t, []string{test.expect}, tx.Results())
+		})
+	}
+}
+
+func TestMySQLRowJSON(t *testing.T) {
+	table := mustNewTableInfo("testschema", "blobs", []string{"id"}, map[string]*ColumnInfo{
+		"id":   NewColumnInfo("id", "VARCHAR", ""),
+		"hash": NewColumnInfo("hash", "VARBINARY", []byte{}),
+	})
+
+	assert.Equal(t, `JSON_OBJECT('hash',HEX("hash"),'id',"id")`, mysqlDialect{}.rowJSON(table))
+	assert.Equal(t, map[string]bool{"hash": true}, mysqlDialect{}.binaryColumns(table))
+	assert.Nil(t, mysqlDialect{}.binaryColumns(nil))
+}
+
+func TestMySQLPrimaryKeysInClause(t *testing.T) {
+	args := newPositionalArgs()
+	clause := mysqlDialect{}.primaryKeysInClause([]map[string]string{{"idx": "3", "hash": "0xdead"}, {"idx": "4", "hash": "0xbeef"}}, args)
+
+	assert.Equal(t, "(`hash`,`idx`) IN ((?,?),(?,?))", clause)
+	assert.Equal(t, []any{"0xdead", "3", "0xbeef", "4"}, args.args)
+}
+
+func TestMySQLEscapeIdentifier(t *testing.T) {
+	assert.Equal(t, "`col`", mysqlDialect{}.EscapeIdentifier("col"))
+	assert.Equal(t, "`with``backtick`", mysqlDialect{}.EscapeIdentifier("with`backtick"))
+}
+
+func TestMySQLInsertStatements(t *testing.T) {
+	l, _ := NewTestLoader(zlog, tracer, "testschema", TestTables("testschema"))
+	block := uint64(10)
+
+	require.NoError(t, l.Insert("xfer", map[string]string{"id": "1"}, map[string]string{"from": "a"}, &block))
+	entries, _ := l.entries.Get("xfer")
+	statements, err := mysqlDialect{}.prepareBatchStatements("testschema", batchOperations(entries, postgresMaxBatchRows, postgresMaxParams)[0])
+	require.NoError(t, err)
+	require.Len(t, statements, 2)
+	assert.Equal(t, "INSERT INTO `testschema`.`substreams_history` (op,table_name,pk,block_num) VALUES (?,?,?,?);", statements[0].query)
+	assert.Equal(t, []any{"I", `"testschema"."xfer"`, `{"id":"1"}`, block}, statements[0].args)
+	assert.Equal(t, `INSERT INTO "testschema"."xfer" ("from","id") VALUES (?,?);`, statements[1].query)
+
+	l.reset()
+	require.NoError(t, l.Upsert("xfer", map[string]string{"id": "1"}, map[string]string{"from": "a"}, &block))
+	entries, _ = l.entries.Get("xfer")
+	statements, err = mysqlDialect{}.prepareBatchStatements("testschema", batchOperations(entries, postgresMaxBatchRows, postgresMaxParams)[0])
+	require.NoError(t, err)
+	require.Len(t, statements, 2)
+	assert.Contains(t, statements[0].query, "SELECT ?,?,?,NULL,? FROM DUAL WHERE NOT EXISTS")
+	assert.Equal(t, `INSERT INTO "testschema"."xfer" ("from","id") VALUES (?,?) ON DUPLICATE KEY UPDATE "from"=VALUES("from"), "id"=VALUES("id");`, statements[1].query)
+}
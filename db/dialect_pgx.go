@@ -0,0 +1,216 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"go.uber.org/zap"
+)
+
+// pgxDialect is the Postgres dialect used when connecting through the pgx driver. It shares
+// its queries with the `postgresDialect` but sends them over pgx's native API: the statements
+// of a flush are pipelined in batches and inserts are written using the binary `COPY` protocol.
+type pgxDialect struct {
+	postgresDialect
+}
+
+// BeginTx opens a native pgx transaction on a connection acquired from the `database/sql` pool,
+// so that the loader holds a single pool whichever API it uses.
+func (d pgxDialect) BeginTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions) (Tx, error) {
+	conn, err := stdlib.AcquireConn(db)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring pgx connection: %w", err)
+	}
+
+	tx, err := conn.BeginTx(ctx, pgxTxOptions(opts))
+	if err != nil {
+		stdlib.ReleaseConn(db, conn)
+		return nil, err
+	}
+
+	return &pgxTx{db: db, conn: conn, tx: tx}, nil
+}
+
+func (d pgxDialect) Revert(tx Tx, ctx context.Context, l *Loader, lastValidFinalBlock uint64) error {
+	ptx, err := asPgxTx(tx)
+	if err != nil {
+		return err
+	}
+
+	query := d.revertHistoryQuery(l.schema)
+	rows, err := ptx.tx.Query(ctx, query, lastValidFinalBlock)
+	if err != nil {
+		return err
+	}
+
+	history, err := readHistoryRows(rows)
+	rows.Close()
+	if err != nil {
+		return fmt.Errorf("iterating on rows from query %q: %w", query, err)
+	}
+
+	return d.revertHistory(tx, ctx, l, history, lastValidFinalBlock)
+}
+
+func (d pgxDialect) Flush(tx Tx, ctx context.Context, l *Loader, outputModuleHash string, lastFinalBlock uint64) (int, error) {
+	ptx, err := asPgxTx(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	var rowCount int
+	var pipeline []*statement
+	for entriesPair := l.entries.Oldest(); entriesPair != nil; entriesPair = entriesPair.Next() {
+		tableName := entriesPair.Key
+		entries := entriesPair.Value
+
+		if l.tracer.Enabled() {
+			l.logger.Debug("flushing table rows", zap.String("table_name", tableName), zap.Int("row_count", entries.Len()))
+		}
+		for _, batch := range batchOperations(entries, postgresMaxBatchRows, postgresMaxParams) {
+			// Inserts of soft deleted tables may revive an existing row, which `COPY` cannot do
+			if batch.opType != OperationTypeInsert || batch.upsertsOnConflict() {
+				statements, err := d.prepareBatchStatements(l.schema, batch)
+				if err != nil {
+					return 0, fmt.Errorf("failed to prepare statement: %w", err)
+				}
+
+				pipeline = append(pipeline, statements...)
+				continue
+			}
+
+			if history := d.saveInserts(l.schema, batch.table.identifier, batch.reversibleOperations()); history != nil {
+				pipeline = append(pipeline, history)
+			}
+
+			// The `COPY` runs outside of the pipeline, what was queued so far is sent first to keep the operations order
+			if err := d.sendPipeline(ctx, ptx, pipeline); err != nil {
+				return 0, err
+			}
+			pipeline = nil
+
+			if err := d.copyInserts(ctx, ptx, batch); err != nil {
+				return 0, fmt.Errorf("copying rows into %q: %w", tableName, err)
+			}
+		}
+		rowCount += entries.Len()
+	}
+
+	if err := d.sendPipeline(ctx, ptx, pipeline); err != nil {
+		return 0, err
+	}
+
+	if err := d.pruneReversibleSegment(tx, ctx, l.schema, lastFinalBlock); err != nil {
+		return 0, err
+	}
+
+	return rowCount, nil
+}
+
+// sendPipeline sends all the statements to the database in a single round trip
+func (d pgxDialect) sendPipeline(ctx context.Context, tx *pgxTx, statements []*statement) error {
+	if len(statements) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, stmt := range statements {
+		batch.Queue(stmt.query, stmt.args...)
+	}
+
+	results := tx.tx.SendBatch(ctx, batch)
+	for _, stmt := range statements {
+		if _, err := results.Exec(); err != nil {
+			results.Close()
+			return fmt.Errorf("executing query %q: %w", stmt.query, err)
+		}
+	}
+
+	return results.Close()
+}
+
+// copyInserts writes the rows of the insert batch using `COPY ... FROM STDIN (FORMAT binary)`
+func (d pgxDialect) copyInserts(ctx context.Context, tx *pgxTx, b *operationBatch) error {
+	connInfo := tx.tx.Conn().ConnInfo()
+
+	columns := make([]string, 0, len(b.operations[0].data))
+	for column := range b.operations[0].data {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	rows := make([][]any, len(b.operations))
+	for i, o := range b.operations {
+		row := make([]any, len(columns))
+		for j, columnName := range columns {
+			columnInfo, found := o.table.columnsByName[columnName]
+			if !found {
+				return fmt.Errorf("cannot find column %q for table %q", columnName, o.table.identifier)
+			}
+
+			value, err := columnInfo.convertValue(o.data[columnName], d.normalizeValueType)
+			if err != nil {
+				return fmt.Errorf("getting sql value from table %s for column %q raw value %q: %w", o.table.identifier, columnName, o.data[columnName], err)
+			}
+
+			if row[j], err = copyValue(connInfo, columnInfo, value); err != nil {
+				return fmt.Errorf("converting value of column %q for table %s: %w", columnName, o.table.identifier, err)
+			}
+		}
+		rows[i] = row
+	}
+
+	_, err := tx.tx.CopyFrom(ctx, pgx.Identifier{b.table.schema, b.table.name}, columns, pgx.CopyFromRows(rows))
+	return err
+}
+
+// copyValue converts a normalized value into one that can be written with the binary `COPY`
+// format. Strings are sent as-is by pgx which is only valid for textual columns, for other
+// types the string is parsed from its text representation by the column's `pgtype`.
+func copyValue(connInfo *pgtype.ConnInfo, column *ColumnInfo, value any) (any, error) {
+	if valuer, ok := value.(driver.Valuer); ok {
+		// Values of the built-in converters, like JSON documents, are textual
+		var err error
+		if value, err = valuer.Value(); err != nil {
+			return nil, err
+		}
+	}
+
+	str, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+
+	dataType, found := connInfo.DataTypeForName(strings.ToLower(column.databaseTypeName))
+	if !found {
+		// Unknown types, like enums, are textual so the raw string is valid
+		return value, nil
+	}
+
+	decoder, ok := pgtype.NewValue(dataType.Value).(pgtype.TextDecoder)
+	if !ok {
+		return value, nil
+	}
+
+	if err := decoder.DecodeText(connInfo, []byte(str)); err != nil {
+		return nil, fmt.Errorf("parsing %q as %s: %w", str, column.databaseTypeName, err)
+	}
+
+	return decoder, nil
+}
+
+func asPgxTx(tx Tx) (*pgxTx, error) {
+	ptx, ok := tx.(*pgxTx)
+	if !ok {
+		return nil, fmt.Errorf("pgx dialect requires a pgx transaction, got %T", tx)
+	}
+
+	return ptx, nil
+}
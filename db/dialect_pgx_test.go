@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		column *ColumnInfo
+		value  any
+		expect any
+	}{
+		{
+			name:   "non string value",
+			column: NewColumnInfo("col", "INT8", int64(0)),
+			value:  int64(12),
+			expect: int64(12),
+		},
+		{
+			name:   "unknown type",
+			column: NewColumnInfo("col", "MY_ENUM", ""),
+			value:  "first",
+			expect: "first",
+		},
+		{
+			name:   "numeric",
+			column: NewColumnInfo("col", "NUMERIC", ""),
+			value:  "1.5",
+			expect: &pgtype.Numeric{Int: big.NewInt(15), Exp: -1, Status: pgtype.Present},
+		},
+		{
+			name:   "bytea",
+			column: NewColumnInfo("col", "BYTEA", []byte{}),
+			value:  `\xdead`,
+			expect: &pgtype.Bytea{Bytes: []byte{0xde, 0xad}, Status: pgtype.Present},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := copyValue(pgtype.NewConnInfo(), test.column, test.value)
+			require.NoError(t, err)
+			assert.Equal(t, test.expect, value)
+		})
+	}
+}
+
+// fakePgxTx is a native pgx transaction answering every query with the same rows
+type fakePgxTx struct {
+	pgx.Tx
+
+	queries []string
+	rows    [][]any
+}
+
+func (t *fakePgxTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	t.queries = append(t.queries, sql)
+	return &fakePgxRows{rows: t.rows}, nil
+}
+
+type fakePgxRows struct {
+	pgx.Rows
+
+	rows    [][]any
+	current int
+}
+
+func (r *fakePgxRows) Next() bool {
+	r.current++
+	return r.current <= len(r.rows)
+}
+
+func (r *fakePgxRows) Scan(dest ...any) error {
+	for i, value := range r.rows[r.current-1] {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(value))
+	}
+	return nil
+}
+
+func (r *fakePgxRows) Err() error {
+	return nil
+}
+
+func (r *fakePgxRows) Close() {}
+
+func TestPgxTxCheckOldValues(t *testing.T) {
+	l, _ := NewTestLoader(zlog, tracer, "testschema", TestTables("testschema"))
+	require.NoError(t, l.SetCheckOldValues(true))
+	require.NoError(t, l.UpdateWithOldValues("xfer", map[string]string{"id": "1"}, map[string]string{"to": "b"}, &OldValues{BlockNum: 10, Data: map[string]string{"to": "a"}}, nil))
+
+	tx := &fakePgxTx{rows: [][]any{{int64(1), int64(0)}}}
+	err := l.verifyOldValues(context.Background(), &pgxTx{tx: tx})
+
+	var divergence *DivergenceError
+	require.True(t, errors.As(err, &divergence))
+	assert.Equal(t, uint64(10), divergence.BlockNum)
+	assert.Equal(t, []string{`SELECT 1,CASE WHEN "to" = $1 THEN 1 ELSE 0 END FROM "testschema"."xfer" WHERE "id" = $2;`}, tx.queries)
+}
+
+func TestPgxTxDetachRollupGroups(t *testing.T) {
+	l, _ := NewTestLoader(zlog, tracer, "testschema", featureTestTables("testschema"))
+	require.NoError(t, l.SetRollups([]*Rollup{tradesHourlyRollup()}))
+
+	tx := &fakePgxTx{rows: [][]any{{"2023-11-14 22:00:00+00", "eth"}, {"2023-11-14 23:00:00+00", "btc"}}}
+	detached, err := l.detachRollupGroups(context.Background(), &pgxTx{tx: tx}, rollupRows{"trades": {{"id": "1"}, {"id": "2"}}})
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"2023-11-14 22:00:00+00", "eth"}, {"2023-11-14 23:00:00+00", "btc"}}, detached[l.rollups[0]])
+}
@@ -3,14 +3,15 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"reflect"
 	"sort"
 	"strconv"
 	"strings"
-	"time"
 
+	"github.com/bobg/go-generics/v2/slices"
 	"github.com/streamingfast/cli"
 	sink "github.com/streamingfast/substreams-sink"
 	"go.uber.org/zap"
@@ -20,51 +21,107 @@ import (
 type postgresDialect struct{}
 
 func (d postgresDialect) Revert(tx Tx, ctx context.Context, l *Loader, lastValidFinalBlock uint64) error {
-	query := fmt.Sprintf(`SELECT op,table_name,pk,prev_value,block_num FROM %s WHERE "block_num" > %d ORDER BY "block_num" DESC`,
-		d.historyTable(l.schema),
-		lastValidFinalBlock,
-	)
+	query := d.revertHistoryQuery(l.schema)
 
-	rows, err := tx.QueryContext(ctx, query)
+	rows, err := tx.QueryContext(ctx, query, lastValidFinalBlock)
 	if err != nil {
 		return err
 	}
 
-	l.logger.Info("reverting forked block block(s)", zap.Uint64("last_valid_final_block", lastValidFinalBlock))
+	var history []*historyRow
 	if rows != nil { // rows will be nil with no error only in testing scenarios
-		defer rows.Close()
-		for rows.Next() {
-			var op string
-			var table_name string
-			var pk string
-			var prev_value_nullable sql.NullString
-			var block_num uint64
-			if err := rows.Scan(&op, &table_name, &pk, &prev_value_nullable, &block_num); err != nil {
-				return fmt.Errorf("scanning row: %w", err)
-			}
-			l.logger.Debug("reverting", zap.String("operation", op), zap.String("table_name", table_name), zap.String("pk", pk), zap.Uint64("block_num", block_num))
-			prev_value := prev_value_nullable.String
+		history, err = readHistoryRows(rows)
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("iterating on rows from query %q: %w", query, err)
+		}
+	}
 
-			if err := d.revertOp(tx, ctx, op, table_name, pk, prev_value, block_num); err != nil {
-				return fmt.Errorf("revertOp: %w", err)
-			}
+	return d.revertHistory(tx, ctx, l, history, lastValidFinalBlock)
+}
+
+func (d postgresDialect) revertHistoryQuery(schema string) string {
+	return fmt.Sprintf(`SELECT op,table_name,pk,prev_value,block_num FROM %s WHERE "block_num" > $1 ORDER BY "block_num" DESC`,
+		d.historyTable(schema),
+	)
+}
+
+// historyRow is a row of the history table, read before being reverted
+type historyRow struct {
+	op        string
+	tableName string
+	pk        string
+	prevValue string
+	blockNum  uint64
+}
+
+// historyRows is implemented by both `*sql.Rows` and `pgx.Rows`
+type historyRows interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+}
+
+// readHistoryRows reads all the history rows up front, the connection cannot execute the revert
+// queries while the rows are still being streamed from it.
+func readHistoryRows(rows historyRows) (out []*historyRow, err error) {
+	for rows.Next() {
+		row := &historyRow{}
+		var prevValue sql.NullString
+		if err := rows.Scan(&row.op, &row.tableName, &row.pk, &prevValue, &row.blockNum); err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
 		}
-		if err := rows.Err(); err != nil {
-			return fmt.Errorf("iterating on rows from query %q: %w", query, err)
+
+		row.prevValue = prevValue.String
+		out = append(out, row)
+	}
+
+	return out, rows.Err()
+}
+
+func (d postgresDialect) revertHistory(tx Tx, ctx context.Context, l *Loader, history []*historyRow, lastValidFinalBlock uint64) error {
+	l.logger.Info("reverting forked block block(s)", zap.Uint64("last_valid_final_block", lastValidFinalBlock))
+	l.logRevertedHistory(history)
+
+	rollupRows, err := l.revertedRollupRows(history)
+	if err != nil {
+		return fmt.Errorf("reverted rollup rows: %w", err)
+	}
+
+	detached, err := l.detachRollupGroups(ctx, tx, rollupRows)
+	if err != nil {
+		return fmt.Errorf("detach rollup groups: %w", err)
+	}
+
+	for _, row := range history {
+		l.logger.Debug("reverting", zap.String("operation", row.op), zap.String("table_name", row.tableName), zap.String("pk", row.pk), zap.Uint64("block_num", row.blockNum))
+
+		if err := d.revertOp(tx, ctx, row.op, row.tableName, row.pk, row.prevValue, row.blockNum); err != nil {
+			return fmt.Errorf("revertOp: %w", err)
 		}
 	}
-	pruneHistory := fmt.Sprintf(`DELETE FROM %s WHERE "block_num" > %d;`,
+
+	if err := l.attachRollupGroups(ctx, tx, rollupRows, detached); err != nil {
+		return fmt.Errorf("attach rollup groups: %w", err)
+	}
+
+	pruneHistory := fmt.Sprintf(`DELETE FROM %s WHERE "block_num" > $1;`,
 		d.historyTable(l.schema),
-		lastValidFinalBlock,
 	)
 
-	_, err = tx.ExecContext(ctx, pruneHistory)
-	if err != nil {
+	if _, err := tx.ExecContext(ctx, pruneHistory, lastValidFinalBlock); err != nil {
 		return fmt.Errorf("executing pruneHistory: %w", err)
 	}
 	return nil
 }
 
+// postgresMaxBatchRows caps the amount of rows a single batched statement holds, a
+// large catch up flush is split in multiple statements of at most that many rows.
+const postgresMaxBatchRows = 1000
+
+// postgresMaxParams is the maximum amount of bind parameters Postgres accepts in a single statement
+const postgresMaxParams = 65535
+
 func (d postgresDialect) Flush(tx Tx, ctx context.Context, l *Loader, outputModuleHash string, lastFinalBlock uint64) (int, error) {
 	var rowCount int
 	for entriesPair := l.entries.Oldest(); entriesPair != nil; entriesPair = entriesPair.Next() {
@@ -74,20 +131,20 @@ func (d postgresDialect) Flush(tx Tx, ctx context.Context, l *Loader, outputModu
 		if l.tracer.Enabled() {
 			l.logger.Debug("flushing table rows", zap.String("table_name", tableName), zap.Int("row_count", entries.Len()))
 		}
-		for entryPair := entries.Oldest(); entryPair != nil; entryPair = entryPair.Next() {
-			entry := entryPair.Value
-
-			query, err := d.prepareStatement(l.schema, entry)
+		for _, batch := range batchOperations(entries, postgresMaxBatchRows, postgresMaxParams) {
+			statements, err := d.prepareBatchStatements(l.schema, batch)
 			if err != nil {
 				return 0, fmt.Errorf("failed to prepare statement: %w", err)
 			}
 
-			if l.tracer.Enabled() {
-				l.logger.Debug("adding query from operation to transaction", zap.Stringer("op", entry), zap.String("query", query))
-			}
+			for _, stmt := range batch.keyStatements(statements) {
+				if l.tracer.Enabled() {
+					l.logger.Debug("adding query from batched operations to transaction", zap.String("table_name", tableName), zap.String("op_type", string(batch.opType)), zap.Int("row_count", len(batch.operations)), zap.String("query", stmt.query))
+				}
 
-			if _, err := tx.ExecContext(ctx, query); err != nil {
-				return 0, fmt.Errorf("executing query %q: %w", query, err)
+				if _, err := l.execPrepared(ctx, tx, stmt); err != nil {
+					return 0, fmt.Errorf("executing query %q: %w", stmt.query, err)
+				}
 			}
 		}
 		rowCount += entries.Len()
@@ -106,22 +163,24 @@ func (d postgresDialect) revertOp(tx Tx, ctx context.Context, op, escaped_table_
 	if err := json.Unmarshal([]byte(pk), &pkmap); err != nil {
 		return fmt.Errorf("revertOp: unmarshalling %q: %w", pk, err)
 	}
+
+	args := newPostgresArgs()
 	switch op {
 	case "I":
 		query := fmt.Sprintf(`DELETE FROM %s WHERE %s;`,
 			escaped_table_name,
-			getPrimaryKeyWhereClause(pkmap),
+			getPrimaryKeyWhereClause(pkmap, args),
 		)
-		if _, err := tx.ExecContext(ctx, query); err != nil {
+		if _, err := tx.ExecContext(ctx, query, args.args...); err != nil {
 			return fmt.Errorf("executing revert query %q: %w", query, err)
 		}
 	case "D":
 		query := fmt.Sprintf(`INSERT INTO %s SELECT * FROM json_populate_record(null::%s,%s);`,
 			escaped_table_name,
 			escaped_table_name,
-			escapeStringValue(prev_value),
+			args.add(prev_value),
 		)
-		if _, err := tx.ExecContext(ctx, query); err != nil {
+		if _, err := tx.ExecContext(ctx, query, args.args...); err != nil {
 			return fmt.Errorf("executing revert query %q: %w", query, err)
 		}
 
@@ -136,10 +195,10 @@ func (d postgresDialect) revertOp(tx Tx, ctx context.Context, op, escaped_table_
 			columns,
 			columns,
 			escaped_table_name,
-			escapeStringValue(prev_value),
-			getPrimaryKeyWhereClause(pkmap),
+			args.add(prev_value),
+			getPrimaryKeyWhereClause(pkmap, args),
 		)
-		if _, err := tx.ExecContext(ctx, query); err != nil {
+		if _, err := tx.ExecContext(ctx, query, args.args...); err != nil {
 			return fmt.Errorf("executing revert query %q: %w", query, err)
 		}
 	default:
@@ -165,8 +224,8 @@ func sqlColumnNamesFromJSON(in string) (string, error) {
 }
 
 func (d postgresDialect) pruneReversibleSegment(tx Tx, ctx context.Context, schema string, highestFinalBlock uint64) error {
-	query := fmt.Sprintf(`DELETE FROM %s WHERE block_num <= %d;`, d.historyTable(schema), highestFinalBlock)
-	if _, err := tx.ExecContext(ctx, query); err != nil {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE block_num <= $1;`, d.historyTable(schema))
+	if _, err := tx.ExecContext(ctx, query, highestFinalBlock); err != nil {
 		return fmt.Errorf("executing prune query %q: %w", query, err)
 	}
 	return nil
@@ -210,6 +269,47 @@ func (d postgresDialect) GetCreateHistoryQuery(schema string, withPostgraphile b
 	return out
 }
 
+func (d postgresDialect) GetCreateChangesQuery(schema string, withPostgraphile bool) string {
+	out := fmt.Sprintf(cli.Dedent(`
+		create table if not exists %s
+		(
+			id           BIGSERIAL PRIMARY KEY,
+			op           text not null,
+			table_name   text not null,
+			pk           text,
+			new_value    text,
+			block_num    bigint,
+			block_id     text,
+			revert       boolean not null default false
+		);
+		`),
+		d.changesTable(schema),
+	)
+	if withPostgraphile {
+		out += fmt.Sprintf("COMMENT ON TABLE %s IS E'@omit';", d.changesTable(schema))
+	}
+	return out
+}
+
+func (d postgresDialect) GetCreateBlocksQuery(schema string, withPostgraphile bool) string {
+	out := fmt.Sprintf(cli.Dedent(`
+		create table if not exists %s
+		(
+			block_num        bigint not null primary key,
+			block_id         text not null,
+			block_timestamp  timestamptz,
+			flushed_at       timestamptz not null,
+			row_counts       jsonb not null
+		);
+		`),
+		d.blocksTable(schema),
+	)
+	if withPostgraphile {
+		out += fmt.Sprintf("COMMENT ON TABLE %s IS E'@omit';", d.blocksTable(schema))
+	}
+	return out
+}
+
 func (d postgresDialect) ExecuteSetupScript(ctx context.Context, l *Loader, schemaSql string) error {
 	if _, err := l.ExecContext(ctx, schemaSql); err != nil {
 		return fmt.Errorf("exec schema: %w", err)
@@ -217,14 +317,30 @@ func (d postgresDialect) ExecuteSetupScript(ctx context.Context, l *Loader, sche
 	return nil
 }
 
-func (d postgresDialect) GetUpdateCursorQuery(table, moduleHash string, cursor *sink.Cursor, block_num uint64, block_id string) string {
+func (d postgresDialect) EscapeIdentifier(valueToEscape string) string {
+	return EscapeIdentifier(valueToEscape)
+}
+
+func (d postgresDialect) GetAllCursorsQuery(table string) string {
+	return fmt.Sprintf("SELECT id, cursor, block_num, block_id FROM %s", table)
+}
+
+func (d postgresDialect) GetInsertCursorQuery(table, moduleHash string, cursor *sink.Cursor, block_num uint64, block_id string) (string, []any) {
 	return query(`
-		UPDATE %s set cursor = '%s', block_num = %d, block_id = '%s' WHERE id = '%s';
-	`, table, cursor, block_num, block_id, moduleHash)
+		INSERT INTO %s (id, cursor, block_num, block_id) values ($1, $2, $3, $4);
+	`, table), []any{moduleHash, cursor.String(), block_num, block_id}
 }
 
-func (d postgresDialect) ParseDatetimeNormalization(value string) string {
-	return escapeStringValue(value)
+func (d postgresDialect) GetUpdateCursorQuery(table, moduleHash string, cursor *sink.Cursor, block_num uint64, block_id string) (string, []any) {
+	return query(`
+		UPDATE %s set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4;
+	`, table), []any{cursor.String(), block_num, block_id, moduleHash}
+}
+
+func (d postgresDialect) GetDeleteCursorQuery(table, moduleHash string) (string, []any) {
+	return query(`
+		DELETE FROM %s WHERE id = $1;
+	`, table), []any{moduleHash}
 }
 
 func (d postgresDialect) DriverSupportRowsAffected() bool {
@@ -239,106 +355,665 @@ func (d postgresDialect) historyTable(schema string) string {
 	return fmt.Sprintf("%s.%s", EscapeIdentifier(schema), EscapeIdentifier("substreams_history"))
 }
 
-func (d postgresDialect) saveInsert(schema string, table string, primaryKey map[string]string, blockNum uint64) string {
-	return fmt.Sprintf(`INSERT INTO %s (op,table_name,pk,block_num) values (%s,%s,%s,%d);`,
-		d.historyTable(schema),
-		escapeStringValue("I"),
-		escapeStringValue(table),
-		escapeStringValue(primaryKeyToJSON(primaryKey)),
-		blockNum,
+func (d postgresDialect) changesTable(schema string) string {
+	return fmt.Sprintf("%s.%s", EscapeIdentifier(schema), EscapeIdentifier(CHANGES_TABLE))
+}
+
+// changesStatements returns the multi-row `INSERT` statements appending the changes to the
+// changes log, split in statements of at most `postgresMaxBatchRows` rows.
+func (d postgresDialect) changesStatements(schema string, changes []*change) (out []*statement) {
+	for start := 0; start < len(changes); start += postgresMaxBatchRows {
+		end := start + postgresMaxBatchRows
+		if end > len(changes) {
+			end = len(changes)
+		}
+
+		args := newPostgresArgs()
+		rows := make([]string, 0, end-start)
+		for _, entry := range changes[start:end] {
+			var blockNum, blockID any
+			if entry.block != nil {
+				blockNum, blockID = entry.block.Number, entry.block.ID
+			}
+
+			rows = append(rows, "("+strings.Join([]string{
+				args.add(entry.op),
+				args.add(entry.tableName),
+				args.add(entry.pk),
+				args.add(entry.newValue),
+				args.add(blockNum),
+				args.add(blockID),
+				args.add(entry.revert),
+			}, ",")+")")
+		}
+
+		out = append(out, args.statement(fmt.Sprintf("INSERT INTO %s (op,table_name,pk,new_value,block_num,block_id,revert) VALUES %s;",
+			d.changesTable(schema),
+			strings.Join(rows, ","),
+		)))
+	}
+
+	return out
+}
+
+func (d postgresDialect) blocksTable(schema string) string {
+	return fmt.Sprintf("%s.%s", EscapeIdentifier(schema), EscapeIdentifier(BLOCKS_TABLE))
+}
+
+// blocksStatements returns the multi-row `INSERT` statements of the blocks, split in statements of
+// at most `postgresMaxBatchRows` rows, their flush time being the time of the transaction.
+func (d postgresDialect) blocksStatements(schema string, blocks []*blockEntry) (out []*statement, err error) {
+	for start := 0; start < len(blocks); start += postgresMaxBatchRows {
+		end := start + postgresMaxBatchRows
+		if end > len(blocks) {
+			end = len(blocks)
+		}
+
+		args := newPostgresArgs()
+		rows := make([]string, 0, end-start)
+		for _, entry := range blocks[start:end] {
+			rowCounts, err := entry.rowCountsJSON()
+			if err != nil {
+				return nil, fmt.Errorf("marshalling row counts of block #%d: %w", entry.block.Number, err)
+			}
+
+			var timestamp any
+			if !entry.block.Timestamp.IsZero() {
+				timestamp = entry.block.Timestamp
+			}
+
+			rows = append(rows, "("+strings.Join([]string{
+				args.add(entry.block.Number),
+				args.add(entry.block.ID),
+				args.add(timestamp),
+				"now()",
+				args.add(rowCounts),
+			}, ",")+")")
+		}
+
+		out = append(out, args.statement(fmt.Sprintf("INSERT INTO %s (block_num,block_id,block_timestamp,flushed_at,row_counts) VALUES %s;",
+			d.blocksTable(schema),
+			strings.Join(rows, ","),
+		)))
+	}
+
+	return out, nil
+}
+
+func (d postgresDialect) revertBlocksStatement(schema string, lastValidBlock uint64) *statement {
+	return &statement{
+		query: fmt.Sprintf("DELETE FROM %s WHERE block_num > $1;", d.blocksTable(schema)),
+		args:  []any{lastValidBlock},
+	}
+}
+
+// pruneStatement deletes at most limit expired rows, Postgres not supporting `DELETE ... LIMIT`
+// they are selected by their physical location.
+func (d postgresDialect) pruneStatement(table *TableInfo, column *ColumnInfo, cutoff any, limit int) *statement {
+	return &statement{
+		query: fmt.Sprintf("DELETE FROM %s WHERE ctid = ANY(ARRAY(SELECT ctid FROM %s WHERE %s < $1 LIMIT %d));",
+			table.identifier,
+			table.identifier,
+			column.escapedName,
+			limit,
+		),
+		args: []any{cutoff},
+	}
+}
+
+// rollupDetachStatement deletes the rollup rows of the groups of the source rows, the key of the
+// groups being returned as text to be bound as is when they are computed again.
+func (d postgresDialect) rollupDetachStatement(r *rollup, primaryKeys []map[string]string) *statement {
+	args := newPostgresArgs()
+	keyColumns := slices.Map(r.keyColumns(), EscapeIdentifier)
+	returning := make([]string, len(keyColumns))
+	for i, column := range keyColumns {
+		returning[i] = column + "::text"
+	}
+
+	return args.statement(fmt.Sprintf("DELETE FROM %s WHERE (%s) IN (SELECT %s FROM %s WHERE %s) RETURNING %s;",
+		r.table.identifier,
+		strings.Join(keyColumns, ","),
+		strings.Join(d.rollupGroupExpressions(r), ","),
+		r.source.identifier,
+		getPrimaryKeysInClause(primaryKeys, args),
+		strings.Join(returning, ","),
+	))
+}
+
+// rollupAddStatement aggregates the source rows per group and adds them to the existing rollup
+// rows, a sum being NULL when all of its values are.
+func (d postgresDialect) rollupAddStatement(r *rollup, primaryKeys []map[string]string) *statement {
+	args := newPostgresArgs()
+	where := getPrimaryKeysInClause(primaryKeys, args)
+
+	return args.statement(d.rollupInsertQuery(r, where, func(measure *RollupMeasure, current, excluded string) string {
+		switch measure.Func {
+		case "sum":
+			return fmt.Sprintf("COALESCE(%s+%s,%s,%s)", current, excluded, current, excluded)
+		case "min":
+			return fmt.Sprintf("LEAST(%s,%s)", current, excluded)
+		case "max":
+			return fmt.Sprintf("GREATEST(%s,%s)", current, excluded)
+		default:
+			return current + "+" + excluded
+		}
+	}))
+}
+
+// rollupRecomputeStatement aggregates all the source rows of the groups, overwriting the rollup
+// rows already written for them.
+func (d postgresDialect) rollupRecomputeStatement(r *rollup, groups [][]string) *statement {
+	args := newPostgresArgs()
+	where := getTuplesInClause(d.rollupGroupExpressions(r), groups, args)
+
+	return args.statement(d.rollupInsertQuery(r, where, func(_ *RollupMeasure, _, excluded string) string {
+		return excluded
+	}))
+}
+
+// rollupInsertQuery returns the query inserting the rollup rows aggregated from the source rows
+// matching the where clause, a rollup row already existing being updated by the expression
+// returned by update for each measure.
+func (d postgresDialect) rollupInsertQuery(r *rollup, where string, update func(measure *RollupMeasure, current, excluded string) string) string {
+	keyColumns := slices.Map(r.keyColumns(), EscapeIdentifier)
+	groupExpressions := d.rollupGroupExpressions(r)
+
+	columns := append([]string{}, keyColumns...)
+	selects := append([]string{}, groupExpressions...)
+	groupBy := make([]string, len(groupExpressions))
+	for i := range groupExpressions {
+		groupBy[i] = strconv.Itoa(i + 1)
+	}
+
+	updates := make([]string, len(r.Measures))
+	for i, measure := range r.Measures {
+		column := EscapeIdentifier(measure.Column)
+		argument := "*"
+		if measure.Source != "" {
+			argument = EscapeIdentifier(measure.Source)
+		}
+
+		columns = append(columns, column)
+		selects = append(selects, measure.Func+"("+argument+")")
+		updates[i] = column + "=" + update(measure, r.table.nameEscaped+"."+column, "EXCLUDED."+column)
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s WHERE %s GROUP BY %s ON CONFLICT (%s) DO UPDATE SET %s;",
+		r.table.identifier,
+		strings.Join(columns, ","),
+		strings.Join(selects, ","),
+		r.source.identifier,
+		where,
+		strings.Join(groupBy, ","),
+		strings.Join(keyColumns, ","),
+		strings.Join(updates, ","),
 	)
 }
 
-func (d postgresDialect) saveUpdate(schema string, escapedTableName string, primaryKey map[string]string, blockNum uint64) string {
-	return d.saveRow("U", schema, escapedTableName, primaryKey, blockNum)
+// rollupGroupExpressions returns the expressions computing the key of the rollup group of the
+// source rows, the bucket first.
+func (d postgresDialect) rollupGroupExpressions(r *rollup) []string {
+	out := []string{fmt.Sprintf("date_trunc('%s',%s)", r.Bucket.Interval, EscapeIdentifier(r.Bucket.Column))}
+	for _, column := range r.GroupBy {
+		out = append(out, EscapeIdentifier(column))
+	}
+
+	return out
+}
+
+// saveInserts returns the statement recording in the history table the reversible inserts
+// of the batch, nil is returned if none of the operations is reversible.
+func (d postgresDialect) saveInserts(schema string, table string, ops []*Operation) *statement {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	args := newPostgresArgs()
+	rows := make([]string, len(ops))
+	for i, op := range ops {
+		rows[i] = fmt.Sprintf("(%s,%s,%s,%s)",
+			args.add("I"),
+			args.add(table),
+			args.add(primaryKeyToJSON(op.primaryKey)),
+			args.add(*op.reversibleBlockNum),
+		)
+	}
+
+	return args.statement(fmt.Sprintf(`INSERT INTO %s (op,table_name,pk,block_num) values %s;`,
+		d.historyTable(schema),
+		strings.Join(rows, ","),
+	))
+}
+
+func (d postgresDialect) saveUpdates(schema string, escapedTableName string, ops []*Operation) *statement {
+	return d.saveRows("U", schema, escapedTableName, ops)
 }
 
-func (d postgresDialect) saveDelete(schema string, escapedTableName string, primaryKey map[string]string, blockNum uint64) string {
-	return d.saveRow("D", schema, escapedTableName, primaryKey, blockNum)
+func (d postgresDialect) saveDeletes(schema string, escapedTableName string, ops []*Operation) *statement {
+	return d.saveRows("D", schema, escapedTableName, ops)
 }
 
-func (d postgresDialect) saveRow(op, schema, escapedTableName string, primaryKey map[string]string, blockNum uint64) string {
+// saveRows returns a single statement recording in the history table the current value of each
+// row touched by the reversible operations, nil is returned if there is none.
+func (d postgresDialect) saveRows(op, schema, escapedTableName string, ops []*Operation) *statement {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	args := newPostgresArgs()
 	schemaAndTable := fmt.Sprintf("%s.%s", EscapeIdentifier(schema), escapedTableName)
-	return fmt.Sprintf(`INSERT INTO %s (op,table_name,pk,prev_value,block_num) SELECT %s,%s,%s,row_to_json(%s),%d FROM %s.%s WHERE %s;`,
+	selects := make([]string, len(ops))
+	for i, o := range ops {
+		// Parameters of the selected values are cast explicitly, their type cannot be inferred through the `UNION ALL`
+		selects[i] = fmt.Sprintf(`SELECT %s::text,%s::text,%s::text,row_to_json(%s),%s::bigint FROM %s.%s WHERE %s`,
+			args.add(op), args.add(schemaAndTable), args.add(primaryKeyToJSON(o.primaryKey)), escapedTableName, args.add(*o.reversibleBlockNum),
+			EscapeIdentifier(schema), escapedTableName,
+			getPrimaryKeyWhereClause(o.primaryKey, args),
+		)
+	}
+
+	return args.statement(fmt.Sprintf(`INSERT INTO %s (op,table_name,pk,prev_value,block_num) %s;`,
 		d.historyTable(schema),
-		escapeStringValue(op), escapeStringValue(schemaAndTable), escapeStringValue(primaryKeyToJSON(primaryKey)), escapedTableName, blockNum,
-		EscapeIdentifier(schema), escapedTableName,
-		getPrimaryKeyWhereClause(primaryKey),
-	)
+		strings.Join(selects, " UNION ALL "),
+	))
+}
+
+// saveUpserts returns a single statement recording in the history table whether each row touched
+// by the reversible upserts existed, nil is returned if there is none. An existing row is recorded
+// as updated along with its current value, computed by the rowJSON expression, a missing one as
+// inserted, so that reverting the upsert either restores the row or deletes it.
+func (d postgresDialect) saveUpserts(schema string, table *TableInfo, ops []*Operation, rowJSON string) *statement {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	args := newPostgresArgs()
+	selects := make([]string, 2*len(ops))
+	for i, o := range ops {
+		updateOp, tableName, pk, blockNum := args.add("U"), args.add(table.identifier), args.add(primaryKeyToJSON(o.primaryKey)), args.add(*o.reversibleBlockNum)
+
+		// Parameters of the selected values are cast explicitly, their type cannot be inferred through the `UNION ALL`
+		selects[2*i] = fmt.Sprintf(`SELECT %s::text,%s::text,%s::text,%s,%s::bigint FROM %s WHERE %s`,
+			updateOp, tableName, pk, rowJSON, blockNum,
+			table.identifier,
+			getPrimaryKeyWhereClause(o.primaryKey, args),
+		)
+		selects[2*i+1] = fmt.Sprintf(`SELECT %s::text,%s::text,%s::text,NULL::text,%s::bigint WHERE NOT EXISTS (SELECT 1 FROM %s WHERE %s)`,
+			args.add("I"), tableName, pk, blockNum,
+			table.identifier,
+			getPrimaryKeyWhereClause(o.primaryKey, args),
+		)
+	}
 
+	return args.statement(fmt.Sprintf(`INSERT INTO %s (op,table_name,pk,prev_value,block_num) %s;`,
+		d.historyTable(schema),
+		strings.Join(selects, " UNION ALL "),
+	))
 }
 
-func (d *postgresDialect) prepareStatement(schema string, o *Operation) (string, error) {
-	var columns, values []string
-	if o.opType == OperationTypeInsert || o.opType == OperationTypeUpdate {
-		var err error
-		columns, values, err = d.prepareColValues(o.table, o.data)
-		if err != nil {
-			return "", fmt.Errorf("preparing column & values: %w", err)
+// onConflictUpdateClause returns the `ON CONFLICT` clause of an upsert setting the columns which
+// are not part of the primary key to their new value, or to the result of their field update
+// operation, Postgres, CockroachDB, SQLite and DuckDB sharing the syntax.
+func onConflictUpdateClause(table *TableInfo, escapedColumns []string, fieldOps map[string]FieldUpdateOp, fieldSQL fieldUpdateSQL) string {
+	primaryColumns := make([]string, len(table.primaryColumns))
+	isPrimary := make(map[string]bool, len(table.primaryColumns))
+	for i, column := range table.primaryColumns {
+		primaryColumns[i] = EscapeIdentifier(column.name)
+		isPrimary[primaryColumns[i]] = true
+	}
+
+	var updates []string
+	for _, column := range escapedColumns {
+		if !isPrimary[column] {
+			excluded := "EXCLUDED." + column
+			updates = append(updates, column+"="+fieldSQL.expression(fieldOps[column], table.nameEscaped+"."+column, func() string { return excluded }))
 		}
 	}
 
-	if o.opType == OperationTypeUpdate || o.opType == OperationTypeDelete {
-		// A table without a primary key set yield a `primaryKey` map with a single entry where the key is an empty string
-		if _, found := o.primaryKey[""]; found {
-			return "", fmt.Errorf("trying to perform %s operation but table %q don't have a primary key set, this is not accepted", o.opType, o.table.name)
+	if len(updates) == 0 {
+		return fmt.Sprintf("ON CONFLICT (%s) DO NOTHING", strings.Join(primaryColumns, ","))
+	}
+
+	return fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(primaryColumns, ","), strings.Join(updates, ", "))
+}
+
+// prepareBatchStatements returns the statement applying all the operations of the batch at once
+// preceded by the statement saving the history of its reversible operations, if any.
+//
+// Inserts are turned into a multi-row `INSERT ... VALUES (...),(...)`, upserts and the inserts
+// of soft deleted tables into the same with an `ON CONFLICT (<pk>) DO UPDATE` clause, replaces
+// into a delete followed by an insert, updates into a set based `UPDATE ... FROM
+// json_populate_recordset(...)` so that values are typed using the table's own row type, columns
+// having a field update operation being computed from their current value, and deletes into
+// `DELETE ... WHERE <pk> IN (...)`.
+func (d *postgresDialect) prepareBatchStatements(schema string, b *operationBatch) ([]*statement, error) {
+	if b.opType != OperationTypeInsert {
+		for _, o := range b.operations {
+			// A table without a primary key set yield a `primaryKey` map with a single entry where the key is an empty string
+			if _, found := o.primaryKey[""]; found {
+				return nil, fmt.Errorf("trying to perform %s operation but table %q don't have a primary key set, this is not accepted", o.opType, o.table.name)
+			}
 		}
 	}
 
-	switch o.opType {
-	case OperationTypeInsert:
-		insertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);",
-			o.table.identifier,
-			strings.Join(columns, ","),
-			strings.Join(values, ","),
-		)
-		if o.reversibleBlockNum != nil {
-			return d.saveInsert(schema, o.table.identifier, o.primaryKey, *o.reversibleBlockNum) + insertQuery, nil
+	switch b.opType {
+	case OperationTypeInsert, OperationTypeUpsert:
+		upsert := b.upsertsOnConflict()
+		insert, err := d.insertStatement(b, upsert)
+		if err != nil {
+			return nil, err
 		}
-		return insertQuery, nil
+
+		if upsert {
+			return withHistory(d.saveUpserts(schema, b.table, b.reversibleOperations(), "row_to_json("+b.table.nameEscaped+")::text"), insert), nil
+		}
+
+		return withHistory(d.saveInserts(schema, b.table.identifier, b.reversibleOperations()), insert), nil
+
+	case OperationTypeReplace:
+		insert, err := d.insertStatement(b, false)
+		if err != nil {
+			return nil, err
+		}
+
+		// The history records the row as it was before being deleted, like for an upsert
+		history := d.saveUpserts(schema, b.table, b.reversibleOperations(), "row_to_json("+b.table.nameEscaped+")::text")
+		return append(withHistory(history, d.deleteStatement(b)), insert), nil
 
 	case OperationTypeUpdate:
-		updates := make([]string, len(columns))
-		for i := 0; i < len(columns); i++ {
-			updates[i] = fmt.Sprintf("%s=%s", columns[i], values[i])
+		if len(b.columns) == 0 {
+			// Nothing to update, the row stays untouched
+			return nil, nil
 		}
 
-		primaryKeySelector := getPrimaryKeyWhereClause(o.primaryKey)
+		rows := make([]map[string]any, len(b.operations))
+		for i, o := range b.operations {
+			row, err := d.prepareRowValues(o.table, o.primaryKey, o.data)
+			if err != nil {
+				return nil, fmt.Errorf("preparing row values: %w", err)
+			}
+
+			rows[i] = row
+		}
 
-		updateQuery := fmt.Sprintf("UPDATE %s SET %s WHERE %s",
-			o.table.identifier,
+		rowsJSON, err := json.Marshal(rows)
+		if err != nil {
+			return nil, fmt.Errorf("marshalling rows: %w", err)
+		}
+
+		updates := make([]string, len(b.columns))
+		for i, column := range b.columns {
+			value := "v." + column
+			updates[i] = column + "=" + standardFieldUpdateSQL.expression(b.fieldOps[column], b.table.nameEscaped+"."+column, func() string { return value })
+		}
+
+		args := newPostgresArgs()
+		updateQuery := fmt.Sprintf("UPDATE %s SET %s FROM json_populate_recordset(null::%s,%s) AS v WHERE %s;",
+			b.table.identifier,
 			strings.Join(updates, ", "),
-			primaryKeySelector,
+			b.table.identifier,
+			args.add(string(rowsJSON)),
+			getPrimaryKeyJoinClause(b.table.nameEscaped, "v", b.operations[0].primaryKey),
 		)
 
-		if o.reversibleBlockNum != nil {
-			return d.saveUpdate(schema, o.table.nameEscaped, o.primaryKey, *o.reversibleBlockNum) + updateQuery, nil
-		}
-		return updateQuery, nil
+		return withHistory(d.saveUpdates(schema, b.table.nameEscaped, b.reversibleOperations()), args.statement(updateQuery)), nil
 
 	case OperationTypeDelete:
-		primaryKeyWhereClause := getPrimaryKeyWhereClause(o.primaryKey)
-		deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE %s",
-			o.table.identifier,
-			primaryKeyWhereClause,
-		)
-		if o.reversibleBlockNum != nil {
-			return d.saveDelete(schema, o.table.nameEscaped, o.primaryKey, *o.reversibleBlockNum) + deleteQuery, nil
+		return withHistory(d.saveDeletes(schema, b.table.nameEscaped, b.reversibleOperations()), d.deleteStatement(b)), nil
+
+	default:
+		panic(fmt.Errorf("unknown operation type %q", b.opType))
+	}
+}
+
+// deleteStatement returns the statement deleting all the rows of the batch at once
+func (d *postgresDialect) deleteStatement(b *operationBatch) *statement {
+	args := newPostgresArgs()
+	return args.statement(fmt.Sprintf("DELETE FROM %s WHERE %s;",
+		b.table.identifier,
+		getPrimaryKeysInClause(b.primaryKeys(), args),
+	))
+}
+
+// oldValuesStatement returns the statement checking that the operation's row holds its old values
+func (d postgresDialect) oldValuesStatement(op *Operation) (*statement, error) {
+	columns, values, err := d.prepareColValues(op.table, op.oldValues.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	args := newPostgresArgs()
+	return args.statement(oldValuesQuery(op.table, columns, values, args, func() string {
+		return getPrimaryKeyWhereClause(op.primaryKey, args)
+	})), nil
+}
+
+// versionStatements returns the statements writing the operation on a versioned table. The current
+// version of the row is closed at the operation's block first, then inserts write the new version
+// as is while updates and upserts write it from the closed version overlaid with the operation's
+// data, upserts inserting it as is when the row has no version. Deletes only close the current
+// version.
+func (d postgresDialect) versionStatements(op *Operation) ([]*statement, error) {
+	switch op.opType {
+	case OperationTypeInsert, OperationTypeReplace:
+		data := make(map[string]string, len(op.data)+1)
+		for column, value := range op.data {
+			data[column] = value
+		}
+		data[VersionValidFromColumn] = strconv.FormatUint(op.versionBlockNum, 10)
+
+		columns, values, err := d.prepareColValues(op.table, data)
+		if err != nil {
+			return nil, fmt.Errorf("preparing column & values: %w", err)
+		}
+
+		args := newPostgresArgs()
+		insert := args.statement(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);",
+			op.table.identifier,
+			strings.Join(columns, ","),
+			strings.Join(slices.Map(values, args.add), ","),
+		))
+
+		return []*statement{d.closeVersionStatement(op), insert}, nil
+
+	case OperationTypeUpdate, OperationTypeUpsert:
+		if op.opType == OperationTypeUpdate && len(op.data) == 0 {
+			// Nothing to update, the row keeps its current version
+			return nil, nil
+		}
+
+		row, err := d.prepareRowValues(op.table, op.primaryKey, op.data)
+		if err != nil {
+			return nil, fmt.Errorf("preparing row values: %w", err)
 		}
-		return deleteQuery, nil
+		row[VersionValidFromColumn] = op.versionBlockNum
+
+		rowJSON, err := json.Marshal(row)
+		if err != nil {
+			return nil, fmt.Errorf("marshalling row: %w", err)
+		}
+
+		columnNames := maps.Keys(op.table.columnsByName)
+		sort.Strings(columnNames) // sorted for determinism in tests
+
+		var columns, selects []string
+		for _, columnName := range columnNames {
+			if columnName == VersionValidFromColumn || columnName == VersionValidToColumn {
+				continue
+			}
+
+			column := EscapeIdentifier(columnName)
+			value := "v." + column
+			if _, found := op.data[columnName]; found {
+				selects = append(selects, standardFieldUpdateSQL.expression(op.fieldOps[columnName], "c."+column, func() string { return value }))
+			} else {
+				selects = append(selects, "c."+column)
+			}
+			columns = append(columns, column)
+		}
+
+		validFrom := EscapeIdentifier(VersionValidFromColumn)
+		args := newPostgresArgs()
+		newVersion := args.statement(fmt.Sprintf("INSERT INTO %s (%s,%s) SELECT %s,v.%s FROM %s AS c, json_populate_record(null::%s,%s) AS v WHERE %s AND c.%s = v.%s;",
+			op.table.identifier,
+			strings.Join(columns, ","),
+			validFrom,
+			strings.Join(selects, ","),
+			validFrom,
+			op.table.identifier,
+			op.table.identifier,
+			args.add(string(rowJSON)),
+			getPrimaryKeyJoinClause("c", "v", op.primaryKey),
+			EscapeIdentifier(VersionValidToColumn),
+			validFrom,
+		))
+
+		statements := []*statement{d.closeVersionStatement(op), newVersion}
+		if op.opType == OperationTypeUpsert {
+			dataColumns := maps.Keys(op.data)
+			sort.Strings(dataColumns)
+
+			escapedColumns := make([]string, len(dataColumns)+1)
+			for i, column := range dataColumns {
+				escapedColumns[i] = EscapeIdentifier(column)
+			}
+			escapedColumns[len(dataColumns)] = validFrom
+
+			insertArgs := newPostgresArgs()
+			rowJSONArg := insertArgs.add(string(rowJSON))
+			statements = append(statements, insertArgs.statement(fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM json_populate_record(null::%s,%s) AS v WHERE NOT EXISTS (SELECT 1 FROM %s WHERE %s AND %s = v.%s);",
+				op.table.identifier,
+				strings.Join(escapedColumns, ","),
+				"v."+strings.Join(escapedColumns, ",v."),
+				op.table.identifier,
+				rowJSONArg,
+				op.table.identifier,
+				getPrimaryKeyWhereClause(op.primaryKey, insertArgs),
+				validFrom,
+				validFrom,
+			)))
+		}
+
+		return statements, nil
+
+	case OperationTypeDelete:
+		return []*statement{d.closeVersionStatement(op)}, nil
 
 	default:
-		panic(fmt.Errorf("unknown operation type %q", o.opType))
+		panic(fmt.Errorf("unknown operation type %q", op.opType))
 	}
 }
 
-func (d *postgresDialect) prepareColValues(table *TableInfo, colValues map[string]string) (columns []string, values []string, err error) {
+// closeVersionStatement returns the statement closing the current version of the operation's row
+// at the operation's block, versions opened at that block are left untouched.
+func (d postgresDialect) closeVersionStatement(op *Operation) *statement {
+	args := newPostgresArgs()
+	blockNum := args.add(op.versionBlockNum)
+	validTo := EscapeIdentifier(VersionValidToColumn)
+
+	return args.statement(fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s AND %s IS NULL AND %s < %s;",
+		op.table.identifier,
+		validTo,
+		blockNum,
+		getPrimaryKeyWhereClause(op.primaryKey, args),
+		validTo,
+		EscapeIdentifier(VersionValidFromColumn),
+		blockNum,
+	))
+}
+
+// revertVersionsStatements returns the statements deleting the versions opened after the last
+// valid block and re-opening the ones closed after it.
+func (d postgresDialect) revertVersionsStatements(table *TableInfo, lastValidBlock uint64) []*statement {
+	validTo := EscapeIdentifier(VersionValidToColumn)
+
+	return []*statement{
+		{
+			query: fmt.Sprintf("DELETE FROM %s WHERE %s > $1;", table.identifier, EscapeIdentifier(VersionValidFromColumn)),
+			args:  []any{lastValidBlock},
+		},
+		{
+			query: fmt.Sprintf("UPDATE %s SET %s = NULL WHERE %s > $1;", table.identifier, validTo, validTo),
+			args:  []any{lastValidBlock},
+		},
+	}
+}
+
+// versionIndexesQueries returns the queries creating the unique index of the current version of
+// each row and the index of the block range of the versions.
+func (d postgresDialect) versionIndexesQueries(table *TableInfo) []string {
+	keys := table.keyColumns()
+	keyColumns := make([]string, len(keys))
+	for i, column := range keys {
+		keyColumns[i] = EscapeIdentifier(column.name)
+	}
+
+	validTo := EscapeIdentifier(VersionValidToColumn)
+	return []string{
+		fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s) WHERE %s IS NULL;",
+			EscapeIdentifier(table.name+"_current_version"),
+			table.identifier,
+			strings.Join(keyColumns, ","),
+			validTo,
+		),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s,%s);",
+			EscapeIdentifier(table.name+"_version_range"),
+			table.identifier,
+			EscapeIdentifier(VersionValidFromColumn),
+			validTo,
+		),
+	}
+}
+
+// insertStatement returns the multi-row `INSERT` of the batch's rows, when upsert is true, rows
+// already existing are updated instead.
+func (d *postgresDialect) insertStatement(b *operationBatch, upsert bool) (*statement, error) {
+	args := newPostgresArgs()
+
+	var columns []string
+	rows := make([]string, len(b.operations))
+	for i, o := range b.operations {
+		var values []any
+		var err error
+		columns, values, err = d.prepareColValues(o.table, o.data)
+		if err != nil {
+			return nil, fmt.Errorf("preparing column & values: %w", err)
+		}
+
+		rows[i] = "(" + strings.Join(slices.Map(values, args.add), ",") + ")"
+	}
+
+	onConflict := ""
+	if upsert {
+		onConflict = " " + onConflictUpdateClause(b.table, columns, b.fieldOps, standardFieldUpdateSQL)
+	}
+
+	return args.statement(fmt.Sprintf("INSERT INTO %s (%s) VALUES %s%s;",
+		b.table.identifier,
+		strings.Join(columns, ","),
+		strings.Join(rows, ","),
+		onConflict,
+	)), nil
+}
+
+// withHistory returns the statements to execute in order, the history one being
+// first and omitted if nil.
+func withHistory(history *statement, stmt *statement) []*statement {
+	if history == nil {
+		return []*statement{stmt}
+	}
+
+	return []*statement{history, stmt}
+}
+
+func (d *postgresDialect) prepareColValues(table *TableInfo, colValues map[string]string) (columns []string, values []any, err error) {
 	if len(colValues) == 0 {
 		return
 	}
 
 	columns = make([]string, len(colValues))
-	values = make([]string, len(colValues))
+	values = make([]any, len(colValues))
 
 	i := 0
 	for colName := range colValues {
@@ -354,7 +1029,7 @@ func (d *postgresDialect) prepareColValues(table *TableInfo, colValues map[strin
 			return nil, nil, fmt.Errorf("cannot find column %q for table %q (valid columns are %q)", columnName, table.identifier, strings.Join(maps.Keys(table.columnsByName), ", "))
 		}
 
-		normalizedValue, err := d.normalizeValueType(value, columnInfo.scanType)
+		normalizedValue, err := columnInfo.convertValue(value, d.normalizeValueType)
 		if err != nil {
 			return nil, nil, fmt.Errorf("getting sql value from table %s for column %q raw value %q: %w", table.identifier, columnName, value, err)
 		}
@@ -365,63 +1040,146 @@ func (d *postgresDialect) prepareColValues(table *TableInfo, colValues map[strin
 	return
 }
 
-func getPrimaryKeyWhereClause(primaryKey map[string]string) string {
+// prepareRowValues returns the primary key and data of a row keyed by column name with each value
+// typed for the column, ready to be used with `json_populate_record(set)`.
+func (d *postgresDialect) prepareRowValues(table *TableInfo, primaryKey map[string]string, colValues map[string]string) (map[string]any, error) {
+	row := make(map[string]any, len(primaryKey)+len(colValues))
+	for columnName, value := range primaryKey {
+		row[columnName] = value
+	}
+
+	for columnName, value := range colValues {
+		columnInfo, found := table.columnsByName[columnName]
+		if !found {
+			return nil, fmt.Errorf("cannot find column %q for table %q (valid columns are %q)", columnName, table.identifier, strings.Join(maps.Keys(table.columnsByName), ", "))
+		}
+
+		normalizedValue, err := columnInfo.convertValue(value, d.normalizeValueType)
+		if err != nil {
+			return nil, fmt.Errorf("getting sql value from table %s for column %q raw value %q: %w", table.identifier, columnName, value, err)
+		}
+
+		if bytes, ok := normalizedValue.([]byte); ok {
+			// Bytes are marshalled as base64 by default, `bytea` parses its hex format instead
+			normalizedValue = `\x` + hex.EncodeToString(bytes)
+		}
+
+		row[columnName] = normalizedValue
+	}
+
+	return row, nil
+}
+
+func getPrimaryKeyWhereClause(primaryKey map[string]string, args *queryArgs) string {
 	// Avoid any allocation if there is a single primary key
 	if len(primaryKey) == 1 {
 		for key, value := range primaryKey {
-			return EscapeIdentifier(key) + " = " + escapeStringValue(value)
+			return EscapeIdentifier(key) + " = " + args.add(value)
 		}
 	}
 
+	keys := maps.Keys(primaryKey)
+	sort.Strings(keys)
+
+	reg := make([]string, len(keys))
+	for i, key := range keys {
+		reg[i] = EscapeIdentifier(key) + " = " + args.add(primaryKey[key])
+	}
+
+	return strings.Join(reg[:], " AND ")
+}
+
+// getPrimaryKeyJoinClause returns the clause matching the primary key column(s) of `table`
+// against the same column(s) of `alias`.
+func getPrimaryKeyJoinClause(table string, alias string, primaryKey map[string]string) string {
 	reg := make([]string, 0, len(primaryKey))
-	for key, value := range primaryKey {
-		reg = append(reg, EscapeIdentifier(key)+" = "+escapeStringValue(value))
+	for key := range primaryKey {
+		escapedKey := EscapeIdentifier(key)
+		reg = append(reg, table+"."+escapedKey+" = "+alias+"."+escapedKey)
 	}
 	sort.Strings(reg)
 
-	return strings.Join(reg[:], " AND ")
+	return strings.Join(reg, " AND ")
+}
+
+// getPrimaryKeysInClause returns the clause matching any of the received primary keys, a single column
+// key gives `"id" IN ($1,$2)` while a composite one gives `("id","idx") IN (($1,$2),($3,$4))`.
+func getPrimaryKeysInClause(primaryKeys []map[string]string, args *queryArgs) string {
+	keys := maps.Keys(primaryKeys[0])
+	sort.Strings(keys)
+
+	tuples := make([][]string, len(primaryKeys))
+	for i, primaryKey := range primaryKeys {
+		tuples[i] = make([]string, len(keys))
+		for j, key := range keys {
+			tuples[i][j] = primaryKey[key]
+		}
+	}
+
+	return getTuplesInClause(slices.Map(keys, EscapeIdentifier), tuples, args)
 }
 
-// Format based on type, value returned unescaped
-func (d *postgresDialect) normalizeValueType(value string, valueType reflect.Type) (string, error) {
+// getTuplesInClause returns the clause matching the expressions against any of the tuples of values,
+// each tuple holding a value per expression.
+func getTuplesInClause(expressions []string, tuples [][]string, args *queryArgs) string {
+	values := make([]string, len(tuples))
+	for i, tuple := range tuples {
+		placeholders := make([]string, len(tuple))
+		for j, value := range tuple {
+			placeholders[j] = args.add(value)
+		}
+
+		values[i] = strings.Join(placeholders, ",")
+		if len(expressions) > 1 {
+			values[i] = "(" + values[i] + ")"
+		}
+	}
+
+	columns := strings.Join(expressions, ",")
+	if len(expressions) > 1 {
+		columns = "(" + columns + ")"
+	}
+
+	return columns + " IN (" + strings.Join(values, ",") + ")"
+}
+
+// normalizeValueType converts the value received from Substreams into the Go type bound as
+// a parameter for the column's type. Values the database is better suited to parse, like
+// date strings or bytes representation, are passed as string to the database.
+func (d *postgresDialect) normalizeValueType(value string, valueType reflect.Type) (any, error) {
+	if value == NullValue {
+		return nil, nil
+	}
+
 	switch valueType.Kind() {
 	case reflect.String:
 		// replace unicode null character with empty string
-		value = strings.ReplaceAll(value, "\u0000", "")
-		return escapeStringValue(value), nil
+		return strings.ReplaceAll(value, "\u0000", ""), nil
 
-	// BYTES in Postgres must be escaped, we receive a Vec<u8> from substreams
+	// BYTES in Postgres are received in their textual representation, parsed by the database
 	case reflect.Slice:
-		return escapeStringValue(value), nil
+		return value, nil
 
 	case reflect.Bool:
-		return fmt.Sprintf("'%s'", value), nil
+		return strconv.ParseBool(value)
 
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return value, nil
+		return strconv.ParseInt(value, 10, 64)
 
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return value, nil
+		return strconv.ParseUint(value, 10, 64)
 
 	case reflect.Float32, reflect.Float64:
-		return value, nil
+		return strconv.ParseFloat(value, 64)
 
 	case reflect.Struct:
 		if valueType == reflectTypeTime {
-			if integerRegex.MatchString(value) {
-				i, err := strconv.Atoi(value)
-				if err != nil {
-					return "", fmt.Errorf("could not convert %s to int: %w", value, err)
-				}
-
-				return escapeStringValue(time.Unix(int64(i), 0).Format(time.RFC3339)), nil
-			}
-
-			// It's a plain string, parse by dialect it and pass it to the database
-			return d.ParseDatetimeNormalization(value), nil
+			// Timestamp columns are converted by `Loader.convertTimestamp`, other time types, like
+			// `time` or `timetz`, are parsed by the database
+			return value, nil
 		}
 
-		return "", fmt.Errorf("unsupported struct type %s", valueType)
+		return nil, fmt.Errorf("unsupported struct type %s", valueType)
 	default:
 		// It's a column's type the schema parsing don't know how to represents as
 		// a Go type. In that case, we pass it unmodified to the database engine. It
@@ -115,7 +115,7 @@ func TestRevertOp(t *testing.T) {
 				pk:         `{"id":"2345"}`,
 				prev_value: "", // unused
 			},
-			expect: `DELETE FROM "testschema"."xfer" WHERE "id" = '2345';`,
+			expect: `DELETE FROM "testschema"."xfer" WHERE "id" = $1; [2345]`,
 		},
 		{
 			name: "rollback delete row",
@@ -125,8 +125,8 @@ func TestRevertOp(t *testing.T) {
 				pk:         `{"id":"2345"}`,
 				prev_value: `{"id":"2345","sender":"0xdead","receiver":"0xbeef"}`,
 			},
-			expect: `INSERT INTO "testschema"."xfer" SELECT * FROM json_populate_record(null::"testschema"."xfer",` +
-				`'{"id":"2345","sender":"0xdead","receiver":"0xbeef"}');`,
+			expect: `INSERT INTO "testschema"."xfer" SELECT * FROM json_populate_record(null::"testschema"."xfer",$1);` +
+				` [{"id":"2345","sender":"0xdead","receiver":"0xbeef"}]`,
 		},
 		{
 			name: "rollback update row",
@@ -136,8 +136,8 @@ func TestRevertOp(t *testing.T) {
 				pk:         `{"id":"2345"}`,
 				prev_value: `{"id":"2345","sender":"0xdead","receiver":"0xbeef"}`,
 			},
-			expect: `UPDATE "testschema"."xfer" SET("id","receiver","sender")=((SELECT "id","receiver","sender" FROM json_populate_record(null::"testschema"."xfer",` +
-				`'{"id":"2345","sender":"0xdead","receiver":"0xbeef"}'))) WHERE "id" = '2345';`,
+			expect: `UPDATE "testschema"."xfer" SET("id","receiver","sender")=((SELECT "id","receiver","sender" FROM json_populate_record(null::"testschema"."xfer",$1))) WHERE "id" = $2;` +
+				` [{"id":"2345","sender":"0xdead","receiver":"0xbeef"} 2345]`,
 		},
 	}
 	for _, test := range tests {
@@ -154,3 +154,64 @@ func TestRevertOp(t *testing.T) {
 	}
 
 }
+
+func TestGetPrimaryKeysInClause(t *testing.T) {
+	tests := []struct {
+		name       string
+		keys       []map[string]string
+		expect     string
+		expectArgs []any
+	}{
+		{
+			name:       "single key",
+			keys:       []map[string]string{{"id": "1"}, {"id": "2"}},
+			expect:     `"id" IN ($1,$2)`,
+			expectArgs: []any{"1", "2"},
+		},
+		{
+			name:       "composite key",
+			keys:       []map[string]string{{"idx": "3", "hash": "0xdead"}, {"idx": "4", "hash": "0xbeef"}},
+			expect:     `("hash","idx") IN (($1,$2),($3,$4))`,
+			expectArgs: []any{"0xdead", "3", "0xbeef", "4"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := newPostgresArgs()
+			assert.Equal(t, test.expect, getPrimaryKeysInClause(test.keys, args))
+			assert.Equal(t, test.expectArgs, args.args)
+		})
+	}
+}
+
+func TestBatchStatementKeys(t *testing.T) {
+	l, _ := NewTestLoader(zlog, tracer, "testschema", TestTables("testschema"))
+	block := uint64(10)
+
+	batchStatements := func(ids ...string) []*statement {
+		l.reset()
+		for _, id := range ids {
+			require.NoError(t, l.Insert("xfer", map[string]string{"id": id}, map[string]string{"from": "a"}, &block))
+		}
+
+		entries, _ := l.entries.Get("xfer")
+		batch := batchOperations(entries, postgresMaxBatchRows, postgresMaxParams)[0]
+		statements, err := (&postgresDialect{}).prepareBatchStatements("testschema", batch)
+		require.NoError(t, err)
+
+		return batch.keyStatements(statements)
+	}
+
+	first := batchStatements("1")
+	second := batchStatements("2")
+	require.Len(t, first, 2)
+	require.Len(t, second, 2)
+	assert.NotEmpty(t, first[0].key)
+	assert.NotEqual(t, first[0].key, first[1].key)
+	assert.Equal(t, first[0].key, second[0].key)
+	assert.Equal(t, first[1].key, second[1].key)
+
+	for _, stmt := range batchStatements("1", "2") {
+		assert.Empty(t, stmt.key, "multi-row statements are not prepared")
+	}
+}
@@ -0,0 +1,539 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bobg/go-generics/v2/slices"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/streamingfast/cli"
+	sink "github.com/streamingfast/substreams-sink"
+	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
+)
+
+// sqliteMaxBatchRows caps the amount of rows a single batched statement holds
+const sqliteMaxBatchRows = 500
+
+// sqliteMaxParams is SQLite's default `SQLITE_MAX_VARIABLE_NUMBER` since 3.32.0
+const sqliteMaxParams = 32766
+
+// sqliteDialect targets a single SQLite database file, it's meant for local development and
+// tests. SQLite being embedded, statements are cheap to execute so updates are applied one row
+// at a time, reorgs are handled through the history table using SQLite's JSON functions.
+type sqliteDialect struct{}
+
+func (d sqliteDialect) EscapeIdentifier(valueToEscape string) string {
+	return EscapeIdentifier(valueToEscape)
+}
+
+func (d sqliteDialect) Revert(tx Tx, ctx context.Context, l *Loader, lastValidFinalBlock uint64) error {
+	query := fmt.Sprintf(`SELECT op,table_name,pk,prev_value,block_num FROM %s WHERE "block_num" > ? ORDER BY "block_num" DESC`,
+		d.historyTable(l.schema),
+	)
+
+	rows, err := tx.QueryContext(ctx, query, lastValidFinalBlock)
+	if err != nil {
+		return err
+	}
+
+	var history []*historyRow
+	if rows != nil { // rows will be nil with no error only in testing scenarios
+		history, err = readHistoryRows(rows)
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("iterating on rows from query %q: %w", query, err)
+		}
+	}
+
+	l.logger.Info("reverting forked block block(s)", zap.Uint64("last_valid_final_block", lastValidFinalBlock))
+	for _, row := range history {
+		l.logger.Debug("reverting", zap.String("operation", row.op), zap.String("table_name", row.tableName), zap.String("pk", row.pk), zap.Uint64("block_num", row.blockNum))
+
+		if err := d.revertOp(tx, ctx, row.op, row.tableName, row.pk, row.prevValue); err != nil {
+			return fmt.Errorf("revertOp: %w", err)
+		}
+	}
+
+	pruneHistory := fmt.Sprintf(`DELETE FROM %s WHERE "block_num" > ?;`, d.historyTable(l.schema))
+	if _, err := tx.ExecContext(ctx, pruneHistory, lastValidFinalBlock); err != nil {
+		return fmt.Errorf("executing pruneHistory: %w", err)
+	}
+	return nil
+}
+
+func (d sqliteDialect) Flush(tx Tx, ctx context.Context, l *Loader, outputModuleHash string, lastFinalBlock uint64) (int, error) {
+	var rowCount int
+	for entriesPair := l.entries.Oldest(); entriesPair != nil; entriesPair = entriesPair.Next() {
+		tableName := entriesPair.Key
+		entries := entriesPair.Value
+
+		if l.tracer.Enabled() {
+			l.logger.Debug("flushing table rows", zap.String("table_name", tableName), zap.Int("row_count", entries.Len()))
+		}
+		for _, batch := range batchOperations(entries, sqliteMaxBatchRows, sqliteMaxParams) {
+			statements, err := d.prepareBatchStatements(l.schema, batch)
+			if err != nil {
+				return 0, fmt.Errorf("failed to prepare statement: %w", err)
+			}
+
+			for _, stmt := range batch.keyStatements(statements) {
+				if l.tracer.Enabled() {
+					l.logger.Debug("adding query from batched operations to transaction", zap.String("table_name", tableName), zap.String("op_type", string(batch.opType)), zap.Int("row_count", len(batch.operations)), zap.String("query", stmt.query))
+				}
+
+				if _, err := l.execPrepared(ctx, tx, stmt); err != nil {
+					return 0, fmt.Errorf("executing query %q: %w", stmt.query, err)
+				}
+			}
+		}
+		rowCount += entries.Len()
+	}
+
+	pruneHistory := fmt.Sprintf(`DELETE FROM %s WHERE block_num <= ?;`, d.historyTable(l.schema))
+	if _, err := tx.ExecContext(ctx, pruneHistory, lastFinalBlock); err != nil {
+		return 0, fmt.Errorf("executing prune query %q: %w", pruneHistory, err)
+	}
+
+	return rowCount, nil
+}
+
+func (d sqliteDialect) revertOp(tx Tx, ctx context.Context, op, escapedTableName, pk, prevValue string) error {
+	pkmap := make(map[string]string)
+	if err := json.Unmarshal([]byte(pk), &pkmap); err != nil {
+		return fmt.Errorf("revertOp: unmarshalling %q: %w", pk, err)
+	}
+
+	args := newPositionalArgs()
+	var query string
+	switch op {
+	case "I":
+		query = fmt.Sprintf(`DELETE FROM %s WHERE %s;`,
+			escapedTableName,
+			getPrimaryKeyWhereClause(pkmap, args),
+		)
+
+	case "D":
+		columns, values, err := d.jsonColumnValues(prevValue)
+		if err != nil {
+			return err
+		}
+
+		query = fmt.Sprintf(`INSERT INTO %s (%s) SELECT %s FROM (SELECT %s AS prev_value) AS v;`,
+			escapedTableName,
+			columns,
+			values,
+			args.add(prevValue),
+		)
+
+	case "U":
+		columns, values, err := d.jsonColumnValues(prevValue)
+		if err != nil {
+			return err
+		}
+
+		query = fmt.Sprintf(`UPDATE %s SET (%s) = (SELECT %s FROM (SELECT %s AS prev_value) AS v) WHERE %s;`,
+			escapedTableName,
+			columns,
+			values,
+			args.add(prevValue),
+			getPrimaryKeyWhereClause(pkmap, args),
+		)
+
+	default:
+		panic("invalid op in revert command")
+	}
+
+	if _, err := tx.ExecContext(ctx, query, args.args...); err != nil {
+		return fmt.Errorf("executing revert query %q: %w", query, err)
+	}
+	return nil
+}
+
+// jsonColumnValues returns the escaped columns of the JSON row `prevValue` along with the
+// expressions extracting each of them out of the `v.prev_value` document.
+func (d sqliteDialect) jsonColumnValues(prevValue string) (columns string, values string, err error) {
+	valueMap := make(map[string]any)
+	if err := json.Unmarshal([]byte(prevValue), &valueMap); err != nil {
+		return "", "", fmt.Errorf("unmarshalling %q into valueMap: %w", prevValue, err)
+	}
+
+	names := maps.Keys(valueMap)
+	sort.Strings(names)
+
+	extracts := make([]string, len(names))
+	for i, name := range names {
+		path := `$."` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+		extracts[i] = fmt.Sprintf("json_extract(v.prev_value,'%s')", strings.ReplaceAll(path, "'", "''"))
+	}
+
+	return strings.Join(slices.Map(names, EscapeIdentifier), ","), strings.Join(extracts, ","), nil
+}
+
+func (d sqliteDialect) GetCreateCursorQuery(schema string, withPostgraphile bool) string {
+	_ = withPostgraphile // Postgraphile only exists for Postgres
+	return fmt.Sprintf(cli.Dedent(`
+		create table if not exists %s.%s
+		(
+			id         text not null constraint cursor_pk primary key,
+			cursor     text,
+			block_num  bigint,
+			block_id   text
+		);
+		`),
+		EscapeIdentifier(schema), EscapeIdentifier(CURSORS_TABLE),
+	)
+}
+
+func (d sqliteDialect) GetCreateHistoryQuery(schema string, withPostgraphile bool) string {
+	_ = withPostgraphile // Postgraphile only exists for Postgres
+	return fmt.Sprintf(cli.Dedent(`
+		create table if not exists %s
+		(
+			id           integer primary key,
+			op           text,
+			table_name   text,
+			pk           text,
+			prev_value   text,
+			block_num    bigint
+		);
+		`),
+		d.historyTable(schema),
+	)
+}
+
+func (d sqliteDialect) ExecuteSetupScript(ctx context.Context, l *Loader, schemaSql string) error {
+	if _, err := l.ExecContext(ctx, schemaSql); err != nil {
+		return fmt.Errorf("exec schema: %w", err)
+	}
+	return nil
+}
+
+func (d sqliteDialect) GetAllCursorsQuery(table string) string {
+	return fmt.Sprintf("SELECT id, cursor, block_num, block_id FROM %s", table)
+}
+
+func (d sqliteDialect) GetInsertCursorQuery(table, moduleHash string, cursor *sink.Cursor, block_num uint64, block_id string) (string, []any) {
+	return query(`
+		INSERT INTO %s (id, cursor, block_num, block_id) values (?, ?, ?, ?);
+	`, table), []any{moduleHash, cursor.String(), block_num, block_id}
+}
+
+func (d sqliteDialect) GetUpdateCursorQuery(table, moduleHash string, cursor *sink.Cursor, block_num uint64, block_id string) (string, []any) {
+	return query(`
+		UPDATE %s set cursor = ?, block_num = ?, block_id = ? WHERE id = ?;
+	`, table), []any{cursor.String(), block_num, block_id, moduleHash}
+}
+
+func (d sqliteDialect) GetDeleteCursorQuery(table, moduleHash string) (string, []any) {
+	return query(`
+		DELETE FROM %s WHERE id = ?;
+	`, table), []any{moduleHash}
+}
+
+func (d sqliteDialect) DriverSupportRowsAffected() bool {
+	return true
+}
+
+func (d sqliteDialect) OnlyInserts() bool {
+	return false
+}
+
+func (d sqliteDialect) historyTable(schema string) string {
+	return fmt.Sprintf("%s.%s", EscapeIdentifier(schema), EscapeIdentifier(HISTORY_TABLE))
+}
+
+// prepareBatchStatements returns the statements applying the operations of the batch preceded by
+// the statement saving the history of its reversible operations, if any.
+//
+// Inserts are turned into a multi-row `INSERT ... VALUES (...),(...)`, upserts and the inserts of
+// soft deleted tables into the same with an `ON CONFLICT (<pk>) DO UPDATE` clause, deletes into
+// `DELETE ... WHERE <pk> IN (...)`, replaces into a delete followed by an insert and updates are
+// applied one row at a time.
+func (d sqliteDialect) prepareBatchStatements(schema string, b *operationBatch) ([]*statement, error) {
+	if b.opType != OperationTypeInsert {
+		for _, o := range b.operations {
+			// A table without a primary key set yield a `primaryKey` map with a single entry where the key is an empty string
+			if _, found := o.primaryKey[""]; found {
+				return nil, fmt.Errorf("trying to perform %s operation but table %q don't have a primary key set, this is not accepted", o.opType, o.table.name)
+			}
+		}
+	}
+
+	switch b.opType {
+	case OperationTypeInsert, OperationTypeUpsert, OperationTypeReplace:
+		args := newPositionalArgs()
+
+		var columns []string
+		rows := make([]string, len(b.operations))
+		for i, o := range b.operations {
+			var values []any
+			var err error
+			columns, values, err = d.prepareColValues(o.table, o.data)
+			if err != nil {
+				return nil, fmt.Errorf("preparing column & values: %w", err)
+			}
+
+			rows[i] = "(" + strings.Join(slices.Map(values, args.add), ",") + ")"
+		}
+
+		if b.upsertsOnConflict() {
+			upsertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s %s;",
+				b.table.identifier,
+				strings.Join(columns, ","),
+				strings.Join(rows, ","),
+				onConflictUpdateClause(b.table, columns, b.fieldOps, sqliteFieldUpdateSQL),
+			)
+
+			return withHistory(d.saveUpserts(schema, b.table, b.reversibleOperations()), args.statement(upsertQuery)), nil
+		}
+
+		insertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s;",
+			b.table.identifier,
+			strings.Join(columns, ","),
+			strings.Join(rows, ","),
+		)
+
+		if b.opType == OperationTypeReplace {
+			// The history records the row as it was before being deleted, like for an upsert
+			history := d.saveUpserts(schema, b.table, b.reversibleOperations())
+			return append(withHistory(history, d.deleteStatement(b)), args.statement(insertQuery)), nil
+		}
+
+		return withHistory(d.saveInserts(schema, b.table.identifier, b.reversibleOperations()), args.statement(insertQuery)), nil
+
+	case OperationTypeUpdate:
+		if len(b.columns) == 0 {
+			// Nothing to update, the row stays untouched
+			return nil, nil
+		}
+
+		var statements []*statement
+		if history := d.saveRows("U", schema, b.table, b.reversibleOperations()); history != nil {
+			statements = append(statements, history)
+		}
+
+		for _, o := range b.operations {
+			columns, values, err := d.prepareColValues(o.table, o.data)
+			if err != nil {
+				return nil, fmt.Errorf("preparing column & values: %w", err)
+			}
+
+			args := newPositionalArgs()
+			updates := make([]string, len(columns))
+			for i, column := range columns {
+				updates[i] = column + "=" + sqliteFieldUpdateSQL.expression(b.fieldOps[column], column, func() string { return args.add(values[i]) })
+			}
+
+			update := args.statement(fmt.Sprintf("UPDATE %s SET %s WHERE %s;",
+				o.table.identifier,
+				strings.Join(updates, ", "),
+				getPrimaryKeyWhereClause(o.primaryKey, args),
+			))
+			// The rows of the batch are all updated by the same query, prepared once
+			update.key = o.shapeKey(b.columns)
+			statements = append(statements, update)
+		}
+
+		return statements, nil
+
+	case OperationTypeDelete:
+		return withHistory(d.saveRows("D", schema, b.table, b.reversibleOperations()), d.deleteStatement(b)), nil
+
+	default:
+		panic(fmt.Errorf("unknown operation type %q", b.opType))
+	}
+}
+
+// deleteStatement returns the statement deleting all the rows of the batch at once
+func (d sqliteDialect) deleteStatement(b *operationBatch) *statement {
+	args := newPositionalArgs()
+	return args.statement(fmt.Sprintf("DELETE FROM %s WHERE %s;",
+		b.table.identifier,
+		d.primaryKeysInClause(b.primaryKeys(), args),
+	))
+}
+
+// oldValuesStatement returns the statement checking that the operation's row holds its old values
+func (d sqliteDialect) oldValuesStatement(op *Operation) (*statement, error) {
+	columns, values, err := d.prepareColValues(op.table, op.oldValues.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	args := newPositionalArgs()
+	return args.statement(oldValuesQuery(op.table, columns, values, args, func() string {
+		return getPrimaryKeyWhereClause(op.primaryKey, args)
+	})), nil
+}
+
+// saveInserts returns the statement recording in the history table the reversible inserts
+// of the batch, nil is returned if none of the operations is reversible.
+func (d sqliteDialect) saveInserts(schema string, table string, ops []*Operation) *statement {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	args := newPositionalArgs()
+	rows := make([]string, len(ops))
+	for i, op := range ops {
+		rows[i] = fmt.Sprintf("(%s,%s,%s,%s)",
+			args.add("I"),
+			args.add(table),
+			args.add(primaryKeyToJSON(op.primaryKey)),
+			args.add(*op.reversibleBlockNum),
+		)
+	}
+
+	return args.statement(fmt.Sprintf(`INSERT INTO %s (op,table_name,pk,block_num) values %s;`,
+		d.historyTable(schema),
+		strings.Join(rows, ","),
+	))
+}
+
+// saveRows returns a single statement recording in the history table the current value of each
+// row touched by the reversible operations, nil is returned if there is none. The row is saved as
+// a JSON document built with `json_object` out of all the table's columns.
+func (d sqliteDialect) saveRows(op, schema string, table *TableInfo, ops []*Operation) *statement {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	rowJSON := d.rowJSON(table)
+
+	args := newPositionalArgs()
+	selects := make([]string, len(ops))
+	for i, o := range ops {
+		selects[i] = fmt.Sprintf(`SELECT %s,%s,%s,%s,%s FROM %s WHERE %s`,
+			args.add(op), args.add(table.identifier), args.add(primaryKeyToJSON(o.primaryKey)), rowJSON, args.add(*o.reversibleBlockNum),
+			table.identifier,
+			getPrimaryKeyWhereClause(o.primaryKey, args),
+		)
+	}
+
+	return args.statement(fmt.Sprintf(`INSERT INTO %s (op,table_name,pk,prev_value,block_num) %s;`,
+		d.historyTable(schema),
+		strings.Join(selects, " UNION ALL "),
+	))
+}
+
+// saveUpserts returns a single statement recording in the history table whether each row touched
+// by the reversible upserts existed, nil is returned if there is none. An existing row is recorded
+// as updated along with its current value, a missing one as inserted.
+func (d sqliteDialect) saveUpserts(schema string, table *TableInfo, ops []*Operation) *statement {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	rowJSON := d.rowJSON(table)
+
+	args := newPositionalArgs()
+	selects := make([]string, 2*len(ops))
+	for i, o := range ops {
+		selects[2*i] = fmt.Sprintf(`SELECT %s,%s,%s,%s,%s FROM %s WHERE %s`,
+			args.add("U"), args.add(table.identifier), args.add(primaryKeyToJSON(o.primaryKey)), rowJSON, args.add(*o.reversibleBlockNum),
+			table.identifier,
+			getPrimaryKeyWhereClause(o.primaryKey, args),
+		)
+		selects[2*i+1] = fmt.Sprintf(`SELECT %s,%s,%s,NULL,%s WHERE NOT EXISTS (SELECT 1 FROM %s WHERE %s)`,
+			args.add("I"), args.add(table.identifier), args.add(primaryKeyToJSON(o.primaryKey)), args.add(*o.reversibleBlockNum),
+			table.identifier,
+			getPrimaryKeyWhereClause(o.primaryKey, args),
+		)
+	}
+
+	return args.statement(fmt.Sprintf(`INSERT INTO %s (op,table_name,pk,prev_value,block_num) %s;`,
+		d.historyTable(schema),
+		strings.Join(selects, " UNION ALL "),
+	))
+}
+
+// rowJSON returns the expression building the JSON document of the table's row out of all its columns
+func (d sqliteDialect) rowJSON(table *TableInfo) string {
+	columns := maps.Keys(table.columnsByName)
+	sort.Strings(columns)
+
+	jsonFields := make([]string, len(columns))
+	for i, column := range columns {
+		jsonFields[i] = "'" + strings.ReplaceAll(column, "'", "''") + "'," + table.columnsByName[column].escapedName
+	}
+	return "json_object(" + strings.Join(jsonFields, ",") + ")"
+}
+
+func (d sqliteDialect) prepareColValues(table *TableInfo, colValues map[string]string) (columns []string, values []any, err error) {
+	if len(colValues) == 0 {
+		return
+	}
+
+	columns = maps.Keys(colValues)
+	sort.Strings(columns) // sorted for determinism in tests
+
+	values = make([]any, len(columns))
+	for i, columnName := range columns {
+		value := colValues[columnName]
+		columnInfo, found := table.columnsByName[columnName]
+		if !found {
+			return nil, nil, fmt.Errorf("cannot find column %q for table %q (valid columns are %q)", columnName, table.identifier, strings.Join(maps.Keys(table.columnsByName), ", "))
+		}
+
+		normalizedValue, err := columnInfo.convertValue(value, d.normalizeValueType)
+		if err != nil {
+			return nil, nil, fmt.Errorf("getting sql value from table %s for column %q raw value %q: %w", table.identifier, columnName, value, err)
+		}
+
+		values[i] = normalizedValue
+		columns[i] = columnInfo.escapedName
+	}
+	return
+}
+
+// normalizeValueType converts the value received from Substreams into the value bound for the
+// column. SQLite converts text values according to the column's type affinity by itself, only
+// booleans and times, which have no storage class of their own, are converted here.
+func (d sqliteDialect) normalizeValueType(value string, valueType reflect.Type) (any, error) {
+	if value == NullValue {
+		return nil, nil
+	}
+
+	valueType = baseScanType(valueType)
+	switch {
+	case valueType == reflectTypeTime:
+		// Timestamp columns are converted by `Loader.convertTimestamp`, other time types are parsed by the database
+		return value, nil
+
+	case valueType.Kind() == reflect.Bool:
+		return strconv.ParseBool(value)
+
+	default:
+		return value, nil
+	}
+}
+
+// primaryKeysInClause returns the clause matching any of the received primary keys, a single column
+// key gives `"id" IN (?,?)` while a composite one, compared as row values, gives
+// `("id","idx") IN (VALUES (?,?),(?,?))`.
+func (d sqliteDialect) primaryKeysInClause(primaryKeys []map[string]string, args *queryArgs) string {
+	if len(primaryKeys[0]) == 1 {
+		return getPrimaryKeysInClause(primaryKeys, args)
+	}
+
+	keys := maps.Keys(primaryKeys[0])
+	sort.Strings(keys)
+
+	tuples := make([]string, len(primaryKeys))
+	for i, primaryKey := range primaryKeys {
+		values := make([]string, len(keys))
+		for j, key := range keys {
+			values[j] = args.add(primaryKey[key])
+		}
+
+		tuples[i] = "(" + strings.Join(values, ",") + ")"
+	}
+
+	return "(" + strings.Join(slices.Map(keys, EscapeIdentifier), ",") + ") IN (VALUES " + strings.Join(tuples, ",") + ")"
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	sink "github.com/streamingfast/substreams-sink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteFlushAndRevert(t *testing.T) {
+	ctx := context.Background()
+
+	l, err := NewLoader("sqlite://"+t.TempDir()+"/substreams.db", 0, OnModuleHashMismatchIgnore, nil, zlog, tracer)
+	require.NoError(t, err)
+	defer l.Close()
+
+	require.NoError(t, l.Setup(ctx, `
+		create table "xfer" ("id" text not null primary key, "amount" numeric, "active" boolean);
+		create table "balance" ("account" text not null, "token" text not null, "value" bigint, primary key ("account", "token"));
+	`, false))
+	require.NoError(t, l.LoadTables())
+
+	cursor := sink.NewBlankCursor()
+	require.NoError(t, l.InsertCursor(ctx, "abc", cursor))
+
+	blockNum := func(num uint64) *uint64 { return &num }
+
+	// Block 10 is final, nothing is recorded in the history for it
+	require.NoError(t, l.Insert("xfer", map[string]string{"id": "1"}, map[string]string{"amount": "1.5", "active": "true"}, nil))
+	require.NoError(t, l.Insert("xfer", map[string]string{"id": "2"}, map[string]string{"amount": "2", "active": "false"}, nil))
+	require.NoError(t, l.Insert("balance", map[string]string{"account": "a", "token": "t"}, map[string]string{"value": "10"}, nil))
+	require.NoError(t, l.Insert("balance", map[string]string{"account": "b", "token": "t"}, map[string]string{"value": "20"}, nil))
+	_, err = l.Flush(ctx, "abc", cursor, 10)
+	require.NoError(t, err)
+
+	// Block 11 is reversible
+	require.NoError(t, l.Insert("xfer", map[string]string{"id": "3"}, map[string]string{"amount": "3", "active": "true"}, blockNum(11)))
+	require.NoError(t, l.Update("xfer", map[string]string{"id": "1"}, map[string]string{"amount": "100"}, blockNum(11)))
+	require.NoError(t, l.Delete("xfer", map[string]string{"id": "2"}, blockNum(11)))
+	require.NoError(t, l.Delete("balance", map[string]string{"account": "a", "token": "t"}, blockNum(11)))
+	require.NoError(t, l.Delete("balance", map[string]string{"account": "b", "token": "t"}, blockNum(11)))
+	_, err = l.Flush(ctx, "abc", cursor, 10)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"1|100|1", "3|3|1"}, sqliteRows(t, l, `SELECT "id" || '|' || "amount" || '|' || "active" FROM "xfer" ORDER BY "id"`))
+	assert.Empty(t, sqliteRows(t, l, `SELECT "account" FROM "balance"`))
+
+	require.NoError(t, l.Revert(ctx, "abc", cursor, 10))
+
+	assert.Equal(t, []string{"1|1.5|1", "2|2|0"}, sqliteRows(t, l, `SELECT "id" || '|' || "amount" || '|' || "active" FROM "xfer" ORDER BY "id"`))
+	assert.Equal(t, []string{"a|t|10", "b|t|20"}, sqliteRows(t, l, `SELECT "account" || '|' || "token" || '|' || "value" FROM "balance" ORDER BY "account"`))
+	assert.Empty(t, sqliteRows(t, l, `SELECT "op" FROM "substreams_history"`))
+
+	cursors, err := l.GetAllCursors(ctx)
+	require.NoError(t, err)
+	assert.Len(t, cursors, 1)
+
+	require.NoError(t, l.DeleteCursor(ctx, "abc"))
+	assert.ErrorIs(t, l.DeleteCursor(ctx, "abc"), ErrCursorNotFound)
+}
+
+func sqliteRows(t *testing.T, l *Loader, query string) (out []string) {
+	t.Helper()
+
+	rows, err := l.DB.Query(query)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	for rows.Next() {
+		var value string
+		require.NoError(t, rows.Scan(&value))
+		out = append(out, value)
+	}
+	require.NoError(t, rows.Err())
+
+	return out
+}
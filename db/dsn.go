@@ -13,6 +13,7 @@ import (
 
 type DSN struct {
 	driver   string
+	scheme   string
 	original string
 
 	host     string
@@ -27,7 +28,12 @@ type DSN struct {
 var driverMap = map[string]string{
 	"psql":       "postgres",
 	"postgres":   "postgres",
+	"pgx":        "pgx",
 	"clickhouse": "clickhouse",
+	"mysql":      "mysql",
+	"sqlite":     "sqlite3",
+	"duckdb":     "duckdb",
+	"cockroach":  "postgres",
 }
 
 func ParseDSN(dsn string) (*DSN, error) {
@@ -56,6 +62,12 @@ func ParseDSN(dsn string) (*DSN, error) {
 	host := dsnURL.Hostname()
 
 	port := int64(5432)
+	if driver == "mysql" {
+		port = 3306
+	}
+	if dsnURL.Scheme == "cockroach" {
+		port = 26257
+	}
 	if strings.Contains(dsnURL.Host, ":") {
 		port, _ = strconv.ParseInt(dsnURL.Port(), 10, 32)
 	}
@@ -74,6 +86,7 @@ func ParseDSN(dsn string) (*DSN, error) {
 	d := &DSN{
 		original: dsn,
 		driver:   driver,
+		scheme:   dsnURL.Scheme,
 		host:     host,
 		port:     port,
 		username: username,
@@ -82,10 +95,18 @@ func ParseDSN(dsn string) (*DSN, error) {
 		schema:   "public",
 	}
 
-	if driver == "clickhouse" {
+	if driver == "clickhouse" || driver == "mysql" {
 		d.schema = database
 	}
 
+	if driver == "sqlite3" || driver == "duckdb" {
+		// The database is the path of the file, `sqlite://path/to/file.db` is parsed with `path` as the host
+		d.host = ""
+		d.port = 0
+		d.database = dsnURL.Host + dsnURL.Path
+		d.schema = "main"
+	}
+
 	options := make([]string, len(query))
 	for i, key := range keys {
 		if key == "schema" {
@@ -103,6 +124,27 @@ func (c *DSN) ConnString() string {
 	if c.driver == "clickhouse" {
 		return c.original
 	}
+	if c.driver == "sqlite3" {
+		out := "file:" + c.database
+		if options := nonEmptyOptions(c.options); len(options) > 0 {
+			out += "?" + strings.Join(options, "&")
+		}
+		return out
+	}
+	if c.driver == "duckdb" {
+		out := c.database
+		if options := nonEmptyOptions(c.options); len(options) > 0 {
+			out += "?" + strings.Join(options, "&")
+		}
+		return out
+	}
+	if c.driver == "mysql" {
+		// Rows affected must count matched rows and not only changed ones, updating a cursor
+		// to the same value must report the row as affected
+		options := append([]string{"clientFoundRows=true", "multiStatements=true"}, nonEmptyOptions(c.options)...)
+
+		return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?%s", c.username, c.password, c.host, c.port, c.database, strings.Join(options, "&"))
+	}
 	out := fmt.Sprintf("host=%s port=%d user=%s dbname=%s %s", c.host, c.port, c.username, c.database, strings.Join(c.options, " "))
 	if c.password != "" {
 		out = out + " password=" + c.password
@@ -110,6 +152,16 @@ func (c *DSN) ConnString() string {
 	return out
 }
 
+// nonEmptyOptions filters out the options left empty by keys handled by ParseDSN itself, like `schema`
+func nonEmptyOptions(options []string) (out []string) {
+	for _, option := range options {
+		if option != "" {
+			out = append(out, option)
+		}
+	}
+	return
+}
+
 func (c *DSN) Schema() string {
 	return c.schema
 }
@@ -0,0 +1,264 @@
+package db
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// FieldUpdateOp defines how the value received for a column by an update is applied to the
+// column's current value, the default being to overwrite it.
+type FieldUpdateOp string
+
+const (
+	FieldUpdateOpSet       FieldUpdateOp = "SET"
+	FieldUpdateOpAdd       FieldUpdateOp = "ADD"
+	FieldUpdateOpMax       FieldUpdateOp = "MAX"
+	FieldUpdateOpMin       FieldUpdateOp = "MIN"
+	FieldUpdateOpSetIfNull FieldUpdateOp = "SET_IF_NULL"
+	FieldUpdateOpAppend    FieldUpdateOp = "APPEND"
+)
+
+var fieldUpdateOps = map[string]FieldUpdateOp{
+	"set":         FieldUpdateOpSet,
+	"add":         FieldUpdateOpAdd,
+	"max":         FieldUpdateOpMax,
+	"min":         FieldUpdateOpMin,
+	"set_if_null": FieldUpdateOpSetIfNull,
+	"append":      FieldUpdateOpAppend,
+}
+
+// FieldUpdateOps are the update operations of the columns, keyed by table then column name,
+// columns not listed are overwritten.
+type FieldUpdateOps map[string]map[string]FieldUpdateOp
+
+// ParseFieldUpdateOps parses field update operations of the form `<table>.<column>=<op>` where
+// `<op>` is one of `set`, `add`, `max`, `min`, `set_if_null` or `append`.
+func ParseFieldUpdateOps(in []string) (FieldUpdateOps, error) {
+	out := FieldUpdateOps{}
+	for _, element := range in {
+		column, opName, found := strings.Cut(element, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid field update operation %q, expected <table>.<column>=<op>", element)
+		}
+
+		table, column, found := strings.Cut(strings.TrimSpace(column), ".")
+		if !found || table == "" || column == "" {
+			return nil, fmt.Errorf("invalid field update operation %q, expected <table>.<column>=<op>", element)
+		}
+
+		op, found := fieldUpdateOps[strings.ToLower(strings.TrimSpace(opName))]
+		if !found {
+			return nil, fmt.Errorf("invalid field update operation %q, unknown operation %q", element, opName)
+		}
+
+		if out[table] == nil {
+			out[table] = map[string]FieldUpdateOp{}
+		}
+		out[table][column] = op
+	}
+
+	return out, nil
+}
+
+// SetFieldUpdateOps configures the update operations of the columns, the tables must have been
+// loaded first.
+func (l *Loader) SetFieldUpdateOps(ops FieldUpdateOps) error {
+	for tableName, columns := range ops {
+		table, found := l.tables[tableName]
+		if !found {
+			return fmt.Errorf("unknown table %q", tableName)
+		}
+
+		for column := range columns {
+			if _, found := table.columnsByName[column]; !found {
+				return fmt.Errorf("unknown column %q in table %q", column, tableName)
+			}
+		}
+	}
+
+	l.fieldUpdateOps = ops
+	return nil
+}
+
+// updateFieldOps returns the update operations of the data's columns which are not overwritten,
+// nil if there is none.
+func (l *Loader) updateFieldOps(tableName string, data map[string]string) (out map[string]FieldUpdateOp) {
+	for column, op := range l.fieldUpdateOps[tableName] {
+		// A column set to NULL is overwritten whatever its operation
+		if value, found := data[column]; !found || value == NullValue || op == FieldUpdateOpSet {
+			continue
+		}
+
+		if out == nil {
+			out = map[string]FieldUpdateOp{}
+		}
+		out[column] = op
+	}
+
+	return out
+}
+
+// fieldOpsKey returns a deterministic representation of the operation's field update operations,
+// operations are only batched together when they share the same ones.
+func (o *Operation) fieldOpsKey() string {
+	if len(o.fieldOps) == 0 {
+		return ""
+	}
+
+	columns := make([]string, 0, len(o.fieldOps))
+	for column, op := range o.fieldOps {
+		columns = append(columns, column+"="+string(op))
+	}
+	sort.Strings(columns)
+
+	return strings.Join(columns, ",")
+}
+
+// escapedFieldOps returns the operation's field update operations keyed by column name escaped
+// for the dialect, the way columns are rendered in statements.
+func (o *Operation) escapedFieldOps() map[string]FieldUpdateOp {
+	if len(o.fieldOps) == 0 {
+		return nil
+	}
+
+	out := make(map[string]FieldUpdateOp, len(o.fieldOps))
+	for column, op := range o.fieldOps {
+		out[o.table.columnsByName[column].escapedName] = op
+	}
+
+	return out
+}
+
+// foldFieldUpdate returns the value resulting of applying the update operation with the value to
+// the current value, so that two updates of the same row can be merged in the buffer. Like in
+// SQL, a NULL current value is replaced by the value whatever the operation.
+func foldFieldUpdate(op FieldUpdateOp, current, value string) (string, error) {
+	if current == NullValue {
+		return value, nil
+	}
+
+	switch op {
+	case FieldUpdateOpAdd:
+		a, b, err := parseDecimals(current, value)
+		if err != nil {
+			return "", fmt.Errorf("add: %w", err)
+		}
+
+		return formatDecimal(new(big.Rat).Add(a, b), max(decimalPlaces(current), decimalPlaces(value))), nil
+
+	case FieldUpdateOpMax, FieldUpdateOpMin:
+		a, b, err := parseDecimals(current, value)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", strings.ToLower(string(op)), err)
+		}
+
+		if cmp := a.Cmp(b); (op == FieldUpdateOpMax && cmp >= 0) || (op == FieldUpdateOpMin && cmp <= 0) {
+			return current, nil
+		}
+		return value, nil
+
+	case FieldUpdateOpSetIfNull:
+		return current, nil
+
+	case FieldUpdateOpAppend:
+		return current + value, nil
+
+	default:
+		return value, nil
+	}
+}
+
+// foldFieldUpdates returns the data with the values of columns having a field update operation
+// applied to the current row, for databases where the row is rewritten as a whole. Columns of the
+// current row which are empty or NULL are considered not set.
+func foldFieldUpdates(current, data map[string]string, fieldOps map[string]FieldUpdateOp) (map[string]string, error) {
+	if len(fieldOps) == 0 || current == nil {
+		return data, nil
+	}
+
+	out := make(map[string]string, len(data))
+	for column, value := range data {
+		out[column] = value
+
+		op, found := fieldOps[column]
+		if !found || current[column] == "" || current[column] == NullValue {
+			continue
+		}
+
+		folded, err := foldFieldUpdate(op, current[column], value)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %w", column, err)
+		}
+		out[column] = folded
+	}
+
+	return out, nil
+}
+
+func parseDecimals(a, b string) (*big.Rat, *big.Rat, error) {
+	ratA, ok := new(big.Rat).SetString(a)
+	if !ok {
+		return nil, nil, fmt.Errorf("value %q is not a number", a)
+	}
+
+	ratB, ok := new(big.Rat).SetString(b)
+	if !ok {
+		return nil, nil, fmt.Errorf("value %q is not a number", b)
+	}
+
+	return ratA, ratB, nil
+}
+
+func decimalPlaces(value string) int {
+	if dot := strings.IndexByte(value, '.'); dot != -1 {
+		return len(value) - dot - 1
+	}
+	return 0
+}
+
+func formatDecimal(value *big.Rat, places int) string {
+	if places == 0 && value.IsInt() {
+		return value.Num().String()
+	}
+	return value.FloatString(places)
+}
+
+// fieldUpdateSQL holds the SQL functions rendering the field update operations, their name
+// differs from one database to the other.
+type fieldUpdateSQL struct {
+	greatest string
+	least    string
+
+	// concat is the format of the concatenation of two strings
+	concat string
+}
+
+var (
+	standardFieldUpdateSQL = fieldUpdateSQL{greatest: "GREATEST", least: "LEAST", concat: "%s || %s"}
+	mysqlFieldUpdateSQL    = fieldUpdateSQL{greatest: "GREATEST", least: "LEAST", concat: "CONCAT(%s,%s)"}
+
+	// sqliteFieldUpdateSQL uses the multi-argument `max` and `min` scalar functions of SQLite
+	sqliteFieldUpdateSQL = fieldUpdateSQL{greatest: "max", least: "min", concat: "%s || %s"}
+)
+
+// expression returns the expression of the value set to a column by the update operation, current
+// is the expression of the column's current value and value returns the one of the new value, it
+// is called each time the new value is used so that positional parameters can be bound again.
+func (s fieldUpdateSQL) expression(op FieldUpdateOp, current string, value func() string) string {
+	switch op {
+	case FieldUpdateOpAdd:
+		return fmt.Sprintf("COALESCE(%s,0)+%s", current, value())
+	case FieldUpdateOpMax:
+		return fmt.Sprintf("%s(COALESCE(%s,%s),%s)", s.greatest, current, value(), value())
+	case FieldUpdateOpMin:
+		return fmt.Sprintf("%s(COALESCE(%s,%s),%s)", s.least, current, value(), value())
+	case FieldUpdateOpSetIfNull:
+		return fmt.Sprintf("COALESCE(%s,%s)", current, value())
+	case FieldUpdateOpAppend:
+		return fmt.Sprintf(s.concat, "COALESCE("+current+",'')", value())
+	default:
+		return value()
+	}
+}
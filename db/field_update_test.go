@@ -0,0 +1,95 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFieldUpdateOps(t *testing.T) {
+	out, err := ParseFieldUpdateOps([]string{"accounts.balance=add", "accounts.first_seen = SET_IF_NULL", "xfer.memo=append"})
+	require.NoError(t, err)
+	assert.Equal(t, FieldUpdateOps{
+		"accounts": {"balance": FieldUpdateOpAdd, "first_seen": FieldUpdateOpSetIfNull},
+		"xfer":     {"memo": FieldUpdateOpAppend},
+	}, out)
+
+	for _, in := range []string{"balance=add", "accounts.balance", "accounts.balance=sum", ".balance=add"} {
+		_, err := ParseFieldUpdateOps([]string{in})
+		assert.Error(t, err, in)
+	}
+}
+
+func TestFoldFieldUpdate(t *testing.T) {
+	tests := []struct {
+		op        FieldUpdateOp
+		current   string
+		value     string
+		expect    string
+		expectErr bool
+	}{
+		{op: FieldUpdateOpSet, current: "1", value: "2", expect: "2"},
+		{op: FieldUpdateOpAdd, current: "1", value: "2", expect: "3"},
+		{op: FieldUpdateOpAdd, current: "123456789012345678901234567890", value: "-1", expect: "123456789012345678901234567889"},
+		{op: FieldUpdateOpAdd, current: "1.25", value: "0.5", expect: "1.75"},
+		{op: FieldUpdateOpAdd, current: "1.5", value: "2.5", expect: "4.0"},
+		{op: FieldUpdateOpAdd, current: "abc", value: "1", expectErr: true},
+		{op: FieldUpdateOpMax, current: "10", value: "9", expect: "10"},
+		{op: FieldUpdateOpMax, current: "9", value: "10", expect: "10"},
+		{op: FieldUpdateOpMin, current: "10", value: "9", expect: "9"},
+		{op: FieldUpdateOpMin, current: "9", value: "10", expect: "9"},
+		{op: FieldUpdateOpSetIfNull, current: "first", value: "second", expect: "first"},
+		{op: FieldUpdateOpAppend, current: "a,", value: "b", expect: "a,b"},
+		{op: FieldUpdateOpAdd, current: NullValue, value: "2", expect: "2"},
+		{op: FieldUpdateOpSetIfNull, current: NullValue, value: "second", expect: "second"},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.op)+"/"+test.current+"/"+test.value, func(t *testing.T) {
+			out, err := foldFieldUpdate(test.op, test.current, test.value)
+			if test.expectErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expect, out)
+		})
+	}
+}
+
+func TestFieldUpdateMerge(t *testing.T) {
+	l, _ := NewTestLoader(zlog, tracer, "testschema", TestTables("testschema"))
+	require.NoError(t, l.SetFieldUpdateOps(FieldUpdateOps{"xfer": {"to": FieldUpdateOpAppend}}))
+
+	// Updates of the same row are folded, the operation is kept to apply it to the current value
+	require.NoError(t, l.Update("xfer", map[string]string{"id": "1"}, map[string]string{"to": "a"}, nil))
+	require.NoError(t, l.Update("xfer", map[string]string{"id": "1"}, map[string]string{"to": "b", "from": "c"}, nil))
+
+	// Updates following an insert are folded into the inserted value
+	require.NoError(t, l.Insert("xfer", map[string]string{"id": "2"}, map[string]string{"to": "a"}, nil))
+	require.NoError(t, l.Update("xfer", map[string]string{"id": "2"}, map[string]string{"to": "b"}, nil))
+
+	entries, found := l.entries.Get("xfer")
+	require.True(t, found)
+
+	update, _ := entries.Get("1")
+	assert.Equal(t, map[string]string{"to": "ab", "from": "c"}, update.data)
+	assert.Equal(t, map[string]FieldUpdateOp{"to": FieldUpdateOpAppend}, update.fieldOps)
+
+	insert, _ := entries.Get("2")
+	assert.Equal(t, map[string]string{"id": "2", "to": "ab"}, insert.data)
+	assert.Nil(t, insert.fieldOps)
+
+	statements, err := (&postgresDialect{}).prepareBatchStatements("testschema", batchOperations(entries, postgresMaxBatchRows, postgresMaxParams)[0])
+	require.NoError(t, err)
+	require.Len(t, statements, 1)
+	assert.Equal(t,
+		`UPDATE "testschema"."xfer" SET "from"=v."from", "to"=COALESCE("xfer"."to",'') || v."to" FROM json_populate_recordset(null::"testschema"."xfer",$1) AS v WHERE "xfer"."id" = v."id";`,
+		statements[0].query,
+	)
+
+	assert.Error(t, l.SetFieldUpdateOps(FieldUpdateOps{"xfer": {"unknown": FieldUpdateOpAdd}}))
+	assert.Error(t, l.SetFieldUpdateOps(FieldUpdateOps{"unknown": {"to": FieldUpdateOpAdd}}))
+}
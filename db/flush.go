@@ -14,32 +14,56 @@ func (l *Loader) Flush(ctx context.Context, outputModuleHash string, cursor *sin
 	ctx = clickhouse.Context(context.Background(), clickhouse.WithStdAsync(false))
 
 	startAt := time.Now()
-	tx, err := l.BeginTx(ctx, nil)
-	if err != nil {
-		return 0, fmt.Errorf("failed to being db transaction: %w", err)
-	}
-	defer func() {
-		if err != nil {
-			if err := tx.Rollback(); err != nil {
-				l.logger.Warn("failed to rollback transaction", zap.Error(err))
+	err = l.inTx(ctx, func(tx Tx) error {
+		if l.checkOldValues {
+			if err := l.verifyOldValues(ctx, tx); err != nil {
+				return fmt.Errorf("verify old values: %w", err)
 			}
 		}
-	}()
 
-	rowFlushedCount, err = l.getDialect().Flush(tx, ctx, l, outputModuleHash, lastFinalBlock)
-	if err != nil {
-		return 0, fmt.Errorf("dialect flush: %w", err)
-	}
+		changedRollupRows, writtenRollupRows := l.bufferedRollupRows()
+		detachedRollupGroups, err := l.detachRollupGroups(ctx, tx, changedRollupRows)
+		if err != nil {
+			return fmt.Errorf("detach rollup groups: %w", err)
+		}
 
-	rowFlushedCount += 1
-	if err := l.UpdateCursor(ctx, tx, outputModuleHash, cursor); err != nil {
-		return 0, fmt.Errorf("update cursor: %w", err)
-	}
+		count, err := l.getDialect().Flush(tx, ctx, l, outputModuleHash, lastFinalBlock)
+		if err != nil {
+			return fmt.Errorf("dialect flush: %w", err)
+		}
 
-	if err := tx.Commit(); err != nil {
-		return 0, fmt.Errorf("failed to commit db transaction: %w", err)
+		if err := l.attachRollupGroups(ctx, tx, writtenRollupRows, detachedRollupGroups); err != nil {
+			return fmt.Errorf("attach rollup groups: %w", err)
+		}
+
+		versionCount, err := l.flushVersions(ctx, tx)
+		if err != nil {
+			return fmt.Errorf("flush versions: %w", err)
+		}
+
+		if err := l.flushChanges(ctx, tx); err != nil {
+			return fmt.Errorf("flush changes log: %w", err)
+		}
+
+		if err := l.flushBlocks(ctx, tx); err != nil {
+			return fmt.Errorf("flush blocks: %w", err)
+		}
+
+		rowFlushedCount = count + versionCount + 1
+		if err := l.UpdateCursor(ctx, tx, outputModuleHash, cursor); err != nil {
+			return fmt.Errorf("update cursor: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
 	l.reset()
+	if err := l.pruneExpiredRows(ctx); err != nil {
+		// The flush itself is committed, only the pruning of the expired rows failed
+		return rowFlushedCount, fmt.Errorf("prune expired rows: %w", err)
+	}
 
 	// We add + 1 to the table count because the `cursors` table is an implicit table
 	l.logger.Debug("flushed table(s) rows to database", zap.Int("table_count", l.entries.Len()+1), zap.Int("row_count", rowFlushedCount), zap.Duration("took", time.Since(startAt)))
@@ -47,6 +71,70 @@ func (l *Loader) Flush(ctx context.Context, outputModuleHash string, cursor *sin
 }
 
 func (l *Loader) Revert(ctx context.Context, outputModuleHash string, cursor *sink.Cursor, lastValidBlock uint64) error {
+	// The compensating entries of the changes log are recorded at the last valid block, apart
+	// from the changes not flushed yet
+	l.currentBlock = &BlockMetadata{Number: lastValidBlock, ID: cursor.Block().ID()}
+	pendingChanges := l.changes
+	defer func() { l.changes = pendingChanges }()
+
+	err := l.inTx(ctx, func(tx Tx) error {
+		l.changes = nil
+		if err := l.getDialect().Revert(tx, ctx, l, lastValidBlock); err != nil {
+			return err
+		}
+
+		if err := l.revertVersions(ctx, tx, lastValidBlock); err != nil {
+			return fmt.Errorf("revert versions: %w", err)
+		}
+
+		if err := l.flushChanges(ctx, tx); err != nil {
+			return fmt.Errorf("flush changes log: %w", err)
+		}
+
+		if err := l.revertBlocks(ctx, tx, lastValidBlock); err != nil {
+			return fmt.Errorf("revert blocks: %w", err)
+		}
+
+		if err := l.UpdateCursor(ctx, tx, outputModuleHash, cursor); err != nil {
+			return fmt.Errorf("update cursor after revert: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	l.logger.Debug("reverted changes to database", zap.Uint64("last_valid_block", lastValidBlock))
+	return nil
+}
+
+// txMaxAttempts is the maximum amount of times a transaction failing with a retryable error is attempted
+const txMaxAttempts = 10
+
+// inTx runs `work` within a transaction, committed if `work` succeeds and rolled back otherwise. When
+// the dialect reports the error as retryable, like a serialization failure on CockroachDB, the whole
+// transaction is attempted again after a short backoff.
+func (l *Loader) inTx(ctx context.Context, work func(tx Tx) error) error {
+	retrier, _ := l.getDialect().(txRetrier)
+	for attempt := 1; ; attempt++ {
+		err := l.runTx(ctx, work)
+		if err == nil || retrier == nil || !retrier.RetryableTxError(err) || attempt >= txMaxAttempts {
+			return err
+		}
+
+		backoff := time.Duration(attempt) * 50 * time.Millisecond
+		l.logger.Info("retrying transaction after retryable error", zap.Int("attempt", attempt), zap.Duration("backoff", backoff), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func (l *Loader) runTx(ctx context.Context, work func(tx Tx) error) (err error) {
 	tx, err := l.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to being db transaction: %w", err)
@@ -59,19 +147,14 @@ func (l *Loader) Revert(ctx context.Context, outputModuleHash string, cursor *si
 		}
 	}()
 
-	if err := l.getDialect().Revert(tx, ctx, l, lastValidBlock); err != nil {
+	if err := work(tx); err != nil {
 		return err
 	}
 
-	if err := l.UpdateCursor(ctx, tx, outputModuleHash, cursor); err != nil {
-		return fmt.Errorf("update cursor after revert: %w", err)
-	}
-
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit db transaction: %w", err)
 	}
 
-	l.logger.Debug("reverted changes to database", zap.Uint64("last_valid_block", lastValidBlock))
 	return nil
 }
 
@@ -79,4 +162,9 @@ func (l *Loader) reset() {
 	for entriesPair := l.entries.Oldest(); entriesPair != nil; entriesPair = entriesPair.Next() {
 		l.entries.Set(entriesPair.Key, NewOrderedMap[string, *Operation]())
 	}
+	for entriesPair := l.versions.Oldest(); entriesPair != nil; entriesPair = entriesPair.Next() {
+		l.versions.Set(entriesPair.Key, NewOrderedMap[string, *Operation]())
+	}
+	l.changes = nil
+	l.blocks = nil
 }
@@ -0,0 +1,239 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	"golang.org/x/exp/maps"
+)
+
+// OldValues are the values a row is expected to hold before an update or a delete is applied
+// to it, along with the block of the change, they are only checked once enabled through
+// `Loader.SetCheckOldValues`.
+type OldValues struct {
+	BlockNum uint64
+	Data     map[string]string
+}
+
+// expectedColumns returns the old values to check, columns without an old value are skipped
+// since an empty value cannot be told apart from a column which was never set.
+func (v *OldValues) expectedColumns() map[string]string {
+	out := make(map[string]string, len(v.Data))
+	for column, value := range v.Data {
+		if value != "" {
+			out[column] = value
+		}
+	}
+
+	return out
+}
+
+// DivergenceError is returned when a row of the database does not hold the old values of an
+// update or a delete, meaning the database diverged from the state Substreams expects.
+type DivergenceError struct {
+	Table      string
+	PrimaryKey map[string]string
+	BlockNum   uint64
+	Reason     string
+}
+
+func (e *DivergenceError) Error() string {
+	return fmt.Sprintf("divergence detected in table %q for primary key %s at block #%d: %s", e.Table, primaryKeyToJSON(e.PrimaryKey), e.BlockNum, e.Reason)
+}
+
+// oldValuesChecker is implemented by the dialects able to check that rows hold the old values
+// of the operations before they are flushed.
+type oldValuesChecker interface {
+	// oldValuesStatement returns the statement selecting, for the row of the operation, one
+	// column per expected old value being 1 if the stored value matches it and 0 otherwise.
+	oldValuesStatement(op *Operation) (*statement, error)
+}
+
+// SetCheckOldValues enables the checking, before each flush, that the rows updated or deleted
+// still hold the old values of the changes, a mismatch failing the flush with a `DivergenceError`.
+func (l *Loader) SetCheckOldValues(enabled bool) error {
+	if _, ok := l.getDialect().(oldValuesChecker); enabled && !ok {
+		return fmt.Errorf("checking old values is not supported by the current database")
+	}
+
+	l.checkOldValues = enabled
+	return nil
+}
+
+// withOldValues returns the old values to attach to an operation, nil when they are not checked
+func (l *Loader) withOldValues(oldValues *OldValues) *OldValues {
+	if !l.checkOldValues || oldValues == nil {
+		return nil
+	}
+
+	return &OldValues{BlockNum: oldValues.BlockNum, Data: oldValues.expectedColumns()}
+}
+
+// divergence returns the error reporting that the row of the operation diverged at the block
+func (o *Operation) divergence(blockNum uint64, format string, args ...any) error {
+	return &DivergenceError{
+		Table:      o.table.name,
+		PrimaryKey: o.primaryKey,
+		BlockNum:   blockNum,
+		Reason:     fmt.Sprintf(format, args...),
+	}
+}
+
+// checkNextOldValues checks the old values of the next operation on the same row against the
+// scheduled operation. The columns it does not write are expected to hold their old value
+// before the scheduled operation, for an update they are checked at flush time along with
+// the scheduled operation's own old values.
+func (o *Operation) checkNextOldValues(next *Operation) error {
+	if next.oldValues == nil {
+		return nil
+	}
+
+	if o.opType == OperationTypeDelete {
+		return next.divergence(next.oldValues.BlockNum, "row was deleted earlier in the same flush")
+	}
+
+	columns := maps.Keys(next.oldValues.Data)
+	sort.Strings(columns)
+
+	for _, column := range columns {
+		expected := next.oldValues.Data[column]
+		if _, found := o.fieldOps[column]; found {
+			// The scheduled value is applied to the stored one, the resulting value is not known
+			continue
+		}
+
+		if current, found := o.data[column]; found {
+			if current != expected {
+				return next.divergence(next.oldValues.BlockNum, "column %q was set to %s earlier in the same flush but its old value is %s", column, displayValue(current), displayValue(expected))
+			}
+			continue
+		}
+
+		if o.opType != OperationTypeUpdate {
+			continue
+		}
+
+		if o.oldValues == nil {
+			o.oldValues = &OldValues{BlockNum: next.oldValues.BlockNum, Data: map[string]string{}}
+		}
+		if _, found := o.oldValues.Data[column]; !found {
+			o.oldValues.Data[column] = expected
+		}
+	}
+
+	return nil
+}
+
+// verifyOldValues checks that the rows of the scheduled operations hold their old values, it is
+// done within the flush transaction before any of the operations is applied.
+func (l *Loader) verifyOldValues(ctx context.Context, tx Tx) error {
+	checker, ok := l.getDialect().(oldValuesChecker)
+	if !ok {
+		return fmt.Errorf("checking old values is not supported by the current database")
+	}
+
+	var checked int
+	for entriesPair := l.entries.Oldest(); entriesPair != nil; entriesPair = entriesPair.Next() {
+		for entryPair := entriesPair.Value.Oldest(); entryPair != nil; entryPair = entryPair.Next() {
+			op := entryPair.Value
+			if op.oldValues == nil {
+				continue
+			}
+
+			if err := op.verifyOldValues(ctx, tx, checker); err != nil {
+				return err
+			}
+			checked++
+		}
+	}
+
+	if l.tracer.Enabled() {
+		l.logger.Debug("checked old values of scheduled operations", zap.Int("row_count", checked))
+	}
+
+	return nil
+}
+
+func (o *Operation) verifyOldValues(ctx context.Context, tx Tx, checker oldValuesChecker) error {
+	stmt, err := checker.oldValuesStatement(o)
+	if err != nil {
+		return fmt.Errorf("preparing old values statement of %s: %w", o, err)
+	}
+
+	rows, err := tx.QueryContext(ctx, stmt.query, stmt.args...)
+	if err != nil {
+		return fmt.Errorf("executing query %q: %w", stmt.query, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("reading old values of %s: %w", o, err)
+		}
+
+		return o.divergence(o.oldValues.BlockNum, "row does not exist")
+	}
+
+	columns := oldValuesColumns(o.oldValues)
+	matches := make([]int64, len(columns)+1)
+	pointers := make([]any, len(matches))
+	for i := range matches {
+		pointers[i] = &matches[i]
+	}
+
+	if err := rows.Scan(pointers...); err != nil {
+		return fmt.Errorf("scanning old values of %s: %w", o, err)
+	}
+
+	var mismatches []string
+	for i, column := range columns {
+		if matches[i+1] != 1 {
+			mismatches = append(mismatches, fmt.Sprintf("%q (expected %s)", column, displayValue(o.oldValues.Data[column])))
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return o.divergence(o.oldValues.BlockNum, "stored value of column(s) %s does not match the old value", strings.Join(mismatches, ", "))
+	}
+
+	return rows.Err()
+}
+
+// oldValuesColumns returns the sorted columns of the old values, the order in which they are
+// selected by `oldValuesQuery`.
+func oldValuesColumns(oldValues *OldValues) []string {
+	columns := maps.Keys(oldValues.Data)
+	sort.Strings(columns)
+	return columns
+}
+
+// oldValuesQuery returns the query selecting a constant, so that a row is returned when it exists,
+// followed by whether each column holds its expected value. The columns and values are the sorted
+// escaped columns and the typed values of the old values, a nil value expecting the column to be
+// NULL, `where` renders the primary key clause and is called once the values have been bound.
+func oldValuesQuery(table *TableInfo, columns []string, values []any, args *queryArgs, where func() string) string {
+	selected := make([]string, len(columns)+1)
+	selected[0] = "1"
+	for i, column := range columns {
+		if values[i] == nil {
+			selected[i+1] = fmt.Sprintf("CASE WHEN %s IS NULL THEN 1 ELSE 0 END", column)
+			continue
+		}
+
+		selected[i+1] = fmt.Sprintf("CASE WHEN %s = %s THEN 1 ELSE 0 END", column, args.add(values[i]))
+	}
+
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s;", strings.Join(selected, ","), table.identifier, where())
+}
+
+// displayValue returns the value quoted for error messages, NULL for a NULL value
+func displayValue(value string) string {
+	if value == NullValue {
+		return "NULL"
+	}
+
+	return fmt.Sprintf("%q", value)
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOldValuesFold(t *testing.T) {
+	l, _ := NewTestLoader(zlog, tracer, "testschema", TestTables("testschema"))
+	require.NoError(t, l.SetCheckOldValues(true))
+
+	pk := map[string]string{"id": "1"}
+
+	// Old values of columns not written earlier are checked against the stored row, empty ones are skipped
+	require.NoError(t, l.UpdateWithOldValues("xfer", pk, map[string]string{"to": "b"}, &OldValues{BlockNum: 10, Data: map[string]string{"to": "a", "from": ""}}, nil))
+	require.NoError(t, l.UpdateWithOldValues("xfer", pk, map[string]string{"to": "c"}, &OldValues{BlockNum: 11, Data: map[string]string{"to": "b", "from": "x"}}, nil))
+
+	entries, _ := l.entries.Get("xfer")
+	update, _ := entries.Get("1")
+	assert.Equal(t, &OldValues{BlockNum: 10, Data: map[string]string{"to": "a", "from": "x"}}, update.oldValues)
+
+	statements, err := (postgresDialect{}).oldValuesStatement(update)
+	require.NoError(t, err)
+	assert.Equal(t,
+		`SELECT 1,CASE WHEN "from" = $1 THEN 1 ELSE 0 END,CASE WHEN "to" = $2 THEN 1 ELSE 0 END FROM "testschema"."xfer" WHERE "id" = $3;`,
+		statements.query,
+	)
+	assert.Equal(t, []any{"x", "a", "1"}, statements.args)
+
+	// Old values of columns written earlier in the buffer must match the written value
+	err = l.UpdateWithOldValues("xfer", pk, map[string]string{"to": "d"}, &OldValues{BlockNum: 12, Data: map[string]string{"to": "b"}}, nil)
+	var divergence *DivergenceError
+	require.True(t, errors.As(err, &divergence))
+	assert.Equal(t, "xfer", divergence.Table)
+	assert.Equal(t, pk, divergence.PrimaryKey)
+	assert.Equal(t, uint64(12), divergence.BlockNum)
+
+	// The delete keeps the old values of the update it replaces
+	require.NoError(t, l.DeleteWithOldValues("xfer", pk, &OldValues{BlockNum: 12, Data: map[string]string{"to": "c"}}, nil))
+	deleted, _ := entries.Get("1")
+	assert.Equal(t, OperationTypeDelete, deleted.opType)
+	assert.Equal(t, uint64(10), deleted.oldValues.BlockNum)
+
+	// Updating a row deleted earlier in the buffer diverges
+	err = l.UpdateWithOldValues("xfer", pk, map[string]string{"to": "e"}, &OldValues{BlockNum: 13, Data: map[string]string{"to": "c"}}, nil)
+	require.True(t, errors.As(err, &divergence))
+	assert.Equal(t, uint64(13), divergence.BlockNum)
+}
+
+func TestOldValuesNotChecked(t *testing.T) {
+	l, _ := NewTestLoader(zlog, tracer, "testschema", TestTables("testschema"))
+
+	pk := map[string]string{"id": "1"}
+	require.NoError(t, l.UpdateWithOldValues("xfer", pk, map[string]string{"to": "b"}, &OldValues{BlockNum: 10, Data: map[string]string{"to": "a"}}, nil))
+	require.NoError(t, l.UpdateWithOldValues("xfer", pk, map[string]string{"to": "c"}, &OldValues{BlockNum: 11, Data: map[string]string{"to": "z"}}, nil))
+
+	entries, _ := l.entries.Get("xfer")
+	update, _ := entries.Get("1")
+	assert.Nil(t, update.oldValues)
+}
@@ -1,12 +1,15 @@
 package db
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"reflect"
-	"regexp"
+	"sort"
 	"strings"
 	"time"
+
+	"golang.org/x/exp/maps"
 )
 
 type TypeGetter func(tableName string, columnName string) (reflect.Type, error)
@@ -21,14 +24,38 @@ const (
 	OperationTypeInsert OperationType = "INSERT"
 	OperationTypeUpdate OperationType = "UPDATE"
 	OperationTypeDelete OperationType = "DELETE"
+
+	// OperationTypeUpsert inserts the row or, if a row with the same primary key already
+	// exists, updates it with the operation's data.
+	OperationTypeUpsert OperationType = "UPSERT"
+
+	// OperationTypeReplace deletes the row, if it exists, and inserts it again with the operation's
+	// data, it results of a delete followed by an insert or an upsert of the same row in the buffer.
+	OperationTypeReplace OperationType = "REPLACE"
 )
 
+// NullValue is the value of a column set to SQL NULL. It is not valid UTF-8, protobuf strings always
+// being valid UTF-8 it cannot collide with a value received from Substreams.
+const NullValue = "\xff"
+
 type Operation struct {
 	table              *TableInfo
 	opType             OperationType
 	primaryKey         map[string]string
 	data               map[string]string
 	reversibleBlockNum *uint64 // nil if that block is known to be irreversible
+
+	// fieldOps are the update operations of the data's columns which are not overwritten, only
+	// set on updates and upserts.
+	fieldOps map[string]FieldUpdateOp
+
+	// oldValues are the values the row is expected to hold before the operation, only set on
+	// updates and deletes when old values are checked.
+	oldValues *OldValues
+
+	// versionBlockNum is the block the operation writes a version at, only set on the operations
+	// of versioned tables.
+	versionBlockNum uint64
 }
 
 func (o *Operation) String() string {
@@ -55,6 +82,16 @@ func (l *Loader) newUpdateOperation(table *TableInfo, primaryKey map[string]stri
 	}
 }
 
+func (l *Loader) newUpsertOperation(table *TableInfo, primaryKey map[string]string, data map[string]string, reversibleBlockNum *uint64) *Operation {
+	return &Operation{
+		table:              table,
+		opType:             OperationTypeUpsert,
+		primaryKey:         primaryKey,
+		data:               data,
+		reversibleBlockNum: reversibleBlockNum,
+	}
+}
+
 func (l *Loader) newDeleteOperation(table *TableInfo, primaryKey map[string]string, reversibleBlockNum *uint64) *Operation {
 	return &Operation{
 		table:              table,
@@ -64,34 +101,143 @@ func (l *Loader) newDeleteOperation(table *TableInfo, primaryKey map[string]stri
 	}
 }
 
-func (o *Operation) mergeData(newData map[string]string) error {
+// mergeData merges the data of a subsequent operation on the same row, the values of columns
+// having a field update operation are folded into the ones already scheduled.
+func (o *Operation) mergeData(newData map[string]string, fieldOps map[string]FieldUpdateOp) error {
 	if o.opType == OperationTypeDelete {
 		return fmt.Errorf("unable to merge data for a delete operation")
 	}
 
 	for k, v := range newData {
+		op, found := fieldOps[k]
+		if !found {
+			o.data[k] = v
+			if o.fieldOps != nil {
+				delete(o.fieldOps, k)
+			}
+			continue
+		}
+
+		if current, found := o.data[k]; found {
+			folded, err := foldFieldUpdate(op, current, v)
+			if err != nil {
+				return fmt.Errorf("column %q: %w", k, err)
+			}
+
+			o.data[k] = folded
+			continue
+		}
+
 		o.data[k] = v
+		if o.opType == OperationTypeUpdate || o.opType == OperationTypeUpsert {
+			if o.fieldOps == nil {
+				o.fieldOps = map[string]FieldUpdateOp{}
+			}
+			o.fieldOps[k] = op
+		}
 	}
 	return nil
 }
 
-var integerRegex = regexp.MustCompile(`^\d+$`)
+// fold returns the operation resulting of the operation followed by the next one on the same row,
+// nil if they cancel out. The rules are, for the scheduled operation (rows) followed by the next
+// one (columns):
+//
+//	          | INSERT  | UPDATE  | UPSERT  | REPLACE | DELETE
+//	INSERT    | INSERT  | INSERT  | INSERT  | INSERT  | (none)
+//	UPDATE    | UPSERT  | UPDATE  | UPSERT  | REPLACE | DELETE
+//	UPSERT    | UPSERT  | UPSERT  | UPSERT  | REPLACE | DELETE
+//	DELETE    | REPLACE | DELETE  | REPLACE | REPLACE | DELETE
+//	REPLACE   | REPLACE | REPLACE | REPLACE | REPLACE | DELETE
+//
+// The data of the next operation is merged into the scheduled one, except after a delete or for a
+// replace where the row is written again with the next operation's data only, an update of a
+// deleted row being ignored. The folded operation keeps the reversible block of the scheduled one, its history
+// records the row as it was before the scheduled operation. Likewise, it keeps the old values
+// of the scheduled operation, the ones of the next operation being checked against it.
+func (o *Operation) fold(next *Operation) (*Operation, error) {
+	if err := o.checkNextOldValues(next); err != nil {
+		return nil, err
+	}
+
+	if next.opType == OperationTypeDelete {
+		if o.opType == OperationTypeInsert {
+			// The row did not exist before being inserted, nothing is left to write
+			return nil, nil
+		}
+
+		next.reversibleBlockNum = o.reversibleBlockNum
+		next.oldValues = o.oldValues
+		return next, nil
+	}
+
+	if next.opType == OperationTypeReplace {
+		if o.opType == OperationTypeInsert {
+			// The row did not exist before being inserted, it still is an insert
+			next.opType = OperationTypeInsert
+		}
+
+		next.reversibleBlockNum = o.reversibleBlockNum
+		next.oldValues = o.oldValues
+		return next, nil
+	}
+
+	if o.opType == OperationTypeDelete {
+		if next.opType == OperationTypeUpdate {
+			// Like in SQL, updating a row which does not exist does nothing
+			return o, nil
+		}
+
+		next.opType = OperationTypeReplace
+		next.fieldOps = nil
+		next.reversibleBlockNum = o.reversibleBlockNum
+		next.oldValues = o.oldValues
+		return next, nil
+	}
+
+	if o.opType == OperationTypeUpdate && next.opType != OperationTypeUpdate {
+		o.opType = OperationTypeUpsert
+	}
+
+	if err := o.mergeData(next.data, next.fieldOps); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
 var reflectTypeTime = reflect.TypeOf(time.Time{})
 
-func EscapeIdentifier(valueToEscape string) string {
-	if strings.Contains(valueToEscape, `"`) {
-		valueToEscape = strings.ReplaceAll(valueToEscape, `"`, `""`)
+// nullableScanTypes maps the scan types some drivers report for nullable or textual columns
+// to the type of the value they hold.
+var nullableScanTypes = map[reflect.Type]reflect.Type{
+	reflect.TypeOf(sql.NullString{}):  reflect.TypeOf(""),
+	reflect.TypeOf(sql.NullInt64{}):   reflect.TypeOf(int64(0)),
+	reflect.TypeOf(sql.NullInt32{}):   reflect.TypeOf(int32(0)),
+	reflect.TypeOf(sql.NullInt16{}):   reflect.TypeOf(int16(0)),
+	reflect.TypeOf(sql.NullFloat64{}): reflect.TypeOf(float64(0)),
+	reflect.TypeOf(sql.NullBool{}):    reflect.TypeOf(false),
+	reflect.TypeOf(sql.NullTime{}):    reflectTypeTime,
+	reflect.TypeOf(sql.RawBytes{}):    reflect.TypeOf(""),
+}
+
+// baseScanType returns the type of the value held by the column, unwrapping the `sql.Null*`
+// types MySQL and SQLite drivers report for nullable columns and the `sql.RawBytes` used by
+// the MySQL driver for textual ones.
+func baseScanType(scanType reflect.Type) reflect.Type {
+	if base, found := nullableScanTypes[scanType]; found {
+		return base
 	}
 
-	return `"` + valueToEscape + `"`
+	return scanType
 }
 
-func escapeStringValue(valueToEscape string) string {
-	if strings.Contains(valueToEscape, `'`) {
-		valueToEscape = strings.ReplaceAll(valueToEscape, `'`, `''`)
+func EscapeIdentifier(valueToEscape string) string {
+	if strings.Contains(valueToEscape, `"`) {
+		valueToEscape = strings.ReplaceAll(valueToEscape, `"`, `""`)
 	}
 
-	return `'` + valueToEscape + `'`
+	return `"` + valueToEscape + `"`
 }
 
 // to store in an history table
@@ -112,3 +258,65 @@ func jsonToPrimaryKey(in string) (map[string]string, error) {
 	}
 	return out, nil
 }
+
+// rowToJSON returns the row as a JSON object to store in an history table, NULL columns being
+// stored as JSON nulls.
+func rowToJSON(row map[string]string) string {
+	nullable := make(map[string]*string, len(row))
+	for column, value := range row {
+		if value == NullValue {
+			nullable[column] = nil
+			continue
+		}
+
+		value := value
+		nullable[column] = &value
+	}
+
+	m, err := json.Marshal(nullable)
+	if err != nil {
+		panic(err) // should never happen with map[string]*string
+	}
+	return string(m)
+}
+
+// jsonToRow returns a row stored in an history table with rowToJSON
+func jsonToRow(in string) (map[string]string, error) {
+	nullable := make(map[string]*string)
+	if err := json.Unmarshal([]byte(in), &nullable); err != nil {
+		return nil, fmt.Errorf("unmarshalling %q into row: %w", in, err)
+	}
+
+	out := make(map[string]string, len(nullable))
+	for column, value := range nullable {
+		if value == nil {
+			out[column] = NullValue
+			continue
+		}
+
+		out[column] = *value
+	}
+	return out, nil
+}
+
+// jsonToTextRow returns the sorted columns and values of a row saved in the history table as a JSON
+// object of the columns textual representation, a `null` value being a NULL column. The values are
+// meant to be bound as parameters, the database parsing them back to the column's type.
+func jsonToTextRow(in string) (columns []string, values []any, err error) {
+	row := make(map[string]*string)
+	if err := json.Unmarshal([]byte(in), &row); err != nil {
+		return nil, nil, fmt.Errorf("unmarshalling %q into row: %w", in, err)
+	}
+
+	columns = maps.Keys(row)
+	sort.Strings(columns)
+
+	values = make([]any, len(columns))
+	for i, column := range columns {
+		if value := row[column]; value != nil {
+			values[i] = *value
+		}
+	}
+
+	return columns, values, nil
+}
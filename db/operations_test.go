@@ -112,8 +112,8 @@ func TestEscapeValues(t *testing.T) {
 			tx, err := dbLoader.DB.Begin()
 			require.NoError(t, err)
 
-			insertStatement := fmt.Sprintf(`insert into "test" ("col") values (%s);`, escapeStringValue(str))
-			_, err = tx.ExecContext(ctx, insertStatement)
+			insertStatement := `insert into "test" ("col") values ($1);`
+			_, err = tx.ExecContext(ctx, insertStatement, str)
 			require.NoError(tt, err)
 
 			checkStatement := `select "col" from "test";`
@@ -138,7 +138,7 @@ func Test_prepareColValues(t *testing.T) {
 		name        string
 		args        args
 		wantColumns []string
-		wantValues  []string
+		wantValues  []any
 		assertion   require.ErrorAssertionFunc
 	}{
 		{
@@ -148,7 +148,17 @@ func Test_prepareColValues(t *testing.T) {
 				map[string]string{"col": "true"},
 			},
 			[]string{`"col"`},
-			[]string{`'true'`},
+			[]any{true},
+			require.NoError,
+		},
+		{
+			"null integer",
+			args{
+				newTable(t, "schema", "name", "id", NewColumnInfo("col", "int8", int64(0))),
+				map[string]string{"col": NullValue},
+			},
+			[]string{`"col"`},
+			[]any{nil},
 			require.NoError,
 		},
 	}
@@ -176,3 +186,13 @@ func newTable(t *testing.T, schema, name, primaryColumn string, columnInfos ...*
 
 	return table
 }
+
+func TestRowToJSONNulls(t *testing.T) {
+	row := map[string]string{"id": "1", "from": NullValue, "to": ""}
+
+	assert.Equal(t, `{"from":null,"id":"1","to":""}`, rowToJSON(row))
+
+	out, err := jsonToRow(rowToJSON(row))
+	require.NoError(t, err)
+	assert.Equal(t, row, out)
+}
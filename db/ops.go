@@ -9,7 +9,8 @@ import (
 )
 
 // Insert a row in the DB, it is assumed the table exists, you can do a
-// check before with HasTable()
+// check before with HasTable(). For tables with soft deletes, a soft deleted
+// row is revived, the insert updating it and clearing its soft delete columns.
 func (l *Loader) Insert(tableName string, primaryKey map[string]string, data map[string]string, reversibleBlockNum *uint64) error {
 	uniqueID := createRowUniqueID(primaryKey)
 
@@ -22,24 +23,6 @@ func (l *Loader) Insert(tableName string, primaryKey map[string]string, data map
 		return fmt.Errorf("unknown table %q", tableName)
 	}
 
-	entry, found := l.entries.Get(tableName)
-	if !found {
-		if l.tracer.Enabled() {
-			l.logger.Debug("adding tracking of table never seen before", zap.String("table_name", tableName))
-		}
-
-		entry = NewOrderedMap[string, *Operation]()
-		l.entries.Set(tableName, entry)
-	}
-
-	if _, found := entry.Get(uniqueID); found {
-		return fmt.Errorf("attempting to insert in table %q a primary key %q, that is already scheduled for insertion, insert should only be called once for a given primary key", tableName, primaryKey)
-	}
-
-	if l.tracer.Enabled() {
-		l.logger.Debug("primary key entry never existed for table, adding insert operation", zap.String("primary_key", uniqueID), zap.String("table_name", tableName))
-	}
-
 	// We need to make sure to add the primary key(s) in the data so that those column get created correctly, but only if there is data
 	for _, primary := range l.tables[tableName].primaryColumns {
 		if dataFromPrimaryKey, ok := primaryKey[primary.name]; ok {
@@ -47,9 +30,12 @@ func (l *Loader) Insert(tableName string, primaryKey map[string]string, data map
 		}
 	}
 
-	entry.Set(uniqueID, l.newInsertOperation(table, primaryKey, data, reversibleBlockNum))
-	l.entriesCount++
-	return nil
+	if table.softDelete {
+		clearSoftDeleteColumns(table, data)
+	}
+
+	op := l.newInsertOperation(table, primaryKey, data, reversibleBlockNum)
+	return l.schedule(tableName, uniqueID, op)
 }
 
 func createRowUniqueID(m map[string]string) string {
@@ -78,8 +64,12 @@ func createRowUniqueID(m map[string]string) string {
 	return strings.Join(values, "/")
 }
 
+// GetPrimaryKey returns the primary key of the table's row identified by the single primary key
+// string sent by Substreams. For tables with a composite primary key, the string is split using the
+// primary key separator, if configured, each part being assigned to the primary key columns in the
+// order they are declared.
 func (l *Loader) GetPrimaryKey(tableName string, pk string) (map[string]string, error) {
-	primaryKeyColumns := l.tables[tableName].primaryColumns
+	primaryKeyColumns := l.tables[tableName].keyColumns()
 
 	switch len(primaryKeyColumns) {
 	case 0:
@@ -92,12 +82,38 @@ func (l *Loader) GetPrimaryKey(tableName string, pk string) (map[string]string,
 	for i := range primaryKeyColumns {
 		cols[i] = primaryKeyColumns[i].name
 	}
-	return nil, fmt.Errorf("substreams sent a single primary key, but our sql table has a composite primary key (columns: %s). This is unsupported.", strings.Join(cols, ","))
+
+	if l.primaryKeySeparator == "" {
+		return nil, fmt.Errorf("substreams sent a single primary key, but our sql table has a composite primary key (columns: %s). This is unsupported unless a primary key separator is configured.", strings.Join(cols, ","))
+	}
+
+	parts := strings.Split(pk, l.primaryKeySeparator)
+	if len(parts) != len(cols) {
+		return nil, fmt.Errorf("substreams sent a single primary key %q which splits into %d part(s) using separator %q, but our sql table has a composite primary key of %d columns (columns: %s)", pk, len(parts), l.primaryKeySeparator, len(cols), strings.Join(cols, ","))
+	}
+
+	out := make(map[string]string, len(cols))
+	for i, col := range cols {
+		out[col] = parts[i]
+	}
+	return out, nil
+}
+
+// SetPrimaryKeySeparator configures the separator splitting the single primary key string sent by
+// Substreams into the columns of a composite primary key, an empty separator disables splitting.
+func (l *Loader) SetPrimaryKeySeparator(separator string) {
+	l.primaryKeySeparator = separator
 }
 
 // Update a row in the DB, it is assumed the table exists, you can do a
 // check before with HasTable()
 func (l *Loader) Update(tableName string, primaryKey map[string]string, data map[string]string, reversibleBlockNum *uint64) error {
+	return l.UpdateWithOldValues(tableName, primaryKey, data, nil, reversibleBlockNum)
+}
+
+// UpdateWithOldValues updates a row in the DB like Update, the row being expected to hold the
+// old values before the update when they are checked, see SetCheckOldValues.
+func (l *Loader) UpdateWithOldValues(tableName string, primaryKey map[string]string, data map[string]string, oldValues *OldValues, reversibleBlockNum *uint64) error {
 	if l.getDialect().OnlyInserts() {
 		return fmt.Errorf("update operation is not supported by the current database")
 	}
@@ -116,43 +132,56 @@ func (l *Loader) Update(tableName string, primaryKey map[string]string, data map
 		return fmt.Errorf("trying to perform an UPDATE operation but table %q don't have a primary key(s) set, this is not accepted", tableName)
 	}
 
-	entry, found := l.entries.Get(tableName)
-	if !found {
-		if l.tracer.Enabled() {
-			l.logger.Debug("adding tracking of table never seen before", zap.String("table_name", tableName))
-		}
+	op := l.newUpdateOperation(table, primaryKey, data, reversibleBlockNum)
+	op.fieldOps = l.updateFieldOps(tableName, data)
+	op.oldValues = l.withOldValues(oldValues)
 
-		entry = NewOrderedMap[string, *Operation]()
-		l.entries.Set(tableName, entry)
+	return l.schedule(tableName, uniqueID, op)
+}
+
+// Upsert a row in the DB, inserting it if it does not exist or updating it otherwise, it is
+// assumed the table exists, you can do a check before with HasTable()
+func (l *Loader) Upsert(tableName string, primaryKey map[string]string, data map[string]string, reversibleBlockNum *uint64) error {
+	if l.getDialect().OnlyInserts() {
+		return fmt.Errorf("upsert operation is not supported by the current database")
 	}
 
-	if op, found := entry.Get(uniqueID); found {
-		if op.opType == OperationTypeDelete {
-			return fmt.Errorf("attempting to update an object with primary key %q, that schedule to be deleted", primaryKey)
-		}
+	uniqueID := createRowUniqueID(primaryKey)
+	if l.tracer.Enabled() {
+		l.logger.Debug("processing upsert operation", zap.String("table_name", tableName), zap.String("primary_key", uniqueID), zap.Int("field_count", len(data)))
+	}
 
-		if l.tracer.Enabled() {
-			l.logger.Debug("primary key entry already exist for table, merging fields together", zap.String("primary_key", uniqueID), zap.String("table_name", tableName))
-		}
+	table, found := l.tables[tableName]
+	if !found {
+		return fmt.Errorf("unknown table %q", tableName)
+	}
 
-		op.mergeData(data)
-		entry.Set(uniqueID, op)
-		return nil
-	} else {
-		l.entriesCount++
+	if len(table.primaryColumns) == 0 {
+		return fmt.Errorf("trying to perform an UPSERT operation but table %q don't have a primary key(s) set, this is not accepted", tableName)
 	}
 
-	if l.tracer.Enabled() {
-		l.logger.Debug("primary key entry never existed for table, adding update operation", zap.String("primary_key", uniqueID), zap.String("table_name", tableName))
+	// We need to make sure to add the primary key(s) in the data so that those column get created correctly if the row does not exist
+	for _, primary := range table.primaryColumns {
+		if dataFromPrimaryKey, ok := primaryKey[primary.name]; ok {
+			data[primary.name] = dataFromPrimaryKey
+		}
 	}
 
-	entry.Set(uniqueID, l.newUpdateOperation(table, primaryKey, data, reversibleBlockNum))
-	return nil
+	op := l.newUpsertOperation(table, primaryKey, data, reversibleBlockNum)
+	op.fieldOps = l.updateFieldOps(tableName, data)
+
+	return l.schedule(tableName, uniqueID, op)
 }
 
 // Delete a row in the DB, it is assumed the table exists, you can do a
 // check before with HasTable()
 func (l *Loader) Delete(tableName string, primaryKey map[string]string, reversibleBlockNum *uint64) error {
+	return l.DeleteWithOldValues(tableName, primaryKey, nil, reversibleBlockNum)
+}
+
+// DeleteWithOldValues deletes a row in the DB like Delete, the row being expected to hold the
+// old values before the delete when they are checked, see SetCheckOldValues.
+func (l *Loader) DeleteWithOldValues(tableName string, primaryKey map[string]string, oldValues *OldValues, reversibleBlockNum *uint64) error {
 	if l.getDialect().OnlyInserts() {
 		return fmt.Errorf("delete operation is not supported by the current database")
 	}
@@ -167,32 +196,73 @@ func (l *Loader) Delete(tableName string, primaryKey map[string]string, reversib
 		return fmt.Errorf("unknown table %q", tableName)
 	}
 
-	if len(table.primaryColumns) != 1 {
+	if len(table.primaryColumns) == 0 {
 		return fmt.Errorf("trying to perform a DELETE operation but table %q don't have a primary key(s) set, this is not accepted", tableName)
 	}
 
-	entry, found := l.entries.Get(tableName)
+	op := l.newDeleteOperation(table, primaryKey, reversibleBlockNum)
+	op.oldValues = l.withOldValues(oldValues)
+
+	return l.schedule(tableName, uniqueID, op)
+}
+
+// schedule adds the operation to the buffer, if an operation on the same row is already
+// scheduled, both are folded together following the rules of `Operation.fold`.
+func (l *Loader) schedule(tableName string, uniqueID string, op *Operation) error {
+	if op.table.versioned {
+		return fmt.Errorf("table %q is versioned, its operations must be scheduled with ScheduleVersion", tableName)
+	}
+
+	return l.scheduleIn(l.entries, tableName, uniqueID, op)
+}
+
+// scheduleIn is schedule adding the operation to the received buffer
+func (l *Loader) scheduleIn(buffer *OrderedMap[string, *OrderedMap[string, *Operation]], tableName string, uniqueID string, op *Operation) error {
+	if err := l.logChange(op); err != nil {
+		return fmt.Errorf("logging %s operation of primary key %q in table %q: %w", strings.ToLower(string(op.opType)), uniqueID, tableName, err)
+	}
+	l.countBlockRow(tableName)
+
+	entry, found := buffer.Get(tableName)
 	if !found {
 		if l.tracer.Enabled() {
 			l.logger.Debug("adding tracking of table never seen before", zap.String("table_name", tableName))
 		}
 
 		entry = NewOrderedMap[string, *Operation]()
-		l.entries.Set(tableName, entry)
+		buffer.Set(tableName, entry)
 	}
 
-	if _, found := entry.Get(uniqueID); !found {
+	scheduled, found := entry.Get(uniqueID)
+	if !found {
 		if l.tracer.Enabled() {
-			l.logger.Debug("primary key entry never existed for table", zap.String("primary_key", uniqueID), zap.String("table_name", tableName))
+			l.logger.Debug("primary key entry never existed for table, adding operation", zap.String("primary_key", uniqueID), zap.String("table_name", tableName), zap.String("op_type", string(op.opType)))
 		}
 
+		entry.Set(uniqueID, op)
 		l.entriesCount++
+		return nil
+	}
+
+	folded, err := scheduled.fold(op)
+	if err != nil {
+		return fmt.Errorf("folding %s operation of primary key %q in table %q: %w", strings.ToLower(string(op.opType)), uniqueID, tableName, err)
+	}
+
+	if folded == nil {
+		if l.tracer.Enabled() {
+			l.logger.Debug("primary key entry operations cancel out, removing it", zap.String("primary_key", uniqueID), zap.String("table_name", tableName))
+		}
+
+		entry.Delete(uniqueID)
+		l.entriesCount--
+		return nil
 	}
 
 	if l.tracer.Enabled() {
-		l.logger.Debug("adding deleting operation", zap.String("primary_key", uniqueID), zap.String("table_name", tableName))
+		l.logger.Debug("primary key entry already exist for table, folding operations together", zap.String("primary_key", uniqueID), zap.String("table_name", tableName), zap.String("op_type", string(folded.opType)))
 	}
 
-	entry.Set(uniqueID, l.newDeleteOperation(table, primaryKey, reversibleBlockNum))
+	entry.Set(uniqueID, folded)
 	return nil
 }
@@ -1,8 +1,10 @@
 package db
 
 import (
+	"context"
 	"testing"
 
+	sink "github.com/streamingfast/substreams-sink"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -11,6 +13,8 @@ func TestGetPrimaryKey(t *testing.T) {
 	tests := []struct {
 		name        string
 		in          []*ColumnInfo
+		separator   string
+		pk          string
 		expectOut   map[string]string
 		expectError bool
 	}{
@@ -41,6 +45,50 @@ func TestGetPrimaryKey(t *testing.T) {
 				"id": "testval",
 			},
 		},
+		{
+			name: "more than one primkey with separator ok",
+			in: []*ColumnInfo{
+				{
+					name: "one",
+				},
+				{
+					name: "two",
+				},
+			},
+			separator: ":",
+			pk:        "a:b",
+			expectOut: map[string]string{
+				"one": "a",
+				"two": "b",
+			},
+		},
+		{
+			name: "more than one primkey with separator parts mismatch error",
+			in: []*ColumnInfo{
+				{
+					name: "one",
+				},
+				{
+					name: "two",
+				},
+			},
+			separator:   "/",
+			pk:          "a/b/c",
+			expectError: true,
+		},
+		{
+			name: "single primkey with separator not split",
+			in: []*ColumnInfo{
+				{
+					name: "id",
+				},
+			},
+			separator: "/",
+			pk:        "a/b",
+			expectOut: map[string]string{
+				"id": "a/b",
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -50,8 +98,15 @@ func TestGetPrimaryKey(t *testing.T) {
 						primaryColumns: test.in,
 					},
 				},
+				primaryKeySeparator: test.separator,
+			}
+
+			pk := test.pk
+			if pk == "" {
+				pk = "testval"
 			}
-			out, err := l.GetPrimaryKey("test", "testval")
+
+			out, err := l.GetPrimaryKey("test", pk)
 			if test.expectError {
 				assert.Error(t, err)
 			} else {
@@ -63,3 +118,224 @@ func TestGetPrimaryKey(t *testing.T) {
 	}
 
 }
+
+func TestUpsertMerge(t *testing.T) {
+	tests := []struct {
+		name       string
+		apply      func(l *Loader) error
+		expectType OperationType
+		expectData map[string]string
+		expectErr  bool
+	}{
+		{
+			name: "upsert alone",
+			apply: func(l *Loader) error {
+				return l.Upsert("xfer", map[string]string{"id": "1"}, map[string]string{"from": "a"}, nil)
+			},
+			expectType: OperationTypeUpsert,
+			expectData: map[string]string{"id": "1", "from": "a"},
+		},
+		{
+			name: "insert then upsert",
+			apply: func(l *Loader) error {
+				require.NoError(t, l.Insert("xfer", map[string]string{"id": "1"}, map[string]string{"from": "a", "to": "b"}, nil))
+				return l.Upsert("xfer", map[string]string{"id": "1"}, map[string]string{"from": "c"}, nil)
+			},
+			expectType: OperationTypeInsert,
+			expectData: map[string]string{"id": "1", "from": "c", "to": "b"},
+		},
+		{
+			name: "update then upsert",
+			apply: func(l *Loader) error {
+				require.NoError(t, l.Update("xfer", map[string]string{"id": "1"}, map[string]string{"to": "b"}, nil))
+				return l.Upsert("xfer", map[string]string{"id": "1"}, map[string]string{"from": "a"}, nil)
+			},
+			expectType: OperationTypeUpsert,
+			expectData: map[string]string{"id": "1", "from": "a", "to": "b"},
+		},
+		{
+			name: "upsert then update",
+			apply: func(l *Loader) error {
+				require.NoError(t, l.Upsert("xfer", map[string]string{"id": "1"}, map[string]string{"from": "a"}, nil))
+				return l.Update("xfer", map[string]string{"id": "1"}, map[string]string{"to": "b"}, nil)
+			},
+			expectType: OperationTypeUpsert,
+			expectData: map[string]string{"id": "1", "from": "a", "to": "b"},
+		},
+		{
+			name: "upsert then delete",
+			apply: func(l *Loader) error {
+				require.NoError(t, l.Upsert("xfer", map[string]string{"id": "1"}, map[string]string{"from": "a"}, nil))
+				return l.Delete("xfer", map[string]string{"id": "1"}, nil)
+			},
+			expectType: OperationTypeDelete,
+		},
+		{
+			name: "delete then upsert",
+			apply: func(l *Loader) error {
+				require.NoError(t, l.Delete("xfer", map[string]string{"id": "1"}, nil))
+				return l.Upsert("xfer", map[string]string{"id": "1"}, map[string]string{"from": "a"}, nil)
+			},
+			expectType: OperationTypeReplace,
+			expectData: map[string]string{"id": "1", "from": "a"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, _ := NewTestLoader(zlog, tracer, "testschema", TestTables("testschema"))
+
+			err := test.apply(l)
+			if test.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			entries, found := l.entries.Get("xfer")
+			require.True(t, found)
+			require.Equal(t, 1, entries.Len())
+
+			op := entries.Oldest().Value
+			assert.Equal(t, test.expectType, op.opType)
+			assert.Equal(t, test.expectData, op.data)
+			assert.Equal(t, uint64(1), l.entriesCount)
+		})
+	}
+}
+
+func TestDeleteCompositePrimaryKey(t *testing.T) {
+	tables := TestTables("testschema")
+	tables["balance"] = mustNewTableInfo("testschema", "balance", []string{"account", "token"}, map[string]*ColumnInfo{
+		"account": NewColumnInfo("account", "text", ""),
+		"token":   NewColumnInfo("token", "text", ""),
+		"value":   NewColumnInfo("value", "text", ""),
+	})
+
+	l, tx := NewTestLoader(zlog, tracer, "testschema", tables)
+	require.NoError(t, l.Delete("balance", map[string]string{"account": "a", "token": "t"}, nil))
+	require.NoError(t, l.Delete("balance", map[string]string{"account": "b", "token": "t"}, nil))
+
+	_, err := l.Flush(context.Background(), "abc", sink.NewBlankCursor(), 10)
+	require.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "testschema"."balance" WHERE ("account","token") IN (($1,$2),($3,$4)); [a t b t]`, tx.Results()[0])
+}
+
+func TestFoldOperations(t *testing.T) {
+	type op struct {
+		opType OperationType
+		data   map[string]string
+		block  uint64
+	}
+
+	tests := []struct {
+		name        string
+		ops         []op
+		expectType  OperationType
+		expectData  map[string]string
+		expectBlock uint64
+		expectNone  bool
+	}{
+		{
+			name:       "insert then insert",
+			ops:        []op{{OperationTypeInsert, map[string]string{"from": "a", "to": "b"}, 10}, {OperationTypeInsert, map[string]string{"from": "c"}, 11}},
+			expectType: OperationTypeInsert, expectData: map[string]string{"id": "1", "from": "c", "to": "b"}, expectBlock: 10,
+		},
+		{
+			name:       "insert then update",
+			ops:        []op{{OperationTypeInsert, map[string]string{"from": "a"}, 10}, {OperationTypeUpdate, map[string]string{"to": "b"}, 11}},
+			expectType: OperationTypeInsert, expectData: map[string]string{"id": "1", "from": "a", "to": "b"}, expectBlock: 10,
+		},
+		{
+			name:       "insert then delete",
+			ops:        []op{{OperationTypeInsert, map[string]string{"from": "a"}, 10}, {OperationTypeDelete, nil, 10}},
+			expectNone: true,
+		},
+		{
+			name:       "update then insert",
+			ops:        []op{{OperationTypeUpdate, map[string]string{"to": "b"}, 10}, {OperationTypeInsert, map[string]string{"from": "a"}, 11}},
+			expectType: OperationTypeUpsert, expectData: map[string]string{"id": "1", "from": "a", "to": "b"}, expectBlock: 10,
+		},
+		{
+			name:       "update then update",
+			ops:        []op{{OperationTypeUpdate, map[string]string{"to": "b"}, 10}, {OperationTypeUpdate, map[string]string{"to": "c"}, 11}},
+			expectType: OperationTypeUpdate, expectData: map[string]string{"to": "c"}, expectBlock: 10,
+		},
+		{
+			name:       "update then delete",
+			ops:        []op{{OperationTypeUpdate, map[string]string{"to": "b"}, 10}, {OperationTypeDelete, nil, 11}},
+			expectType: OperationTypeDelete, expectBlock: 10,
+		},
+		{
+			name:       "delete then insert",
+			ops:        []op{{OperationTypeDelete, nil, 10}, {OperationTypeInsert, map[string]string{"from": "a"}, 11}},
+			expectType: OperationTypeReplace, expectData: map[string]string{"id": "1", "from": "a"}, expectBlock: 10,
+		},
+		{
+			name:       "delete then update",
+			ops:        []op{{OperationTypeDelete, nil, 10}, {OperationTypeUpdate, map[string]string{"to": "b"}, 11}},
+			expectType: OperationTypeDelete, expectBlock: 10,
+		},
+		{
+			name:       "delete then delete",
+			ops:        []op{{OperationTypeDelete, nil, 10}, {OperationTypeDelete, nil, 11}},
+			expectType: OperationTypeDelete, expectBlock: 10,
+		},
+		{
+			name:       "delete then insert then update",
+			ops:        []op{{OperationTypeDelete, nil, 10}, {OperationTypeInsert, map[string]string{"from": "a"}, 10}, {OperationTypeUpdate, map[string]string{"to": "b"}, 11}},
+			expectType: OperationTypeReplace, expectData: map[string]string{"id": "1", "from": "a", "to": "b"}, expectBlock: 10,
+		},
+		{
+			name:       "delete then insert then delete",
+			ops:        []op{{OperationTypeDelete, nil, 10}, {OperationTypeInsert, map[string]string{"from": "a"}, 10}, {OperationTypeDelete, nil, 11}},
+			expectType: OperationTypeDelete, expectBlock: 10,
+		},
+		{
+			name:       "insert then delete then insert",
+			ops:        []op{{OperationTypeInsert, map[string]string{"from": "a"}, 10}, {OperationTypeDelete, nil, 10}, {OperationTypeInsert, map[string]string{"to": "b"}, 11}},
+			expectType: OperationTypeInsert, expectData: map[string]string{"id": "1", "to": "b"}, expectBlock: 11,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, _ := NewTestLoader(zlog, tracer, "testschema", TestTables("testschema"))
+
+			primaryKey := map[string]string{"id": "1"}
+			for _, o := range test.ops {
+				block := o.block
+
+				var err error
+				switch o.opType {
+				case OperationTypeInsert:
+					err = l.Insert("xfer", primaryKey, o.data, &block)
+				case OperationTypeUpdate:
+					err = l.Update("xfer", primaryKey, o.data, &block)
+				case OperationTypeDelete:
+					err = l.Delete("xfer", primaryKey, &block)
+				}
+				require.NoError(t, err)
+			}
+
+			entries, found := l.entries.Get("xfer")
+			require.True(t, found)
+
+			if test.expectNone {
+				assert.Equal(t, 0, entries.Len())
+				assert.Equal(t, uint64(0), l.entriesCount)
+				return
+			}
+
+			require.Equal(t, 1, entries.Len())
+			assert.Equal(t, uint64(1), l.entriesCount)
+
+			folded := entries.Oldest().Value
+			assert.Equal(t, test.expectType, folded.opType)
+			if test.expectData != nil {
+				assert.Equal(t, test.expectData, folded.data)
+			}
+			assert.Equal(t, test.expectBlock, *folded.reversibleBlockNum)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+)
+
+// OpenPgxDB opens the connection pool of the DSN through the pgx driver, the native pgx
+// connections needed by `COPY` are acquired from that same pool, see CopyFromCSV.
+func OpenPgxDB(dsn *DSN) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn.ConnString())
+	if err != nil {
+		return nil, fmt.Errorf("open db connection: %w", err)
+	}
+
+	return db, nil
+}
+
+// CopyFromCSV streams the CSV content of `reader`, which must start with a header line, into
+// the table using `COPY ... FROM STDIN` and returns the amount of rows copied.
+func CopyFromCSV(ctx context.Context, db *sql.DB, schema string, table string, columns []string, reader io.Reader) (int64, error) {
+	query := fmt.Sprintf(`COPY %s.%s ("%s") FROM STDIN WITH (FORMAT CSV, HEADER)`,
+		EscapeIdentifier(schema),
+		EscapeIdentifier(table),
+		strings.Join(columns, `","`),
+	)
+
+	conn, err := stdlib.AcquireConn(db)
+	if err != nil {
+		return 0, fmt.Errorf("acquiring pgx connection: %w", err)
+	}
+	defer stdlib.ReleaseConn(db, conn)
+
+	tag, err := conn.PgConn().CopyFrom(ctx, reader, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed COPY FROM for %q: %w", table, err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// pgxTx adapts a native pgx transaction to the `Tx` interface used by the loader, its connection
+// is acquired from the `database/sql` pool and released to it once the transaction ends.
+type pgxTx struct {
+	db   *sql.DB
+	conn *pgx.Conn
+	tx   pgx.Tx
+}
+
+func (t *pgxTx) Rollback() error {
+	return t.release(t.tx.Rollback(context.Background()))
+}
+
+func (t *pgxTx) Commit() error {
+	return t.release(t.tx.Commit(context.Background()))
+}
+
+// release gives the connection back to the pool, once, and returns the error of the transaction's
+// end if any, otherwise the one of the release.
+func (t *pgxTx) release(err error) error {
+	if t.conn == nil {
+		return err
+	}
+
+	releaseErr := stdlib.ReleaseConn(t.db, t.conn)
+	t.conn = nil
+	if err != nil {
+		return err
+	}
+
+	return releaseErr
+}
+
+func (t *pgxTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	tag, err := t.tx.Exec(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgxResult{tag}, nil
+}
+
+func (t *pgxTx) QueryContext(ctx context.Context, query string, args ...any) (Rows, error) {
+	rows, err := t.tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgxRows{rows}, nil
+}
+
+// pgxRows adapts native pgx rows to the `Rows` interface
+type pgxRows struct {
+	pgx.Rows
+}
+
+func (r pgxRows) Close() error {
+	r.Rows.Close()
+	return nil
+}
+
+type pgxResult struct {
+	tag pgconn.CommandTag
+}
+
+func (r pgxResult) LastInsertId() (int64, error) {
+	return 0, fmt.Errorf("LastInsertId is not supported by pgx")
+}
+
+func (r pgxResult) RowsAffected() (int64, error) {
+	return r.tag.RowsAffected(), nil
+}
+
+func pgxTxOptions(opts *sql.TxOptions) pgx.TxOptions {
+	if opts == nil {
+		return pgx.TxOptions{}
+	}
+
+	out := pgx.TxOptions{}
+	if opts.ReadOnly {
+		out.AccessMode = pgx.ReadOnly
+	}
+
+	switch opts.Isolation {
+	case sql.LevelReadUncommitted:
+		out.IsoLevel = pgx.ReadUncommitted
+	case sql.LevelReadCommitted:
+		out.IsoLevel = pgx.ReadCommitted
+	case sql.LevelRepeatableRead:
+		out.IsoLevel = pgx.RepeatableRead
+	case sql.LevelSerializable:
+		out.IsoLevel = pgx.Serializable
+	}
+
+	return out
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// postgresValueConverters are the built-in value converters of Postgres, values are validated and
+// sent in their textual representation which Postgres parses using the column's type.
+var postgresValueConverters = ValueConverters{
+	"numeric": convertPostgresNumeric,
+	"decimal": convertPostgresNumeric,
+	"uuid":    convertPostgresUUID,
+	"json":    convertPostgresJSON,
+	"jsonb":   convertPostgresJSON,
+	"inet":    convertPostgresInet,
+	"cidr":    convertPostgresInet,
+	"array":   convertPostgresArray,
+}
+
+func (d postgresDialect) builtinValueConverters(convertTimestamp ValueConverter) ValueConverters {
+	return postgresValueConverters
+}
+
+// EnumLabels returns the labels of the enum columns of the schema's tables in their sort order
+func (d postgresDialect) EnumLabels(db *sql.DB, schema string) (map[string]map[string][]string, error) {
+	query := `SELECT c.relname,a.attname,e.enumlabel FROM pg_catalog.pg_attribute a
+		JOIN pg_catalog.pg_class c ON c.oid = a.attrelid
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_catalog.pg_enum e ON e.enumtypid = a.atttypid
+		WHERE n.nspname = $1 AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY c.relname,a.attname,e.enumsortorder`
+
+	rows, err := db.Query(query, schema)
+	if err != nil {
+		return nil, fmt.Errorf("executing query %q: %w", query, err)
+	}
+	defer rows.Close()
+
+	out := map[string]map[string][]string{}
+	for rows.Next() {
+		var table, column, label string
+		if err := rows.Scan(&table, &column, &label); err != nil {
+			return nil, fmt.Errorf("scanning enum label: %w", err)
+		}
+
+		if out[table] == nil {
+			out[table] = map[string][]string{}
+		}
+		out[table][column] = append(out[table][column], label)
+	}
+
+	return out, rows.Err()
+}
+
+var postgresNumericRegex = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)([eE][+-]?\d+)?$`)
+
+func convertPostgresNumeric(typeName string, value string) (any, error) {
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(value) {
+	case "nan", "infinity", "+infinity", "-infinity":
+		return value, nil
+	}
+
+	if !postgresNumericRegex.MatchString(value) {
+		return nil, fmt.Errorf("invalid %s value %q, expected a decimal number", typeName, value)
+	}
+
+	return value, nil
+}
+
+func convertPostgresUUID(typeName string, value string) (any, error) {
+	value = strings.TrimSpace(value)
+
+	digits := strings.ReplaceAll(strings.TrimSuffix(strings.TrimPrefix(value, "{"), "}"), "-", "")
+	if len(digits) != 32 || strings.Trim(digits, "0123456789abcdefABCDEF") != "" {
+		return nil, fmt.Errorf("invalid %s value %q, expected 32 hexadecimal digits", typeName, value)
+	}
+
+	return value, nil
+}
+
+// postgresJSON is a JSON document, bound as text and embedded as is in the JSON document of
+// `json_populate_recordset` instead of being turned into a JSON string.
+type postgresJSON string
+
+func (j postgresJSON) Value() (driver.Value, error) {
+	return string(j), nil
+}
+
+func (j postgresJSON) MarshalJSON() ([]byte, error) {
+	return []byte(j), nil
+}
+
+func convertPostgresJSON(typeName string, value string) (any, error) {
+	if !json.Valid([]byte(value)) {
+		return nil, fmt.Errorf("invalid %s value %q, expected a JSON document", typeName, value)
+	}
+
+	return postgresJSON(value), nil
+}
+
+func convertPostgresInet(typeName string, value string) (any, error) {
+	value = strings.TrimSpace(value)
+	if net.ParseIP(value) == nil {
+		if _, _, err := net.ParseCIDR(value); err != nil {
+			return nil, fmt.Errorf("invalid %s value %q, expected an IP address or network", typeName, value)
+		}
+	}
+
+	return value, nil
+}
+
+// convertPostgresArray accepts a JSON array, turned into a Postgres array literal, or a Postgres
+// array literal which is sent as is.
+func convertPostgresArray(typeName string, value string) (any, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "{") {
+		return value, nil
+	}
+
+	var elements []any
+	if !strings.HasPrefix(value, "[") || decodeJSON(value, &elements) != nil {
+		if strings.HasPrefix(value, "[") && strings.Contains(value, "={") {
+			// Array literal with explicit bounds, like `[0:1]={1,2}`
+			return value, nil
+		}
+
+		return nil, fmt.Errorf("invalid %s value %q, expected a JSON array or a Postgres array literal", typeName, value)
+	}
+
+	var out strings.Builder
+	if err := writePostgresArray(&out, elements); err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %w", typeName, value, err)
+	}
+
+	return out.String(), nil
+}
+
+var postgresArrayElementEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+func writePostgresArray(out *strings.Builder, elements []any) error {
+	out.WriteByte('{')
+	for i, element := range elements {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+
+		switch v := element.(type) {
+		case nil:
+			out.WriteString("NULL")
+		case []any:
+			if err := writePostgresArray(out, v); err != nil {
+				return err
+			}
+		case string:
+			out.WriteString(`"` + postgresArrayElementEscaper.Replace(v) + `"`)
+		case map[string]any:
+			document, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("marshalling element %d: %w", i, err)
+			}
+			out.WriteString(`"` + postgresArrayElementEscaper.Replace(string(document)) + `"`)
+		default:
+			// json.Number or bool whose textual representation is the one of Postgres
+			out.WriteString(fmt.Sprint(v))
+		}
+	}
+	out.WriteByte('}')
+
+	return nil
+}
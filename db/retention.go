@@ -0,0 +1,280 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// retentionBatchSize is the maximum amount of expired rows deleted by a single transaction, so
+// that pruning a large backlog of expired rows never holds a transaction for long.
+const retentionBatchSize = 10000
+
+// retentionMaxBatches is the maximum amount of batches deleted from a table after a flush, a
+// larger backlog of expired rows being pruned over the following flushes so that the next flush
+// is not held back by it.
+const retentionMaxBatches = 10
+
+// retentionMaxFailures is the amount of consecutive failed prunings of a table after which the
+// failure is returned by the flush instead of only being logged.
+const retentionMaxFailures = 5
+
+// RetentionWindow is how long the rows of a table are kept, either for a number of blocks against
+// a column holding the block number of the rows or for a duration against a column holding their
+// timestamp.
+type RetentionWindow struct {
+	Table  string
+	Column string
+
+	// Blocks is the amount of blocks the rows are kept for, zero when Duration is set
+	Blocks uint64
+
+	// Duration is the time the rows are kept for, compared to the timestamp of the last block
+	// flushed, zero when Blocks is set.
+	Duration time.Duration
+}
+
+// retentionDialect is implemented by the dialects which can delete the expired rows of a table
+type retentionDialect interface {
+	// pruneStatement returns the statement deleting at most limit rows of the table whose column
+	// is lower than cutoff.
+	pruneStatement(table *TableInfo, column *ColumnInfo, cutoff any, limit int) *statement
+}
+
+// retentionTTLDialect is implemented by the dialects expiring rows by themselves, the retention
+// being defined on the table at setup.
+type retentionTTLDialect interface {
+	retentionTTLQuery(table *TableInfo, column *ColumnInfo, window *RetentionWindow) (string, error)
+}
+
+// ParseRetentionWindows parses retention windows of the form `<table>.<column>=<window>` where
+// `<window>` is either a block count, like `100000`, or a duration, like `12h` or `30d`.
+func ParseRetentionWindows(in []string) ([]*RetentionWindow, error) {
+	out := make([]*RetentionWindow, 0, len(in))
+	for _, element := range in {
+		column, window, found := strings.Cut(element, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid retention window %q, expected <table>.<column>=<window>", element)
+		}
+
+		table, column, found := strings.Cut(strings.TrimSpace(column), ".")
+		if !found || table == "" || column == "" {
+			return nil, fmt.Errorf("invalid retention window %q, expected <table>.<column>=<window>", element)
+		}
+
+		retention := &RetentionWindow{Table: table, Column: column}
+		window = strings.TrimSpace(window)
+		if blocks, err := strconv.ParseUint(window, 10, 64); err == nil {
+			retention.Blocks = blocks
+		} else {
+			duration, err := parseRetentionDuration(window)
+			if err != nil {
+				return nil, fmt.Errorf("invalid retention window %q, expected a block count or a duration: %w", element, err)
+			}
+			retention.Duration = duration
+		}
+
+		if retention.Blocks == 0 && retention.Duration <= 0 {
+			return nil, fmt.Errorf("invalid retention window %q, it must be greater than zero", element)
+		}
+
+		out = append(out, retention)
+	}
+
+	return out, nil
+}
+
+// parseRetentionDuration parses a duration as `time.ParseDuration` does, with support for a
+// number of days like `30d`.
+func parseRetentionDuration(in string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(in, "d"); found {
+		count, err := strconv.ParseUint(days, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days %q", days)
+		}
+
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(in)
+}
+
+// SetRetentionWindows configures the retention windows of the tables, the tables must have been
+// loaded first. After a flush, once every interval, the expired rows of those tables are deleted
+// in batches of bounded size, each in its own transaction apart from the flush one.
+func (l *Loader) SetRetentionWindows(windows []*RetentionWindow, interval time.Duration) error {
+	if _, ok := l.getDialect().(retentionDialect); len(windows) > 0 && !ok {
+		if _, ok := l.getDialect().(retentionTTLDialect); ok {
+			return fmt.Errorf("row retention is handled by the database's TTL, set it up with 'setup --retention'")
+		}
+
+		return fmt.Errorf("row retention is not supported by the current database")
+	}
+
+	retentionWindows := make(map[string]*RetentionWindow, len(windows))
+	for _, window := range windows {
+		if err := l.validateRetentionWindow(window); err != nil {
+			return err
+		}
+
+		retentionWindows[window.Table] = window
+	}
+
+	l.retentionWindows = retentionWindows
+	l.retentionInterval = interval
+	l.retentionFailures = make(map[string]int, len(windows))
+	return nil
+}
+
+func (l *Loader) validateRetentionWindow(window *RetentionWindow) error {
+	table, found := l.tables[window.Table]
+	if !found {
+		return fmt.Errorf("unknown table %q", window.Table)
+	}
+
+	if table.name == CURSORS_TABLE || table.name == HISTORY_TABLE {
+		return fmt.Errorf("system table %q cannot have a retention window", window.Table)
+	}
+
+	if _, found := table.columnsByName[window.Column]; !found {
+		return fmt.Errorf("unknown column %q in table %q", window.Column, window.Table)
+	}
+
+	return nil
+}
+
+// SetupRetention defines the retention windows on the tables of the dialects expiring rows by
+// themselves, like ClickHouse's TTL, it does nothing for the other dialects which delete the
+// expired rows after flushes. The tables must have been loaded first.
+func (l *Loader) SetupRetention(ctx context.Context, windows []*RetentionWindow) error {
+	ttl, ok := l.getDialect().(retentionTTLDialect)
+	for _, window := range windows {
+		if err := l.validateRetentionWindow(window); err != nil {
+			return err
+		}
+
+		if !ok {
+			continue
+		}
+
+		table := l.tables[window.Table]
+		query, err := ttl.retentionTTLQuery(table, table.columnsByName[window.Column], window)
+		if err != nil {
+			return fmt.Errorf("retention window of table %q: %w", window.Table, err)
+		}
+
+		if _, err := l.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("executing query %q: %w", query, err)
+		}
+	}
+
+	return nil
+}
+
+// pruneExpiredRows deletes the expired rows of the tables having a retention window, relative to
+// the last block flushed. Tables with more expired rows than `retentionMaxBatches` batches are
+// pruned again after the next flush without waiting for the interval. Failures are logged and
+// pruning is attempted again after the next flush, an error being returned once a table failed
+// `retentionMaxFailures` times in a row.
+func (l *Loader) pruneExpiredRows(ctx context.Context) error {
+	if len(l.retentionWindows) == 0 || l.currentBlock == nil {
+		return nil
+	}
+
+	if !l.lastRetentionAt.IsZero() && time.Since(l.lastRetentionAt) < l.retentionInterval {
+		return nil
+	}
+	l.lastRetentionAt = time.Now()
+
+	tableNames := make([]string, 0, len(l.retentionWindows))
+	for tableName := range l.retentionWindows {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+
+	for _, tableName := range tableNames {
+		window := l.retentionWindows[tableName]
+		cutoff, found := window.cutoff(l.tables[tableName].columnsByName[window.Column], l.currentBlock)
+		if !found {
+			continue
+		}
+
+		startAt := time.Now()
+		rowCount, done, err := l.pruneTable(ctx, l.tables[tableName], window, cutoff)
+		if err != nil {
+			l.retentionFailures[tableName]++
+			if failures := l.retentionFailures[tableName]; failures >= retentionMaxFailures {
+				return fmt.Errorf("pruning expired rows of table %q failed %d times in a row: %w", tableName, failures, err)
+			}
+
+			l.logger.Warn("failed to prune expired rows", zap.String("table_name", tableName), zap.Int64("row_count", rowCount), zap.Error(err))
+			continue
+		}
+		delete(l.retentionFailures, tableName)
+
+		if !done {
+			// The remaining expired rows are pruned after the next flush
+			l.lastRetentionAt = time.Time{}
+		}
+
+		if rowCount > 0 {
+			l.logger.Info("pruned expired rows", zap.String("table_name", tableName), zap.Int64("row_count", rowCount), zap.Bool("done", done), zap.Duration("took", time.Since(startAt)))
+		}
+	}
+
+	return nil
+}
+
+// pruneTable deletes the rows of the table whose column is lower than the cutoff, one batch of at
+// most `retentionBatchSize` rows per transaction until a batch is not full, returning whether all
+// the expired rows were deleted before reaching `retentionMaxBatches` batches.
+func (l *Loader) pruneTable(ctx context.Context, table *TableInfo, window *RetentionWindow, cutoff any) (rowCount int64, done bool, err error) {
+	stmt := l.getDialect().(retentionDialect).pruneStatement(table, table.columnsByName[window.Column], cutoff, retentionBatchSize)
+
+	for batch := 0; batch < retentionMaxBatches; batch++ {
+		var batchCount int64
+		err := l.inTx(ctx, func(tx Tx) error {
+			result, err := tx.ExecContext(ctx, stmt.query, stmt.args...)
+			if err != nil {
+				return fmt.Errorf("executing query %q: %w", stmt.query, err)
+			}
+
+			batchCount, err = result.RowsAffected()
+			return err
+		})
+		if err != nil {
+			return rowCount, false, err
+		}
+
+		rowCount += batchCount
+		if batchCount < retentionBatchSize {
+			return rowCount, true, nil
+		}
+	}
+
+	return rowCount, false, nil
+}
+
+// cutoff returns the value of the column below which the rows are expired at the block, false if
+// the rows cannot be expired yet.
+func (w *RetentionWindow) cutoff(column *ColumnInfo, block *BlockMetadata) (any, bool) {
+	if w.Blocks > 0 {
+		if block.Number < w.Blocks {
+			return nil, false
+		}
+
+		// The rows of the last `Blocks` blocks, the current one included, are kept
+		return block.Number - w.Blocks + 1, true
+	}
+
+	if block.Timestamp.IsZero() {
+		return nil, false
+	}
+
+	return blockTimestampValue(column, block.Timestamp.Add(-w.Duration)), true
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	sink "github.com/streamingfast/substreams-sink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetentionWindows(t *testing.T) {
+	windows, err := ParseRetentionWindows([]string{"trades.block_num=100000", "events.timestamp=12h", "logs.at = 30d"})
+	require.NoError(t, err)
+	assert.Equal(t, []*RetentionWindow{
+		{Table: "trades", Column: "block_num", Blocks: 100000},
+		{Table: "events", Column: "timestamp", Duration: 12 * time.Hour},
+		{Table: "logs", Column: "at", Duration: 30 * 24 * time.Hour},
+	}, windows)
+
+	_, err = ParseRetentionWindows([]string{"trades=100"})
+	assert.ErrorContains(t, err, `invalid retention window "trades=100", expected <table>.<column>=<window>`)
+
+	_, err = ParseRetentionWindows([]string{"trades.block_num=soon"})
+	assert.ErrorContains(t, err, `invalid retention window "trades.block_num=soon", expected a block count or a duration`)
+
+	_, err = ParseRetentionWindows([]string{"trades.block_num=0"})
+	assert.ErrorContains(t, err, `invalid retention window "trades.block_num=0", it must be greater than zero`)
+}
+
+func TestPruneExpiredRows(t *testing.T) {
+	ctx := context.Background()
+	l, tx := NewTestLoader(zlog, tracer, "testschema", featureTestTables("testschema"))
+	require.NoError(t, l.SetRetentionWindows([]*RetentionWindow{
+		{Table: "trades", Column: "block_num", Blocks: 100},
+		{Table: "events", Column: "ts", Duration: time.Hour},
+	}, time.Minute))
+
+	l.SetCurrentBlock(&BlockMetadata{Number: 1000, ID: "a", Timestamp: time.Unix(1700000000, 0)})
+	_, err := l.Flush(ctx, "abc", sink.NewBlankCursor(), 1000)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		`DELETE FROM "testschema"."events" WHERE ctid = ANY(ARRAY(SELECT ctid FROM "testschema"."events" WHERE "ts" < $1 LIMIT 10000)); [2023-11-14T21:13:20Z]`,
+		`DELETE FROM "testschema"."trades" WHERE ctid = ANY(ARRAY(SELECT ctid FROM "testschema"."trades" WHERE "block_num" < $1 LIMIT 10000)); [901]`,
+	}, tx.ResultsContaining("ctid"))
+
+	tx.queries = nil
+	_, err = l.Flush(ctx, "abc", sink.NewBlankCursor(), 1001)
+	require.NoError(t, err)
+	assert.Empty(t, tx.ResultsContaining("ctid"), "expired rows are pruned once per interval")
+}
+
+func TestClickhouseRetentionTTL(t *testing.T) {
+	table := mustNewTableInfo("testschema", "events", []string{"id"}, map[string]*ColumnInfo{
+		"id":      NewColumnInfo("id", "String", ""),
+		"ts":      NewColumnInfo("ts", "DateTime", time.Time{}),
+		"seconds": NewColumnInfo("seconds", "UInt32", uint32(0)),
+	})
+
+	query, err := clickhouseDialect{}.retentionTTLQuery(table, table.columnsByName["ts"], &RetentionWindow{Table: "events", Column: "ts", Duration: 7 * 24 * time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, `ALTER TABLE "testschema"."events" MODIFY TTL "ts" + INTERVAL 604800 SECOND`, query)
+
+	query, err = clickhouseDialect{}.retentionTTLQuery(table, table.columnsByName["seconds"], &RetentionWindow{Table: "events", Column: "seconds", Duration: time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, `ALTER TABLE "testschema"."events" MODIFY TTL toDateTime("seconds") + INTERVAL 3600 SECOND`, query)
+
+	_, err = clickhouseDialect{}.retentionTTLQuery(table, table.columnsByName["seconds"], &RetentionWindow{Table: "events", Column: "seconds", Blocks: 100})
+	assert.ErrorContains(t, err, "retention windows in blocks are not supported by ClickHouse")
+}
@@ -0,0 +1,426 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// rollupBatchRows is the maximum amount of source rows or groups a single rollup statement holds
+const rollupBatchRows = 1000
+
+// RollupIntervals are the time buckets a rollup can aggregate its source rows in
+var RollupIntervals = []string{"minute", "hour", "day", "week", "month"}
+
+// RollupFuncs are the aggregate functions of the measures of a rollup
+var RollupFuncs = []string{"sum", "count", "min", "max"}
+
+// Rollup is a table aggregating the rows of a source table per time bucket and group, maintained
+// by the loader in the transaction writing the source rows. The primary key of the rollup table
+// is made of the bucket column followed by the group by columns, all named as in the source table.
+type Rollup struct {
+	// Table is the rollup table
+	Table string `yaml:"table"`
+
+	// Source is the table whose rows are aggregated
+	Source string `yaml:"source"`
+
+	Bucket RollupBucket `yaml:"bucket"`
+
+	// GroupBy are the columns of the source table grouping its rows within a bucket
+	GroupBy []string `yaml:"group_by"`
+
+	Measures []*RollupMeasure `yaml:"measures"`
+}
+
+// RollupBucket is the time bucket the source rows are aggregated in, computed from a timestamp
+// column of the source table.
+type RollupBucket struct {
+	Column string `yaml:"column"`
+
+	// Interval is the size of the buckets, one of RollupIntervals
+	Interval string `yaml:"interval"`
+}
+
+// RollupMeasure is a column of the rollup table aggregating a column of the source rows
+type RollupMeasure struct {
+	// Column is the column of the rollup table
+	Column string `yaml:"column"`
+
+	// Func is the aggregate function, one of RollupFuncs
+	Func string `yaml:"func"`
+
+	// Source is the aggregated column of the source table, optional for `count` which then
+	// counts the rows.
+	Source string `yaml:"source"`
+}
+
+// rollupDialect is implemented by the dialects supporting rollups
+type rollupDialect interface {
+	// rollupDetachStatement returns the statement deleting the rollup rows of the groups the
+	// source rows belong to, returning the key of each group deleted as text.
+	rollupDetachStatement(r *rollup, primaryKeys []map[string]string) *statement
+
+	// rollupAddStatement returns the statement adding the source rows to the rollup rows of their
+	// group, the source rows must not be part of them yet.
+	rollupAddStatement(r *rollup, primaryKeys []map[string]string) *statement
+
+	// rollupRecomputeStatement returns the statement computing the rollup rows of the groups again
+	// from all the rows of the source table.
+	rollupRecomputeStatement(r *rollup, groups [][]string) *statement
+}
+
+// rollup is a rollup whose tables are resolved
+type rollup struct {
+	*Rollup
+
+	source *TableInfo
+	table  *TableInfo
+}
+
+// keyColumns returns the columns of the rollup table identifying a group, the bucket first
+func (r *rollup) keyColumns() []string {
+	return append([]string{r.Bucket.Column}, r.GroupBy...)
+}
+
+// ParseRollups parses the rollups declared in YAML under a `rollups` key, ex:
+//
+//	rollups:
+//	  - table: trades_hourly
+//	    source: trades
+//	    bucket: { column: timestamp, interval: hour }
+//	    group_by: [pair]
+//	    measures:
+//	      - { column: volume, func: sum, source: amount }
+//	      - { column: trade_count, func: count }
+func ParseRollups(content []byte) ([]*Rollup, error) {
+	var config struct {
+		Rollups []*Rollup `yaml:"rollups"`
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("decoding rollups: %w", err)
+	}
+
+	for i, r := range config.Rollups {
+		if r.Table == "" || r.Source == "" {
+			return nil, fmt.Errorf("rollup #%d: both 'table' and 'source' are required", i+1)
+		}
+
+		if r.Bucket.Column == "" {
+			return nil, fmt.Errorf("rollup %q: the bucket column is required", r.Table)
+		}
+
+		if !contains(RollupIntervals, r.Bucket.Interval) {
+			return nil, fmt.Errorf("rollup %q: invalid bucket interval %q, expected one of %s", r.Table, r.Bucket.Interval, strings.Join(RollupIntervals, ", "))
+		}
+
+		if len(r.Measures) == 0 {
+			return nil, fmt.Errorf("rollup %q: at least one measure is required", r.Table)
+		}
+
+		for _, measure := range r.Measures {
+			if measure.Column == "" {
+				return nil, fmt.Errorf("rollup %q: the column of a measure is required", r.Table)
+			}
+
+			if !contains(RollupFuncs, measure.Func) {
+				return nil, fmt.Errorf("rollup %q: invalid function %q of measure %q, expected one of %s", r.Table, measure.Func, measure.Column, strings.Join(RollupFuncs, ", "))
+			}
+
+			if measure.Source == "" && measure.Func != "count" {
+				return nil, fmt.Errorf("rollup %q: the source column of measure %q is required", r.Table, measure.Column)
+			}
+		}
+	}
+
+	return config.Rollups, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetRollups configures the rollups maintained by the loader, the tables must have been loaded
+// first and the soft delete and versioned tables set, their rows cannot be aggregated.
+//
+// The rollup rows are updated in the flush transaction: the source rows inserted are added to
+// the rollup rows of their group while the groups of the rows updated or deleted are computed
+// again from the source table. Reverts of the source rows, through the history table, update
+// the rollup rows the same way.
+func (l *Loader) SetRollups(rollups []*Rollup) error {
+	if _, ok := l.getDialect().(rollupDialect); len(rollups) > 0 && !ok {
+		return fmt.Errorf("rollups are not supported by the current database")
+	}
+
+	resolved := make([]*rollup, 0, len(rollups))
+	for _, r := range rollups {
+		out, err := l.resolveRollup(r)
+		if err != nil {
+			return fmt.Errorf("rollup %q: %w", r.Table, err)
+		}
+
+		resolved = append(resolved, out)
+	}
+
+	l.rollups = resolved
+	return nil
+}
+
+func (l *Loader) resolveRollup(r *Rollup) (*rollup, error) {
+	source, found := l.tables[r.Source]
+	if !found {
+		return nil, fmt.Errorf("unknown source table %q", r.Source)
+	}
+
+	switch {
+	case source.name == CURSORS_TABLE || source.name == HISTORY_TABLE:
+		return nil, fmt.Errorf("system table %q cannot be the source of a rollup", r.Source)
+	case len(source.primaryColumns) == 0:
+		return nil, fmt.Errorf("source table %q has no primary key", r.Source)
+	case source.softDelete || source.versioned:
+		return nil, fmt.Errorf("source table %q is soft deleted or versioned, its rows cannot be aggregated", r.Source)
+	}
+
+	table, found := l.tables[r.Table]
+	if !found {
+		return nil, fmt.Errorf("unknown table %q", r.Table)
+	}
+
+	if table == source {
+		return nil, fmt.Errorf("table %q cannot be its own source", r.Table)
+	}
+
+	bucket, found := source.columnsByName[r.Bucket.Column]
+	if !found {
+		return nil, fmt.Errorf("unknown column %q in table %q", r.Bucket.Column, r.Source)
+	}
+
+	if bucket.scanType == nil || baseScanType(bucket.scanType) != reflectTypeTime {
+		return nil, fmt.Errorf("bucket column %q of table %q is not a timestamp", r.Bucket.Column, r.Source)
+	}
+
+	for _, column := range r.GroupBy {
+		if _, found := source.columnsByName[column]; !found {
+			return nil, fmt.Errorf("unknown column %q in table %q", column, r.Source)
+		}
+	}
+
+	out := &rollup{Rollup: r, source: source, table: table}
+	for _, column := range out.keyColumns() {
+		if _, found := table.columnsByName[column]; !found {
+			return nil, fmt.Errorf("unknown column %q in table %q", column, r.Table)
+		}
+	}
+
+	for _, measure := range r.Measures {
+		if _, found := table.columnsByName[measure.Column]; !found {
+			return nil, fmt.Errorf("unknown column %q in table %q", measure.Column, r.Table)
+		}
+
+		if _, found := source.columnsByName[measure.Source]; measure.Source != "" && !found {
+			return nil, fmt.Errorf("unknown column %q in table %q", measure.Source, r.Source)
+		}
+	}
+
+	keyColumns := out.keyColumns()
+	primaryColumns := make([]string, len(table.primaryColumns))
+	for i, column := range table.primaryColumns {
+		primaryColumns[i] = column.name
+	}
+	sort.Strings(keyColumns)
+	sort.Strings(primaryColumns)
+	if strings.Join(keyColumns, ",") != strings.Join(primaryColumns, ",") {
+		return nil, fmt.Errorf("the primary key of table %q must be made of the bucket column and of the group by columns", r.Table)
+	}
+
+	return out, nil
+}
+
+// rollupRows are the primary keys of source rows, keyed by source table name
+type rollupRows map[string][]map[string]string
+
+// bufferedRollupRows returns the primary keys of the buffered operations of the source tables of
+// the rollups, the rows changed being the ones existing before the flush which are updated or
+// deleted, the rows written the ones existing after the flush.
+func (l *Loader) bufferedRollupRows() (changed, written rollupRows) {
+	if len(l.rollups) == 0 {
+		return nil, nil
+	}
+
+	changed, written = rollupRows{}, rollupRows{}
+	for _, r := range l.rollups {
+		tableName := r.source.name
+		if _, done := written[tableName]; done {
+			continue
+		}
+
+		written[tableName] = nil
+		entries, found := l.entries.Get(tableName)
+		if !found {
+			continue
+		}
+
+		for pair := entries.Oldest(); pair != nil; pair = pair.Next() {
+			if pair.Value.opType != OperationTypeInsert {
+				changed[tableName] = append(changed[tableName], pair.Value.primaryKey)
+			}
+
+			if pair.Value.opType != OperationTypeDelete {
+				written[tableName] = append(written[tableName], pair.Value.primaryKey)
+			}
+		}
+	}
+
+	return changed, written
+}
+
+// revertedRollupRows returns the primary keys of the rows of the source tables of the rollups
+// reverted by the history rows, each of them is both changed and written by the revert.
+func (l *Loader) revertedRollupRows(history []*historyRow) (rollupRows, error) {
+	if len(l.rollups) == 0 {
+		return nil, nil
+	}
+
+	sources := make(map[string]bool, len(l.rollups))
+	for _, r := range l.rollups {
+		sources[r.source.name] = true
+	}
+
+	out := rollupRows{}
+	seen := make(map[string]bool, len(history))
+	for _, row := range history {
+		tableName := l.historyTableName(row.tableName)
+		if !sources[tableName] || seen[tableName+"/"+row.pk] {
+			continue
+		}
+		seen[tableName+"/"+row.pk] = true
+
+		primaryKey, err := jsonToPrimaryKey(row.pk)
+		if err != nil {
+			return nil, fmt.Errorf("decoding primary key %q of table %q: %w", row.pk, tableName, err)
+		}
+
+		out[tableName] = append(out[tableName], primaryKey)
+	}
+
+	return out, nil
+}
+
+// detachRollupGroups deletes the rollup rows of the groups the changed source rows belong to,
+// before those are changed, and returns the key of the groups deleted for each rollup.
+func (l *Loader) detachRollupGroups(ctx context.Context, tx Tx, changed rollupRows) (map[*rollup][][]string, error) {
+	if len(l.rollups) == 0 {
+		return nil, nil
+	}
+
+	detached := make(map[*rollup][][]string, len(l.rollups))
+	for _, r := range l.rollups {
+		primaryKeys := changed[r.source.name]
+		for start := 0; start < len(primaryKeys); start += rollupBatchRows {
+			end := start + rollupBatchRows
+			if end > len(primaryKeys) {
+				end = len(primaryKeys)
+			}
+
+			groups, err := l.detachRollupBatch(ctx, tx, r, primaryKeys[start:end])
+			if err != nil {
+				return nil, fmt.Errorf("rollup %q: %w", r.Table, err)
+			}
+
+			detached[r] = append(detached[r], groups...)
+		}
+	}
+
+	return detached, nil
+}
+
+func (l *Loader) detachRollupBatch(ctx context.Context, tx Tx, r *rollup, primaryKeys []map[string]string) ([][]string, error) {
+	stmt := l.getDialect().(rollupDialect).rollupDetachStatement(r, primaryKeys)
+	if l.tracer.Enabled() {
+		l.logger.Debug("adding query from rollup to transaction", zap.String("query", stmt.query))
+	}
+
+	rows, err := tx.QueryContext(ctx, stmt.query, stmt.args...)
+	if err != nil {
+		return nil, fmt.Errorf("executing query %q: %w", stmt.query, err)
+	}
+
+	if rows == nil { // rows will be nil with no error only in testing scenarios
+		return nil, nil
+	}
+	defer rows.Close()
+
+	var groups [][]string
+	for rows.Next() {
+		group := make([]string, len(r.keyColumns()))
+		pointers := make([]any, len(group))
+		for i := range group {
+			pointers[i] = &group[i]
+		}
+
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("scanning group: %w", err)
+		}
+
+		groups = append(groups, group)
+	}
+
+	return groups, rows.Err()
+}
+
+// attachRollupGroups adds the written source rows to the rollup rows of their group, then
+// computes again the rollup rows of the detached groups from all the rows of the source table.
+// The rows of a detached group added first are overwritten by its recomputed rollup row.
+func (l *Loader) attachRollupGroups(ctx context.Context, tx Tx, written rollupRows, detached map[*rollup][][]string) error {
+	for _, r := range l.rollups {
+		var statements []*statement
+
+		primaryKeys := written[r.source.name]
+		for start := 0; start < len(primaryKeys); start += rollupBatchRows {
+			end := start + rollupBatchRows
+			if end > len(primaryKeys) {
+				end = len(primaryKeys)
+			}
+
+			statements = append(statements, l.getDialect().(rollupDialect).rollupAddStatement(r, primaryKeys[start:end]))
+		}
+
+		groups := detached[r]
+		for start := 0; start < len(groups); start += rollupBatchRows {
+			end := start + rollupBatchRows
+			if end > len(groups) {
+				end = len(groups)
+			}
+
+			statements = append(statements, l.getDialect().(rollupDialect).rollupRecomputeStatement(r, groups[start:end]))
+		}
+
+		for _, stmt := range statements {
+			if l.tracer.Enabled() {
+				l.logger.Debug("adding query from rollup to transaction", zap.String("query", stmt.query))
+			}
+
+			if _, err := tx.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+				return fmt.Errorf("rollup %q: executing query %q: %w", r.Table, stmt.query, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	sink "github.com/streamingfast/substreams-sink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRollups(t *testing.T) {
+	rollups, err := ParseRollups([]byte(`
+rollups:
+  - table: trades_hourly
+    source: trades
+    bucket: { column: ts, interval: hour }
+    group_by: [pair]
+    measures:
+      - { column: volume, func: sum, source: amount }
+      - { column: trade_count, func: count }
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []*Rollup{{
+		Table:   "trades_hourly",
+		Source:  "trades",
+		Bucket:  RollupBucket{Column: "ts", Interval: "hour"},
+		GroupBy: []string{"pair"},
+		Measures: []*RollupMeasure{
+			{Column: "volume", Func: "sum", Source: "amount"},
+			{Column: "trade_count", Func: "count"},
+		},
+	}}, rollups)
+
+	rollups, err = ParseRollups(nil)
+	require.NoError(t, err)
+	assert.Empty(t, rollups)
+
+	_, err = ParseRollups([]byte("rollups:\n  - table: t\n    source: s\n    bucket: { column: ts, interval: hour }\n    grouping: [pair]\n"))
+	assert.ErrorContains(t, err, "field grouping not found")
+
+	_, err = ParseRollups([]byte("rollups:\n  - table: t\n    source: s\n    bucket: { column: ts, interval: year }\n"))
+	assert.ErrorContains(t, err, `rollup "t": invalid bucket interval "year", expected one of minute, hour, day, week, month`)
+
+	_, err = ParseRollups([]byte("rollups:\n  - table: t\n    source: s\n    bucket: { column: ts, interval: day }\n    measures: [{ column: total, func: sum }]\n"))
+	assert.ErrorContains(t, err, `rollup "t": the source column of measure "total" is required`)
+}
+
+func tradesHourlyRollup() *Rollup {
+	return &Rollup{
+		Table:   "trades_hourly",
+		Source:  "trades",
+		Bucket:  RollupBucket{Column: "ts", Interval: "hour"},
+		GroupBy: []string{"pair"},
+		Measures: []*RollupMeasure{
+			{Column: "volume", Func: "sum", Source: "amount"},
+			{Column: "high", Func: "max", Source: "amount"},
+			{Column: "trade_count", Func: "count"},
+		},
+	}
+}
+
+func TestFlushRollups(t *testing.T) {
+	l, tx := NewTestLoader(zlog, tracer, "testschema", featureTestTables("testschema"))
+	require.NoError(t, l.SetRollups([]*Rollup{tradesHourlyRollup()}))
+
+	require.NoError(t, l.Insert("trades", map[string]string{"id": "1"}, map[string]string{"ts": "1700000000", "pair": "eth", "amount": "2"}, nil))
+	require.NoError(t, l.Update("trades", map[string]string{"id": "2"}, map[string]string{"amount": "3"}, nil))
+	require.NoError(t, l.Delete("trades", map[string]string{"id": "3"}, nil))
+
+	_, err := l.Flush(context.Background(), "abc", sink.NewBlankCursor(), 10)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		`DELETE FROM "testschema"."trades_hourly" WHERE ("ts","pair") IN (SELECT date_trunc('hour',"ts"),"pair" FROM "testschema"."trades" WHERE "id" IN ($1,$2)) RETURNING "ts"::text,"pair"::text; [2 3]`,
+		`INSERT INTO "testschema"."trades_hourly" ("ts","pair","volume","high","trade_count") SELECT date_trunc('hour',"ts"),"pair",sum("amount"),max("amount"),count(*) FROM "testschema"."trades" WHERE "id" IN ($1,$2) GROUP BY 1,2 ` +
+			`ON CONFLICT ("ts","pair") DO UPDATE SET "volume"=COALESCE("trades_hourly"."volume"+EXCLUDED."volume","trades_hourly"."volume",EXCLUDED."volume"),` +
+			`"high"=GREATEST("trades_hourly"."high",EXCLUDED."high"),"trade_count"="trades_hourly"."trade_count"+EXCLUDED."trade_count"; [1 2]`,
+	}, tx.ResultsContaining(`"testschema"."trades_hourly"`))
+
+	tx.queries = nil
+	require.NoError(t, postgresDialect{}.revertHistory(tx, context.Background(), l, []*historyRow{
+		{op: "U", tableName: `"testschema"."trades"`, pk: `{"id":"2"}`, prevValue: `{"id":"2","amount":"1"}`, blockNum: 11},
+		{op: "I", tableName: `"testschema"."trades"`, pk: `{"id":"2"}`, blockNum: 11},
+		{op: "I", tableName: `"testschema"."xfer"`, pk: `{"id":"1"}`, blockNum: 11},
+	}, 10))
+
+	assert.Equal(t, []string{
+		`DELETE FROM "testschema"."trades_hourly" WHERE ("ts","pair") IN (SELECT date_trunc('hour',"ts"),"pair" FROM "testschema"."trades" WHERE "id" IN ($1)) RETURNING "ts"::text,"pair"::text; [2]`,
+		`INSERT INTO "testschema"."trades_hourly" ("ts","pair","volume","high","trade_count") SELECT date_trunc('hour',"ts"),"pair",sum("amount"),max("amount"),count(*) FROM "testschema"."trades" WHERE "id" IN ($1) GROUP BY 1,2 ` +
+			`ON CONFLICT ("ts","pair") DO UPDATE SET "volume"=COALESCE("trades_hourly"."volume"+EXCLUDED."volume","trades_hourly"."volume",EXCLUDED."volume"),` +
+			`"high"=GREATEST("trades_hourly"."high",EXCLUDED."high"),"trade_count"="trades_hourly"."trade_count"+EXCLUDED."trade_count"; [2]`,
+	}, tx.ResultsContaining(`"testschema"."trades_hourly"`))
+}
+
+func TestRollupRecomputeStatement(t *testing.T) {
+	l, _ := NewTestLoader(zlog, tracer, "testschema", featureTestTables("testschema"))
+	require.NoError(t, l.SetRollups([]*Rollup{tradesHourlyRollup()}))
+
+	stmt := postgresDialect{}.rollupRecomputeStatement(l.rollups[0], [][]string{{"2023-11-14 22:00:00+00", "eth"}, {"2023-11-14 23:00:00+00", "btc"}})
+	assert.Equal(t, `INSERT INTO "testschema"."trades_hourly" ("ts","pair","volume","high","trade_count") SELECT date_trunc('hour',"ts"),"pair",sum("amount"),max("amount"),count(*) FROM "testschema"."trades" `+
+		`WHERE (date_trunc('hour',"ts"),"pair") IN (($1,$2),($3,$4)) GROUP BY 1,2 `+
+		`ON CONFLICT ("ts","pair") DO UPDATE SET "volume"=EXCLUDED."volume","high"=EXCLUDED."high","trade_count"=EXCLUDED."trade_count";`, stmt.query)
+	assert.Equal(t, []any{"2023-11-14 22:00:00+00", "eth", "2023-11-14 23:00:00+00", "btc"}, stmt.args)
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	// SoftDeleteAtColumn is the column set to the timestamp of the block of a soft delete
+	SoftDeleteAtColumn = "_deleted_at"
+
+	// SoftDeleteBlockColumn is the column set to the number of the block of a soft delete
+	SoftDeleteBlockColumn = "_deleted_block"
+
+	// SoftDeleteAllTables enables soft deletes for all the tables having a soft delete column
+	SoftDeleteAllTables = "*"
+)
+
+// SetSoftDeleteTables enables soft deletes for the tables, `SoftDeleteAllTables` enabling them for
+// all the tables having a `_deleted_at` or `_deleted_block` column. Deletes of those tables set
+// the soft delete columns of the row instead of removing it, and inserts revive a soft deleted row
+// by updating it and clearing its soft delete columns. The tables must have been loaded first, the ones listed by name
+// must have at least one of the soft delete columns.
+func (l *Loader) SetSoftDeleteTables(tableNames []string) error {
+	softDeleteTables := make(map[string]bool, len(tableNames))
+	for _, tableName := range tableNames {
+		if tableName == SoftDeleteAllTables {
+			softDeleteTables[tableName] = true
+			continue
+		}
+
+		table, found := l.tables[tableName]
+		if !found {
+			return fmt.Errorf("unknown table %q", tableName)
+		}
+
+		if !hasSoftDeleteColumns(table) {
+			return fmt.Errorf("table %q has neither a %q nor a %q column, one of them is required for soft deletes", tableName, SoftDeleteAtColumn, SoftDeleteBlockColumn)
+		}
+
+		if len(table.primaryColumns) == 0 {
+			return fmt.Errorf("table %q has no primary key, it is required for soft deletes", tableName)
+		}
+
+		softDeleteTables[tableName] = true
+	}
+
+	l.softDeleteTables = softDeleteTables
+	for _, table := range l.tables {
+		l.resolveSoftDelete(table)
+	}
+
+	return nil
+}
+
+// resolveSoftDelete sets whether the table is soft deleted, system tables and tables without a
+// primary key never are.
+func (l *Loader) resolveSoftDelete(table *TableInfo) {
+	table.softDelete = false
+	if table.name == CURSORS_TABLE || table.name == HISTORY_TABLE || len(table.primaryColumns) == 0 || !hasSoftDeleteColumns(table) {
+		return
+	}
+
+	table.softDelete = l.softDeleteTables[table.name] || l.softDeleteTables[SoftDeleteAllTables]
+}
+
+func hasSoftDeleteColumns(table *TableInfo) bool {
+	_, hasDeletedAt := table.columnsByName[SoftDeleteAtColumn]
+	_, hasDeletedBlock := table.columnsByName[SoftDeleteBlockColumn]
+
+	return hasDeletedAt || hasDeletedBlock
+}
+
+// clearSoftDeleteColumns sets the soft delete columns of the table to NULL in the row's data
+func clearSoftDeleteColumns(table *TableInfo, data map[string]string) {
+	for _, column := range []string{SoftDeleteAtColumn, SoftDeleteBlockColumn} {
+		if _, found := table.columnsByName[column]; found {
+			data[column] = NullValue
+		}
+	}
+}
+
+// HasSoftDeletes returns true if the deletes of the table are soft deletes, see SetSoftDeleteTables
+func (l *Loader) HasSoftDeletes(tableName string) bool {
+	table, found := l.tables[tableName]
+	return found && table.softDelete
+}
+
+// SoftDelete soft deletes a row, setting its soft delete columns from the block. It is an update
+// of the row, its previous state being restored on reorgs like for any update. The row being
+// expected to hold the old values before the delete when they are checked, see SetCheckOldValues.
+func (l *Loader) SoftDelete(tableName string, primaryKey map[string]string, oldValues *OldValues, block *BlockMetadata, reversibleBlockNum *uint64) error {
+	table, found := l.tables[tableName]
+	if !found {
+		return fmt.Errorf("unknown table %q", tableName)
+	}
+
+	if !table.softDelete {
+		return fmt.Errorf("soft deletes are not enabled for table %q", tableName)
+	}
+
+	data := make(map[string]string, 2)
+	if column, found := table.columnsByName[SoftDeleteAtColumn]; found {
+		data[SoftDeleteAtColumn] = blockTimestampValue(column, block.Timestamp)
+	}
+	if _, found := table.columnsByName[SoftDeleteBlockColumn]; found {
+		data[SoftDeleteBlockColumn] = strconv.FormatUint(block.Number, 10)
+	}
+
+	return l.UpdateWithOldValues(tableName, primaryKey, data, oldValues, reversibleBlockNum)
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasSoftDeletes(t *testing.T) {
+	l, _ := NewTestLoader(zlog, tracer, "testschema", featureTestTables("testschema"))
+
+	require.NoError(t, l.SetSoftDeleteTables([]string{SoftDeleteAllTables}))
+	assert.True(t, l.HasSoftDeletes("tokens"))
+	assert.False(t, l.HasSoftDeletes("xfer"))
+	assert.False(t, l.HasSoftDeletes(CURSORS_TABLE))
+
+	require.NoError(t, l.SetSoftDeleteTables(nil))
+	assert.False(t, l.HasSoftDeletes("tokens"))
+}
+
+func TestSoftDelete(t *testing.T) {
+	block := func(number uint64) *BlockMetadata {
+		return &BlockMetadata{Number: number, ID: "abc", Timestamp: time.Unix(1700000000, 0)}
+	}
+
+	tests := []struct {
+		name        string
+		apply       func(l *Loader, primaryKey map[string]string) error
+		expectType  OperationType
+		expectData  map[string]string
+		expectBlock uint64
+	}{
+		{
+			name: "delete",
+			apply: func(l *Loader, primaryKey map[string]string) error {
+				block10 := uint64(10)
+				return l.SoftDelete("tokens", primaryKey, nil, block(10), &block10)
+			},
+			expectType:  OperationTypeUpdate,
+			expectData:  map[string]string{"_deleted_at": "2023-11-14T22:13:20Z", "_deleted_block": "10"},
+			expectBlock: 10,
+		},
+		{
+			name: "insert",
+			apply: func(l *Loader, primaryKey map[string]string) error {
+				block10 := uint64(10)
+				return l.Insert("tokens", primaryKey, map[string]string{"owner": "a"}, &block10)
+			},
+			expectType:  OperationTypeInsert,
+			expectData:  map[string]string{"id": "1", "owner": "a", "_deleted_at": NullValue, "_deleted_block": NullValue},
+			expectBlock: 10,
+		},
+		{
+			name: "delete then insert revives the row",
+			apply: func(l *Loader, primaryKey map[string]string) error {
+				block10, block11 := uint64(10), uint64(11)
+				if err := l.SoftDelete("tokens", primaryKey, nil, block(10), &block10); err != nil {
+					return err
+				}
+				return l.Insert("tokens", primaryKey, map[string]string{"owner": "b"}, &block11)
+			},
+			expectType:  OperationTypeUpsert,
+			expectData:  map[string]string{"id": "1", "owner": "b", "_deleted_at": NullValue, "_deleted_block": NullValue},
+			expectBlock: 10,
+		},
+		{
+			name: "insert then delete",
+			apply: func(l *Loader, primaryKey map[string]string) error {
+				block10, block11 := uint64(10), uint64(11)
+				if err := l.Insert("tokens", primaryKey, map[string]string{"owner": "a"}, &block10); err != nil {
+					return err
+				}
+				return l.SoftDelete("tokens", primaryKey, nil, block(11), &block11)
+			},
+			expectType:  OperationTypeInsert,
+			expectData:  map[string]string{"id": "1", "owner": "a", "_deleted_at": "2023-11-14T22:13:20Z", "_deleted_block": "11"},
+			expectBlock: 10,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, _ := NewTestLoader(zlog, tracer, "testschema", featureTestTables("testschema"))
+			require.NoError(t, l.SetSoftDeleteTables([]string{"tokens"}))
+
+			require.NoError(t, test.apply(l, map[string]string{"id": "1"}))
+
+			entries, found := l.entries.Get("tokens")
+			require.True(t, found)
+			require.Equal(t, 1, entries.Len())
+
+			folded := entries.Oldest().Value
+			assert.Equal(t, test.expectType, folded.opType)
+			assert.Equal(t, test.expectData, folded.data)
+			assert.Equal(t, test.expectBlock, *folded.reversibleBlockNum)
+		})
+	}
+
+	l, _ := NewTestLoader(zlog, tracer, "testschema", featureTestTables("testschema"))
+	assert.ErrorContains(t, l.SoftDelete("tokens", map[string]string{"id": "1"}, nil, block(10), nil), `soft deletes are not enabled for table "tokens"`)
+}
+
+func TestSoftDeleteInsertStatements(t *testing.T) {
+	l, _ := NewTestLoader(zlog, tracer, "testschema", featureTestTables("testschema"))
+	require.NoError(t, l.SetSoftDeleteTables([]string{"tokens"}))
+
+	block := uint64(10)
+	require.NoError(t, l.Insert("tokens", map[string]string{"id": "1"}, map[string]string{"owner": "a"}, &block))
+
+	entries, _ := l.entries.Get("tokens")
+	batch := batchOperations(entries, postgresMaxBatchRows, postgresMaxParams)[0]
+	assert.Equal(t, OperationTypeInsert, batch.opType)
+	assert.True(t, batch.upsertsOnConflict())
+
+	statements, err := (&postgresDialect{}).prepareBatchStatements("testschema", batch)
+	require.NoError(t, err)
+	require.Len(t, statements, 2)
+	assert.Contains(t, statements[0].query, `SELECT $6::text,$2::text,$3::text,NULL::text,$4::bigint WHERE NOT EXISTS`)
+	assert.Equal(t, `INSERT INTO "testschema"."tokens" ("_deleted_at","_deleted_block","id","owner") VALUES ($1,$2,$3,$4) `+
+		`ON CONFLICT ("id") DO UPDATE SET "_deleted_at"=EXCLUDED."_deleted_at", "_deleted_block"=EXCLUDED."_deleted_block", "owner"=EXCLUDED."owner";`, statements[1].query)
+	assert.Equal(t, []any{nil, nil, "1", "a"}, statements[1].args)
+}
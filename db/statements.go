@@ -0,0 +1,148 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// statement is a SQL query along with the values bound to its parameters
+type statement struct {
+	query string
+	args  []any
+
+	// key identifies the shape of the statement when its query only depends on it, such
+	// statements are prepared once and re-used from one flush to the other. Statements without
+	// a key, like the multi-row ones whose query depends on their row count, are executed as is.
+	key string
+}
+
+// keyStatements sets the key of the statements sharing the shape identified by `key`, each
+// statement being keyed by its position among them.
+func keyStatements(key string, statements []*statement) []*statement {
+	for i, stmt := range statements {
+		stmt.key = key + "#" + strconv.Itoa(i) + "/" + strconv.Itoa(len(statements))
+	}
+
+	return statements
+}
+
+// queryArgs accumulates the bind parameters of a query being built, each added value
+// returns the placeholder to use for it in the query.
+type queryArgs struct {
+	args        []any
+	placeholder func(index int) string
+}
+
+func newPostgresArgs() *queryArgs {
+	return &queryArgs{placeholder: func(index int) string { return "$" + strconv.Itoa(index) }}
+}
+
+// newPositionalArgs is used by the dialects whose placeholders are a plain `?`
+func newPositionalArgs() *queryArgs {
+	return &queryArgs{placeholder: func(index int) string { return "?" }}
+}
+
+func (a *queryArgs) add(value any) string {
+	a.args = append(a.args, value)
+	return a.placeholder(len(a.args))
+}
+
+func (a *queryArgs) statement(query string) *statement {
+	return &statement{query: query, args: a.args}
+}
+
+// maxPreparedStatements bounds the amount of statements kept prepared. Only the statements of
+// a fixed shape are prepared, their amount growing with the tables and column sets written, so
+// the least recently used statement is closed once the bound is reached.
+const maxPreparedStatements = 1024
+
+// preparedStatements caches the statements prepared against the database keyed by their shape
+// so that the database can re-use their query plan from one flush to the other.
+type preparedStatements struct {
+	db *sql.DB
+
+	lock       sync.Mutex
+	statements map[string]*list.Element
+	// recent orders the cached statements from the most to the least recently used
+	recent *list.List
+}
+
+type preparedStatement struct {
+	key   string
+	query string
+	stmt  *sql.Stmt
+}
+
+func newPreparedStatements(db *sql.DB) *preparedStatements {
+	return &preparedStatements{
+		db:         db,
+		statements: make(map[string]*list.Element),
+		recent:     list.New(),
+	}
+}
+
+// get returns the prepared statement of the key, preparing the query if needed and evicting the
+// least recently used statement if the cache is full. A statement prepared for the key with
+// another query is replaced.
+func (p *preparedStatements) get(ctx context.Context, key string, query string) (*sql.Stmt, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if element, found := p.statements[key]; found {
+		if prepared := element.Value.(*preparedStatement); prepared.query == query {
+			p.recent.MoveToFront(element)
+			return prepared.stmt, nil
+		}
+
+		if err := p.evict(element); err != nil {
+			return nil, err
+		}
+	}
+
+	stmt, err := p.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("prepare statement %q: %w", query, err)
+	}
+
+	if p.recent.Len() >= maxPreparedStatements {
+		if err := p.evict(p.recent.Back()); err != nil {
+			stmt.Close()
+			return nil, err
+		}
+	}
+
+	p.statements[key] = p.recent.PushFront(&preparedStatement{key: key, query: query, stmt: stmt})
+	return stmt, nil
+}
+
+// evict removes the statement from the cache and closes it, the transactions using it keep
+// their own copy until they end.
+func (p *preparedStatements) evict(element *list.Element) error {
+	prepared := p.recent.Remove(element).(*preparedStatement)
+	delete(p.statements, prepared.key)
+
+	if err := prepared.stmt.Close(); err != nil {
+		return fmt.Errorf("close statement %q: %w", prepared.query, err)
+	}
+
+	return nil
+}
+
+// execPrepared runs the statement within the transaction, re-using the statement prepared for
+// its key if it has one and the transaction is a real database transaction.
+func (l *Loader) execPrepared(ctx context.Context, tx Tx, stmt *statement) (sql.Result, error) {
+	if stdTx, ok := tx.(*sqlTx); ok && stmt.key != "" {
+		prepared, err := l.statements.get(ctx, stmt.key, stmt.query)
+		if err != nil {
+			return nil, err
+		}
+
+		return stdTx.StmtContext(ctx, prepared).ExecContext(ctx, stmt.args...)
+	}
+
+	return tx.ExecContext(ctx, stmt.query, stmt.args...)
+}
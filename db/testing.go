@@ -3,6 +3,9 @@ package db
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/streamingfast/logging"
 	"go.uber.org/zap"
@@ -43,6 +46,56 @@ func TestTables(schema string) map[string]*TableInfo {
 	}
 }
 
+// featureTestTables returns the test tables along with the ones used by the optional features of
+// the loader: soft deletes, versioned tables, the changes log, the blocks table, retention
+// windows and rollups.
+func featureTestTables(schema string) map[string]*TableInfo {
+	tables := TestTables(schema)
+	tables["tokens"] = mustNewTableInfo(schema, "tokens", []string{"id"}, map[string]*ColumnInfo{
+		"id":             NewColumnInfo("id", "text", ""),
+		"owner":          NewColumnInfo("owner", "text", ""),
+		"_deleted_at":    NewColumnInfo("_deleted_at", "TIMESTAMPTZ", time.Time{}),
+		"_deleted_block": NewColumnInfo("_deleted_block", "int8", int64(0)),
+	})
+	tables["owners"] = mustNewTableInfo(schema, "owners", []string{"id", "valid_from_block"}, map[string]*ColumnInfo{
+		"id":               NewColumnInfo("id", "text", ""),
+		"owner":            NewColumnInfo("owner", "text", ""),
+		"valid_from_block": NewColumnInfo("valid_from_block", "int8", int64(0)),
+		"valid_to_block":   NewColumnInfo("valid_to_block", "int8", int64(0)),
+	})
+	tables["unkeyed"] = mustNewTableInfo(schema, "unkeyed", []string{"id"}, map[string]*ColumnInfo{
+		"id":               NewColumnInfo("id", "text", ""),
+		"valid_from_block": NewColumnInfo("valid_from_block", "int8", int64(0)),
+		"valid_to_block":   NewColumnInfo("valid_to_block", "int8", int64(0)),
+	})
+	tables[CHANGES_TABLE] = mustNewTableInfo(schema, CHANGES_TABLE, []string{"id"}, map[string]*ColumnInfo{
+		"id": NewColumnInfo("id", "int8", int64(0)),
+	})
+	tables[BLOCKS_TABLE] = mustNewTableInfo(schema, BLOCKS_TABLE, []string{"block_num"}, map[string]*ColumnInfo{
+		"block_num": NewColumnInfo("block_num", "int8", int64(0)),
+	})
+	tables["trades"] = mustNewTableInfo(schema, "trades", []string{"id"}, map[string]*ColumnInfo{
+		"id":        NewColumnInfo("id", "text", ""),
+		"block_num": NewColumnInfo("block_num", "int8", int64(0)),
+		"ts":        NewColumnInfo("ts", "TIMESTAMPTZ", time.Time{}),
+		"pair":      NewColumnInfo("pair", "text", ""),
+		"amount":    NewColumnInfo("amount", "numeric", ""),
+	})
+	tables["trades_hourly"] = mustNewTableInfo(schema, "trades_hourly", []string{"ts", "pair"}, map[string]*ColumnInfo{
+		"ts":          NewColumnInfo("ts", "TIMESTAMPTZ", time.Time{}),
+		"pair":        NewColumnInfo("pair", "text", ""),
+		"volume":      NewColumnInfo("volume", "numeric", ""),
+		"high":        NewColumnInfo("high", "numeric", ""),
+		"trade_count": NewColumnInfo("trade_count", "int8", int64(0)),
+	})
+	tables["events"] = mustNewTableInfo(schema, "events", []string{"id"}, map[string]*ColumnInfo{
+		"id": NewColumnInfo("id", "text", ""),
+		"ts": NewColumnInfo("ts", "TIMESTAMPTZ", time.Time{}),
+	})
+
+	return tables
+}
+
 func mustNewTableInfo(schema, name string, pkList []string, columnsByName map[string]*ColumnInfo) *TableInfo {
 	ti, err := NewTableInfo(schema, name, pkList, columnsByName)
 	if err != nil {
@@ -67,7 +120,7 @@ func (t *TestTx) Commit() error {
 }
 
 func (t *TestTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
-	t.queries = append(t.queries, query)
+	t.queries = append(t.queries, withArgs(query, args))
 	return &testResult{}, nil
 }
 
@@ -75,11 +128,32 @@ func (t *TestTx) Results() []string {
 	return t.queries
 }
 
-func (t *TestTx) QueryContext(ctx context.Context, query string, args ...any) (out *sql.Rows, err error) {
-	t.queries = append(t.queries, query)
+// ResultsContaining returns the recorded queries containing `substr`, like the identifier of the
+// table they write to.
+func (t *TestTx) ResultsContaining(substr string) (out []string) {
+	for _, query := range t.queries {
+		if strings.Contains(query, substr) {
+			out = append(out, query)
+		}
+	}
+
+	return out
+}
+
+func (t *TestTx) QueryContext(ctx context.Context, query string, args ...any) (out Rows, err error) {
+	t.queries = append(t.queries, withArgs(query, args))
 	return nil, nil
 }
 
+// withArgs appends the bound values to the recorded query so that tests can assert on both
+func withArgs(query string, args []any) string {
+	if len(args) == 0 {
+		return query
+	}
+
+	return query + " " + fmt.Sprintf("%v", args)
+}
+
 type testResult struct{}
 
 func (t *testResult) LastInsertId() (int64, error) {
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimestampUnit is the unit of the unix timestamps received for timestamp columns
+type TimestampUnit string
+
+const (
+	// TimestampUnitAuto detects the unit of unix timestamps from their magnitude, the default
+	TimestampUnitAuto         TimestampUnit = "auto"
+	TimestampUnitSeconds      TimestampUnit = "s"
+	TimestampUnitMilliseconds TimestampUnit = "ms"
+	TimestampUnitMicroseconds TimestampUnit = "us"
+	TimestampUnitNanoseconds  TimestampUnit = "ns"
+)
+
+// timestampUnitsPerSecond is the number of units of each timestamp unit in a second
+var timestampUnitsPerSecond = map[TimestampUnit]int64{
+	TimestampUnitSeconds:      1,
+	TimestampUnitMilliseconds: 1_000,
+	TimestampUnitMicroseconds: 1_000_000,
+	TimestampUnitNanoseconds:  1_000_000_000,
+}
+
+// ParseTimestampUnit parses a timestamp unit, one of `auto`, `s`, `ms`, `us` or `ns`
+func ParseTimestampUnit(in string) (TimestampUnit, error) {
+	unit := TimestampUnit(strings.ToLower(strings.TrimSpace(in)))
+	if _, found := timestampUnitsPerSecond[unit]; !found && unit != TimestampUnitAuto {
+		return "", fmt.Errorf("unknown timestamp unit %q, valid units are auto, s, ms, us and ns", in)
+	}
+
+	return unit, nil
+}
+
+// SetTimestampUnit sets the unit of the unix timestamps received for timestamp columns, by default
+// it is detected from their magnitude.
+func (l *Loader) SetTimestampUnit(unit TimestampUnit) {
+	l.timestampUnit = unit
+}
+
+// timestampColumnTypes are the database types whose values are parsed by `parseTimestamp`
+var timestampColumnTypes = map[string]bool{
+	"timestamp":                true,
+	"timestamptz":              true,
+	"timestamp with time zone": true,
+	"timestamp_s":              true,
+	"timestamp_ms":             true,
+	"timestamp_ns":             true,
+	"date":                     true,
+	"date32":                   true,
+	"datetime":                 true,
+	"datetime64":               true,
+}
+
+// convertTimestamp is the value converter of the timestamp columns
+func (l *Loader) convertTimestamp(typeName string, value string) (any, error) {
+	out, err := parseTimestamp(value, l.timestampUnit)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %w", typeName, value, err)
+	}
+
+	return out, nil
+}
+
+var unixTimestampRegex = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+
+// timestampLayouts are the layouts of the textual timestamps, the ones without an offset are in UTC
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	time.DateOnly,
+}
+
+// parseTimestamp parses a unix timestamp, with an optional fractional part, in the unit or in
+// the unit detected from its magnitude for `TimestampUnitAuto`, a RFC 3339 timestamp or a
+// `YYYY-MM-DD` date. The timestamp is returned in UTC with its full precision.
+func parseTimestamp(value string, unit TimestampUnit) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if unixTimestampRegex.MatchString(value) {
+		return parseUnixTimestamp(value, unit)
+	}
+
+	for _, layout := range timestampLayouts {
+		if out, err := time.Parse(layout, value); err == nil {
+			return out.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("expected a unix timestamp, a RFC 3339 timestamp or a YYYY-MM-DD date")
+}
+
+func parseUnixTimestamp(value string, unit TimestampUnit) (time.Time, error) {
+	integer, fraction, _ := strings.Cut(value, ".")
+	units, err := strconv.ParseInt(integer, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unix timestamp out of range")
+	}
+
+	if unit == "" || unit == TimestampUnitAuto {
+		unit = unixTimestampUnit(units)
+	}
+
+	unitsPerSecond, found := timestampUnitsPerSecond[unit]
+	if !found {
+		return time.Time{}, fmt.Errorf("unknown timestamp unit %q", unit)
+	}
+
+	nanosPerUnit := int64(time.Second) / unitsPerSecond
+	nanos := (units % unitsPerSecond) * nanosPerUnit
+
+	if fraction != "" && nanosPerUnit > 1 {
+		// The fraction of the unit, down to the nanosecond
+		digits := len(strconv.FormatInt(nanosPerUnit, 10)) - 1
+		fractionNanos, _ := strconv.ParseInt((fraction + "000000000")[:digits], 10, 64)
+		if strings.HasPrefix(integer, "-") {
+			fractionNanos = -fractionNanos
+		}
+		nanos += fractionNanos
+	}
+
+	return time.Unix(units/unitsPerSecond, nanos).UTC(), nil
+}
+
+// unixTimestampUnit returns the unit of a unix timestamp from its magnitude, values below 10^11
+// are in seconds, below 10^14 in milliseconds and below 10^17 in microseconds. Timestamps in
+// seconds are detected until the year 5138 and the ones in milliseconds after March 1973.
+func unixTimestampUnit(units int64) TimestampUnit {
+	if units < 0 {
+		units = -units
+	}
+
+	switch {
+	case units < 100_000_000_000:
+		return TimestampUnitSeconds
+	case units < 100_000_000_000_000:
+		return TimestampUnitMilliseconds
+	case units < 100_000_000_000_000_000:
+		return TimestampUnitMicroseconds
+	default:
+		return TimestampUnitNanoseconds
+	}
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		value     string
+		unit      TimestampUnit
+		expect    time.Time
+		expectErr bool
+	}{
+		{value: "1700000000", expect: time.Unix(1700000000, 0)},
+		{value: "1700000000.5", expect: time.Unix(1700000000, 500000000)},
+		{value: "1700000000123", expect: time.Unix(1700000000, 123000000)},
+		{value: "1700000000123456", expect: time.Unix(1700000000, 123456000)},
+		{value: "1700000000123456789", expect: time.Unix(1700000000, 123456789)},
+		{value: "-1.5", expect: time.Unix(-2, 500000000)},
+		{value: "1700000000", unit: TimestampUnitMilliseconds, expect: time.Unix(1700000, 0)},
+		{value: "1700000000123.5", unit: TimestampUnitMilliseconds, expect: time.Unix(1700000000, 123500000)},
+		{value: "1700000000", unit: TimestampUnitNanoseconds, expect: time.Unix(1, 700000000)},
+		{value: "2023-11-14T22:13:20Z", expect: time.Unix(1700000000, 0)},
+		{value: "2023-11-14T22:13:20.123456789Z", expect: time.Unix(1700000000, 123456789)},
+		{value: "2023-11-14T17:13:20.5-05:00", expect: time.Unix(1700000000, 500000000)},
+		{value: "2023-11-14 22:13:20.5+00:00", expect: time.Unix(1700000000, 500000000)},
+		{value: "2023-11-14 22:13:20+00", expect: time.Unix(1700000000, 0)},
+		{value: "2023-11-14T23:13:20+01", expect: time.Unix(1700000000, 0)},
+		{value: "2023-11-14T22:13:20", expect: time.Unix(1700000000, 0)},
+		{value: "2023-11-14", expect: time.Date(2023, 11, 14, 0, 0, 0, 0, time.UTC)},
+		{value: "99999999999999999999", expectErr: true},
+		{value: "2023-11-14T22:13", expectErr: true},
+		{value: "14/11/2023", expectErr: true},
+		{value: "yesterday", expectErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s/%s", test.value, test.unit), func(t *testing.T) {
+			out, err := parseTimestamp(test.value, test.unit)
+			if test.expectErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expect.UTC(), out)
+		})
+	}
+}
+
+func TestParseTimestampUnit(t *testing.T) {
+	unit, err := ParseTimestampUnit("MS")
+	require.NoError(t, err)
+	assert.Equal(t, TimestampUnitMilliseconds, unit)
+
+	_, err = ParseTimestampUnit("minutes")
+	assert.ErrorContains(t, err, `unknown timestamp unit "minutes"`)
+}
+
+func TestTimestampColumns(t *testing.T) {
+	tables := map[string]*TableInfo{
+		"blocks": mustNewTableInfo("testschema", "blocks", []string{"number"}, map[string]*ColumnInfo{
+			"number":    NewColumnInfo("number", "INT8", int64(0)),
+			"timestamp": NewColumnInfo("timestamp", "TIMESTAMPTZ", time.Time{}),
+			"day":       NewColumnInfo("day", "DATE", time.Time{}),
+		}),
+	}
+
+	l, _ := NewTestLoader(zlog, tracer, "testschema", tables)
+	for _, table := range l.tables {
+		l.resolveValueConversions(table)
+	}
+	l.SetTimestampUnit(TimestampUnitMilliseconds)
+
+	table := l.tables["blocks"]
+	row, err := (&postgresDialect{}).prepareRowValues(table, map[string]string{"number": "1"}, map[string]string{"timestamp": "1700000000123", "day": "2023-11-14"})
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1700000000, 123000000).UTC(), row["timestamp"])
+	assert.Equal(t, time.Date(2023, 11, 14, 0, 0, 0, 0, time.UTC), row["day"])
+
+	_, _, err = (&postgresDialect{}).prepareColValues(table, map[string]string{"timestamp": "yesterday"})
+	assert.ErrorContains(t, err, `invalid TIMESTAMPTZ value "yesterday"`)
+}
+
+func TestTimeColumnsPassThrough(t *testing.T) {
+	tables := map[string]*TableInfo{
+		"opening_hours": mustNewTableInfo("testschema", "opening_hours", []string{"id"}, map[string]*ColumnInfo{
+			"id":    NewColumnInfo("id", "INT8", int64(0)),
+			"opens": NewColumnInfo("opens", "TIME", time.Time{}),
+			"ends":  NewColumnInfo("ends", "TIMETZ", time.Time{}),
+		}),
+	}
+
+	l, _ := NewTestLoader(zlog, tracer, "testschema", tables)
+	l.resolveValueConversions(l.tables["opening_hours"])
+
+	_, values, err := (&postgresDialect{}).prepareColValues(l.tables["opening_hours"], map[string]string{"opens": "12:34:56", "ends": "18:00:00+02"})
+	require.NoError(t, err)
+	assert.Equal(t, []any{"18:00:00+02", "12:34:56"}, values)
+}
@@ -27,11 +27,26 @@ type TableInfo struct {
 	// Identifier is equivalent to 'escape(<schema>).escape(<name>)' but pre-computed
 	// for usage when computing queries.
 	identifier string
+
+	// blockMetadataColumns are the block metadata columns of the table, nil if it has none
+	blockMetadataColumns *BlockMetadataColumns
+
+	// softDelete is set when the deletes of the table are soft deletes
+	softDelete bool
+
+	// versioned is set when the table keeps a row per version of each entity, see SetVersionedTables
+	versioned bool
 }
 
 func NewTableInfo(schema, name string, pkList []string, columnsByName map[string]*ColumnInfo) (*TableInfo, error) {
-	schemaEscaped := EscapeIdentifier(schema)
-	nameEscaped := EscapeIdentifier(name)
+	return newTableInfo(EscapeIdentifier, schema, name, pkList, columnsByName)
+}
+
+// newTableInfo is NewTableInfo escaping the schema and table names using the received function,
+// the quoting character of identifiers being specific to each dialect.
+func newTableInfo(escapeIdentifier func(string) string, schema, name string, pkList []string, columnsByName map[string]*ColumnInfo) (*TableInfo, error) {
+	schemaEscaped := escapeIdentifier(schema)
+	nameEscaped := escapeIdentifier(name)
 	primaryColumns := make([]*ColumnInfo, len(pkList))
 
 	for i, primaryKeyColumnName := range pkList {
@@ -57,11 +72,31 @@ func NewTableInfo(schema, name string, pkList []string, columnsByName map[string
 	}, nil
 }
 
+// isPrimaryColumn returns true if the column is part of the table's primary key
+func (t *TableInfo) isPrimaryColumn(name string) bool {
+	for _, column := range t.primaryColumns {
+		if column.name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
 type ColumnInfo struct {
 	name             string
 	escapedName      string
 	databaseTypeName string
 	scanType         reflect.Type
+
+	// converter converts the values of the column, nil if they are normalized by the dialect
+	converter ValueConverter
+
+	// bytesEncoding is the encoding of the column's values decoded by its converter, raw if none
+	bytesEncoding BytesEncoding
+
+	// enumLabels are the values accepted by an enum column, nil for the other columns
+	enumLabels []string
 }
 
 func NewColumnInfo(name string, databaseTypeName string, scanType any) *ColumnInfo {
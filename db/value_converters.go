@@ -0,0 +1,144 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ValueConverter converts the value received from Substreams for a column into the value bound
+// for it, `typeName` being the column's database type name as reported by the driver, including
+// its parameters. The value is validated, an error describing why it is invalid is returned
+// otherwise.
+type ValueConverter func(typeName string, value string) (any, error)
+
+// ValueConverters are value converters keyed by database type name, lower cased and without
+// parameters, like `numeric` or `decimal`.
+type ValueConverters map[string]ValueConverter
+
+// valueConvertersProvider is implemented by the dialects shipping built-in value converters for
+// the types their `normalizeValueType` does not handle, `convertTimestamp` being the converter of
+// the timestamp types for the converters of composite types.
+type valueConvertersProvider interface {
+	builtinValueConverters(convertTimestamp ValueConverter) ValueConverters
+}
+
+// RegisterValueConverter registers the converter of the values of the columns whose database type
+// is `typeName`, replacing the built-in one if any. Type names are case insensitive and matched
+// without their parameters, `Decimal` matching a `Decimal(38, 18)` column. Columns of the tables
+// already loaded use it right away, except the ones having a bytes encoding.
+func (l *Loader) RegisterValueConverter(typeName string, converter ValueConverter) {
+	l.getValueConverters()[valueConverterKeys(typeName)[0]] = converter
+
+	for _, table := range l.tables {
+		l.resolveValueConversions(table)
+	}
+}
+
+func (l *Loader) getValueConverters() ValueConverters {
+	if l.valueConverters == nil {
+		l.valueConverters = ValueConverters{}
+		for typeName := range timestampColumnTypes {
+			l.valueConverters[typeName] = l.convertTimestamp
+		}
+
+		if provider, ok := l.getDialect().(valueConvertersProvider); ok {
+			for typeName, converter := range provider.builtinValueConverters(l.convertTimestamp) {
+				l.valueConverters[typeName] = converter
+			}
+		}
+	}
+
+	return l.valueConverters
+}
+
+// valueConverter returns the converter of the database type, nil if there is none
+func (l *Loader) valueConverter(typeName string) ValueConverter {
+	converters := l.getValueConverters()
+	for _, key := range valueConverterKeys(typeName) {
+		if converter, found := converters[key]; found {
+			return converter
+		}
+	}
+
+	return nil
+}
+
+// valueConverterKeys returns the keys under which the converter of a database type is looked up,
+// most specific first. Names are lower cased, stripped of their parameters and of the `Nullable`
+// and `LowCardinality` wrappers of ClickHouse. Postgres array types, reported as their element
+// type prefixed with `_`, fall back to `array`.
+func valueConverterKeys(typeName string) []string {
+	name := strings.ToLower(unwrapClickhouseType(typeName))
+	if open := strings.IndexByte(name, '('); open != -1 {
+		name = strings.TrimSpace(name[:open])
+	}
+
+	if strings.HasPrefix(name, "_") || strings.HasSuffix(name, "[]") {
+		return []string{name, "array"}
+	}
+
+	return []string{name}
+}
+
+// unwrapClickhouseType returns the type wrapped by the `Nullable` and `LowCardinality` types of
+// ClickHouse, the type itself for other types.
+func unwrapClickhouseType(typeName string) string {
+	typeName = strings.TrimSpace(typeName)
+	for _, wrapper := range []string{"nullable(", "lowcardinality("} {
+		if len(typeName) > len(wrapper) && strings.EqualFold(typeName[:len(wrapper)], wrapper) && strings.HasSuffix(typeName, ")") {
+			return unwrapClickhouseType(typeName[len(wrapper) : len(typeName)-1])
+		}
+	}
+
+	return typeName
+}
+
+// enumValueConverter returns the converter of the enum column, accepting only its labels which
+// are bound as is.
+func enumValueConverter(table *TableInfo, column *ColumnInfo) ValueConverter {
+	labels := make(map[string]bool, len(column.enumLabels))
+	for _, label := range column.enumLabels {
+		labels[label] = true
+	}
+
+	return func(typeName string, value string) (any, error) {
+		if !labels[value] {
+			return nil, fmt.Errorf("invalid value %q for enum column %q of table %q, expected one of %s", value, column.name, table.name, strings.Join(column.enumLabels, ", "))
+		}
+
+		return value, nil
+	}
+}
+
+// convertValue returns the value bound for the column, converted by the column's value converter
+// if it has one or by the dialect's `normalize` function otherwise.
+func (c *ColumnInfo) convertValue(value string, normalize func(value string, valueType reflect.Type) (any, error)) (any, error) {
+	if value == NullValue {
+		return nil, nil
+	}
+
+	if c.converter != nil {
+		return c.converter(unwrapClickhouseType(c.databaseTypeName), value)
+	}
+
+	return normalize(value, c.scanType)
+}
+
+// decodeJSON decodes the JSON value keeping numbers as `json.Number` so that large integers and
+// decimals are not rounded through a float64.
+func decodeJSON(value string, out any) error {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(out); err != nil {
+		return err
+	}
+
+	if decoder.More() {
+		return fmt.Errorf("unexpected data after JSON value")
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueConverterKeys(t *testing.T) {
+	assert.Equal(t, []string{"numeric"}, valueConverterKeys("NUMERIC"))
+	assert.Equal(t, []string{"_int4", "array"}, valueConverterKeys("_INT4"))
+	assert.Equal(t, []string{"decimal"}, valueConverterKeys("Nullable(Decimal(38, 18))"))
+	assert.Equal(t, []string{"array"}, valueConverterKeys("LowCardinality(Nullable(Array(String)))"))
+	assert.Equal(t, "Map(String, Array(UInt64))", unwrapClickhouseType("Nullable(Map(String, Array(UInt64)))"))
+	assert.Equal(t, []string{"String", "Array(UInt64)"}, clickhouseTypeParams("Map(String, Array(UInt64))"))
+}
+
+func TestValueConverters(t *testing.T) {
+	clickhouseConverters := clickhouseValueConverters((&Loader{}).convertTimestamp)
+
+	tests := []struct {
+		converters ValueConverters
+		typeName   string
+		value      string
+		expect     any
+		expectErr  bool
+	}{
+		{converters: postgresValueConverters, typeName: "NUMERIC", value: "-12.50", expect: "-12.50"},
+		{converters: postgresValueConverters, typeName: "NUMERIC", value: "NaN", expect: "NaN"},
+		{converters: postgresValueConverters, typeName: "NUMERIC", value: "12,5", expectErr: true},
+		{converters: postgresValueConverters, typeName: "UUID", value: "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", expect: "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"},
+		{converters: postgresValueConverters, typeName: "UUID", value: "a0eebc99", expectErr: true},
+		{converters: postgresValueConverters, typeName: "JSONB", value: `{"a":1}`, expect: postgresJSON(`{"a":1}`)},
+		{converters: postgresValueConverters, typeName: "JSONB", value: `{"a":`, expectErr: true},
+		{converters: postgresValueConverters, typeName: "INET", value: "192.168.0.1", expect: "192.168.0.1"},
+		{converters: postgresValueConverters, typeName: "CIDR", value: "10.0.0.0/8", expect: "10.0.0.0/8"},
+		{converters: postgresValueConverters, typeName: "INET", value: "localhost", expectErr: true},
+		{converters: postgresValueConverters, typeName: "_TEXT", value: `["a","b \"c\"",null]`, expect: `{"a","b \"c\"",NULL}`},
+		{converters: postgresValueConverters, typeName: "_INT4", value: `[[1,2],[3,4]]`, expect: `{{1,2},{3,4}}`},
+		{converters: postgresValueConverters, typeName: "_INT4", value: `{1,2}`, expect: `{1,2}`},
+		{converters: postgresValueConverters, typeName: "_INT4", value: `1,2`, expectErr: true},
+
+		{converters: clickhouseConverters, typeName: "Decimal(38, 18)", value: "1.25", expect: decimal.RequireFromString("1.25")},
+		{converters: clickhouseConverters, typeName: "Decimal(38, 18)", value: "abc", expectErr: true},
+		{converters: clickhouseConverters, typeName: "UInt256", value: "115792089237316195423570985008687907853269984665640564039457584007913129639935", expect: mustBigInt("115792089237316195423570985008687907853269984665640564039457584007913129639935")},
+		{converters: clickhouseConverters, typeName: "UInt256", value: "-1", expectErr: true},
+		{converters: clickhouseConverters, typeName: "Int128", value: "-1", expect: big.NewInt(-1)},
+		{converters: clickhouseConverters, typeName: "Array(UInt64)", value: "[1,2,3]", expect: []uint64{1, 2, 3}},
+		{converters: clickhouseConverters, typeName: "Array(DateTime64(3))", value: `["2023-11-14T22:13:20.5Z",1700000000]`, expect: []time.Time{time.Unix(1700000000, 500000000).UTC(), time.Unix(1700000000, 0).UTC()}},
+		{converters: clickhouseConverters, typeName: "Array(Array(String))", value: `[["a"],["b","c"]]`, expect: [][]string{{"a"}, {"b", "c"}}},
+		{converters: clickhouseConverters, typeName: "Array(UInt8)", value: "[256]", expectErr: true},
+		{converters: clickhouseConverters, typeName: "Map(String, UInt64)", value: `{"a":1,"b":2}`, expect: map[string]uint64{"a": 1, "b": 2}},
+		{converters: clickhouseConverters, typeName: "Map(String, UInt64)", value: `[1]`, expectErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s/%s", test.typeName, test.value), func(t *testing.T) {
+			converter := test.converters[valueConverterKeys(test.typeName)[len(valueConverterKeys(test.typeName))-1]]
+			require.NotNil(t, converter)
+
+			out, err := converter(test.typeName, test.value)
+			if test.expectErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expect, out)
+		})
+	}
+}
+
+func TestClickhouseTimestampElements(t *testing.T) {
+	l := &Loader{}
+	l.SetTimestampUnit(TimestampUnitMilliseconds)
+
+	converter := clickhouseValueConverters(l.convertTimestamp)["array"]
+	out, err := converter("Array(DateTime64(3))", `[1700000000123]`)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{time.Unix(1700000000, 123000000).UTC()}, out)
+}
+
+func TestRegisterValueConverter(t *testing.T) {
+	l, _ := NewTestLoader(zlog, tracer, "testschema", TestTables("testschema"))
+	l.RegisterValueConverter("TEXT", func(typeName string, value string) (any, error) {
+		if value == "" {
+			return nil, fmt.Errorf("empty %s value", typeName)
+		}
+		return strings.ToUpper(value), nil
+	})
+
+	table := l.tables["xfer"]
+	_, values, err := (&postgresDialect{}).prepareColValues(table, map[string]string{"from": "a", "to": NullValue})
+	require.NoError(t, err)
+	assert.Equal(t, []any{"A", nil}, values)
+
+	_, _, err = (&postgresDialect{}).prepareColValues(table, map[string]string{"from": ""})
+	assert.ErrorContains(t, err, `"testschema"."xfer" for column "from" raw value "": empty text value`)
+}
+
+func TestEnumValueConverter(t *testing.T) {
+	status := NewColumnInfo("status", "", "")
+	status.enumLabels = []string{"open", "closed"}
+
+	tables := TestTables("testschema")
+	tables["orders"] = mustNewTableInfo("testschema", "orders", []string{"id"}, map[string]*ColumnInfo{
+		"id":     NewColumnInfo("id", "text", ""),
+		"status": status,
+	})
+
+	l, _ := NewTestLoader(zlog, tracer, "testschema", tables)
+	l.resolveValueConversions(l.tables["orders"])
+
+	_, values, err := (&postgresDialect{}).prepareColValues(l.tables["orders"], map[string]string{"status": "closed"})
+	require.NoError(t, err)
+	assert.Equal(t, []any{"closed"}, values)
+
+	_, values, err = (&postgresDialect{}).prepareColValues(l.tables["orders"], map[string]string{"status": NullValue})
+	require.NoError(t, err)
+	assert.Equal(t, []any{nil}, values)
+
+	_, _, err = (&postgresDialect{}).prepareColValues(l.tables["orders"], map[string]string{"status": "pending"})
+	assert.ErrorContains(t, err, `invalid value "pending" for enum column "status" of table "orders", expected one of open, closed`)
+
+	// A converter registered for the enum's type takes precedence over its labels
+	status.databaseTypeName = "order_status"
+	l.RegisterValueConverter("order_status", func(typeName string, value string) (any, error) {
+		return strings.ToUpper(value), nil
+	})
+
+	_, values, err = (&postgresDialect{}).prepareColValues(l.tables["orders"], map[string]string{"status": "pending"})
+	require.NoError(t, err)
+	assert.Equal(t, []any{"PENDING"}, values)
+}
+
+func mustBigInt(value string) *big.Int {
+	out, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		panic(fmt.Errorf("invalid integer %q", value))
+	}
+	return out
+}
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// VersionValidFromColumn is the column of a versioned table holding the block a version starts at
+	VersionValidFromColumn = "valid_from_block"
+
+	// VersionValidToColumn is the column of a versioned table holding the block a version ends at,
+	// NULL for the current version of a row.
+	VersionValidToColumn = "valid_to_block"
+)
+
+// versionedTablesDialect is implemented by the dialects supporting versioned tables
+type versionedTablesDialect interface {
+	// versionStatements returns the statements writing the operation as a new version of its row,
+	// or closing its current version for a delete, at the operation's block.
+	versionStatements(op *Operation) ([]*statement, error)
+
+	// revertVersionsStatements returns the statements removing the versions of the table opened
+	// after the last valid block and re-opening the ones closed after it.
+	revertVersionsStatements(table *TableInfo, lastValidBlock uint64) []*statement
+
+	// versionIndexesQueries returns the queries creating the indexes of a versioned table
+	versionIndexesQueries(table *TableInfo) []string
+}
+
+// SetVersionedTables enables versioning for the tables, the tables must have been loaded first.
+// Rows of a versioned table are never overwritten, each change of a row closes its current version
+// by setting its `valid_to_block` and inserts a new one starting at the block of the change in
+// `valid_from_block`, deletes only close the current version. The primary key of a versioned table
+// is the one of its rows, as sent by Substreams, along with `valid_from_block`.
+func (l *Loader) SetVersionedTables(tableNames []string) error {
+	if _, ok := l.getDialect().(versionedTablesDialect); len(tableNames) > 0 && !ok {
+		return fmt.Errorf("versioned tables are not supported by the current database")
+	}
+
+	versionedTables := make(map[string]bool, len(tableNames))
+	for _, tableName := range tableNames {
+		table, found := l.tables[tableName]
+		if !found {
+			return fmt.Errorf("unknown table %q", tableName)
+		}
+
+		for _, column := range []string{VersionValidFromColumn, VersionValidToColumn} {
+			if _, found := table.columnsByName[column]; !found {
+				return fmt.Errorf("versioned table %q has no %q column", tableName, column)
+			}
+		}
+
+		if !table.isPrimaryColumn(VersionValidFromColumn) || len(table.primaryColumns) < 2 {
+			return fmt.Errorf("the primary key of versioned table %q must be made of the row's primary key and of the %q column", tableName, VersionValidFromColumn)
+		}
+
+		versionedTables[tableName] = true
+	}
+
+	l.versionedTables = versionedTables
+	for _, table := range l.tables {
+		l.resolveVersioned(table)
+	}
+
+	return nil
+}
+
+func (l *Loader) resolveVersioned(table *TableInfo) {
+	table.versioned = l.versionedTables[table.name]
+}
+
+// IsVersioned returns true if the table is versioned, see SetVersionedTables
+func (l *Loader) IsVersioned(tableName string) bool {
+	table, found := l.tables[tableName]
+	return found && table.versioned
+}
+
+// keyColumns returns the columns of the primary key of the table's rows, which for a versioned
+// table excludes `valid_from_block`.
+func (t *TableInfo) keyColumns() []*ColumnInfo {
+	if !t.versioned {
+		return t.primaryColumns
+	}
+
+	out := make([]*ColumnInfo, 0, len(t.primaryColumns)-1)
+	for _, column := range t.primaryColumns {
+		if column.name != VersionValidFromColumn {
+			out = append(out, column)
+		}
+	}
+
+	return out
+}
+
+// ScheduleVersion schedules the operation on a row of a versioned table at the block, the
+// operations of the same row are folded together only within the same block so that each
+// block changing the row writes a version of it.
+func (l *Loader) ScheduleVersion(tableName string, opType OperationType, primaryKey map[string]string, data map[string]string, blockNum uint64) error {
+	uniqueID := createRowUniqueID(primaryKey)
+	if l.tracer.Enabled() {
+		l.logger.Debug("processing versioned operation", zap.String("table_name", tableName), zap.String("primary_key", uniqueID), zap.String("op_type", string(opType)), zap.Uint64("block_num", blockNum))
+	}
+
+	table, found := l.tables[tableName]
+	if !found {
+		return fmt.Errorf("unknown table %q", tableName)
+	}
+
+	if !table.versioned {
+		return fmt.Errorf("table %q is not versioned", tableName)
+	}
+
+	if opType == OperationTypeInsert || opType == OperationTypeUpsert {
+		for _, primary := range table.keyColumns() {
+			if dataFromPrimaryKey, ok := primaryKey[primary.name]; ok {
+				data[primary.name] = dataFromPrimaryKey
+			}
+		}
+	}
+
+	var op *Operation
+	switch opType {
+	case OperationTypeInsert:
+		op = l.newInsertOperation(table, primaryKey, data, nil)
+	case OperationTypeUpdate:
+		op = l.newUpdateOperation(table, primaryKey, data, nil)
+		op.fieldOps = l.updateFieldOps(tableName, data)
+	case OperationTypeUpsert:
+		op = l.newUpsertOperation(table, primaryKey, data, nil)
+		op.fieldOps = l.updateFieldOps(tableName, data)
+	case OperationTypeDelete:
+		op = l.newDeleteOperation(table, primaryKey, nil)
+	default:
+		return fmt.Errorf("unsupported operation type %q for versioned table %q", opType, tableName)
+	}
+	op.versionBlockNum = blockNum
+
+	return l.scheduleIn(l.versions, tableName, uniqueID+"@"+strconv.FormatUint(blockNum, 10), op)
+}
+
+// flushVersions writes the scheduled operations of the versioned tables, one at a time in the
+// order they were received since the versions of a row depend on the previous ones.
+func (l *Loader) flushVersions(ctx context.Context, tx Tx) (int, error) {
+	// Versioned tables can only be enabled on the dialects supporting them
+	writer, ok := l.getDialect().(versionedTablesDialect)
+	if !ok {
+		return 0, nil
+	}
+
+	var rowCount int
+	for entriesPair := l.versions.Oldest(); entriesPair != nil; entriesPair = entriesPair.Next() {
+		for entryPair := entriesPair.Value.Oldest(); entryPair != nil; entryPair = entryPair.Next() {
+			op := entryPair.Value
+			statements, err := writer.versionStatements(op)
+			if err != nil {
+				return 0, fmt.Errorf("failed to prepare statement of %s: %w", op, err)
+			}
+
+			// Versions are written one row at a time, their queries only depend on the operation's shape
+			for _, stmt := range keyStatements("version/"+op.shapeKey(op.columnNames()), statements) {
+				if l.tracer.Enabled() {
+					l.logger.Debug("adding query from versioned operation to transaction", zap.Stringer("op", entryPair.Value), zap.String("query", stmt.query))
+				}
+
+				if _, err := l.execPrepared(ctx, tx, stmt); err != nil {
+					return 0, fmt.Errorf("executing query %q: %w", stmt.query, err)
+				}
+			}
+		}
+		rowCount += entriesPair.Value.Len()
+	}
+
+	return rowCount, nil
+}
+
+// revertVersions reverts the versioned tables to the last valid block, their versions holding
+// the blocks they are valid for, the history table is not involved.
+func (l *Loader) revertVersions(ctx context.Context, tx Tx, lastValidBlock uint64) error {
+	writer, ok := l.getDialect().(versionedTablesDialect)
+	if !ok {
+		return nil
+	}
+
+	var tableNames []string
+	for tableName, table := range l.tables {
+		if table.versioned {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	sort.Strings(tableNames)
+
+	for _, tableName := range tableNames {
+		l.logRevertedVersions(l.tables[tableName])
+		for _, stmt := range writer.revertVersionsStatements(l.tables[tableName], lastValidBlock) {
+			if _, err := tx.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+				return fmt.Errorf("executing query %q: %w", stmt.query, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// SetupVersionedTables creates the indexes of the versioned tables, the unique index of the
+// current version of each row and the index of the block range of the versions. The tables
+// must have been loaded first.
+func (l *Loader) SetupVersionedTables(ctx context.Context, tableNames []string) error {
+	if err := l.SetVersionedTables(tableNames); err != nil {
+		return err
+	}
+
+	if len(tableNames) == 0 {
+		return nil
+	}
+
+	writer := l.getDialect().(versionedTablesDialect)
+	for _, tableName := range tableNames {
+		for _, query := range writer.versionIndexesQueries(l.tables[tableName]) {
+			if _, err := l.ExecContext(ctx, query); err != nil {
+				return fmt.Errorf("creating index of versioned table %q: %w", tableName, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	sink "github.com/streamingfast/substreams-sink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsVersioned(t *testing.T) {
+	l, _ := NewTestLoader(zlog, tracer, "testschema", featureTestTables("testschema"))
+
+	require.NoError(t, l.SetVersionedTables([]string{"owners"}))
+	assert.True(t, l.IsVersioned("owners"))
+	assert.False(t, l.IsVersioned("xfer"))
+
+	primaryKey, err := l.GetPrimaryKey("owners", "1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"id": "1"}, primaryKey)
+
+	assert.ErrorContains(t, l.Insert("owners", primaryKey, map[string]string{"owner": "a"}, nil), `table "owners" is versioned, its operations must be scheduled with ScheduleVersion`)
+	assert.ErrorContains(t, l.ScheduleVersion("xfer", OperationTypeInsert, map[string]string{"id": "1"}, map[string]string{}, 10), `table "xfer" is not versioned`)
+
+	require.NoError(t, l.SetVersionedTables(nil))
+	assert.False(t, l.IsVersioned("owners"))
+}
+
+func TestScheduleVersion(t *testing.T) {
+	l, _ := NewTestLoader(zlog, tracer, "testschema", featureTestTables("testschema"))
+	require.NoError(t, l.SetVersionedTables([]string{"owners"}))
+
+	primaryKey := map[string]string{"id": "1"}
+	require.NoError(t, l.ScheduleVersion("owners", OperationTypeInsert, primaryKey, map[string]string{"owner": "a"}, 10))
+	require.NoError(t, l.ScheduleVersion("owners", OperationTypeUpdate, primaryKey, map[string]string{"owner": "b"}, 10))
+	require.NoError(t, l.ScheduleVersion("owners", OperationTypeUpdate, primaryKey, map[string]string{"owner": "c"}, 11))
+
+	_, found := l.entries.Get("owners")
+	assert.False(t, found, "versions are not scheduled with the other operations")
+
+	versions, found := l.versions.Get("owners")
+	require.True(t, found)
+	require.Equal(t, 2, versions.Len(), "operations are folded within a block only")
+
+	first := versions.Oldest().Value
+	assert.Equal(t, OperationTypeInsert, first.opType)
+	assert.Equal(t, map[string]string{"id": "1", "owner": "b"}, first.data)
+	assert.Equal(t, uint64(10), first.versionBlockNum)
+
+	second := versions.Oldest().Next().Value
+	assert.Equal(t, OperationTypeUpdate, second.opType)
+	assert.Equal(t, map[string]string{"owner": "c"}, second.data)
+	assert.Equal(t, uint64(11), second.versionBlockNum)
+}
+
+func TestFlushAndRevertVersions(t *testing.T) {
+	ctx := context.Background()
+	l, tx := NewTestLoader(zlog, tracer, "testschema", featureTestTables("testschema"))
+	require.NoError(t, l.SetVersionedTables([]string{"owners"}))
+
+	primaryKey := map[string]string{"id": "1"}
+	require.NoError(t, l.ScheduleVersion("owners", OperationTypeInsert, primaryKey, map[string]string{"owner": "a"}, 10))
+	require.NoError(t, l.ScheduleVersion("owners", OperationTypeUpdate, primaryKey, map[string]string{"owner": "b"}, 11))
+	require.NoError(t, l.ScheduleVersion("owners", OperationTypeDelete, primaryKey, nil, 12))
+
+	rowCount, err := l.Flush(ctx, "abc", sink.NewBlankCursor(), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 4, rowCount)
+
+	assert.Equal(t, []string{
+		`UPDATE "testschema"."owners" SET "valid_to_block" = $1 WHERE "id" = $2 AND "valid_to_block" IS NULL AND "valid_from_block" < $1; [10 1]`,
+		`INSERT INTO "testschema"."owners" ("id","owner","valid_from_block") VALUES ($1,$2,$3); [1 a 10]`,
+		`UPDATE "testschema"."owners" SET "valid_to_block" = $1 WHERE "id" = $2 AND "valid_to_block" IS NULL AND "valid_from_block" < $1; [11 1]`,
+		`INSERT INTO "testschema"."owners" ("id","owner","valid_from_block") SELECT c."id",v."owner",v."valid_from_block" FROM "testschema"."owners" AS c, json_populate_record(null::"testschema"."owners",$1) AS v WHERE c."id" = v."id" AND c."valid_to_block" = v."valid_from_block"; [{"id":"1","owner":"b","valid_from_block":11}]`,
+		`UPDATE "testschema"."owners" SET "valid_to_block" = $1 WHERE "id" = $2 AND "valid_to_block" IS NULL AND "valid_from_block" < $1; [12 1]`,
+	}, tx.ResultsContaining(`"testschema"."owners"`))
+
+	versions, _ := l.versions.Get("owners")
+	assert.Equal(t, 0, versions.Len())
+
+	tx.queries = nil
+	require.NoError(t, l.Revert(ctx, "abc", sink.NewBlankCursor(), 10))
+	assert.Equal(t, []string{
+		`DELETE FROM "testschema"."owners" WHERE "valid_from_block" > $1; [10]`,
+		`UPDATE "testschema"."owners" SET "valid_to_block" = NULL WHERE "valid_to_block" > $1; [10]`,
+	}, tx.ResultsContaining(`"testschema"."owners"`))
+}
+
+func TestUpsertVersion(t *testing.T) {
+	l, _ := NewTestLoader(zlog, tracer, "testschema", featureTestTables("testschema"))
+	require.NoError(t, l.SetVersionedTables([]string{"owners"}))
+	require.NoError(t, l.ScheduleVersion("owners", OperationTypeUpsert, map[string]string{"id": "1"}, map[string]string{"owner": "a"}, 10))
+
+	versions, _ := l.versions.Get("owners")
+	statements, err := postgresDialect{}.versionStatements(versions.Oldest().Value)
+	require.NoError(t, err)
+	require.Len(t, statements, 3)
+
+	assert.Equal(t, `INSERT INTO "testschema"."owners" ("id","owner","valid_from_block") SELECT v."id",v."owner",v."valid_from_block" FROM json_populate_record(null::"testschema"."owners",$1) AS v WHERE NOT EXISTS (SELECT 1 FROM "testschema"."owners" WHERE "id" = $2 AND "valid_from_block" = v."valid_from_block");`, statements[2].query)
+	assert.Equal(t, []any{`{"id":"1","owner":"a","valid_from_block":10}`, "1"}, statements[2].args)
+}
@@ -262,6 +262,8 @@ func (s *GenerateCSVSinker) dumpDatabaseChangesIntoCSV(dbChanges *pbdatabase.Dat
 			return fmt.Errorf("This substreams cannot be written to CSV because it performs 'UPDATE' operations on the %q store. Generate-CSV only supports inserts (CREATE)", change.Table)
 		case pbdatabase.TableChange_DELETE:
 			return fmt.Errorf("This substreams cannot be written to CSV because it performs 'DELETE' operations on the %q store. Generate-CSV only supports inserts (CREATE)", change.Table)
+		case pbdatabase.TableChange_UPSERT:
+			return fmt.Errorf("This substreams cannot be written to CSV because it performs 'UPSERT' operations on the %q store. Generate-CSV only supports inserts (CREATE)", change.Table)
 		}
 	}
 
@@ -31,6 +31,9 @@ type SQLSinker struct {
 	tracer logging.Tracer
 
 	stats *Stats
+
+	// nullSentinel is the field value standing for SQL NULL, none when empty
+	nullSentinel string
 }
 
 func New(sink *sink.Sinker, loader *db.Loader, logger *zap.Logger, tracer logging.Tracer) (*SQLSinker, error) {
@@ -46,6 +49,12 @@ func New(sink *sink.Sinker, loader *db.Loader, logger *zap.Logger, tracer loggin
 	}, nil
 }
 
+// SetNullSentinel configures the field value standing for SQL NULL, a field of a table change
+// having that value sets the column to NULL. An empty sentinel disables NULL values.
+func (s *SQLSinker) SetNullSentinel(sentinel string) {
+	s.nullSentinel = sentinel
+}
+
 func (s *SQLSinker) Run(ctx context.Context) {
 	cursor, mistmatchDetected, err := s.loader.GetCursor(ctx, s.OutputModuleHash())
 	if err != nil && !errors.Is(err, db.ErrCursorNotFound) {
@@ -114,7 +123,9 @@ func (s *SQLSinker) HandleBlockScopedData(ctx context.Context, data *pbsubstream
 		return fmt.Errorf("unmarshal database changes: %w", err)
 	}
 
-	if err := s.applyDatabaseChanges(dbChanges, data.Clock.Number, data.FinalBlockHeight); err != nil {
+	block := &db.BlockMetadata{Number: data.Clock.Number, ID: data.Clock.Id, Timestamp: data.Clock.Timestamp.AsTime()}
+	s.loader.SetCurrentBlock(block)
+	if err := s.applyDatabaseChanges(dbChanges, block, data.FinalBlockHeight); err != nil {
 		return fmt.Errorf("apply database changes: %w", err)
 	}
 
@@ -148,7 +159,16 @@ func (s *SQLSinker) HandleBlockScopedData(ctx context.Context, data *pbsubstream
 	return nil
 }
 
-func (s *SQLSinker) applyDatabaseChanges(dbChanges *pbdatabase.DatabaseChanges, blockNum, finalBlockNum uint64) error {
+// versionedOperationTypes are the operations of the changes of versioned tables
+var versionedOperationTypes = map[pbdatabase.TableChange_Operation]db.OperationType{
+	pbdatabase.TableChange_CREATE: db.OperationTypeInsert,
+	pbdatabase.TableChange_UPDATE: db.OperationTypeUpdate,
+	pbdatabase.TableChange_DELETE: db.OperationTypeDelete,
+	pbdatabase.TableChange_UPSERT: db.OperationTypeUpsert,
+}
+
+func (s *SQLSinker) applyDatabaseChanges(dbChanges *pbdatabase.DatabaseChanges, block *db.BlockMetadata, finalBlockNum uint64) error {
+	blockNum := block.Number
 	for _, change := range dbChanges.TableChanges {
 		if !s.loader.HasTable(change.Table) {
 			return fmt.Errorf(
@@ -174,8 +194,10 @@ func (s *SQLSinker) applyDatabaseChanges(dbChanges *pbdatabase.DatabaseChanges,
 		}
 
 		changes := map[string]string{}
+		oldValues := &db.OldValues{BlockNum: blockNum, Data: map[string]string{}}
 		for _, field := range change.Fields {
-			changes[field.Name] = field.NewValue
+			changes[field.Name] = s.fieldValue(field.NewValue)
+			oldValues.Data[field.Name] = s.fieldValue(field.OldValue)
 		}
 
 		var reversibleBlockNum *uint64
@@ -183,6 +205,22 @@ func (s *SQLSinker) applyDatabaseChanges(dbChanges *pbdatabase.DatabaseChanges,
 			reversibleBlockNum = &blockNum
 		}
 
+		if change.Operation != pbdatabase.TableChange_DELETE {
+			s.loader.AddBlockMetadata(change.Table, changes, block)
+		}
+
+		if s.loader.IsVersioned(change.Table) {
+			opType, found := versionedOperationTypes[change.Operation]
+			if !found {
+				continue
+			}
+
+			if err := s.loader.ScheduleVersion(change.Table, opType, primaryKeys, changes, blockNum); err != nil {
+				return fmt.Errorf("database versioned %s: %w", strings.ToLower(string(opType)), err)
+			}
+			continue
+		}
+
 		switch change.Operation {
 		case pbdatabase.TableChange_CREATE:
 			err := s.loader.Insert(change.Table, primaryKeys, changes, reversibleBlockNum)
@@ -190,15 +228,28 @@ func (s *SQLSinker) applyDatabaseChanges(dbChanges *pbdatabase.DatabaseChanges,
 				return fmt.Errorf("database insert: %w", err)
 			}
 		case pbdatabase.TableChange_UPDATE:
-			err := s.loader.Update(change.Table, primaryKeys, changes, reversibleBlockNum)
+			err := s.loader.UpdateWithOldValues(change.Table, primaryKeys, changes, oldValues, reversibleBlockNum)
 			if err != nil {
 				return fmt.Errorf("database update: %w", err)
 			}
 		case pbdatabase.TableChange_DELETE:
-			err := s.loader.Delete(change.Table, primaryKeys, reversibleBlockNum)
+			if s.loader.HasSoftDeletes(change.Table) {
+				err := s.loader.SoftDelete(change.Table, primaryKeys, oldValues, block, reversibleBlockNum)
+				if err != nil {
+					return fmt.Errorf("database soft delete: %w", err)
+				}
+				break
+			}
+
+			err := s.loader.DeleteWithOldValues(change.Table, primaryKeys, oldValues, reversibleBlockNum)
 			if err != nil {
 				return fmt.Errorf("database delete: %w", err)
 			}
+		case pbdatabase.TableChange_UPSERT:
+			err := s.loader.Upsert(change.Table, primaryKeys, changes, reversibleBlockNum)
+			if err != nil {
+				return fmt.Errorf("database upsert: %w", err)
+			}
 		default:
 			//case database.TableChange_UNSET:
 		}
@@ -206,6 +257,15 @@ func (s *SQLSinker) applyDatabaseChanges(dbChanges *pbdatabase.DatabaseChanges,
 	return nil
 }
 
+// fieldValue returns the value of a field of a table change, `db.NullValue` if it is the NULL sentinel
+func (s *SQLSinker) fieldValue(value string) string {
+	if s.nullSentinel != "" && value == s.nullSentinel {
+		return db.NullValue
+	}
+
+	return value
+}
+
 func (s *SQLSinker) HandleBlockUndoSignal(ctx context.Context, data *pbsubstreamsrpc.BlockUndoSignal, cursor *sink.Cursor) error {
 	return s.loader.Revert(ctx, s.OutputModuleHash(), cursor, data.LastValidBlock.Number)
 }
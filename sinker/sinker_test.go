@@ -43,6 +43,10 @@ func TestInserts(t *testing.T) {
 		events         []event
 		expectSQL      []string
 		queryResponses []*sql.Rows
+		nullSentinel   string
+		blockMetadata  bool
+		versioned      []string
+		tables         map[string]*db.TableInfo
 	}{
 		{
 			name: "insert final block",
@@ -54,9 +58,9 @@ func TestInserts(t *testing.T) {
 				},
 			},
 			expectSQL: []string{
-				`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ('sender1','1234','receiver1');`,
-				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= 10;`,
-				`UPDATE "testschema"."cursors" set cursor = 'bN7dsAhRyo44yl_ykkjA36WwLpc_DFtvXwrlIBBBj4r2', block_num = 10, block_id = '10' WHERE id = '756e75736564';`,
+				`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ($1,$2,$3); [sender1 1234 receiver1]`,
+				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= $1; [10]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [bN7dsAhRyo44yl_ykkjA36WwLpc_DFtvXwrlIBBBj4r2 10 10 756e75736564]`,
 				`COMMIT`,
 			},
 		},
@@ -75,13 +79,33 @@ func TestInserts(t *testing.T) {
 				},
 			},
 			expectSQL: []string{
-				`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ('sender1','1234','receiver1');`,
-				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= 10;`,
-				`UPDATE "testschema"."cursors" set cursor = 'bN7dsAhRyo44yl_ykkjA36WwLpc_DFtvXwrlIBBBj4r2', block_num = 10, block_id = '10' WHERE id = '756e75736564';`,
+				`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ($1,$2,$3); [sender1 1234 receiver1]`,
+				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= $1; [10]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [bN7dsAhRyo44yl_ykkjA36WwLpc_DFtvXwrlIBBBj4r2 10 10 756e75736564]`,
 				`COMMIT`,
-				`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ('sender2','2345','receiver2');`,
-				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= 11;`,
-				`UPDATE "testschema"."cursors" set cursor = 'dR5-m-1v1TQvlVRfIM9SXaWwLpc_DFtuXwrkIBBAj4r3', block_num = 11, block_id = '11' WHERE id = '756e75736564';`,
+				`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ($1,$2,$3); [sender2 2345 receiver2]`,
+				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= $1; [11]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [dR5-m-1v1TQvlVRfIM9SXaWwLpc_DFtuXwrkIBBAj4r3 11 11 756e75736564]`,
+				`COMMIT`,
+			},
+		},
+		{
+			name: "insert two rows in the same block are batched",
+			events: []event{
+				{
+					blockNum: 10,
+					libNum:   5,
+					tableChanges: []*pbdatabase.TableChange{
+						insertRowSinglePK("xfer", "1234", "from", "sender1", "to", "receiver1"),
+						insertRowSinglePK("xfer", "2345", "from", "sender2", "to", "receiver2"),
+					},
+				},
+			},
+			expectSQL: []string{
+				`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,block_num) values ($1,$2,$3,$4),($5,$6,$7,$8); [I "testschema"."xfer" {"id":"1234"} 10 I "testschema"."xfer" {"id":"2345"} 10]`,
+				`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ($1,$2,$3),($4,$5,$6); [sender1 1234 receiver1 sender2 2345 receiver2]`,
+				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= $1; [5]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [i4tY9gOcWnhKoGjRCl2VUKWwLpcyB1plVAvvLxtE 10 10 756e75736564]`,
 				`COMMIT`,
 			},
 		},
@@ -95,10 +119,10 @@ func TestInserts(t *testing.T) {
 				},
 			},
 			expectSQL: []string{
-				`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,block_num) values ('I','"testschema"."xfer"','{"id":"1234"}',10);` +
-					`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ('sender1','1234','receiver1');`,
-				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= 5;`,
-				`UPDATE "testschema"."cursors" set cursor = 'i4tY9gOcWnhKoGjRCl2VUKWwLpcyB1plVAvvLxtE', block_num = 10, block_id = '10' WHERE id = '756e75736564';`,
+				`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,block_num) values ($1,$2,$3,$4); [I "testschema"."xfer" {"id":"1234"} 10]`,
+				`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ($1,$2,$3); [sender1 1234 receiver1]`,
+				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= $1; [5]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [i4tY9gOcWnhKoGjRCl2VUKWwLpcyB1plVAvvLxtE 10 10 756e75736564]`,
 				`COMMIT`,
 			},
 		},
@@ -119,15 +143,15 @@ func TestInserts(t *testing.T) {
 				},
 			},
 			expectSQL: []string{
-				`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,block_num) values ('I','"testschema"."xfer"','{"id":"1234","idx":"3"}',10);` +
-					`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ('sender1','1234','receiver1');`,
-				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= 5;`,
-				`UPDATE "testschema"."cursors" set cursor = 'i4tY9gOcWnhKoGjRCl2VUKWwLpcyB1plVAvvLxtE', block_num = 10, block_id = '10' WHERE id = '756e75736564';`,
+				`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,block_num) values ($1,$2,$3,$4); [I "testschema"."xfer" {"id":"1234","idx":"3"} 10]`,
+				`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ($1,$2,$3); [sender1 1234 receiver1]`,
+				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= $1; [5]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [i4tY9gOcWnhKoGjRCl2VUKWwLpcyB1plVAvvLxtE 10 10 756e75736564]`,
 				`COMMIT`,
-				`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,prev_value,block_num) SELECT 'U','"testschema"."xfer"','{"id":"2345","idx":"3"}',row_to_json("xfer"),11 FROM "testschema"."xfer" WHERE "id" = '2345' AND "idx" = '3';` +
-					`UPDATE "testschema"."xfer" SET "from"='sender2', "to"='receiver2' WHERE "id" = '2345' AND "idx" = '3'`,
-				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= 6;`,
-				`UPDATE "testschema"."cursors" set cursor = 'LamYQ1PoEJyzLTRd7kdEiKWwLpcyB1tlVArvLBtH', block_num = 11, block_id = '11' WHERE id = '756e75736564';`,
+				`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,prev_value,block_num) SELECT $1::text,$2::text,$3::text,row_to_json("xfer"),$4::bigint FROM "testschema"."xfer" WHERE "id" = $5 AND "idx" = $6; [U "testschema"."xfer" {"id":"2345","idx":"3"} 11 2345 3]`,
+				`UPDATE "testschema"."xfer" SET "from"=v."from", "to"=v."to" FROM json_populate_recordset(null::"testschema"."xfer",$1) AS v WHERE "xfer"."id" = v."id" AND "xfer"."idx" = v."idx"; [[{"from":"sender2","id":"2345","idx":"3","to":"receiver2"}]]`,
+				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= $1; [6]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [LamYQ1PoEJyzLTRd7kdEiKWwLpcyB1tlVArvLBtH 11 11 756e75736564]`,
 				`COMMIT`,
 			},
 		},
@@ -150,18 +174,18 @@ func TestInserts(t *testing.T) {
 				},
 			},
 			expectSQL: []string{
-				`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,block_num) values ('I','"testschema"."xfer"','{"id":"1234","idx":"3"}',10);` +
-					`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ('sender1','1234','receiver1');`,
-				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= 5;`,
-				`UPDATE "testschema"."cursors" set cursor = 'i4tY9gOcWnhKoGjRCl2VUKWwLpcyB1plVAvvLxtE', block_num = 10, block_id = '10' WHERE id = '756e75736564';`,
+				`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,block_num) values ($1,$2,$3,$4); [I "testschema"."xfer" {"id":"1234","idx":"3"} 10]`,
+				`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ($1,$2,$3); [sender1 1234 receiver1]`,
+				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= $1; [5]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [i4tY9gOcWnhKoGjRCl2VUKWwLpcyB1plVAvvLxtE 10 10 756e75736564]`,
 				`COMMIT`,
 				// the following gets deduped
 				//`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,prev_value,block_num) SELECT 'U','"testschema"."xfer"','{"id":"2345","idx":"3"}',row_to_json("xfer"),11 FROM "testschema"."xfer" WHERE "id" = '2345' AND "idx" = '3';` +
 				//	`UPDATE "testschema"."xfer" SET "from"='sender2', "to"='receiver2' WHERE "id" = '2345' AND "idx" = '3'`,
-				`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,prev_value,block_num) SELECT 'D','"testschema"."xfer"','{"id":"2345","idx":"3"}',row_to_json("xfer"),11 FROM "testschema"."xfer" WHERE "id" = '2345' AND "idx" = '3';` +
-					`DELETE FROM "testschema"."xfer" WHERE "id" = '2345' AND "idx" = '3'`,
-				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= 6;`,
-				`UPDATE "testschema"."cursors" set cursor = 'LamYQ1PoEJyzLTRd7kdEiKWwLpcyB1tlVArvLBtH', block_num = 11, block_id = '11' WHERE id = '756e75736564';`,
+				`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,prev_value,block_num) SELECT $1::text,$2::text,$3::text,row_to_json("xfer"),$4::bigint FROM "testschema"."xfer" WHERE "id" = $5 AND "idx" = $6; [D "testschema"."xfer" {"id":"2345","idx":"3"} 11 2345 3]`,
+				`DELETE FROM "testschema"."xfer" WHERE ("id","idx") IN (($1,$2)); [2345 3]`,
+				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= $1; [6]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [LamYQ1PoEJyzLTRd7kdEiKWwLpcyB1tlVArvLBtH 11 11 756e75736564]`,
 				`COMMIT`,
 			},
 		},
@@ -186,21 +210,152 @@ func TestInserts(t *testing.T) {
 				},
 			},
 			expectSQL: []string{
-				`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,block_num) values ('I','"testschema"."xfer"','{"id":"1234"}',10);` +
-					`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ('sender1','1234','receiver1');`,
-				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= 5;`,
-				`UPDATE "testschema"."cursors" set cursor = 'i4tY9gOcWnhKoGjRCl2VUKWwLpcyB1plVAvvLxtE', block_num = 10, block_id = '10' WHERE id = '756e75736564';`,
+				`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,block_num) values ($1,$2,$3,$4); [I "testschema"."xfer" {"id":"1234"} 10]`,
+				`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ($1,$2,$3); [sender1 1234 receiver1]`,
+				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= $1; [5]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [i4tY9gOcWnhKoGjRCl2VUKWwLpcyB1plVAvvLxtE 10 10 756e75736564]`,
 				`COMMIT`,
-				`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,block_num) values ('I','"testschema"."xfer"','{"id":"2345"}',11);` +
-					`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ('sender2','2345','receiver2');`,
-				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= 5;`,
-				`UPDATE "testschema"."cursors" set cursor = 'Euaqz6R-ylLG0gbdej7Me6WwLpcyB1tlVArvLxtE', block_num = 11, block_id = '11' WHERE id = '756e75736564';`,
+				`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,block_num) values ($1,$2,$3,$4); [I "testschema"."xfer" {"id":"2345"} 11]`,
+				`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ($1,$2,$3); [sender2 2345 receiver2]`,
+				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= $1; [5]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [Euaqz6R-ylLG0gbdej7Me6WwLpcyB1tlVArvLxtE 11 11 756e75736564]`,
 				`COMMIT`,
-				`SELECT op,table_name,pk,prev_value,block_num FROM "testschema"."substreams_history" WHERE "block_num" > 10 ORDER BY "block_num" DESC`,
+				`SELECT op,table_name,pk,prev_value,block_num FROM "testschema"."substreams_history" WHERE "block_num" > $1 ORDER BY "block_num" DESC [10]`,
 
 				//`DELETE FROM "testschema"."xfer" WHERE "id" = "2345";`, // this mechanism is tested in db.revertOp
-				`DELETE FROM "testschema"."substreams_history" WHERE "block_num" > 10;`,
-				`UPDATE "testschema"."cursors" set cursor = 'i4tY9gOcWnhKoGjRCl2VUKWwLpcyB1plVAvvLxtE', block_num = 10, block_id = '10' WHERE id = '756e75736564';`,
+				`DELETE FROM "testschema"."substreams_history" WHERE "block_num" > $1; [10]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [i4tY9gOcWnhKoGjRCl2VUKWwLpcyB1plVAvvLxtE 10 10 756e75736564]`,
+				`COMMIT`,
+			},
+		},
+
+		{
+			name: "upsert reversible block",
+			events: []event{
+				{
+					blockNum:     10,
+					libNum:       5,
+					tableChanges: []*pbdatabase.TableChange{upsertRowSinglePK("xfer", "1234", "from", "sender1", "to", "receiver1")},
+				},
+			},
+			expectSQL: []string{
+				`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,prev_value,block_num) SELECT $1::text,$2::text,$3::text,row_to_json("xfer")::text,$4::bigint FROM "testschema"."xfer" WHERE "id" = $5 UNION ALL SELECT $6::text,$2::text,$3::text,NULL::text,$4::bigint WHERE NOT EXISTS (SELECT 1 FROM "testschema"."xfer" WHERE "id" = $7); [U "testschema"."xfer" {"id":"1234"} 10 1234 I 1234]`,
+				`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ($1,$2,$3) ON CONFLICT ("id") DO UPDATE SET "from"=EXCLUDED."from", "to"=EXCLUDED."to"; [sender1 1234 receiver1]`,
+				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= $1; [5]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [i4tY9gOcWnhKoGjRCl2VUKWwLpcyB1plVAvvLxtE 10 10 756e75736564]`,
+				`COMMIT`,
+			},
+		},
+
+		{
+			name: "insert, then delete in same block (both disappear)",
+			events: []event{
+				{
+					blockNum: 10,
+					libNum:   5,
+					tableChanges: []*pbdatabase.TableChange{
+						insertRowSinglePK("xfer", "1234", "from", "sender1", "to", "receiver1"),
+						deleteRowMultiplePK("xfer", map[string]string{"id": "1234"}),
+					},
+				},
+			},
+			expectSQL: []string{
+				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= $1; [5]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [i4tY9gOcWnhKoGjRCl2VUKWwLpcyB1plVAvvLxtE 10 10 756e75736564]`,
+				`COMMIT`,
+			},
+		},
+
+		{
+			name: "delete, then insert in same block (replace)",
+			events: []event{
+				{
+					blockNum: 10,
+					libNum:   5,
+					tableChanges: []*pbdatabase.TableChange{
+						deleteRowMultiplePK("xfer", map[string]string{"id": "1234"}),
+						insertRowSinglePK("xfer", "1234", "from", "sender1", "to", "receiver1"),
+					},
+				},
+			},
+			expectSQL: []string{
+				`INSERT INTO "testschema"."substreams_history" (op,table_name,pk,prev_value,block_num) SELECT $1::text,$2::text,$3::text,row_to_json("xfer")::text,$4::bigint FROM "testschema"."xfer" WHERE "id" = $5 UNION ALL SELECT $6::text,$2::text,$3::text,NULL::text,$4::bigint WHERE NOT EXISTS (SELECT 1 FROM "testschema"."xfer" WHERE "id" = $7); [U "testschema"."xfer" {"id":"1234"} 10 1234 I 1234]`,
+				`DELETE FROM "testschema"."xfer" WHERE "id" IN ($1); [1234]`,
+				`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ($1,$2,$3); [sender1 1234 receiver1]`,
+				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= $1; [5]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [i4tY9gOcWnhKoGjRCl2VUKWwLpcyB1plVAvvLxtE 10 10 756e75736564]`,
+				`COMMIT`,
+			},
+		},
+		{
+			name:         "insert with null sentinel",
+			nullSentinel: `\N`,
+			events: []event{
+				{
+					blockNum:     10,
+					libNum:       10,
+					tableChanges: []*pbdatabase.TableChange{insertRowSinglePK("xfer", "1234", "from", `\N`, "to", "receiver1")},
+				},
+			},
+			expectSQL: []string{
+				`INSERT INTO "testschema"."xfer" ("from","id","to") VALUES ($1,$2,$3); [<nil> 1234 receiver1]`,
+				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= $1; [10]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [bN7dsAhRyo44yl_ykkjA36WwLpc_DFtvXwrlIBBBj4r2 10 10 756e75736564]`,
+				`COMMIT`,
+			},
+		},
+		{
+			name:          "insert with block metadata",
+			blockMetadata: true,
+			tables: map[string]*db.TableInfo{
+				"xfer": mustNewTableInfo("testschema", "xfer", []string{"id"}, map[string]*db.ColumnInfo{
+					"id":         db.NewColumnInfo("id", "text", ""),
+					"from":       db.NewColumnInfo("from", "text", ""),
+					"to":         db.NewColumnInfo("to", "text", ""),
+					"_block_num": db.NewColumnInfo("_block_num", "int8", int64(0)),
+					"_block_id":  db.NewColumnInfo("_block_id", "text", ""),
+				}),
+				db.CURSORS_TABLE: db.TestTables("testschema")[db.CURSORS_TABLE],
+			},
+			events: []event{
+				{
+					blockNum:     10,
+					libNum:       10,
+					tableChanges: []*pbdatabase.TableChange{insertRowSinglePK("xfer", "1234", "from", "sender1", "to", "receiver1", "_block_num", "1")},
+				},
+			},
+			expectSQL: []string{
+				`INSERT INTO "testschema"."xfer" ("_block_id","_block_num","from","id","to") VALUES ($1,$2,$3,$4,$5); [10 10 sender1 1234 receiver1]`,
+				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= $1; [10]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [bN7dsAhRyo44yl_ykkjA36WwLpc_DFtvXwrlIBBBj4r2 10 10 756e75736564]`,
+				`COMMIT`,
+			},
+		},
+		{
+			name:      "insert in versioned table",
+			versioned: []string{"xfer"},
+			tables: map[string]*db.TableInfo{
+				"xfer": mustNewTableInfo("testschema", "xfer", []string{"id", "valid_from_block"}, map[string]*db.ColumnInfo{
+					"id":               db.NewColumnInfo("id", "text", ""),
+					"from":             db.NewColumnInfo("from", "text", ""),
+					"to":               db.NewColumnInfo("to", "text", ""),
+					"valid_from_block": db.NewColumnInfo("valid_from_block", "int8", int64(0)),
+					"valid_to_block":   db.NewColumnInfo("valid_to_block", "int8", int64(0)),
+				}),
+				db.CURSORS_TABLE: db.TestTables("testschema")[db.CURSORS_TABLE],
+			},
+			events: []event{
+				{
+					blockNum:     10,
+					libNum:       10,
+					tableChanges: []*pbdatabase.TableChange{insertRowSinglePK("xfer", "1234", "from", "sender1", "to", "receiver1")},
+				},
+			},
+			expectSQL: []string{
+				`DELETE FROM "testschema"."substreams_history" WHERE block_num <= $1; [10]`,
+				`UPDATE "testschema"."xfer" SET "valid_to_block" = $1 WHERE "id" = $2 AND "valid_to_block" IS NULL AND "valid_from_block" < $1; [10 1234]`,
+				`INSERT INTO "testschema"."xfer" ("from","id","to","valid_from_block") VALUES ($1,$2,$3,$4); [sender1 1234 receiver1 10]`,
+				`UPDATE "testschema"."cursors" set cursor = $1, block_num = $2, block_id = $3 WHERE id = $4; [bN7dsAhRyo44yl_ykkjA36WwLpc_DFtvXwrlIBBBj4r2 10 10 756e75736564]`,
 				`COMMIT`,
 			},
 		},
@@ -208,15 +363,25 @@ func TestInserts(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			ctx := context.Background()
+			tables := test.tables
+			if tables == nil {
+				tables = db.TestTables("testschema")
+			}
+
 			l, tx := db.NewTestLoader(
 				logger,
 				tracer,
 				"testschema",
-				db.TestTables("testschema"),
+				tables,
 			)
+			if test.blockMetadata {
+				l.SetBlockMetadataColumns(db.DefaultBlockMetadataColumns())
+			}
+			require.NoError(t, l.SetVersionedTables(test.versioned))
 			s, err := sink.New(sink.SubstreamsModeDevelopment, testPackage, testPackage.Modules.Modules[0], []byte("unused"), testClientConfig, logger, nil)
 			require.NoError(t, err)
 			sinker, _ := New(s, l, logger, nil)
+			sinker.SetNullSentinel(test.nullSentinel)
 
 			for _, evt := range test.events {
 				if evt.undoSignal {
@@ -298,6 +463,17 @@ func insertRowSinglePK(table string, pk string, fieldsAndValues ...string) *pbda
 	}
 }
 
+func upsertRowSinglePK(table string, pk string, fieldsAndValues ...string) *pbdatabase.TableChange {
+	return &pbdatabase.TableChange{
+		Table: table,
+		PrimaryKey: &pbdatabase.TableChange_Pk{
+			Pk: pk,
+		},
+		Operation: pbdatabase.TableChange_UPSERT,
+		Fields:    getFields(fieldsAndValues...),
+	}
+}
+
 func insertRowMultiplePK(table string, pk map[string]string, fieldsAndValues ...string) *pbdatabase.TableChange {
 	return &pbdatabase.TableChange{
 		Table: table,